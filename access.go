@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	accessFile = "_access.json"
+
+	defaultAccessFlushInterval = 10 * time.Second
+)
+
+type (
+	RecordInfo struct {
+		Collection string
+		Resource   string
+		Size       int64
+		ModTime    time.Time
+		LastRead   time.Time
+	}
+	// accessTracker keeps last-read times in memory and flushes them to one
+	// file per collection, so reads never wait on a write. A crash loses at
+	// most one flush interval.
+	accessTracker struct {
+		d       *Driver
+		mutex   sync.Mutex
+		times   map[string]map[string]time.Time
+		dirty   map[string]bool
+		flushes sync.Mutex
+	}
+)
+
+func newAccessTracker(d *Driver) *accessTracker {
+	if len(d.opts.TrackReads) == 0 {
+		return nil
+	}
+	return &accessTracker{d: d, times: make(map[string]map[string]time.Time), dirty: make(map[string]bool)}
+}
+
+func (t *accessTracker) tracks(collection string) bool {
+	return t != nil && t.d.opts.TrackReads[collection]
+}
+
+// collection returns the access times of collection, loading them from disk
+// on first use. The caller holds t.mutex.
+func (t *accessTracker) collection(collection string) map[string]time.Time {
+	times, ok := t.times[collection]
+	if ok {
+		return times
+	}
+
+	times = make(map[string]time.Time)
+	b, err := ioutil.ReadFile(filepath.Join(t.d.dir, collection, accessFile))
+	if err == nil {
+		err = json.Unmarshal(b, &times)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		t.d.log.Warn("Unable to load access times of %s: %s", collection, err)
+	}
+	t.times[collection] = times
+	return times
+}
+
+func (t *accessTracker) touch(collection, resource string) {
+	if !t.tracks(collection) {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.collection(collection)[resource] = time.Now().UTC()
+	t.dirty[collection] = true
+}
+
+func (t *accessTracker) lastRead(collection, resource string) time.Time {
+	if !t.tracks(collection) {
+		return time.Time{}
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.collection(collection)[resource]
+}
+
+func (t *accessTracker) forget(collection string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.times, collection)
+	delete(t.dirty, collection)
+}
+
+// flush writes the access times of every collection read since the last
+// flush. Close flushes one last time.
+func (t *accessTracker) flush() error {
+	if t == nil {
+		return nil
+	}
+	t.flushes.Lock()
+	defer t.flushes.Unlock()
+
+	t.mutex.Lock()
+	pending := make(map[string][]byte, len(t.dirty))
+	for collection := range t.dirty {
+		b, err := json.Marshal(t.times[collection])
+		if err != nil {
+			t.mutex.Unlock()
+			return err
+		}
+		pending[collection] = b
+	}
+	t.dirty = make(map[string]bool)
+	t.mutex.Unlock()
+
+	for collection, b := range pending {
+		path := filepath.Join(t.d.dir, collection, accessFile)
+		if err := t.d.writeFile(path+".tmp", b, true); err != nil {
+			return err
+		}
+		if err := t.d.rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *accessTracker) run() {
+	interval := t.d.opts.AccessFlushInterval
+	if interval <= 0 {
+		interval = defaultAccessFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.d.ctx.Done():
+			return
+		}
+		if err := t.flush(); err != nil {
+			t.d.log.Error("Unable to flush access times: %s", err)
+		}
+	}
+}
+
+func (d *Driver) Info(collection, resource string) (_ RecordInfo, err error) {
+	op := d.trace(nil, "Info", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return RecordInfo{}, fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return RecordInfo{}, fmt.Errorf("resource is required")
+	}
+
+	fi, err := d.statFile(d.recordPath(collection, resource))
+	if err != nil {
+		return RecordInfo{}, wrapOp("info", collection, resource, err)
+	}
+	return RecordInfo{
+		Collection: collection,
+		Resource:   resource,
+		Size:       fi.Size(),
+		ModTime:    fi.ModTime(),
+		LastRead:   d.access.lastRead(collection, resource),
+	}, nil
+}
+
+// InfoAll returns the RecordInfo of every record in collection, in name
+// order.
+func (d *Driver) InfoAll(collection string) (_ []RecordInfo, err error) {
+	op := d.trace(nil, "InfoAll", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+
+	files, err := d.readDir(filepath.Join(d.dir, collection))
+	if err != nil {
+		return nil, wrapOp("info", collection, "", err)
+	}
+
+	var infos []RecordInfo
+	for _, file := range files {
+		if file.IsDir() || !d.isRecord(file.Name()) {
+			continue
+		}
+		resource := d.resourceOf(file.Name())
+		infos = append(infos, RecordInfo{
+			Collection: collection,
+			Resource:   resource,
+			Size:       file.Size(),
+			ModTime:    file.ModTime(),
+			LastRead:   d.access.lastRead(collection, resource),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Resource < infos[j].Resource })
+	return infos, nil
+}
+
+// UnreadSince selects the records of a tracked collection that have not
+// been read since cutoff, including records never read at all.
+func (d *Driver) UnreadSince(collection string, cutoff time.Time) ([]string, error) {
+	if !d.access.tracks(collection) {
+		return nil, fmt.Errorf("reads of %s are not tracked", collection)
+	}
+
+	infos, err := d.InfoAll(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []string
+	for _, info := range infos {
+		if info.LastRead.Before(cutoff) {
+			resources = append(resources, info.Resource)
+		}
+	}
+	return resources, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Reads of a tracked collection show up in Info at once and on disk after
+// the flush interval, where a driver opened without a clean Close finds
+// them, and UnreadSince selects the records not read since a cutoff.
+func TestTrackReads(t *testing.T) {
+	opts := Options{TrackReads: map[string]bool{"user": true}, AccessFlushInterval: 20 * time.Millisecond}
+	d := openTest(t, &opts)
+	seedUsers(t, d)
+
+	var u User
+	if err := d.Read("user", "John", &u); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	if err := d.Read("user", "Paul", &u); err != nil {
+		t.Fatal(err)
+	}
+
+	john, err := d.Info("user", "John")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !john.LastRead.Before(cutoff) || john.LastRead.IsZero() {
+		t.Errorf("John was last read at %s, want just before %s", john.LastRead, cutoff)
+	}
+	unread, err := d.UnreadSince("user", cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Albert", "John", "Neo", "Robert", "Vince"}; !reflect.DeepEqual(unread, want) {
+		t.Errorf("unread since the cutoff: %v, want %v", unread, want)
+	}
+
+	waitFor(t, "the access times to be flushed", func() bool {
+		_, err := os.Stat(filepath.Join(d.dir, "user", accessFile))
+		return err == nil
+	})
+	crashed := openUnshared(t, d.dir, opts)
+	if info, err := crashed.Info("user", "John"); err != nil || !info.LastRead.Equal(john.LastRead) {
+		t.Errorf("after a crash John was last read at %s, %v, want %s", info.LastRead, err, john.LastRead)
+	}
+
+	if _, err := d.UnreadSince("n", cutoff); err == nil {
+		t.Error("UnreadSince of an untracked collection succeeded")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// AuditEntry is a line of Options.AuditLog: what one write changed in
+	// a record, by dotted field path. A new record has every field in
+	// Added.
+	AuditEntry struct {
+		Time       time.Time              `json:"time"`
+		Collection string                 `json:"collection"`
+		Resource   string                 `json:"resource"`
+		Added      map[string]interface{} `json:"added,omitempty"`
+		Changed    map[string]AuditChange `json:"changed,omitempty"`
+		Removed    []string               `json:"removed,omitempty"`
+	}
+	AuditChange struct {
+		From interface{} `json:"from"`
+		To   interface{} `json:"to"`
+	}
+
+	auditLog struct {
+		mutex sync.Mutex
+	}
+)
+
+// previousDocument returns the current content of a record for the audit
+// diff of a write replacing it, or nil if there is none.
+func (d *Driver) previousDocument(op *operation, collection, resource string) (map[string]interface{}, error) {
+	if d.opts.AuditLog == nil {
+		return nil, nil
+	}
+	b, err := d.readRecord(op.log, d.recordPath(collection, resource))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument(b)
+}
+
+// audit appends the entry of a write to AuditLog.
+func (d *Driver) audit(collection, resource string, before map[string]interface{}, after []byte) error {
+	if d.opts.AuditLog == nil {
+		return nil
+	}
+	doc, err := decodeDocument(after)
+	if err != nil {
+		return err
+	}
+	entry := AuditEntry{Time: time.Now().UTC(), Collection: collection, Resource: resource}
+	diffDocuments("", before, doc, &entry)
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	d.auditLog.mutex.Lock()
+	defer d.auditLog.mutex.Unlock()
+	_, err = d.opts.AuditLog.Write(append(b, '\n'))
+	return err
+}
+
+// diffDocuments records in entry how after differs from before, descending
+// into objects present on both sides; arrays are compared whole.
+func diffDocuments(prefix string, before, after map[string]interface{}, entry *AuditEntry) {
+	fields := make([]string, 0, len(before)+len(after))
+	for field := range before {
+		fields = append(fields, field)
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		path := prefix + field
+		from, inBefore := before[field]
+		to, inAfter := after[field]
+		switch {
+		case !inAfter:
+			entry.Removed = append(entry.Removed, path)
+		case !inBefore:
+			if entry.Added == nil {
+				entry.Added = make(map[string]interface{})
+			}
+			entry.Added[path] = to
+		default:
+			a, aok := from.(map[string]interface{})
+			b, bok := to.(map[string]interface{})
+			if aok && bok {
+				diffDocuments(path+".", a, b, entry)
+				continue
+			}
+			if !reflect.DeepEqual(from, to) {
+				if entry.Changed == nil {
+					entry.Changed = make(map[string]AuditChange)
+				}
+				entry.Changed[path] = AuditChange{From: from, To: to}
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// The audit entry of a write lists exactly the fields it changed, nested
+// ones by dotted path, and those it removed.
+func TestAuditLog(t *testing.T) {
+	var log bytes.Buffer
+	d := openTest(t, &Options{AuditLog: &log})
+	if err := d.Write("user", "John", testUsers[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(testUsers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	doc["Company"] = "Acme"
+	doc["Address"].(map[string]interface{})["City"] = "mysore"
+	delete(doc, "Contact")
+	doc["Email"] = "john@example.com"
+	if err := d.Write("user", "John", doc); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []AuditEntry
+	dec := json.NewDecoder(&log)
+	for dec.More() {
+		var entry AuditEntry
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d audit entries, want 2", len(entries))
+	}
+	if created := entries[0]; created.Added["Name"] != "John" || len(created.Changed) != 0 || len(created.Removed) != 0 {
+		t.Errorf("the entry of the first write is %+v", created)
+	}
+
+	entry := entries[1]
+	if entry.Collection != "user" || entry.Resource != "John" || entry.Time.IsZero() {
+		t.Errorf("the entry is for %s/%s at %s", entry.Collection, entry.Resource, entry.Time)
+	}
+	want := AuditEntry{
+		Time:       entry.Time,
+		Collection: "user",
+		Resource:   "John",
+		Added:      map[string]interface{}{"Email": "john@example.com"},
+		Changed: map[string]AuditChange{
+			"Company":      {From: "Myrl Tech", To: "Acme"},
+			"Address.City": {From: "bangalore", To: "mysore"},
+		},
+		Removed: []string{"Contact"},
+	}
+	if !reflect.DeepEqual(entry, want) {
+		t.Errorf("audit entry\n%+v\nwant\n%+v", entry, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import "fmt"
+
+type (
+	// BatchBuilder collects writes and deletes across collections and
+	// applies them together under the locks of every involved collection.
+	// It is not transactional: if an operation fails, the ones before it
+	// stay applied.
+	BatchBuilder struct {
+		d   *Driver
+		ops []batchOp
+		err error
+	}
+	batchOp struct {
+		collection string
+		resource   string
+		v          interface{}
+		delete     bool
+	}
+)
+
+func (d *Driver) Batch() *BatchBuilder {
+	return &BatchBuilder{d: d}
+}
+
+func (b *BatchBuilder) Write(collection, resource string, v interface{}) *BatchBuilder {
+	if b.err == nil {
+		b.err = b.check(collection, resource)
+	}
+	if b.err == nil {
+		b.err = b.d.checkEncodable(v)
+	}
+	b.ops = append(b.ops, batchOp{collection: collection, resource: resource, v: v})
+	return b
+}
+
+func (b *BatchBuilder) Delete(collection, resource string) *BatchBuilder {
+	if b.err == nil {
+		b.err = b.check(collection, resource)
+	}
+	b.ops = append(b.ops, batchOp{collection: collection, resource: resource, delete: true})
+	return b
+}
+
+func (b *BatchBuilder) check(collection, resource string) error {
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+	return validateName(collection, resource)
+}
+
+// Commit applies the operations in the order they were added.
+func (b *BatchBuilder) Commit() (err error) {
+	d := b.d
+	op := d.trace(nil, "Batch", "", "")
+	defer func() { op.end(err) }()
+
+	if b.err != nil {
+		return b.err
+	}
+
+	var scope, touched []string
+	for _, o := range b.ops {
+		if err := d.writeLimit.take(d.ctx, op.log, o.collection); err != nil {
+			return err
+		}
+		if o.delete {
+			scope = append(scope, d.deleteScope(o.collection)...)
+		} else {
+			scope = append(scope, d.writeScope(o.collection)...)
+		}
+		touched = append(touched, o.collection)
+	}
+	defer d.lockCollections(scope...)()
+
+	if err := d.checkWritable(touched...); err != nil {
+		return err
+	}
+
+	for _, o := range b.ops {
+		if o.delete {
+			if _, err := d.stat(d.recordPath(o.collection, o.resource)); err != nil {
+				return wrapOp("delete", o.collection, o.resource, err)
+			}
+			err = d.delete(op, o.collection, o.resource)
+		} else {
+			if err = d.write(op, o.collection, o.resource, o.v); err == nil {
+				err = d.setExpiry(op, o.collection, o.resource)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", o.collection, o.resource, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// A batch writes and deletes across two collections in one commit, and a
+// batch holding an invalid operation applies none of them.
+func TestBatchTwoCollections(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	err := d.Batch().
+		Write("order", "o1", order{"o1", "John"}).
+		Delete("user", "Paul").
+		Write("user", "John", testUsers[0]).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var o order
+	if err := d.Read("order", "o1", &o); err != nil || o.User != "John" {
+		t.Errorf("the batched order reads %+v, %v", o, err)
+	}
+	var u User
+	if err := d.Read("user", "Paul", &u); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of the batch-deleted user = %v, want ErrNotFound", err)
+	}
+
+	err = d.Batch().Write("order", "o2", order{"o2", "Robert"}).Write("", "x", 1).Commit()
+	if err == nil {
+		t.Fatal("a batch with an empty collection committed")
+	}
+	if err := d.Read("order", "o2", &o); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the valid half of a refused batch was applied: %v", err)
+	}
+
+	err = d.Batch().Write("order", "o3", order{"o3", "Vince"}).Delete("user", "Nobody").Commit()
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("a batch deleting a missing record = %v, want ErrNotFound", err)
+	}
+}
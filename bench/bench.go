@@ -0,0 +1,213 @@
+// Package bench generates load against a database and reports latency
+// percentiles and throughput, so configuration changes can be compared on
+// the hardware they will run on.
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is the part of the driver the harness exercises.
+type Store interface {
+	Write(collection, resource string, v interface{}) error
+	Read(collection, resource string, v interface{}) error
+}
+
+// WorkloadProfile describes the load to generate. Documents are between
+// MinDocBytes and MaxDocBytes, spread uniformly, and keys are drawn from
+// Keys distinct names of which the first Prefill are written before the
+// clock starts.
+type WorkloadProfile struct {
+	Name        string
+	Collection  string
+	ReadRatio   float64
+	MinDocBytes int
+	MaxDocBytes int
+	Keys        int
+	Prefill     int
+	Concurrency int
+	Duration    time.Duration
+	Seed        int64
+}
+
+var (
+	ReadHeavy = WorkloadProfile{
+		Name:        "read",
+		Collection:  "bench",
+		ReadRatio:   0.95,
+		MinDocBytes: 512,
+		MaxDocBytes: 2048,
+		Keys:        10000,
+		Prefill:     10000,
+		Concurrency: 8,
+		Duration:    10 * time.Second,
+		Seed:        1,
+	}
+	Ingest = WorkloadProfile{
+		Name:        "ingest",
+		Collection:  "bench",
+		ReadRatio:   0.05,
+		MinDocBytes: 256,
+		MaxDocBytes: 4096,
+		Keys:        100000,
+		Prefill:     1000,
+		Concurrency: 8,
+		Duration:    10 * time.Second,
+		Seed:        1,
+	}
+
+	Profiles = map[string]WorkloadProfile{
+		ReadHeavy.Name: ReadHeavy,
+		Ingest.Name:    Ingest,
+	}
+)
+
+type Latency struct {
+	P50, P90, P99, Max time.Duration
+}
+
+type Results struct {
+	Profile    string
+	Elapsed    time.Duration
+	Reads      int
+	Writes     int
+	Misses     int
+	Errors     int
+	Throughput float64
+	Read       Latency
+	Write      Latency
+}
+
+func (r Results) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "profile %s: %d reads, %d writes, %d misses, %d errors in %s\n", r.Profile, r.Reads, r.Writes, r.Misses, r.Errors, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "throughput %.0f ops/s\n", r.Throughput)
+	fmt.Fprintf(&b, "read  p50 %s p90 %s p99 %s max %s\n", r.Read.P50, r.Read.P90, r.Read.P99, r.Read.Max)
+	fmt.Fprintf(&b, "write p50 %s p90 %s p99 %s max %s", r.Write.P50, r.Write.P90, r.Write.P99, r.Write.Max)
+	return b.String()
+}
+
+type document struct {
+	ID      string
+	Payload string
+}
+
+type worker struct {
+	reads, writes []time.Duration
+	misses        int
+	errors        int
+}
+
+// Run prefills db and then drives the profile's mix of reads and writes
+// from Concurrency goroutines until Duration has passed.
+func Run(db Store, profile WorkloadProfile) (Results, error) {
+	if profile.Keys <= 0 || profile.Concurrency <= 0 || profile.Duration <= 0 {
+		return Results{}, fmt.Errorf("profile %s needs keys, concurrency and duration", profile.Name)
+	}
+	if profile.MaxDocBytes < profile.MinDocBytes {
+		return Results{}, fmt.Errorf("profile %s has MaxDocBytes below MinDocBytes", profile.Name)
+	}
+
+	rng := rand.New(rand.NewSource(profile.Seed))
+	for i := 0; i < profile.Prefill && i < profile.Keys; i++ {
+		if err := db.Write(profile.Collection, key(i), newDocument(rng, profile, i)); err != nil {
+			return Results{}, fmt.Errorf("prefill: %w", err)
+		}
+	}
+
+	workers := make([]*worker, profile.Concurrency)
+	deadline := time.Now().Add(profile.Duration)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := &worker{}
+		workers[i] = w
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			w.run(db, profile, rng, deadline)
+		}(rand.New(rand.NewSource(profile.Seed + int64(i) + 1)))
+	}
+	wg.Wait()
+
+	results := Results{Profile: profile.Name, Elapsed: time.Since(start)}
+	var reads, writes []time.Duration
+	for _, w := range workers {
+		reads = append(reads, w.reads...)
+		writes = append(writes, w.writes...)
+		results.Misses += w.misses
+		results.Errors += w.errors
+	}
+	results.Reads, results.Writes = len(reads), len(writes)
+	results.Throughput = float64(len(reads)+len(writes)) / results.Elapsed.Seconds()
+	results.Read = percentiles(reads)
+	results.Write = percentiles(writes)
+
+	return results, nil
+}
+
+func (w *worker) run(db Store, profile WorkloadProfile, rng *rand.Rand, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		i := rng.Intn(profile.Keys)
+
+		if rng.Float64() < profile.ReadRatio {
+			var doc document
+			start := time.Now()
+			err := db.Read(profile.Collection, key(i), &doc)
+			w.reads = append(w.reads, time.Since(start))
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				w.misses++
+			case err != nil:
+				w.errors++
+			}
+			continue
+		}
+
+		doc := newDocument(rng, profile, i)
+		start := time.Now()
+		err := db.Write(profile.Collection, key(i), doc)
+		w.writes = append(w.writes, time.Since(start))
+		if err != nil {
+			w.errors++
+		}
+	}
+}
+
+func key(i int) string {
+	return fmt.Sprintf("k%08d", i)
+}
+
+func newDocument(rng *rand.Rand, profile WorkloadProfile, i int) document {
+	size := profile.MinDocBytes
+	if profile.MaxDocBytes > profile.MinDocBytes {
+		size += rng.Intn(profile.MaxDocBytes - profile.MinDocBytes + 1)
+	}
+
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	payload := make([]byte, size)
+	for j := range payload {
+		payload[j] = letters[rng.Intn(len(letters))]
+	}
+	return document{ID: key(i), Payload: string(payload)}
+}
+
+func percentiles(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	at := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	return Latency{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: samples[len(samples)-1]}
+}
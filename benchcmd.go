@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go-database/bench"
+
+	"github.com/jcelliott/lumber"
+)
+
+// runBench implements `godb bench`, running a canned workload profile
+// against a throwaway database unless -dir is given.
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	name := flags.String("profile", bench.ReadHeavy.Name, "workload profile: read or ingest")
+	duration := flags.Duration("duration", 0, "how long to run, overriding the profile")
+	concurrency := flags.Int("concurrency", 0, "concurrent workers, overriding the profile")
+	dir := flags.String("dir", "", "database directory (default: a temporary one)")
+	compress := flags.Bool("gzip", false, "store records gzip-compressed")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	profile, ok := bench.Profiles[*name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown profile %q\n", *name)
+		return 2
+	}
+	if *duration > 0 {
+		profile.Duration = *duration
+	}
+	if *concurrency > 0 {
+		profile.Concurrency = *concurrency
+	}
+
+	if *dir == "" {
+		tmp, err := ioutil.TempDir("", "godb-bench")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(tmp)
+		*dir = tmp
+	}
+
+	opts := &Options{Logger: lumber.NewConsoleLogger(lumber.WARN)}
+	if *compress {
+		opts.Compression = CompressionGzip
+	}
+	db, err := New(*dir, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	fmt.Printf("running %s for %s with %d workers\n", profile.Name, profile.Duration.Round(time.Second), profile.Concurrency)
+	results, err := bench.Run(db, profile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(results)
+	return 0
+}
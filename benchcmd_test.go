@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go-database/bench"
+)
+
+// Both canned profiles run cleanly against the driver and report their
+// numbers. The run takes seconds, so -short skips it.
+func TestBenchProfiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load generation is skipped in short mode")
+	}
+
+	for _, profile := range []bench.WorkloadProfile{bench.ReadHeavy, bench.Ingest} {
+		t.Run(profile.Name, func(t *testing.T) {
+			profile.Keys, profile.Prefill = 1000, min(profile.Prefill, 1000)
+			profile.Duration = 300 * time.Millisecond
+
+			results, err := bench.Run(openTest(t, nil), profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Logf("\n%s", results)
+
+			if results.Errors != 0 {
+				t.Errorf("%d operations failed", results.Errors)
+			}
+			ops := results.Reads + results.Writes
+			if ops == 0 || results.Throughput <= 0 {
+				t.Fatalf("no operations ran: %+v", results)
+			}
+			if ratio := float64(results.Reads) / float64(ops); ratio < profile.ReadRatio-0.05 || ratio > profile.ReadRatio+0.05 {
+				t.Errorf("%.2f of the operations were reads, want %.2f", ratio, profile.ReadRatio)
+			}
+			if profile.Prefill == profile.Keys && results.Misses != 0 {
+				t.Errorf("%d reads missed a prefilled key", results.Misses)
+			}
+		})
+	}
+}
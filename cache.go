@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	listingCache struct {
+		mutex   sync.Mutex
+		max     int64
+		ttl     time.Duration
+		size    int64
+		entries map[string]*listing
+		gens    map[string]uint64
+	}
+	listing struct {
+		records []string
+		keys    []string
+		size    int64
+		expires time.Time
+		used    time.Time
+	}
+)
+
+func newListingCache(max int64, ttl time.Duration) *listingCache {
+	if max <= 0 {
+		return nil
+	}
+	return &listingCache{
+		max:     max,
+		ttl:     ttl,
+		entries: make(map[string]*listing),
+		gens:    make(map[string]uint64),
+	}
+}
+
+// generation must be taken before reading the collection from disk and
+// handed back to put, so a listing built concurrently with a mutation is
+// never stored.
+func (c *listingCache) generation(collection string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.gens[collection]
+}
+
+func (c *listingCache) get(collection string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	l, ok := c.entries[collection]
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	if !l.expires.IsZero() && now.After(l.expires) {
+		c.remove(collection)
+		return nil, false
+	}
+	l.used = now
+
+	return append([]string(nil), l.records...), true
+}
+
+// lookup returns one record from a cached listing.
+func (c *listingCache) lookup(collection, resource string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	l, ok := c.entries[collection]
+	if !ok || (!l.expires.IsZero() && time.Now().After(l.expires)) {
+		return nil, false
+	}
+	for i, key := range l.keys {
+		if key == resource {
+			return []byte(l.records[i]), true
+		}
+	}
+	return nil, false
+}
+
+func (c *listingCache) put(collection string, gen uint64, records, keys []string) {
+	if c == nil {
+		return
+	}
+
+	var size int64
+	for i := range records {
+		size += int64(len(records[i]) + len(keys[i]))
+	}
+	if size > c.max {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.gens[collection] != gen {
+		return
+	}
+	c.remove(collection)
+
+	for c.size+size > c.max {
+		c.evict()
+	}
+
+	now := time.Now()
+	l := &listing{
+		records: append([]string(nil), records...),
+		keys:    append([]string(nil), keys...),
+		size:    size,
+		used:    now,
+	}
+	if c.ttl > 0 {
+		l.expires = now.Add(c.ttl)
+	}
+	c.entries[collection] = l
+	c.size += size
+}
+
+func (c *listingCache) invalidate(collection string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.gens[collection]++
+	c.remove(collection)
+}
+
+func (c *listingCache) remove(collection string) {
+	if l, ok := c.entries[collection]; ok {
+		c.size -= l.size
+		delete(c.entries, collection)
+	}
+}
+
+func (c *listingCache) evict() {
+	var oldest string
+	var used time.Time
+	for collection, l := range c.entries {
+		if oldest == "" || l.used.Before(used) {
+			oldest, used = collection, l.used
+		}
+	}
+	c.remove(oldest)
+}
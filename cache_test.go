@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// A cached ReadAll reads no files, and a write in between is seen by the
+// next ReadAll.
+func TestReadAllCache(t *testing.T) {
+	d := openTest(t, &Options{ListingCacheBytes: 1 << 20})
+	seedUsers(t, d)
+
+	if _, err := d.ReadAll("user"); err != nil {
+		t.Fatal(err)
+	}
+	reads := d.IOStats().Reads
+	records, err := d.ReadAll("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := d.IOStats().Reads - reads; n != 0 || len(records) != len(testUsers) {
+		t.Fatalf("cached ReadAll read %d files for %d records, want 0 for %d", n, len(records), len(testUsers))
+	}
+
+	changed := testUsers[0]
+	changed.Company = "Elsewhere"
+	if err := d.Write("user", changed.Name, changed); err != nil {
+		t.Fatal(err)
+	}
+	if records, err = d.ReadAll("user"); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, record := range records {
+		found = found || strings.Contains(record, "Elsewhere")
+	}
+	if !found {
+		t.Error("ReadAll after a write served the listing from before it")
+	}
+
+	if err := d.Delete("user", changed.Name); err != nil {
+		t.Fatal(err)
+	}
+	if records, err = d.ReadAll("user"); err != nil || len(records) != len(testUsers)-1 {
+		t.Errorf("ReadAll after a delete returned %d records, %v, want %d", len(records), err, len(testUsers)-1)
+	}
+}
+
+// A cache that cannot hold the listing is bypassed rather than thrashed.
+func TestReadAllCacheBound(t *testing.T) {
+	d := openTest(t, &Options{ListingCacheBytes: 64})
+	seedUsers(t, d)
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.ReadAll("user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.listings.size != 0 {
+		t.Errorf("cache holds %d bytes past its bound of 64", d.listings.size)
+	}
+}
+
+// A hot ReadAll served from the cache reads no files; a mutation between
+// reads makes each one read the collection again.
+func BenchmarkReadAllCache(b *testing.B) {
+	for _, mutate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("mutate=%v", mutate), func(b *testing.B) {
+			d := openTest(b, &Options{ListingCacheBytes: 1 << 20})
+			for i := 0; i < 100; i++ {
+				if err := d.Write("hot", fmt.Sprintf("r%03d", i), packedDoc{N: i}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if _, err := d.ReadAll("hot"); err != nil {
+				b.Fatal(err)
+			}
+			reads := d.IOStats().Reads
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if mutate {
+					if err := d.Write("hot", "r000", packedDoc{N: i}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if _, err := d.ReadAll("hot"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(d.IOStats().Reads-reads)/float64(b.N), "reads/op")
+		})
+	}
+}
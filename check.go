@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"time"
+)
+
+// CheckOptions controls Check. With a SampleRate between 0 and 1 only that
+// fraction of records is parsed; directory-level checks always cover
+// everything. Seed makes the sample reproducible and defaults to the
+// current time. FullOnFailure re-checks every record of a collection in
+// which the sample found a corrupt one.
+type CheckOptions struct {
+	SampleRate    float64
+	Seed          int64
+	FullOnFailure bool
+}
+
+// CheckReport describes a Check run. CorruptRate estimates the fraction of
+// corrupt records in the whole database, with an approximate 95%
+// confidence interval in CorruptRateLow and CorruptRateHigh.
+type CheckReport struct {
+	Seed            int64
+	Collections     int
+	Records         int
+	Checked         int
+	Orphans         []string
+	Corrupt         []RecordError
+	CorruptRate     float64
+	CorruptRateLow  float64
+	CorruptRateHigh float64
+}
+
+func (d *Driver) Check(opts CheckOptions) (_ CheckReport, err error) {
+	op := d.trace(nil, "Check", "", "")
+	defer func() { op.end(err) }()
+
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	report := CheckReport{Seed: opts.Seed}
+	sample := rand.New(rand.NewSource(opts.Seed))
+
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return report, err
+	}
+
+	var estimate, variance float64
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+
+		total, checked, corrupt, err := d.checkCollection(op, entry.Name(), opts, sample, &report)
+		if err != nil {
+			return report, err
+		}
+		report.Collections++
+		report.Records += total
+		report.Checked += checked
+
+		if checked == 0 {
+			continue
+		}
+		n, k, N := float64(checked), float64(corrupt), float64(total)
+		p := k / n
+		estimate += N * p
+		if N > 1 {
+			variance += N * N * p * (1 - p) / n * (N - n) / (N - 1)
+		}
+	}
+
+	if report.Records > 0 {
+		records := float64(report.Records)
+		margin := 1.96 * math.Sqrt(variance)
+		report.CorruptRate = estimate / records
+		report.CorruptRateLow = math.Max(0, (estimate-margin)/records)
+		report.CorruptRateHigh = math.Min(1, (estimate+margin)/records)
+	}
+
+	return report, nil
+}
+
+func (d *Driver) checkCollection(op *operation, collection string, opts CheckOptions, sample *rand.Rand, report *CheckReport) (total, checked, corrupt int, err error) {
+	mutex := d.getOrCreateNewMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	orphans, err := d.findOrphans(collection)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, orphan := range orphans {
+		report.Orphans = append(report.Orphans, filepath.Join(collection, orphan))
+	}
+
+	files, err := d.readDir(filepath.Join(d.dir, collection))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var resources []string
+	for _, file := range files {
+		if !file.IsDir() && d.isRecord(file.Name()) {
+			resources = append(resources, d.resourceOf(file.Name()))
+		}
+	}
+	total = len(resources)
+
+	seen := make(map[string]bool)
+	check := func(resource string) {
+		seen[resource] = true
+		checked++
+		if err := d.checkRecord(op.log, collection, resource); err != nil {
+			corrupt++
+			report.Corrupt = append(report.Corrupt, RecordError{Collection: collection, Resource: resource, Err: err})
+		}
+	}
+
+	for _, resource := range resources {
+		if opts.SampleRate == 1 || sample.Float64() < opts.SampleRate {
+			check(resource)
+		}
+	}
+
+	if corrupt > 0 && opts.FullOnFailure && checked < total {
+		op.log.Warn("Sample found %d corrupt records in %s, checking all %d", corrupt, collection, total)
+		for _, resource := range resources {
+			if !seen[resource] {
+				check(resource)
+			}
+		}
+	}
+
+	return total, checked, corrupt, nil
+}
+
+func (d *Driver) checkRecord(log Logger, collection, resource string) error {
+	b, err := d.readRecord(log, d.recordPath(collection, resource))
+	if isTorn(b, err) {
+		return &TornWriteError{Record: d.recordPath(collection, resource)}
+	}
+	if err != nil {
+		return err
+	}
+	if !json.Valid(b) {
+		return fmt.Errorf("invalid JSON")
+	}
+	return nil
+}
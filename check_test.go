@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// A sampled Check parses only about its share of the records, and its
+// estimate of the corrupt rate brackets the real one.
+func TestCheckSample(t *testing.T) {
+	d := openTest(t, nil)
+	writeN(t, d, 0, 2000)
+	for i := 0; i < 2000; i += 10 {
+		if err := os.WriteFile(d.recordPath("n", fmt.Sprint(i)), []byte("not json"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reads := d.IOStats().Reads
+	report, err := d.Check(CheckOptions{SampleRate: 0.1, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := d.IOStats().Reads - reads; n != uint64(report.Checked) || n < 150 || n > 250 {
+		t.Errorf("the sample read %d records and checked %d, want about 200", n, report.Checked)
+	}
+	if report.Records != 2000 {
+		t.Errorf("the report counts %d records, want 2000", report.Records)
+	}
+	if report.CorruptRateLow > 0.1 || report.CorruptRateHigh < 0.1 {
+		t.Errorf("estimated %.3f in [%.3f, %.3f], want the interval to hold 0.1",
+			report.CorruptRate, report.CorruptRateLow, report.CorruptRateHigh)
+	}
+
+	again, err := d.Check(CheckOptions{SampleRate: 0.1, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Checked != report.Checked || len(again.Corrupt) != len(report.Corrupt) {
+		t.Errorf("the same seed checked %d records, then %d", report.Checked, again.Checked)
+	}
+
+	full, err := d.Check(CheckOptions{SampleRate: 0.1, Seed: 1, FullOnFailure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Checked != 2000 || len(full.Corrupt) != 200 || full.CorruptRate != 0.1 {
+		t.Errorf("FullOnFailure checked %d records and found %d corrupt, rate %.3f",
+			full.Checked, len(full.Corrupt), full.CorruptRate)
+	}
+}
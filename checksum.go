@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumExt names the sidecar holding the SHA-256 of a record file,
+// written with Options.VerifyChecksums.
+const checksumExt = ".sha256"
+
+func (d *Driver) checksumPath(collection, resource string) string {
+	return filepath.Join(d.dir, collection, d.fileKey(resource)+checksumExt)
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeChecksum records the checksum of the bytes stored for a record.
+func (d *Driver) writeChecksum(collection, resource string, stored []byte) error {
+	path := d.checksumPath(collection, resource)
+	if err := d.writeFile(path+".tmp", []byte(checksum(stored)), true); err != nil {
+		return err
+	}
+	return d.rename(path+".tmp", path)
+}
+
+// verifyChecksum compares the bytes read from a record file with its
+// sidecar. Records without one, such as those written before
+// VerifyChecksums was set, are not checked.
+func (d *Driver) verifyChecksum(path string, stored []byte) error {
+	if !d.opts.VerifyChecksums || !strings.HasSuffix(path, d.ext()) {
+		return nil
+	}
+	want, err := ioutil.ReadFile(strings.TrimSuffix(path, d.ext()) + checksumExt)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if got := checksum(stored); got != strings.TrimSpace(string(want)) {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, path)
+	}
+	return nil
+}
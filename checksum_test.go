@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// A record edited on disk into other valid JSON fails its checksum on
+// Read, and the sidecars are not listed as records.
+func TestChecksumMismatch(t *testing.T) {
+	d := openTest(t, &Options{VerifyChecksums: true})
+	seedUsers(t, d)
+
+	var u User
+	if err := d.Read("user", "John", &u); err != nil {
+		t.Fatal(err)
+	}
+	path := d.recordPath("user", "John")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := bytes.Replace(b, []byte(`"Age":23`), []byte(`"Age":24`), 1)
+	if bytes.Equal(flipped, b) {
+		t.Fatalf("John's record %q holds no age to flip", b)
+	}
+	if err := os.WriteFile(path, flipped, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("user", "John", &u); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Read of the flipped record = %v, want ErrChecksumMismatch", err)
+	}
+	if err := d.Read("user", "Paul", &u); err != nil {
+		t.Errorf("Read of an intact record = %v", err)
+	}
+
+	keys, err := d.Keys("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(testUsers) {
+		t.Errorf("Keys lists %v", keys)
+	}
+}
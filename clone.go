@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type CloneInfo struct {
+	Source string
+	Time   time.Time
+}
+
+// CloneOptions controls CloneTo. Files are hardlinked unless Copy is set or
+// the filesystem refuses, in which case they are copied. Incremental
+// refreshes an existing clone, touching only files that changed since.
+type CloneOptions struct {
+	Copy        bool
+	Incremental bool
+}
+
+// CloneTo writes a copy of the database to dir. Each collection is copied
+// under its own lock, together with its key index, so the clone holds a
+// consistent version of every collection; collections are captured one
+// after another rather than at a single instant, and the remaining shared
+// state without any collection lock. The change log and the writer lease
+// are not copied. Hardlinking is safe because the driver never rewrites a
+// file in place: every write renames a new file over the old one, leaving
+// the clone's link pointing at the old content. An incremental refresh
+// keeps a file only when it is the source file or has the same content.
+// New opens the clone read-only unless WritableClone is set.
+func (d *Driver) CloneTo(dir string, opts CloneOptions) (err error) {
+	op := d.trace(nil, "CloneTo", "", "")
+	defer func() { op.end(err) }()
+
+	if dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+	if d.contains(dir) {
+		return fmt.Errorf("cannot clone %s into itself", d.dir)
+	}
+
+	if m, err := readManifest(dir); err == nil {
+		if m.Clone == nil || !opts.Incremental {
+			return fmt.Errorf("%s already holds a database", dir)
+		}
+	} else if entries, _ := ioutil.ReadDir(dir); len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	var collections []string
+	for _, entry := range entries {
+		if entry.IsDir() && !reserved[entry.Name()] {
+			collections = append(collections, entry.Name())
+		}
+	}
+
+	started := time.Now().UTC()
+	c := &cloner{d: d, op: op, dir: dir, link: !opts.Copy, seen: map[string]bool{manifestFile: true}}
+
+	for _, collection := range collections {
+		err := func() error {
+			defer d.lockCollections(collection)()
+			if err := c.tree(filepath.Join(d.dir, collection)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := c.tree(d.keyIndexPath(collection)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.tree(d.dir); err != nil {
+		return err
+	}
+
+	if opts.Incremental {
+		if err := pruneClone(dir, c.seen); err != nil {
+			return err
+		}
+	}
+
+	op.log.Info("Cloned %s to %s: %d linked, %d copied, %d unchanged", d.dir, dir, c.linked, c.copied, c.kept)
+	m := Manifest{Version: Version, Created: started, Compression: d.opts.Compression.String(), KeyEncoding: d.keyEncoding(), Clone: &CloneInfo{Source: d.dir, Time: started}}
+	if err := writeManifest(dir, m); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.snapshot = dir
+	d.mutex.Unlock()
+	return nil
+}
+
+// cloner carries the state of one CloneTo.
+type cloner struct {
+	d    *Driver
+	op   *operation
+	dir  string
+	link bool
+	// seen holds the paths, relative to the database, already cloned.
+	seen map[string]bool
+
+	linked, copied, kept int
+}
+
+// tree clones the file or directory at path, skipping what was already
+// cloned and what a clone must not share with its source.
+func (c *cloner) tree(path string) error {
+	return filepath.Walk(path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.d.dir, path)
+		if err != nil {
+			return err
+		}
+		if (rel == changeLogDir || rel == leaseDir) && fi.IsDir() {
+			return filepath.SkipDir
+		}
+		if rel == "." || strings.HasSuffix(rel, ".tmp") {
+			return nil
+		}
+		if c.seen[rel] {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		c.seen[rel] = true
+
+		target := filepath.Join(c.dir, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return c.file(path, target, fi)
+	})
+}
+
+func (c *cloner) file(path, target string, fi os.FileInfo) error {
+	if existing, err := os.Lstat(target); err == nil {
+		same := os.SameFile(fi, existing)
+		if !same && existing.Size() == fi.Size() {
+			if same, err = sameFiles(path, target); err != nil {
+				return err
+			}
+		}
+		if same {
+			c.kept++
+			return nil
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	// Packs are appended to in place, so a link would let later writes
+	// into the clone.
+	if c.link && filepath.Base(filepath.Dir(path)) != packsDir {
+		if err := os.Link(path, target); err == nil {
+			c.linked++
+			return nil
+		}
+		c.op.log.Info("Hardlinks unavailable for %s, copying instead", c.dir)
+		c.link = false
+	}
+	c.copied++
+	return copyFile(path, target, fi)
+}
+
+// sameFiles reports whether two files hold the same bytes.
+func sameFiles(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ba, bb := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(fa, ba)
+		nb, errb := io.ReadFull(fb, bb)
+		if na != nb || !bytes.Equal(ba[:na], bb[:nb]) {
+			return false, nil
+		}
+		if erra == io.EOF || erra == io.ErrUnexpectedEOF {
+			return errb == io.EOF || errb == io.ErrUnexpectedEOF, nil
+		}
+		if erra != nil {
+			return false, erra
+		}
+		if errb != nil {
+			return false, errb
+		}
+	}
+}
+
+func copyFile(from, to string, fi os.FileInfo) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(to+".tmp", fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(to+".tmp", to)
+}
+
+// pruneClone removes what a previous clone left behind that no longer
+// exists in the source.
+func pruneClone(dir string, seen map[string]bool) error {
+	var stale []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && !seen[rel] {
+			stale = append(stale, path)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range stale {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
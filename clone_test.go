@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// An incremental clone refreshes a record rewritten with the same size and
+// modification time, and never copies the writer lease.
+func TestCloneIncremental(t *testing.T) {
+	d := openTest(t, nil)
+	if err := d.Write("s", "k", "aaaa"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(d.dir, leaseDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(d.dir, leaseDir, "current"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.CloneTo(filepath.Join(d.dir, "inside"), CloneOptions{}); err == nil {
+		t.Error("CloneTo into the database succeeded")
+	}
+
+	dir := t.TempDir()
+	if err := d.CloneTo(dir, CloneOptions{Copy: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, leaseDir)); !os.IsNotExist(err) {
+		t.Errorf("clone holds the writer lease: %v", err)
+	}
+
+	record, cloned := d.recordPath("s", "k"), filepath.Join(dir, "s", "k.json")
+	fi, err := os.Stat(cloned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("s", "k", "bbbb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(record, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.CloneTo(dir, CloneOptions{Copy: true, Incremental: true}); err != nil {
+		t.Fatal(err)
+	}
+	c := reopenTest(t, dir, nil)
+	var v string
+	if err := c.Read("s", "k", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != "bbbb" {
+		t.Errorf("incremental clone kept %q, want %q", v, "bbbb")
+	}
+}
+
+// A hardlinked clone taken while the source keeps writing opens read-only
+// and serves queries from the state it was taken in, unaffected by the
+// writes that continue on the source.
+func TestCloneUnderWrites(t *testing.T) {
+	d := openTest(t, nil)
+	writeN(t, d, 0, 50)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 1; ; round++ {
+			for i := 0; i < 50; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := d.Write("n", fmt.Sprint(i), round*100+i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	dir := t.TempDir()
+	if err := d.CloneTo(dir, CloneOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	c := reopenTest(t, dir, nil)
+	if m, err := readManifest(dir); err != nil || m.Clone == nil || m.Clone.Source != d.dir {
+		t.Errorf("the clone's manifest is %+v, %v", m, err)
+	}
+	if err := c.Write("n", "x", 0); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Write to the clone = %v, want ErrReadOnly", err)
+	}
+
+	// Reads go to the files rather than a listing cache, so a write that
+	// reached the clone through a shared link would show.
+	read := func() []int {
+		values := make([]int, 50)
+		for i := range values {
+			if err := c.Read("n", fmt.Sprint(i), &values[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return values
+	}
+	first := read()
+	for i, n := range first {
+		if n%100 != i {
+			t.Fatalf("the clone holds %d for record %d", n, i)
+		}
+	}
+	writes := d.IOStats().Writes
+	for d.IOStats().Writes < writes+100 {
+		if again := read(); !reflect.DeepEqual(again, first) {
+			t.Fatalf("writes to the source changed the clone from %v to %v", first, again)
+		}
+	}
+}
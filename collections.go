@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// collectionSet is the lazily built listing of the collections under the
+// root, used by Collections and to enforce MaxCollections.
+type collectionSet struct {
+	mutex  sync.Mutex
+	loaded bool
+	names  map[string]bool
+}
+
+// CollectionOrder selects what TopCollections ranks by.
+type CollectionOrder int
+
+const (
+	ByRecords CollectionOrder = iota
+	ByBytes
+)
+
+func (s *collectionSet) load(root string) error {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.names = make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !reserved[entry.Name()] {
+			s.names[entry.Name()] = true
+		}
+	}
+	s.loaded = true
+	return nil
+}
+
+// admitCollection is called before a write creates the directory of collection. It
+// fails with ErrTooManyCollections if that would exceed MaxCollections,
+// rereading the root first in case collections were removed behind the
+// driver's back.
+func (d *Driver) admitCollection(collection string) error {
+	max := d.opts.MaxCollections
+	name := strings.SplitN(filepath.ToSlash(collection), "/", 2)[0]
+
+	s := d.collections
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.loaded {
+		if max <= 0 {
+			return nil
+		}
+		if err := s.load(d.dir); err != nil {
+			return err
+		}
+	}
+	if s.names[name] {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, name)); err == nil {
+		s.names[name] = true
+		return nil
+	}
+
+	if max > 0 && len(s.names) >= max {
+		if err := s.load(d.dir); err != nil {
+			return err
+		}
+		if len(s.names) >= max {
+			return fmt.Errorf("%w: %s would be collection %d of %d", ErrTooManyCollections, name, len(s.names)+1, max)
+		}
+	}
+	s.names[name] = true
+	return nil
+}
+
+// forgetCollection drops a deleted top-level collection from the listing.
+func (s *collectionSet) forget(collection string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.loaded {
+		delete(s.names, collection)
+	}
+}
+
+// Collections lists the top-level collections, building the listing on
+// first use and keeping it current as the driver creates and deletes
+// collections.
+func (d *Driver) Collections() (_ []string, err error) {
+	op := d.trace(nil, "Collections", "", "")
+	defer func() { op.end(err) }()
+
+	s := d.collections
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.loaded {
+		if err := s.load(d.dir); err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RefreshCollections rereads the root, picking up collections created or
+// removed by other processes.
+func (d *Driver) RefreshCollections() (err error) {
+	op := d.trace(nil, "RefreshCollections", "", "")
+	defer func() { op.end(err) }()
+
+	s := d.collections
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.load(d.dir)
+}
+
+// TopCollections returns the n largest collections by record count or by
+// size on disk, largest first.
+func (d *Driver) TopCollections(n int, by CollectionOrder) (_ []CollectionDescription, err error) {
+	op := d.trace(nil, "TopCollections", "", "")
+	defer func() { op.end(err) }()
+
+	descriptions, err := d.describe()
+	if err != nil {
+		return nil, err
+	}
+
+	key := func(desc CollectionDescription) int64 {
+		if by == ByBytes {
+			return desc.Bytes
+		}
+		return int64(desc.Records)
+	}
+	sort.Slice(descriptions, func(i, j int) bool {
+		a, b := key(descriptions[i]), key(descriptions[j])
+		if a != b {
+			return a > b
+		}
+		return descriptions[i].Name < descriptions[j].Name
+	})
+
+	if n >= 0 && n < len(descriptions) {
+		descriptions = descriptions[:n]
+	}
+	return descriptions, nil
+}
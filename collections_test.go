@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// At MaxCollections 1000 the 1001st collection is refused, and the count
+// follows DeleteCollection and collections removed behind the driver's back.
+func TestMaxCollections(t *testing.T) {
+	d := openTest(t, &Options{MaxCollections: 1000})
+	for i := 0; i < 1000; i++ {
+		if err := d.Write(fmt.Sprintf("c%04d", i), "k", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Write("c1000", "k", 0); !errors.Is(err, ErrTooManyCollections) {
+		t.Fatalf("the 1001st collection = %v, want ErrTooManyCollections", err)
+	}
+	if err := d.Write("c0000", "more", 0); err != nil {
+		t.Errorf("a write to an existing collection at the limit = %v", err)
+	}
+
+	if _, err := d.DeleteCollection("c0000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c1000", "k", 0); err != nil {
+		t.Errorf("a new collection after DeleteCollection = %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(d.dir, "c0001")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c1001", "k", 0); err != nil {
+		t.Errorf("a new collection after an external removal = %v", err)
+	}
+	if err := d.Write("c1002", "k", 0); !errors.Is(err, ErrTooManyCollections) {
+		t.Errorf("the next collection = %v, want ErrTooManyCollections", err)
+	}
+}
+
+// TopCollections ranks collections as a brute-force count of their files
+// does, by records and by bytes.
+func TestTopCollections(t *testing.T) {
+	d := openTest(t, nil)
+	rng := rand.New(rand.NewSource(1))
+	for c := 0; c < 20; c++ {
+		collection := fmt.Sprintf("c%02d", c)
+		for r := rng.Intn(30) + 1; r > 0; r-- {
+			if err := d.Write(collection, fmt.Sprint(r), strings.Repeat("x", rng.Intn(200))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	type count struct {
+		name           string
+		records, bytes int64
+	}
+	var counts []count
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+		c := count{name: entry.Name()}
+		files, err := os.ReadDir(filepath.Join(d.dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			fi, err := file.Info()
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.records++
+			c.bytes += fi.Size()
+		}
+		counts = append(counts, c)
+	}
+
+	for _, by := range []CollectionOrder{ByRecords, ByBytes} {
+		key := func(c count) int64 {
+			if by == ByBytes {
+				return c.bytes
+			}
+			return c.records
+		}
+		sort.Slice(counts, func(i, j int) bool {
+			if a, b := key(counts[i]), key(counts[j]); a != b {
+				return a > b
+			}
+			return counts[i].name < counts[j].name
+		})
+
+		top, err := d.TopCollections(5, by)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(top) != 5 {
+			t.Fatalf("TopCollections(5) returned %d", len(top))
+		}
+		for i, desc := range top {
+			if c := counts[i]; desc.Name != c.name || int64(desc.Records) != c.records || desc.Bytes != c.bytes {
+				t.Errorf("by %d, #%d is %+v, want %+v", by, i+1, desc, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var compressions = []Compression{CompressionNone, CompressionGzip, CompressionZstd}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, c := range compressions {
+		t.Run(c.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			d := reopenTest(t, dir, &Options{Compression: c})
+			seedUsers(t, d)
+
+			if _, err := os.Stat(filepath.Join(dir, "user", "John.json"+c.ext())); err != nil {
+				t.Fatal(err)
+			}
+
+			var u User
+			if err := d.Read("user", "John", &u); err != nil {
+				t.Fatal(err)
+			}
+			if u != testUsers[0] {
+				t.Fatalf("read %+v, want %+v", u, testUsers[0])
+			}
+
+			records, err := d.ReadAll("user")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(testUsers) {
+				t.Fatalf("ReadAll returned %d records, want %d", len(records), len(testUsers))
+			}
+		})
+	}
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	rnd := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(rnd)
+	inputs := map[string][]byte{
+		"empty":  nil,
+		"byte":   []byte("a"),
+		"run":    bytes.Repeat([]byte("a"), 1000),
+		"json":   sampleRecords(2000),
+		"random": rnd,
+	}
+
+	for _, c := range compressions {
+		for name, in := range inputs {
+			b, err := c.compress(in)
+			if err != nil {
+				t.Fatalf("%s %s: %s", c, name, err)
+			}
+			out, err := c.decompress(b)
+			if err != nil {
+				t.Fatalf("%s %s: %s", c, name, err)
+			}
+			if !bytes.Equal(out, in) {
+				t.Fatalf("%s %s: round trip changed %d bytes into %d", c, name, len(in), len(out))
+			}
+		}
+	}
+}
+
+// TestZstdReferenceFrame decodes a frame written by the zstd tool at level
+// 19, which uses compressed sequence tables and a content checksum.
+func TestZstdReferenceFrame(t *testing.T) {
+	frame, _ := hex.DecodeString("28b52ffd04681d07009209201950d50383c098046fd2b64369c20ee2206d82722b6145d548c1451d0a821255d6c1a00395b44b3bdbdce665d23a8539056c4d7b2bd29041d7c129639414df6d756ef2d59977adede4abefaff957fdbb0331dfd64ffaa39e0f986598df4b59fc3c8b6fcba61fbb229d53e8f6bbde492127248051c377407c3d031fc0bd0740012fa0209e051951ce014025d5e61889c3a12aafd3ad9d4a5653dd9aa914101d594ab5a0532b9c52c7c833524faadb95a5528b4e64c4a89260d9592649429ec9a21e631d379e97f0ed00f0d1fb0be50f58ed1651eba0cb5564519a767d0d01f80f4eb58ef2")
+	b, err := zstdDecompress(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2000 || !strings.HasPrefix(string(b), "[\n  {\n    \"Name\": \"n0\",") {
+		t.Fatalf("decoded %d bytes: %.40q", len(b), b)
+	}
+
+	frame[len(frame)-1] ^= 1
+	if _, err := zstdDecompress(frame); err == nil {
+		t.Fatal("expected a checksum error")
+	}
+}
+
+func TestCompressionMismatch(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	seedUsers(t, d)
+	d.Close()
+
+	if _, err := New(dir, &Options{Logger: nopLog{}, Compression: CompressionZstd}); err == nil {
+		t.Fatal("expected opening with another compression to fail")
+	}
+
+	// Without a manifest the records themselves give the codec away.
+	os.Remove(filepath.Join(dir, manifestFile))
+	if _, err := New(dir, &Options{Logger: nopLog{}, Compression: CompressionGzip}); err == nil || !strings.Contains(err.Error(), "compression none") {
+		t.Fatalf("expected a compression mismatch, got %v", err)
+	}
+
+	d = reopenTest(t, dir, nil)
+	var u User
+	if err := d.Read("user", "John", &u); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sampleRecords(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		u := testUsers[i%len(testUsers)]
+		u.Name = fmt.Sprintf("%s%d", u.Name, i)
+		u.Contact = fmt.Sprint(23344333 + i*7)
+		b, _ := json.MarshalIndent(u, "", "\t")
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkCompression(b *testing.B) {
+	in := sampleRecords(500)
+	for _, c := range compressions {
+		b.Run(c.String(), func(b *testing.B) {
+			var out []byte
+			b.SetBytes(int64(len(in)))
+			for i := 0; i < b.N; i++ {
+				var err error
+				if out, err = c.compress(in); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := c.decompress(out); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(in))/float64(len(out)), "ratio")
+		})
+	}
+}
+
+func BenchmarkCompressedWrite(b *testing.B) {
+	for _, c := range compressions {
+		b.Run(c.String(), func(b *testing.B) {
+			d := openTest(b, &Options{Compression: c})
+			for i := 0; i < b.N; i++ {
+				if err := d.Write("user", fmt.Sprint(i), testUsers[i%len(testUsers)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Every read path decodes records stored both compressed and encrypted.
+func TestReadVariantsEncoded(t *testing.T) {
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			d := openTest(t, &Options{Compression: c, EncryptionKey: bytes.Repeat([]byte{7}, 32)})
+			seedUsers(t, d)
+			b, err := os.ReadFile(d.recordPath("user", "John"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(b, []byte("bangalore")) {
+				t.Fatal("the stored record is readable in the clear")
+			}
+
+			check := func(via string, b []byte) {
+				t.Helper()
+				var u User
+				if err := json.Unmarshal(b, &u); err != nil {
+					t.Errorf("%s returned %q: %s", via, b, err)
+				}
+			}
+
+			var u User
+			if err := d.Read("user", "John", &u); err != nil || u != testUsers[0] {
+				t.Errorf("Read = %+v, %v", u, err)
+			}
+			for _, level := range []Consistency{ConsistencyStrong, ConsistencyCached} {
+				if err := d.ReadCtx(WithConsistency(context.Background(), level), "user", "Paul", &u); err != nil || u != testUsers[1] {
+					t.Errorf("ReadCtx %s = %+v, %v", level, u, err)
+				}
+			}
+			records, err := d.ReadAll("user")
+			if err != nil || len(records) != len(testUsers) {
+				t.Fatalf("ReadAll returned %d records, %v", len(records), err)
+			}
+			for _, r := range records {
+				check("ReadAll", []byte(r))
+			}
+			var users []User
+			if err := d.ReadAllInto("user", &users); err != nil || len(users) != len(testUsers) {
+				t.Errorf("ReadAllInto read %d users, %v", len(users), err)
+			}
+			for key, b := range d.All("user") {
+				check("All "+key, b)
+			}
+			cur, err := d.Cursor("user")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for cur.Next() {
+				check("Cursor", cur.Value())
+			}
+			if err := cur.Close(); err != nil {
+				t.Error(err)
+			}
+			var buf bytes.Buffer
+			if err := d.ExportNDJSON("user", &buf); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				check("ExportNDJSON", []byte(line))
+			}
+			dump, err := d.Dump()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range dump["user"] {
+				check("Dump", b)
+			}
+			if found, err := d.FindWhere("user", map[string]interface{}{"Company": "Google"}); err != nil || len(found) != 1 {
+				t.Errorf("FindWhere found %d, %v", len(found), err)
+			}
+			if p, err := d.ReadProjection("user", "John", []string{"Name"}); err != nil || string(p) != `{"Name":"John"}` {
+				t.Errorf("ReadProjection = %s, %v", p, err)
+			}
+			if err := d.Pop("user", "Vince", &u); err != nil || u != testUsers[3] {
+				t.Errorf("Pop = %+v, %v", u, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Consistency selects how ReadCtx finds a record. ConsistencyDefault reads
+// the file without taking the collection lock, which is what Read has
+// always done.
+type Consistency int
+
+const (
+	ConsistencyDefault Consistency = iota
+	// ConsistencyStrong reads under the collection lock, so the read is
+	// ordered with respect to writes in flight.
+	ConsistencyStrong
+	// ConsistencyCached serves the record from the listing cache when the
+	// collection is cached, falling back to a default read.
+	ConsistencyCached
+	// ConsistencySnapshot reads from the most recent CloneTo snapshot taken
+	// by this driver, falling back to a default read if there is none.
+	ConsistencySnapshot
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case ConsistencyDefault:
+		return "default"
+	case ConsistencyStrong:
+		return "strong"
+	case ConsistencyCached:
+		return "cached"
+	case ConsistencySnapshot:
+		return "snapshot"
+	}
+	return fmt.Sprintf("Consistency(%d)", int(c))
+}
+
+type consistencyKey struct{}
+
+// WithConsistency returns a context that makes ReadCtx use level.
+func WithConsistency(ctx context.Context, level Consistency) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, level)
+}
+
+func consistencyOf(ctx context.Context) Consistency {
+	if ctx == nil {
+		return ConsistencyDefault
+	}
+	level, _ := ctx.Value(consistencyKey{}).(Consistency)
+	return level
+}
+
+// readConsistent returns the transformed bytes of a record read at the
+// given consistency level.
+func (d *Driver) readConsistent(op *operation, level Consistency, collection, resource string) ([]byte, error) {
+	if level >= 0 && int(level) < len(d.io.consistency) {
+		atomic.AddUint64(&d.io.consistency[level], 1)
+	}
+
+	record := filepath.Join(d.dir, collection, d.fileKey(resource))
+
+	var (
+		b   []byte
+		err error
+	)
+	switch level {
+	case ConsistencyCached:
+		if b, ok := d.listings.lookup(collection, resource); ok {
+			return b, nil
+		}
+		b, err = d.readRetrying(op.log, record)
+	case ConsistencySnapshot:
+		if dir := d.snapshotDir(); dir != "" {
+			if b, err = d.readRecord(op.log, filepath.Join(dir, collection, d.fileKey(resource))+d.ext()); err != nil {
+				return nil, err
+			}
+			return d.transform(collection, b)
+		}
+		b, err = d.readRetrying(op.log, record)
+	case ConsistencyStrong:
+		unlock := d.lockCollections(collection)
+		b, err = d.readRetrying(op.log, record)
+		unlock()
+	default:
+		b, err = d.readRetrying(op.log, record)
+	}
+
+	if isTorn(b, err) {
+		b, err = d.recoverTorn(op, collection, resource)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.readRepair(op.log, collection, resource, b)
+
+	return d.transform(collection, b)
+}
+
+func (d *Driver) snapshotDir() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.snapshot
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// Each consistency level behaves as documented around a write: Strong
+// waits for the write in progress, Cached and Snapshot may serve the older
+// version, and the default reads the file as Read always has.
+func TestConsistencyLevels(t *testing.T) {
+	d := openTest(t, &Options{ListingCacheBytes: 1 << 20})
+	if err := d.Write("n", "k", 1); err != nil {
+		t.Fatal(err)
+	}
+	read := func(level Consistency) int {
+		t.Helper()
+		var n int
+		if err := d.ReadCtx(WithConsistency(context.Background(), level), "n", "k", &n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	t.Run("strong", func(t *testing.T) {
+		unlock := d.lockCollections("n")
+		done := make(chan int)
+		go func() {
+			var n int
+			if err := d.ReadCtx(WithConsistency(context.Background(), ConsistencyStrong), "n", "k", &n); err != nil {
+				t.Error(err)
+			}
+			done <- n
+		}()
+		select {
+		case <-done:
+			t.Fatal("a strong read did not wait for the collection lock")
+		case <-time.After(20 * time.Millisecond):
+		}
+		if n := read(ConsistencyDefault); n != 1 {
+			t.Errorf("a default read during the write got %d", n)
+		}
+		if err := d.write(d.trace(nil, "Write", "n", "k"), "n", "k", 2); err != nil {
+			t.Fatal(err)
+		}
+		unlock()
+		if n := <-done; n != 2 {
+			t.Errorf("a strong read got %d, want the write it waited for", n)
+		}
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		if _, err := d.ReadAll("n"); err != nil {
+			t.Fatal(err)
+		}
+		// Another process rewrites the record behind the cache's back.
+		if err := os.WriteFile(d.recordPath("n", "k"), []byte("3"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if n := read(ConsistencyCached); n != 2 {
+			t.Errorf("a cached read got %d, want the cached 2", n)
+		}
+		if n := read(ConsistencyDefault); n != 3 {
+			t.Errorf("a default read got %d, want the file's 3", n)
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		if err := d.CloneTo(t.TempDir(), CloneOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Write("n", "k", 4); err != nil {
+			t.Fatal(err)
+		}
+		if n := read(ConsistencySnapshot); n != 3 {
+			t.Errorf("a snapshot read got %d, want the snapshot's 3", n)
+		}
+		if n := read(ConsistencyStrong); n != 4 {
+			t.Errorf("a strong read got %d, want 4", n)
+		}
+	})
+
+	stats := d.Stats()
+	if stats.StrongReads != 2 || stats.CachedReads != 1 || stats.SnapshotReads != 1 || stats.DefaultReads != 2 {
+		t.Errorf("reads by level: %d strong, %d cached, %d snapshot, %d default",
+			stats.StrongReads, stats.CachedReads, stats.SnapshotReads, stats.DefaultReads)
+	}
+}
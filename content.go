@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// PutContentAddressed stores v under the SHA-256 of its JSON encoding and
+// returns that hash. Identical content always maps to the same record, so
+// writing it again leaves the existing file untouched.
+func (d *Driver) PutContentAddressed(collection string, v interface{}) (_ string, err error) {
+	op := d.trace(nil, "PutContentAddressed", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return "", fmt.Errorf("collection is required")
+	}
+	if err := validateName(collection, ""); err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	resource := hex.EncodeToString(sum[:])
+	op.ev.Resource = resource
+
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return "", err
+	}
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return "", err
+	}
+
+	if _, err := d.stat(d.recordPath(collection, resource)); err == nil {
+		return resource, nil
+	}
+
+	return resource, d.write(op, collection, resource, v)
+}
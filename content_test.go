@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// Identical content is stored once under the SHA-256 of its encoding, and
+// writing it again leaves the file alone.
+func TestPutContentAddressed(t *testing.T) {
+	d := openTest(t, nil)
+
+	first, err := d.PutContentAddressed("blob", testUsers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(testUsers[0])
+	if sum := sha256.Sum256(b); first != hex.EncodeToString(sum[:]) {
+		t.Errorf("stored under %s, want the SHA-256 of the encoding", first)
+	}
+
+	writes := d.IOStats().Writes
+	again, err := d.PutContentAddressed("blob", testUsers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("the same content hashed to %s, then %s", first, again)
+	}
+	if n := d.IOStats().Writes - writes; n != 0 {
+		t.Errorf("writing the same content again wrote %d files", n)
+	}
+
+	other, err := d.PutContentAddressed("blob", testUsers[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Errorf("different content shares the hash %s", first)
+	}
+	keys, err := d.Keys("blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("the collection holds %d records, want 2", len(keys))
+	}
+
+	var u User
+	if err := d.Read("blob", first, &u); err != nil || u != testUsers[0] {
+		t.Errorf("Read %s = %+v, %v", first, u, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const countersDir = "_counters"
+
+type Counter struct {
+	d          *Driver
+	collection string
+	name       string
+}
+
+func (d *Driver) Counter(collection, name string) *Counter {
+	return &Counter{d: d, collection: collection, name: name}
+}
+
+func (c *Counter) path() string {
+	return filepath.Join(c.d.dir, countersDir, c.collection, c.name)
+}
+
+func (c *Counter) lock() func() {
+	mutex := c.d.getOrCreateNewMutex(filepath.Join(countersDir, c.collection, c.name))
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+func (c *Counter) validate() error {
+	if c.collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if c.name == "" {
+		return fmt.Errorf("counter name is required")
+	}
+	return c.d.checkOccupied(countersDir)
+}
+
+func (c *Counter) Value() (int64, error) {
+	if err := c.validate(); err != nil {
+		return 0, err
+	}
+	defer c.lock()()
+
+	return c.read()
+}
+
+func (c *Counter) Inc(delta int64) (int64, error) {
+	if err := c.validate(); err != nil {
+		return 0, err
+	}
+	if err := c.d.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := c.d.writeLimit.take(c.d.ctx, c.d.log, c.collection); err != nil {
+		return 0, err
+	}
+	defer c.lock()()
+
+	n, err := c.read()
+	if err != nil {
+		return 0, err
+	}
+	n += delta
+
+	path := c.path()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	if err := c.d.writeFile(path+".tmp", []byte(strconv.FormatInt(n, 10)+"\n"), true); err != nil {
+		return 0, err
+	}
+	if err := c.d.rename(path+".tmp", path); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *Counter) read() (int64, error) {
+	b, err := c.d.readFile(c.path())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// Increments from many goroutines, through two handles, are never lost, and
+// the counter outlives a reopen without showing up as a record.
+func TestCounterConcurrent(t *testing.T) {
+	const goroutines, incs = 16, 50
+	dir := t.TempDir()
+	handles := []*Driver{reopenTest(t, dir, nil), reopenTest(t, dir, nil)}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(c *Counter) {
+			defer wg.Done()
+			for i := 0; i < incs; i++ {
+				if _, err := c.Inc(1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(handles[g%2].Counter("user", "visits"))
+	}
+	wg.Wait()
+
+	for _, d := range handles {
+		d.Close()
+	}
+	d := reopenTest(t, dir, nil)
+	if n, err := d.Counter("user", "visits").Value(); err != nil || n != goroutines*incs {
+		t.Errorf("counter = %d, %v, want %d", n, err, goroutines*incs)
+	}
+	if keys, err := d.Keys("user"); err == nil && len(keys) != 0 {
+		t.Errorf("the counter shows up as records %v", keys)
+	}
+}
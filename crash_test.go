@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-database/crashtest"
+)
+
+// syncStore acknowledges writes only once they are fsynced.
+type syncStore struct{ *Driver }
+
+func (s syncStore) Write(collection, resource string, v interface{}) error {
+	return s.Put(context.Background(), collection, resource, v, WithSync())
+}
+
+// renameFirst is a driver whose rename ordering regressed: it moves the
+// temp file into place before writing it.
+type renameFirst struct{ *Driver }
+
+func (s renameFirst) Write(collection, resource string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := s.recordPath(collection, resource)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", nil, 0644); err != nil {
+		return err
+	}
+	if err := failpoint(FailpointBeforeRename); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := failpoint(FailpointAfterTempWrite); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func crashTarget(opts Options, wrap func(*Driver) crashtest.Store) crashtest.Target {
+	opts.Logger = nopLog{}
+	opts.RecoverTempFiles = TempFilesPromote
+	driver := func(s crashtest.Store) *Driver {
+		switch s := s.(type) {
+		case syncStore:
+			return s.Driver
+		case renameFirst:
+			return s.Driver
+		}
+		return s.(*Driver)
+	}
+	return crashtest.Target{
+		Open: func(dir string) (crashtest.Store, error) {
+			// Opened past the registry, as the next process would.
+			d, err := open(dir, dir, opts)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(d), nil
+		},
+		Crash: func(s crashtest.Store) {
+			d := driver(s)
+			d.cancel()
+			d.events.close()
+		},
+		Close: func(s crashtest.Store) error {
+			d := driver(s)
+			d.cancel()
+			d.packs.close()
+			return d.events.close()
+		},
+		Check: func(s crashtest.Store) error {
+			report, err := driver(s).Check(CheckOptions{Seed: 1})
+			if err != nil {
+				return err
+			}
+			if len(report.Corrupt) > 0 {
+				return fmt.Errorf("%d corrupt records, first %v", len(report.Corrupt), report.Corrupt[0])
+			}
+			return nil
+		},
+		Arm: ArmFailpoint,
+	}
+}
+
+// A crash or failure at any failpoint of the write path loses no
+// acknowledged write and tears no record.
+func TestCrashAtFailpoints(t *testing.T) {
+	target := crashTarget(Options{}, func(d *Driver) crashtest.Store { return syncStore{d} })
+	for _, fp := range []string{FailpointAfterTempWrite, FailpointBeforeRename, FailpointAfterRenameBeforeDirSync} {
+		for _, mode := range []crashtest.Mode{crashtest.Crash, crashtest.Fail} {
+			for at := 0; at < 60; at += 17 {
+				name := fmt.Sprintf("%s/%s/%d", fp, mode, at)
+				t.Run(name, func(t *testing.T) {
+					res, err := crashtest.Run(t.TempDir(), target, crashtest.Scenario{
+						Failpoint: fp,
+						Mode:      mode,
+						Writes:    60,
+						Keys:      8,
+						At:        at,
+						Seed:      int64(at),
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if want := at; mode == crashtest.Crash && res.Acknowledged != want {
+						t.Errorf("%d writes acknowledged before the crash, want %d", res.Acknowledged, want)
+					}
+					for _, v := range res.Violations {
+						t.Error(v)
+					}
+				})
+			}
+		}
+	}
+}
+
+// With GroupCommit, acknowledged writes, all of which went through a group
+// commit, survive a crash or failure at any failpoint too.
+func TestCrashGroupCommit(t *testing.T) {
+	for _, fp := range []string{FailpointAfterTempWrite, FailpointBeforeRename, FailpointAfterRenameBeforeDirSync} {
+		for _, mode := range []crashtest.Mode{crashtest.Crash, crashtest.Fail} {
+			t.Run(fmt.Sprintf("%s/%s", fp, mode), func(t *testing.T) {
+				var first *Driver
+				target := crashTarget(Options{GroupCommit: time.Millisecond}, func(d *Driver) crashtest.Store {
+					if first == nil {
+						first = d
+					}
+					return syncStore{d}
+				})
+				res, err := crashtest.Run(t.TempDir(), target, crashtest.Scenario{
+					Failpoint: fp,
+					Mode:      mode,
+					Writes:    30,
+					Keys:      4,
+					At:        20,
+					Seed:      1,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, v := range res.Violations {
+					t.Error(v)
+				}
+				if n := first.IOStats().GroupCommitWrites; n != uint64(res.Acknowledged) {
+					t.Errorf("%d of %d acknowledged writes were group committed", n, res.Acknowledged)
+				}
+			})
+		}
+	}
+}
+
+// The harness catches a driver that renames before the record is written.
+func TestCrashCatchesRenameRegression(t *testing.T) {
+	target := crashTarget(Options{}, func(d *Driver) crashtest.Store { return renameFirst{d} })
+	res, err := crashtest.Run(t.TempDir(), target, crashtest.Scenario{
+		Failpoint: FailpointAfterTempWrite,
+		Writes:    20,
+		Keys:      4,
+		At:        10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Violations) == 0 {
+		t.Fatal("crash after the rename of an empty temp file went unnoticed")
+	}
+	t.Logf("violations: %v", res.Violations)
+}
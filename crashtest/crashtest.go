@@ -0,0 +1,221 @@
+// Package crashtest checks that a database survives a crash at a failpoint
+// of its write path: it runs a workload, crashes the database when a write
+// reaches the failpoint, reopens the directory, runs the database's own
+// check and verifies that every acknowledged write is there and that every
+// record reads back whole.
+//
+// A crash here loses the process, not the machine: what was written but
+// not fsynced is still read back after the reopen.
+package crashtest
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Store is the part of the driver the harness exercises.
+type Store interface {
+	Write(collection, resource string, v interface{}) error
+	Read(collection, resource string, v interface{}) error
+}
+
+// Target is the database under test.
+type Target struct {
+	// Open opens the database in dir.
+	Open func(dir string) (Store, error)
+	// Crash discards s without closing it, as a crash would.
+	Crash func(s Store)
+	// Close closes s.
+	Close func(s Store) error
+	// Check runs the database's own consistency check, failing when it
+	// finds damage. It may be nil.
+	Check func(s Store) error
+	// Arm makes every write reaching the named failpoint call fn until
+	// disarm is called.
+	Arm func(failpoint string, fn func() error) (disarm func())
+}
+
+// Mode is what happens at the failpoint.
+type Mode int
+
+const (
+	// Crash stops the process in the middle of the write.
+	Crash Mode = iota
+	// Fail makes the write return an error, and the workload goes on.
+	Fail
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Crash:
+		return "crash"
+	case Fail:
+		return "fail"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// Scenario describes one crash test.
+type Scenario struct {
+	Failpoint  string
+	Mode       Mode
+	Collection string
+	// Writes are spread over Keys keys; write number At (from 0) is the one
+	// the failpoint hits.
+	Writes int
+	Keys   int
+	At     int
+	Seed   int64
+}
+
+// Result is what a scenario found. A Violation is an invariant the
+// reopened database broke.
+type Result struct {
+	Acknowledged int
+	Failed       int
+	Violations   []string
+}
+
+// Doc is the record the workload writes. Pad makes records of different
+// sizes, so a torn one does not pass for another.
+type Doc struct {
+	Key string
+	N   int
+	Pad string
+}
+
+// ErrInjected is what a failpoint returns in Fail mode.
+var ErrInjected = errors.New("injected failure")
+
+type crash struct{}
+
+// Run runs sc against the database t opens in dir, which should be empty.
+func Run(dir string, t Target, sc Scenario) (Result, error) {
+	if sc.Writes <= 0 || sc.Keys <= 0 || sc.At < 0 || sc.At >= sc.Writes {
+		return Result{}, fmt.Errorf("invalid scenario %+v", sc)
+	}
+	if sc.Collection == "" {
+		sc.Collection = "crash"
+	}
+
+	s, err := t.Open(dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	want := make(map[string]*outcome)
+	var res Result
+	n := 0
+	disarm := t.Arm(sc.Failpoint, func() error {
+		if n != sc.At {
+			return nil
+		}
+		if sc.Mode == Fail {
+			return ErrInjected
+		}
+		panic(crash{})
+	})
+	defer disarm()
+
+	rng := rand.New(rand.NewSource(sc.Seed))
+	crashed := false
+	for ; n < sc.Writes && !crashed; n++ {
+		doc := &Doc{Key: fmt.Sprintf("k%03d", rng.Intn(sc.Keys)), N: n, Pad: strings.Repeat("x", rng.Intn(512))}
+		o := want[doc.Key]
+		if o == nil {
+			o = &outcome{}
+			want[doc.Key] = o
+		}
+		err := write(s, sc.Collection, doc)
+		switch {
+		case err == errCrashed:
+			crashed = true
+			o.docs = append(o.docs, doc)
+		case err != nil:
+			res.Failed++
+			o.docs = append(o.docs, doc)
+		default:
+			res.Acknowledged++
+			o.docs, o.acked = []*Doc{doc}, true
+		}
+	}
+	disarm()
+	if !crashed && sc.Mode == Crash {
+		t.Close(s)
+		return res, fmt.Errorf("no write reached failpoint %s", sc.Failpoint)
+	}
+	t.Crash(s)
+
+	if s, err = t.Open(dir); err != nil {
+		res.Violations = append(res.Violations, fmt.Sprintf("reopen: %s", err))
+		return res, nil
+	}
+	defer t.Close(s)
+	if t.Check != nil {
+		if err := t.Check(s); err != nil {
+			res.Violations = append(res.Violations, fmt.Sprintf("check: %s", err))
+		}
+	}
+
+	keys := make([]string, 0, len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if v := verify(s, sc.Collection, key, want[key]); v != "" {
+			res.Violations = append(res.Violations, v)
+		}
+	}
+	return res, nil
+}
+
+var errCrashed = errors.New("crashed")
+
+// outcome is what a key may hold after the crash: the last acknowledged
+// write, if acked, or any of those after it, which did not complete.
+type outcome struct {
+	docs  []*Doc
+	acked bool
+}
+
+// write runs one write, turning the crash a failpoint panics with into
+// errCrashed.
+func write(s Store, collection string, doc *Doc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(crash); !ok {
+				panic(r)
+			}
+			err = errCrashed
+		}
+	}()
+	return s.Write(collection, doc.Key, doc)
+}
+
+// verify returns what is wrong with key after the crash, if anything.
+func verify(s Store, collection, key string, o *outcome) string {
+	var got Doc
+	err := s.Read(collection, key, &got)
+	if errors.Is(err, fs.ErrNotExist) {
+		if o.acked {
+			return fmt.Sprintf("%s: lost acknowledged write %d", key, o.docs[0].N)
+		}
+		return ""
+	}
+	if err != nil {
+		return fmt.Sprintf("%s: torn read: %s", key, err)
+	}
+	list := make([]string, len(o.docs))
+	for i, doc := range o.docs {
+		if got == *doc {
+			return ""
+		}
+		list[i] = fmt.Sprint(doc.N)
+	}
+	return fmt.Sprintf("%s holds write %d, want one of writes %s", key, got.N, strings.Join(list, ", "))
+}
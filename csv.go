@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// ExportCSV writes collection as CSV with a header row of columns, which
+// are dotted field paths such as "Address.City". Missing fields and nulls
+// are written as empty cells, nested objects and arrays as JSON.
+func (d *Driver) ExportCSV(collection string, columns []string, w io.Writer) (err error) {
+	op := d.trace(nil, "ExportCSV", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("columns are required")
+	}
+
+	dir := filepath.Join(d.dir, collection)
+
+	files, err := d.readDir(dir)
+	if err != nil {
+		return err
+	}
+	files, _ = d.unexpired(dir, files)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+
+	row := make([]string, len(columns))
+	for _, file := range files {
+		b, err := d.readRecord(op.log, filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		op.bytes += int64(len(b))
+
+		if b, err = d.transform(collection, b); err != nil {
+			return err
+		}
+
+		doc, err := decodeDocument(b)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %s", collection, file.Name(), err)
+		}
+		for i, column := range columns {
+			v, _ := lookup(doc, column)
+			if row[i], err = csvCell(v); err != nil {
+				return err
+			}
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvCell(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	default:
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
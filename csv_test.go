@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// ExportCSV writes a header and one row per user with the named columns,
+// nested ones by dotted path and missing ones empty.
+func TestExportCSV(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	if err := os.Remove(d.recordPath("user", "Neo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("user", "Neo", map[string]interface{}{"Name": "Neo", "Age": 31}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := d.ExportCSV("user", []string{"Name", "Age", "Address.Country"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Name,Age,Address.Country\n" +
+		"Albert,32,india\n" +
+		"John,23,india\n" +
+		"Neo,31,\n" +
+		"Paul,25,USA\n" +
+		"Robert,27,india\n" +
+		"Vince,29,india\n"
+	if buf.String() != want {
+		t.Errorf("exported\n%s\nwant\n%s", buf.String(), want)
+	}
+}
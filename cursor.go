@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Cursor walks the records of a collection in name order, as they were
+	// when it was opened:
+	//
+	//	c, err := db.Cursor("user")
+	//	defer c.Close()
+	//	for c.Next() { ... c.Resource(), c.Value() ... }
+	//	err = c.Err()
+	//
+	// In a packed collection the cursor pins the generation it opened, so
+	// it reads the same records to the end whatever is written, deleted or
+	// compacted meanwhile; Compact keeps the packs it replaced until every
+	// cursor reading them is closed. Other collections are read as the
+	// cursor reaches each record, skipping those deleted since it opened.
+	Cursor struct {
+		d          *Driver
+		collection string
+		opened     time.Time
+		names      []string
+		packs      *packStore
+		pin        *packPin
+
+		next     int
+		resource string
+		value    []byte
+		err      error
+		closed   bool
+	}
+
+	// CursorInfo describes a cursor that is still open. One open for long
+	// may have been forgotten, keeping what Compact replaced on disk.
+	CursorInfo struct {
+		Collection string
+		Age        time.Duration
+		Pinned     bool
+	}
+
+	cursorSet struct {
+		mutex sync.Mutex
+		open  map[*Cursor]bool
+	}
+)
+
+// Cursor opens a cursor on collection. It must be closed.
+func (d *Driver) Cursor(collection string) (_ *Cursor, err error) {
+	op := d.trace(nil, "Cursor", collection, "")
+	defer func() { op.end(err) }()
+	defer func() { err = wrapOp("read", collection, "", err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+	if err := d.readLimit.take(d.ctx, op.log, collection); err != nil {
+		return nil, err
+	}
+	defer d.lockCollections(collection)()
+
+	dir := filepath.Join(d.dir, collection)
+	files, err := d.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, _ = d.unexpired(dir, files)
+
+	c := &Cursor{d: d, collection: collection, opened: time.Now()}
+	for _, file := range files {
+		c.names = append(c.names, file.Name())
+	}
+	if c.packs, err = d.packsOf(collection); err != nil {
+		return nil, err
+	}
+	if c.packs != nil {
+		c.pin = c.packs.pin()
+	}
+
+	d.cursors.mutex.Lock()
+	d.cursors.open[c] = true
+	d.cursors.mutex.Unlock()
+	return c, nil
+}
+
+// Next moves to the next record, returning false at the end or on an
+// error, which Err then returns.
+func (c *Cursor) Next() bool {
+	for c.err == nil && !c.closed && c.next < len(c.names) {
+		name := c.names[c.next]
+		c.next++
+
+		resource := c.d.resourceOf(name)
+		b, err := c.read(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			b, err = c.d.transform(c.collection, b)
+		}
+		if err != nil {
+			c.err = wrapOp("read", c.collection, resource, err)
+			return false
+		}
+		c.resource, c.value = resource, b
+		return true
+	}
+	return false
+}
+
+func (c *Cursor) read(name string) ([]byte, error) {
+	if c.pin != nil {
+		// The pack checks the bytes; a checksum sidecar describes the
+		// record as it is now, not as pinned.
+		b, ok, err := c.pin.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return c.d.decodeStored(b)
+		}
+	}
+	return c.d.readRecord(c.d.log, filepath.Join(c.d.dir, c.collection, name))
+}
+
+// Resource returns the key of the current record.
+func (c *Cursor) Resource() string {
+	return c.resource
+}
+
+// Value returns the current record.
+func (c *Cursor) Value() json.RawMessage {
+	return c.value
+}
+
+// Decode unmarshals the current record into v.
+func (c *Cursor) Decode(v interface{}) error {
+	return json.Unmarshal(c.value, v)
+}
+
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close releases the generation the cursor pinned. It is safe to call more
+// than once.
+func (c *Cursor) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	c.d.cursors.mutex.Lock()
+	delete(c.d.cursors.open, c)
+	c.d.cursors.mutex.Unlock()
+
+	if c.pin == nil {
+		return nil
+	}
+	return c.packs.release(c.pin)
+}
+
+// Cursors lists the cursors not yet closed, oldest first.
+func (d *Driver) Cursors() []CursorInfo {
+	return d.cursors.describe()
+}
+
+// describe returns the open cursors, oldest first.
+func (s *cursorSet) describe() []CursorInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	infos := make([]CursorInfo, 0, len(s.open))
+	for c := range s.open {
+		infos = append(infos, CursorInfo{Collection: c.collection, Age: now.Sub(c.opened), Pinned: c.pin != nil})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Age > infos[j].Age })
+	return infos
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A cursor held across a Compact reads the records as they were when it
+// opened, and the packs Compact replaced stay until it is closed.
+func TestCursorPinsGeneration(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, &Options{Packed: map[string]bool{"p": true}, PackSize: 4 << 10})
+	writePacked(t, d, 0, 100)
+
+	c, err := d.Cursor("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	old, err := filepath.Glob(filepath.Join(dir, "p", packsDir, "*"+packExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := 0
+	next := func() {
+		t.Helper()
+		var doc packedDoc
+		if err := c.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("r%04d", read); c.Resource() != want || doc.N != read {
+			t.Fatalf("cursor read %s holding %d, want %s holding %d", c.Resource(), doc.N, want, read)
+		}
+		read++
+	}
+	for read < 10 && c.Next() {
+		next()
+	}
+
+	for i := 0; i < 100; i++ {
+		resource := fmt.Sprintf("r%04d", i)
+		if i%2 == 0 {
+			if err := d.Delete("p", resource); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := d.Write("p", resource, packedDoc{N: -i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Compact("p"); err != nil {
+		t.Fatal(err)
+	}
+
+	if cursors := d.Cursors(); len(cursors) != 1 || cursors[0].Collection != "p" || !cursors[0].Pinned || cursors[0].Age <= 0 {
+		t.Errorf("Cursors = %+v, want the pinned one on p", cursors)
+	}
+	for _, pack := range old {
+		if _, err := os.Stat(pack); err != nil {
+			t.Errorf("pack still pinned by the cursor: %s", err)
+		}
+	}
+
+	for c.Next() {
+		next()
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if read != 100 {
+		t.Errorf("cursor read %d records, want 100", read)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pack := range old {
+		if _, err := os.Stat(pack); !os.IsNotExist(err) {
+			t.Errorf("%s outlived the cursor: %v", filepath.Base(pack), err)
+		}
+	}
+	if cursors := d.Cursors(); len(cursors) != 0 {
+		t.Errorf("Cursors = %+v after Close", cursors)
+	}
+
+	var doc packedDoc
+	if err := d.Read("p", "r0001", &doc); err != nil || doc.N != -1 {
+		t.Errorf("Read after Compact = %+v, %v, want N -1", doc, err)
+	}
+}
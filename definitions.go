@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Definitions is the declarative configuration of a collection. Applied
+// definitions are kept in the collection's _meta.json and take precedence
+// over the matching Options for that collection.
+type Definitions struct {
+	KeyFields  []string             `json:",omitempty"`
+	References map[string]Reference `json:",omitempty"`
+}
+
+// ApplyOptions controls ApplyDefinitions. Definitions present on the
+// collection but missing from the applied document are kept with a
+// warning unless Prune is set. DryRun only logs what would change.
+type ApplyOptions struct {
+	Prune  bool
+	DryRun bool
+}
+
+func (a ReferenceAction) String() string {
+	switch a {
+	case Restrict:
+		return "restrict"
+	case Cascade:
+		return "cascade"
+	case SetNull:
+		return "setnull"
+	}
+	return fmt.Sprintf("ReferenceAction(%d)", int(a))
+}
+
+func (a ReferenceAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *ReferenceAction) UnmarshalText(b []byte) error {
+	for _, action := range []ReferenceAction{Restrict, Cascade, SetNull} {
+		if strings.EqualFold(string(b), action.String()) {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown reference action %q", b)
+}
+
+// keyFields returns the key fields in effect for collection.
+func (d *Driver) keyFields(collection string) []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if defs, ok := d.defs[collection]; ok && len(defs.KeyFields) > 0 {
+		return defs.KeyFields
+	}
+	return d.opts.KeyFields[collection]
+}
+
+// referencesOf returns the references in effect for collection.
+func (d *Driver) referencesOf(collection string) map[string]Reference {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if defs, ok := d.defs[collection]; ok && defs.References != nil {
+		return defs.References
+	}
+	return d.opts.References[collection]
+}
+
+// referencingCollections lists every collection with references in effect.
+func (d *Driver) referencingCollections() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	seen := make(map[string]bool)
+	for collection := range d.opts.References {
+		seen[collection] = true
+	}
+	for collection, defs := range d.defs {
+		if defs.References != nil {
+			seen[collection] = true
+		}
+	}
+
+	collections := make([]string, 0, len(seen))
+	for collection := range seen {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+func (d *Driver) ExportDefinitions(collection string) (_ Definitions, err error) {
+	op := d.trace(nil, "ExportDefinitions", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return Definitions{}, fmt.Errorf("collection is required")
+	}
+
+	defs := Definitions{KeyFields: d.keyFields(collection)}
+	if refs := d.referencesOf(collection); len(refs) > 0 {
+		defs.References = make(map[string]Reference, len(refs))
+		for field, ref := range refs {
+			defs.References[field] = ref
+		}
+	}
+	return defs, nil
+}
+
+// ApplyDefinitions brings collection in line with defs, logging each
+// difference, and persists the result so it survives a restart. The
+// reference index is rebuilt when references change.
+func (d *Driver) ApplyDefinitions(collection string, defs Definitions, opts ApplyOptions) (err error) {
+	op := d.trace(nil, "ApplyDefinitions", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if err := validateName(collection, ""); err != nil {
+		return err
+	}
+	for field, ref := range defs.References {
+		if field == "" || ref.Collection == "" {
+			return fmt.Errorf("reference %q needs a field and a collection", field)
+		}
+	}
+
+	current, err := d.ExportDefinitions(collection)
+	if err != nil {
+		return err
+	}
+
+	next := Definitions{KeyFields: defs.KeyFields, References: make(map[string]Reference)}
+	if len(next.KeyFields) == 0 {
+		next.KeyFields = current.KeyFields
+	} else if !reflect.DeepEqual(next.KeyFields, current.KeyFields) {
+		op.log.Info("%s: key fields %v -> %v", collection, current.KeyFields, next.KeyFields)
+	}
+
+	for field, ref := range defs.References {
+		if old, ok := current.References[field]; !ok {
+			op.log.Info("%s: adding reference %s -> %s (%s)", collection, field, ref.Collection, ref.OnDelete)
+		} else if old != ref {
+			op.log.Info("%s: changing reference %s -> %s (%s)", collection, field, ref.Collection, ref.OnDelete)
+		}
+		next.References[field] = ref
+	}
+	for field, ref := range current.References {
+		if _, ok := defs.References[field]; ok {
+			continue
+		}
+		if opts.Prune {
+			op.log.Info("%s: removing reference %s -> %s", collection, field, ref.Collection)
+			continue
+		}
+		op.log.Warn("%s: reference %s -> %s is not in the applied definitions; keeping it", collection, field, ref.Collection)
+		next.References[field] = ref
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkOccupied(metaPath(collection)); err != nil {
+		return err
+	}
+
+	defer d.lockCollections(collection)()
+
+	if err := os.MkdirAll(filepath.Join(d.dir, collection), 0755); err != nil {
+		return err
+	}
+	meta, err := d.readMeta(collection)
+	if err != nil {
+		return err
+	}
+	meta.Definitions = &next
+	if err := d.writeMeta(collection, meta); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.defs[collection] = next
+	d.mutex.Unlock()
+
+	if !reflect.DeepEqual(current.References, next.References) {
+		d.references.reset(collection)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Definitions exported from one database, serialized and applied to a fresh
+// one re-export identically, and survive a reopen of the fresh one.
+func TestDefinitionsExportApply(t *testing.T) {
+	staging := openTest(t, &Options{
+		KeyFields:  map[string][]string{"order": {"ID"}},
+		References: map[string]map[string]Reference{"order": {"User": {Collection: "user", OnDelete: Cascade}}},
+	})
+	exported, err := staging.ExportDefinitions("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var defs Definitions
+	if err := json.Unmarshal(b, &defs); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	production := reopenTest(t, dir, nil)
+	if err := production.ApplyDefinitions("order", defs, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	production.Close()
+
+	production = reopenTest(t, dir, nil)
+	again, err := production.ExportDefinitions("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, exported) {
+		t.Errorf("re-exported %+v, want %+v", again, exported)
+	}
+
+	seedUsers(t, production)
+	if err := production.Write("order", "o1", order{"o1", "John"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := production.Delete("user", "John"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := production.Keys("order"); len(keys) != 0 {
+		t.Errorf("the applied cascade left orders %v", keys)
+	}
+}
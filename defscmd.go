@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jcelliott/lumber"
+)
+
+// runDefs implements `godb defs export|apply`. Export prints a collection's
+// definitions as JSON; apply reads them from a file (or stdin with "-").
+func runDefs(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: godb defs export -dir DIR COLLECTION")
+		fmt.Fprintln(os.Stderr, "       godb defs apply -dir DIR [-prune] [-dry-run] COLLECTION FILE")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+
+	flags := flag.NewFlagSet("defs "+args[0], flag.ContinueOnError)
+	dir := flags.String("dir", "./db", "database directory")
+	prune := flags.Bool("prune", false, "remove definitions missing from FILE")
+	dryRun := flags.Bool("dry-run", false, "only log what would change")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	db, err := New(*dir, &Options{Logger: lumber.NewConsoleLogger(lumber.INFO)})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	switch {
+	case args[0] == "export" && flags.NArg() == 1:
+		defs, err := db.ExportDefinitions(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		b, err := json.MarshalIndent(defs, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(b))
+	case args[0] == "apply" && flags.NArg() == 2:
+		var b []byte
+		if flags.Arg(1) == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			b, err = ioutil.ReadFile(flags.Arg(1))
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		var defs Definitions
+		if err := json.Unmarshal(b, &defs); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Arg(1), err)
+			return 1
+		}
+		if err := db.ApplyDefinitions(flags.Arg(0), defs, ApplyOptions{Prune: *prune, DryRun: *dryRun}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		return usage()
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+type CollectionDescription struct {
+	Name    string
+	Records int
+	Bytes   int64
+	ModTime time.Time
+}
+
+// Describe lists every collection with its record count, size on disk and
+// last modification time, reading each directory once. ModTime follows
+// CollectionModTime: the newest record, or the directory for an empty
+// collection.
+func (d *Driver) Describe() (_ []CollectionDescription, err error) {
+	op := d.trace(nil, "Describe", "", "")
+	defer func() { op.end(err) }()
+
+	return d.describe()
+}
+
+func (d *Driver) describe() ([]CollectionDescription, error) {
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptions []CollectionDescription
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+
+		files, err := d.readDir(filepath.Join(d.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		desc := CollectionDescription{Name: entry.Name()}
+		for _, file := range files {
+			if file.IsDir() || !d.isRecord(file.Name()) {
+				continue
+			}
+			desc.Records++
+			desc.Bytes += file.Size()
+			if file.ModTime().After(desc.ModTime) {
+				desc.ModTime = file.ModTime()
+			}
+		}
+		if desc.ModTime.IsZero() {
+			desc.ModTime = entry.ModTime()
+		}
+
+		descriptions = append(descriptions, desc)
+	}
+
+	return descriptions, nil
+}
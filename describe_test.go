@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Describe counts the records of every collection, sums their sizes and
+// reports the newest modification time.
+func TestDescribe(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	writeN(t, d, 0, 5)
+	if err := d.Write("empty", "x", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("empty", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	newest := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	want := map[string]*CollectionDescription{
+		"user": {Name: "user", Records: len(testUsers), ModTime: newest},
+		"n":    {Name: "n", Records: 5, ModTime: newest.Add(-time.Hour)},
+	}
+	for collection, desc := range want {
+		keys, err := d.Keys(collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, key := range keys {
+			path := d.recordPath(collection, key)
+			mtime := desc.ModTime.Add(-time.Duration(i) * time.Minute)
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc.Bytes += fi.Size()
+		}
+	}
+	fi, err := os.Stat(filepath.Join(d.dir, "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want["empty"] = &CollectionDescription{Name: "empty", ModTime: fi.ModTime()}
+
+	descriptions, err := d.Describe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(descriptions) != len(want) {
+		t.Fatalf("described %d collections, want %d", len(descriptions), len(want))
+	}
+	for _, got := range descriptions {
+		if w := want[got.Name]; w == nil || !reflect.DeepEqual(got, *w) {
+			t.Errorf("described %+v, want %+v", got, w)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type DropReport struct {
+	Collections int
+	Records     int
+	Bytes       int64
+}
+
+func (d *Driver) DeleteCollection(collection string) (_ DropReport, err error) {
+	op := d.trace(nil, "DeleteCollection", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return DropReport{}, fmt.Errorf("collection is required")
+	}
+	if reserved[collection] {
+		return DropReport{}, fmt.Errorf("%s is reserved", collection)
+	}
+	if err := validateName(collection, ""); err != nil {
+		return DropReport{}, err
+	}
+
+	return d.dropCollection(op, collection, map[string]bool{collection: true})
+}
+
+// dropCollection removes collection and its state. Records of collections
+// outside dropping that reference it get their delete actions first.
+func (d *Driver) dropCollection(op *operation, collection string, dropping map[string]bool) (DropReport, error) {
+	scope := d.deleteScope(collection)
+	defer d.lockDropping(collection, scope...)()
+
+	if err := d.checkWritable(scope...); err != nil {
+		return DropReport{}, err
+	}
+
+	deletes, nulls, err := d.planDrop(collection, dropping)
+	if err != nil {
+		return DropReport{}, err
+	}
+	if err := d.applyDelete(op, deletes, nulls, dropping); err != nil {
+		return DropReport{}, err
+	}
+
+	dir := filepath.Join(d.dir, collection)
+
+	report := DropReport{Collections: 1}
+	files, err := d.readDir(dir)
+	if err != nil {
+		return DropReport{}, err
+	}
+	for _, file := range files {
+		if !file.IsDir() && d.isRecord(file.Name()) {
+			report.Records++
+			report.Bytes += file.Size()
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return DropReport{}, err
+	}
+	if err := d.mirror(op.log, dir); err != nil {
+		return report, err
+	}
+	if err := os.RemoveAll(filepath.Join(d.dir, countersDir, collection)); err != nil {
+		return report, err
+	}
+	if err := d.dropKeyIndex(collection); err != nil {
+		return report, err
+	}
+	if d.opts.BackupDir != "" {
+		if err := os.RemoveAll(filepath.Join(d.opts.BackupDir, collection)); err != nil {
+			return report, err
+		}
+	}
+
+	d.events.emit(EventDelete, collection, "")
+	d.forget(collection)
+
+	return report, nil
+}
+
+// forget drops the in-memory state kept for a collection that no longer
+// exists. It must be called with the collection mutex held.
+func (d *Driver) forget(collection string) {
+	d.listings.invalidate(collection)
+	d.references.reset(collection)
+	d.events.forget(collection)
+	d.access.forget(collection)
+	d.collections.forget(collection)
+	d.indexes.forget(collection)
+	d.packs.forget(collection)
+
+	d.mutex.Lock()
+	delete(d.frozen, collection)
+	d.mutex.Unlock()
+}
+
+func (d *Driver) PrepareDrop() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+
+	d.mutex.Lock()
+	d.dropToken = token
+	d.mutex.Unlock()
+
+	return token
+}
+
+// Drop removes every collection in the database. confirm must be the
+// database path or a token returned by PrepareDrop.
+func (d *Driver) Drop(confirm string) (_ DropReport, err error) {
+	op := d.trace(nil, "Drop", "", "")
+	defer func() { op.end(err) }()
+
+	d.mutex.Lock()
+	token := d.dropToken
+	ok := confirm != "" && (confirm == d.dir || confirm == d.key || confirm == token)
+	if ok && confirm == token {
+		d.dropToken = ""
+	}
+	d.mutex.Unlock()
+
+	if !ok {
+		return DropReport{}, fmt.Errorf("drop of %s not confirmed", d.dir)
+	}
+
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return DropReport{}, err
+	}
+
+	var collections []string
+	for _, entry := range entries {
+		if !reserved[entry.Name()] {
+			collections = append(collections, entry.Name())
+		}
+	}
+	sort.Strings(collections)
+
+	// A handle that may not write, or a frozen collection, stops the drop
+	// before anything is removed, stray files included.
+	if err := d.checkWritable(collections...); err != nil {
+		return DropReport{}, err
+	}
+
+	dropping := make(map[string]bool, len(collections))
+	for _, collection := range collections {
+		dropping[collection] = true
+	}
+
+	var report DropReport
+	for _, collection := range collections {
+		path := filepath.Join(d.dir, collection)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			if err := os.Remove(path); err != nil {
+				return report, err
+			}
+			if err := d.mirror(op.log, path); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		r, err := d.dropCollection(op, collection, dropping)
+		if err != nil {
+			return report, err
+		}
+		report.Collections += r.Collections
+		report.Records += r.Records
+		report.Bytes += r.Bytes
+	}
+
+	op.log.Info("Dropped %d collections (%d records, %d bytes) from %s", report.Collections, report.Records, report.Bytes, d.dir)
+	return report, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDropConfirmation(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, &Options{ListingCacheBytes: 1 << 20})
+	seedUsers(t, d)
+	if _, err := d.ReadAll("user"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Drop("wrong"); err == nil {
+		t.Fatal("expected a wrong confirmation to fail")
+	}
+	if records, _ := d.ReadAll("user"); len(records) != len(testUsers) {
+		t.Fatalf("wrong confirmation removed records: %d left", len(records))
+	}
+
+	report, err := d.Drop(d.PrepareDrop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Collections != 1 || report.Records != len(testUsers) || report.Bytes == 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if _, err := os.Stat(dir + "/user"); !os.IsNotExist(err) {
+		t.Fatalf("user still exists: %v", err)
+	}
+	if records, _ := d.ReadAll("user"); len(records) != 0 {
+		t.Fatalf("cached listing survived the drop: %d records", len(records))
+	}
+
+	// The token is single use.
+	if _, err := d.Drop(d.dropToken); err == nil {
+		t.Fatal("expected a used token to fail")
+	}
+
+	d.Close()
+	d = reopenTest(t, dir, nil)
+	if err := d.Write("user", "John", testUsers[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteCollectionKeepsWaitedMutex(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	// A caller that got the mutex before the drop must keep sharing it with
+	// everyone after, or two writers could hold the collection at once.
+	waiting := d.getOrCreateNewMutex("user")
+	if _, err := d.DeleteCollection("user"); err != nil {
+		t.Fatal(err)
+	}
+	if m := d.getOrCreateNewMutex("user"); m != waiting {
+		t.Fatal("DeleteCollection replaced a mutex that had users")
+	}
+	waiting.Lock()
+	waiting.Unlock()
+	waiting.Lock()
+	waiting.Unlock()
+
+	if _, err := d.DeleteCollection("user"); err == nil {
+		t.Fatal("expected deleting a missing collection to fail")
+	}
+	d.mutex.Lock()
+	_, ok := d.mutexes["user"]
+	d.mutex.Unlock()
+	if ok {
+		t.Fatal("mutex of a dropped collection was kept")
+	}
+}
+
+func TestDropLeavesReservedState(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	seedUsers(t, d)
+	if _, err := d.Drop(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !reserved[entry.Name()] {
+			t.Fatalf("%s survived the drop", entry.Name())
+		}
+	}
+}
+
+func TestDropReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	seedUsers(t, reopenTest(t, dir, nil))
+	stray := filepath.Join(dir, "stray.txt")
+	if err := ioutil.WriteFile(stray, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := open(dir, dir, Options{Logger: nopLog{}, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.cancel() })
+
+	if _, err := d.Drop(dir); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Drop on a read-only handle = %v, want ErrReadOnly", err)
+	}
+	if _, err := os.Stat(stray); err != nil {
+		t.Errorf("the refused Drop removed a stray file: %v", err)
+	}
+	if records, _ := d.ReadAll("user"); len(records) != len(testUsers) {
+		t.Errorf("the refused Drop removed records: %d left", len(records))
+	}
+}
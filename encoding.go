@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+func (d *Driver) newEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(!d.opts.DisableHTMLEscape)
+	if d.opts.Indent != "" {
+		enc.SetIndent("", d.opts.Indent)
+	}
+	return enc
+}
+
+// marshal encodes v the way records are stored, without the trailing
+// newline.
+func (d *Driver) marshal(v interface{}) ([]byte, error) {
+	if !d.opts.DisableHTMLEscape && d.opts.Indent == "" {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	if err := d.newEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// streamable reports whether records of collection can be encoded
+// straight into the temp file, i.e. nothing in store needs the encoded
+// bytes first.
+func (d *Driver) streamable(collection string) bool {
+	return d.opts.StreamWrites &&
+		!d.packed(collection) &&
+		!d.opts.VerifyRoundTrip &&
+		d.opts.VersionField == "" &&
+		d.opts.UpdatedAtField == "" &&
+		!d.opts.VerifyChecksums &&
+		!d.opts.ValidateUTF8 &&
+		d.opts.AuditLog == nil &&
+		d.opts.Compression == CompressionNone &&
+		d.aead == nil &&
+		d.pipelineOf(collection) == nil &&
+		d.opts.Normalizers[collection] == nil &&
+		len(d.referencesOf(collection)) == 0 &&
+		len(d.indexes.of(collection)) == 0
+}
+
+// storeStream is store for streamable collections: v is encoded directly
+// into the temp file, saving the copy json.Marshal returns.
+func (d *Driver) storeStream(op *operation, collection, resource string, v interface{}) error {
+	dir := filepath.Join(d.dir, collection)
+	fnlPath := d.recordPath(collection, resource)
+	tmpPath := d.tempPath(op, fnlPath)
+
+	if err := d.checkGuards(op, collection, resource); err != nil {
+		return err
+	}
+	if err := d.admitCollection(collection); err != nil {
+		return err
+	}
+	if err := d.recordLongKey(resource); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := d.rejectSymlinks(op.log, dir, fnlPath, tmpPath); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := &countingWriter{w: f}
+	err = d.newEncoder(w).Encode(v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	op.bytes = w.n
+	atomic.AddUint64(&d.io.writes, 1)
+	atomic.AddUint64(&d.io.bytesWritten, uint64(w.n))
+	atomic.AddUint64(&d.io.tempFiles, 1)
+
+	if err := failpoint(FailpointAfterTempWrite); err != nil {
+		return err
+	}
+	return d.commitRecord(op, collection, resource, tmpPath, fnlPath, nil, nil)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
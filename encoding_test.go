@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// With HTML escaping disabled < and & are stored literally, whether the
+// record is marshalled or streamed into the temp file, and read back
+// unchanged.
+func TestDisableHTMLEscape(t *testing.T) {
+	const text = "<b>Tom & Jerry</b>"
+	for _, opts := range []Options{
+		{},
+		{DisableHTMLEscape: true},
+		{DisableHTMLEscape: true, StreamWrites: true, Indent: "\t"},
+	} {
+		d := openTest(t, &opts)
+		if err := d.Write("s", "k", map[string]string{"Text": text}); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(d.recordPath("s", "k"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if literal := strings.Contains(string(b), text); literal != opts.DisableHTMLEscape {
+			t.Errorf("with %+v the record holds %s", opts, b)
+		}
+		if opts.Indent != "" && !strings.Contains(string(b), "\n\t\"Text\"") {
+			t.Errorf("with %+v the record is not indented: %s", opts, b)
+		}
+
+		var got map[string]string
+		if err := d.Read("s", "k", &got); err != nil || got["Text"] != text {
+			t.Errorf("with %+v the record reads %q, %v", opts, got, err)
+		}
+	}
+}
+
+// With ValidateUTF8 raw documents holding invalid UTF-8 are refused with
+// ErrInvalidEncoding before any file is created, and a modified record is
+// left as it was.
+func TestValidateUTF8(t *testing.T) {
+	d := openTest(t, &Options{ValidateUTF8: true})
+	seedUsers(t, d)
+
+	ndjson := strings.NewReader("{\"Name\":\"Zo\xffe\"}\n")
+	err := d.ImportNDJSON("raw", ndjson, func(json.RawMessage) (string, error) { return "zoe", nil })
+	if !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("ImportNDJSON of invalid UTF-8 = %v, want ErrInvalidEncoding", err)
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, "raw")); !os.IsNotExist(err) {
+		t.Errorf("the refused import created the collection: %v", err)
+	}
+
+	before, err := os.ReadFile(d.recordPath("user", "John"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Modify("user", "John", func([]byte) ([]byte, error) {
+		return []byte("{\"Name\":\"Jo\xc3\"}"), nil
+	})
+	if !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("Modify to invalid UTF-8 = %v, want ErrInvalidEncoding", err)
+	}
+	if after, err := os.ReadFile(d.recordPath("user", "John")); err != nil || !bytes.Equal(after, before) {
+		t.Errorf("the refused Modify changed the record to %q, %v", after, err)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(d.dir, "user", "*.tmp")); len(temps) != 0 {
+		t.Errorf("temp files left behind: %v", temps)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// encryptedMagic prefixes records sealed with Options.EncryptionKey.
+var encryptedMagic = []byte("GDBE1\x00")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("EncryptionKey: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encodeForStore turns a JSON document into the bytes stored on disk:
+// passed through the collection's CodecConfig, compressed, then encrypted.
+// Every write path goes through it.
+func (d *Driver) encodeForStore(collection string, b []byte) ([]byte, error) {
+	var err error
+	if p := d.pipelineOf(collection); p != nil {
+		if b, err = p.encode(b); err != nil {
+			return nil, err
+		}
+	}
+	if b, err = d.opts.Compression.compress(b); err != nil || d.aead == nil {
+		return b, err
+	}
+
+	nonce := make([]byte, d.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(encryptedMagic)+len(nonce)+len(b)+d.aead.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, nonce...)
+	return d.aead.Seal(out, nonce, b, nil), nil
+}
+
+// decodeStored reverses encodeForStore. Every read path goes through it.
+// Records written before EncryptionKey was set are read as they are, and
+// get encrypted when next written.
+func (d *Driver) decodeStored(b []byte) ([]byte, error) {
+	if bytes.HasPrefix(b, encryptedMagic) {
+		if d.aead == nil {
+			return nil, fmt.Errorf("record is encrypted and no EncryptionKey is set")
+		}
+		sealed := b[len(encryptedMagic):]
+		if len(sealed) < d.aead.NonceSize() {
+			return nil, fmt.Errorf("encrypted record is truncated")
+		}
+		nonce := sealed[:d.aead.NonceSize()]
+		var err error
+		if b, err = d.aead.Open(nil, nonce, sealed[len(nonce):], nil); err != nil {
+			return nil, fmt.Errorf("unable to decrypt record: %w", err)
+		}
+	}
+	b, err := d.opts.Compression.decompress(b)
+	if err == nil && bytes.HasPrefix(b, pipelineMagic) {
+		return decodePipeline(b)
+	}
+	return b, err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Failpoints in the write path, for crash-consistency testing.
+const (
+	FailpointAfterTempWrite           = "after-temp-write"
+	FailpointBeforeRename             = "before-rename"
+	FailpointAfterRenameBeforeDirSync = "after-rename-before-dirsync"
+)
+
+var failpoints struct {
+	armed int32
+	mutex sync.Mutex
+	fns   map[string]func() error
+}
+
+// ArmFailpoint makes every write reaching the named failpoint call fn
+// until disarm is called. An error from fn fails the write at that point;
+// fn may also panic to simulate a crash, leaving the files as they are.
+// Failpoints are process-wide and cost one atomic load when none is armed.
+func ArmFailpoint(name string, fn func() error) (disarm func()) {
+	failpoints.mutex.Lock()
+	defer failpoints.mutex.Unlock()
+
+	if failpoints.fns == nil {
+		failpoints.fns = make(map[string]func() error)
+	}
+	failpoints.fns[name] = fn
+	atomic.StoreInt32(&failpoints.armed, int32(len(failpoints.fns)))
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			failpoints.mutex.Lock()
+			defer failpoints.mutex.Unlock()
+			delete(failpoints.fns, name)
+			atomic.StoreInt32(&failpoints.armed, int32(len(failpoints.fns)))
+		})
+	}
+}
+
+func failpoint(name string) error {
+	if atomic.LoadInt32(&failpoints.armed) == 0 {
+		return nil
+	}
+	failpoints.mutex.Lock()
+	fn := failpoints.fns[name]
+	failpoints.mutex.Unlock()
+	if fn == nil {
+		return nil
+	}
+	return fn()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FixtureOptions controls LoadFixtures. Collections named in Order are
+// loaded first, in that order, so parents can be written before the
+// records referencing them; the rest follow alphabetically.
+type FixtureOptions struct {
+	Truncate bool
+	Order    []string
+}
+
+// LoadFixtures writes every document found at path through Write and
+// returns how many were loaded. path is either a directory laid out like
+// the database (<collection>/<resource>.json) or a single JSON file
+// mapping collections to resources to documents.
+func (d *Driver) LoadFixtures(path string, opts FixtureOptions) (_ int, err error) {
+	op := d.trace(nil, "LoadFixtures", "", "")
+	defer func() { op.end(err) }()
+
+	fixtures, err := d.readFixtures(path)
+	if err != nil {
+		return 0, err
+	}
+	return d.load(fixtures, opts)
+}
+
+// DumpFixtures writes the current contents of every collection to dir in
+// the layout LoadFixtures reads, replacing any previous dump of the same
+// collections.
+func (d *Driver) DumpFixtures(dir string) (err error) {
+	op := d.trace(nil, "DumpFixtures", "", "")
+	defer func() { op.end(err) }()
+
+	// A dump replaces whole directories, which inside the database would
+	// be collections.
+	if d.contains(dir) {
+		if err := d.checkWritable(); err != nil {
+			return err
+		}
+	}
+	data, err := d.dump(op.log)
+	if err != nil {
+		return err
+	}
+
+	for collection, docs := range data {
+		target := filepath.Join(dir, collection)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+
+		for resource, b := range docs {
+			op.bytes += int64(len(b))
+			if err := ioutil.WriteFile(filepath.Join(target, d.fileKey(resource)+".json"), b, 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Dump returns every record in the database keyed by collection and
+// resource, locking one collection at a time.
+func (d *Driver) Dump() (_ map[string]map[string]json.RawMessage, err error) {
+	op := d.trace(nil, "Dump", "", "")
+	defer func() { op.end(err) }()
+
+	return d.dump(op.log)
+}
+
+// Load writes every record in data, the inverse of Dump.
+func (d *Driver) Load(data map[string]map[string]json.RawMessage) (err error) {
+	op := d.trace(nil, "Load", "", "")
+	defer func() { op.end(err) }()
+
+	_, err = d.load(data, FixtureOptions{})
+	return err
+}
+
+func (d *Driver) dump(log Logger) (map[string]map[string]json.RawMessage, error) {
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]map[string]json.RawMessage)
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+		collection := entry.Name()
+
+		docs := make(map[string]json.RawMessage)
+		unlock := d.lockCollections(collection)
+		err := d.eachRecord(log, collection, func(resource string, b []byte) error {
+			docs[resource] = b
+			return nil
+		})
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+		data[collection] = docs
+	}
+
+	return data, nil
+}
+
+func (d *Driver) load(data map[string]map[string]json.RawMessage, opts FixtureOptions) (int, error) {
+	var loaded int
+	for _, collection := range fixtureOrder(data, opts.Order) {
+		if opts.Truncate {
+			if err := d.Truncate(collection); err != nil && !os.IsNotExist(err) {
+				return loaded, err
+			}
+		}
+
+		docs := data[collection]
+		resources := make([]string, 0, len(docs))
+		for resource := range docs {
+			resources = append(resources, resource)
+		}
+		sort.Strings(resources)
+
+		for _, resource := range resources {
+			if err := d.Write(collection, resource, docs[resource]); err != nil {
+				return loaded, fmt.Errorf("%s/%s: %w", collection, resource, err)
+			}
+			loaded++
+		}
+	}
+
+	return loaded, nil
+}
+
+func (d *Driver) readFixtures(path string) (map[string]map[string]json.RawMessage, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := make(map[string]map[string]json.RawMessage)
+
+	if !fi.IsDir() {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &fixtures); err != nil {
+			return nil, fmt.Errorf("fixture manifest %s: %w", path, err)
+		}
+		return fixtures, nil
+	}
+
+	collections, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, collection := range collections {
+		if !collection.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(path, collection.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		docs := make(map[string]json.RawMessage)
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+
+			b, err := ioutil.ReadFile(filepath.Join(path, collection.Name(), file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if !json.Valid(b) {
+				return nil, fmt.Errorf("fixture %s/%s is not valid JSON", collection.Name(), file.Name())
+			}
+			docs[d.keyOf(strings.TrimSuffix(file.Name(), ".json"))] = b
+		}
+		fixtures[collection.Name()] = docs
+	}
+
+	return fixtures, nil
+}
+
+func fixtureOrder(fixtures map[string]map[string]json.RawMessage, order []string) []string {
+	seen := make(map[string]bool)
+
+	var collections []string
+	for _, collection := range order {
+		if _, ok := fixtures[collection]; ok && !seen[collection] {
+			seen[collection] = true
+			collections = append(collections, collection)
+		}
+	}
+
+	var rest []string
+	for collection := range fixtures {
+		if !seen[collection] {
+			rest = append(rest, collection)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(collections, rest...)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Dumping fixtures and loading them into an empty database reproduces it,
+// with Order loading users before the orders that reference them.
+func TestFixturesRoundTrip(t *testing.T) {
+	refs := map[string]map[string]Reference{"order": {"User": {Collection: "user"}}}
+	d := referencesTest(t, Restrict)
+	dir := t.TempDir()
+	if err := d.DumpFixtures(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := openTest(t, &Options{References: refs})
+	n, err := loaded.LoadFixtures(dir, FixtureOptions{Order: []string{"user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(testUsers)+3 {
+		t.Errorf("loaded %d fixtures, want %d", n, len(testUsers)+3)
+	}
+
+	want, err := d.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loaded.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %s, want %s", got, want)
+	}
+}
+
+// A single JSON manifest loads too, and Truncate clears the collections it
+// names first.
+func TestFixturesManifest(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	manifest := filepath.Join(t.TempDir(), "fixtures.json")
+	b := []byte(`{"user": {"Ann": {"Name": "Ann", "Age": 40}}}`)
+	if err := os.WriteFile(manifest, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.LoadFixtures(manifest, FixtureOptions{Truncate: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := d.Keys("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"Ann"}) {
+		t.Errorf("users after the load: %v, want [Ann]", keys)
+	}
+}
+
+// Load of a Dump into a fresh database reproduces it.
+func TestDumpLoad(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	writeN(t, d, 0, 10)
+	data, err := d.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || len(data["user"]) != len(testUsers) || len(data["n"]) != 10 {
+		t.Fatalf("dumped %d collections", len(data))
+	}
+
+	fresh := openTest(t, nil)
+	if err := fresh.Load(data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fresh.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("loaded %s, want %s", got, data)
+	}
+	var u User
+	if err := fresh.Read("user", "Paul", &u); err != nil || u != testUsers[1] {
+		t.Errorf("Read from the loaded database = %+v, %v", u, err)
+	}
+}
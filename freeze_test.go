@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Freezing during a write storm returns once the writes in flight have
+// landed: nothing is written after it, every writer then gets ErrFrozen,
+// and the flag survives a reopen until Thaw.
+func TestFreezeDuringWrites(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	if err := d.Write("user", "seed", packedDoc{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		acked int
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+	)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				err := d.Write("user", fmt.Sprintf("w%d-%d", w, n), packedDoc{N: n})
+				if errors.Is(err, ErrFrozen) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				acked++
+				mu.Unlock()
+				if n == 10 && w == 0 {
+					close(start)
+				}
+			}
+		}(w)
+	}
+
+	<-start
+	if err := d.Freeze("user"); err != nil {
+		t.Fatal(err)
+	}
+	frozen, err := d.Keys("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	after, err := d.Keys("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(frozen) {
+		t.Errorf("%d records were written after Freeze returned", len(after)-len(frozen))
+	}
+	if len(frozen) != acked+1 {
+		t.Errorf("%d records on disk, want the %d acknowledged writes and the seed", len(frozen), acked)
+	}
+	var doc packedDoc
+	if err := d.Read("user", "seed", &doc); err != nil {
+		t.Errorf("Read of a frozen collection = %v", err)
+	}
+	if err := d.Delete("user", "seed"); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Delete in a frozen collection = %v, want ErrFrozen", err)
+	}
+
+	d.Close()
+	d = reopenTest(t, dir, nil)
+	if got := d.Frozen(); len(got) != 1 || got[0] != "user" {
+		t.Errorf("frozen after a reopen: %v, want [user]", got)
+	}
+	if err := d.Thaw("user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("user", "thawed", packedDoc{}); err != nil {
+		t.Errorf("Write after Thaw = %v", err)
+	}
+}
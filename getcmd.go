@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcelliott/lumber"
+)
+
+// runGet implements `godb get COLLECTION RESOURCE`, printing a record,
+// optionally projected to -fields, indented and colorized.
+func runGet(args []string) int {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	dir := flags.String("dir", "./db", "database directory")
+	fields := flags.String("fields", "", "comma-separated dotted field paths to keep")
+	indent := flags.String("indent", "  ", "indentation, empty for compact output")
+	color := flags.Bool("color", isTerminal(os.Stdout), "colorize output")
+	keySecret := flags.String("key-secret", "", "treat RESOURCE as a public key obfuscated with this HMAC secret")
+
+	// Flags may follow the positional arguments.
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return 2
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(positional) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: godb get [-dir DIR] [-fields A,B.C] [-indent STR] [-color] [-key-secret SECRET] COLLECTION RESOURCE")
+		return 2
+	}
+
+	opts := &Options{Logger: lumber.NewConsoleLogger(lumber.WARN), ReadOnly: true}
+	if *keySecret != "" {
+		opts.KeyObfuscator = HMACKeyObfuscator([]byte(*keySecret))
+	}
+	db, err := New(*dir, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	if positional[1], err = db.ResolvePublicKey(positional[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var b json.RawMessage
+	if flagGiven(flags, "fields") {
+		var paths []string
+		if *fields != "" {
+			paths = strings.Split(*fields, ",")
+		}
+		b, err = db.ReadProjection(positional[0], positional[1], paths)
+	} else {
+		err = db.Read(positional[0], positional[1], &b)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var buf bytes.Buffer
+	if *indent != "" {
+		err = json.Indent(&buf, b, "", *indent)
+	} else {
+		err = json.Compact(&buf, b)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	out := buf.Bytes()
+	if *color {
+		out = colorizeJSON(out)
+	}
+	fmt.Println(string(out))
+	return 0
+}
+
+func flagGiven(flags *flag.FlagSet, name string) bool {
+	given := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+const (
+	colorReset  = "\x1b[0m"
+	colorKey    = "\x1b[34m"
+	colorString = "\x1b[32m"
+	colorNumber = "\x1b[33m"
+	colorLit    = "\x1b[35m"
+)
+
+// colorizeJSON wraps keys, strings, numbers and literals of valid JSON in
+// ANSI colors.
+func colorizeJSON(b []byte) []byte {
+	var out bytes.Buffer
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(b) && b[j] != '"' {
+				if b[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j++
+			k := j
+			for k < len(b) && (b[k] == ' ' || b[k] == '\n' || b[k] == '\t' || b[k] == '\r') {
+				k++
+			}
+			color := colorString
+			if k < len(b) && b[k] == ':' {
+				color = colorKey
+			}
+			out.WriteString(color)
+			out.Write(b[i:j])
+			out.WriteString(colorReset)
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			j := i
+			for j < len(b) && strings.IndexByte("+-.eE0123456789", b[j]) >= 0 {
+				j++
+			}
+			out.WriteString(colorNumber)
+			out.Write(b[i:j])
+			out.WriteString(colorReset)
+			i = j
+		case c == 't' || c == 'f' || c == 'n':
+			j := i
+			for j < len(b) && b[j] >= 'a' && b[j] <= 'z' {
+				j++
+			}
+			out.WriteString(colorLit)
+			out.Write(b[i:j])
+			out.WriteString(colorReset)
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes()
+}
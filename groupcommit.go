@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// groupCommit batches the fsyncs of WithSync writes made within
+// Options.GroupCommit of each other. A write stages its record in a temp
+// file of its own and releases the collection lock while one sync covers
+// the temp files of the whole batch. It then retakes the lock, renames its
+// temp file into place and waits for a shared sync of the directories, so
+// a record is never renamed before its contents are durable.
+type groupCommit struct {
+	mutex   sync.Mutex
+	pending [2]*syncBatch
+	// syncingDirs is set while a directory sync runs. Writes arriving
+	// meanwhile form the next batch, which starts as soon as it ends.
+	syncingDirs bool
+
+	batches uint64
+	writes  uint64
+	waited  int64
+}
+
+type (
+	syncBatch struct {
+		paths map[string]error
+		done  chan struct{}
+	}
+	// stagedWrite is a write whose temp file awaits a group commit. finish
+	// renames it into place and does the rest of the write.
+	stagedWrite struct {
+		tmp    string
+		record string
+		before os.FileInfo
+		finish func() error
+	}
+)
+
+const (
+	syncFiles = iota
+	syncDirs
+)
+
+var stagedWrites uint64
+
+// tempPath is where a write puts the record before renaming it into place.
+// A staged write keeps its temp file across a released lock, so it gets one
+// of its own; its name is no record's plus .tmp, so recovery discards it.
+func (d *Driver) tempPath(op *operation, record string) string {
+	if op.stage {
+		return fmt.Sprintf("%s.%d.tmp", record, atomic.AddUint64(&stagedWrites, 1))
+	}
+	return record + ".tmp"
+}
+
+// stageable reports whether WithSync writes to collection may release its
+// lock before their record is in place: nothing that another write checks,
+// a unique value, a reference or a checksum, may lag behind the record.
+// Packed records are appended in place and have no temp file to stage.
+func (d *Driver) stageable(collection string) bool {
+	return !d.opts.VerifyChecksums &&
+		!d.packed(collection) &&
+		len(d.indexes.of(collection)) == 0 &&
+		len(d.referencesOf(collection)) == 0
+}
+
+// putStaged is put for a group-committed write.
+func (d *Driver) putStaged(op *operation, collection, resource string, v interface{}) error {
+	op.stage = true
+	for {
+		s, err := d.stagePut(op, collection, resource, v)
+		if err != nil || s == nil {
+			return err
+		}
+
+		if err := d.groupSync(syncFiles, s.tmp); err != nil {
+			os.Remove(s.tmp)
+			return err
+		}
+		placed, err := d.placeStaged(op, collection, resource, s)
+		if err != nil {
+			return err
+		}
+		if placed {
+			atomic.AddUint64(&d.commits.writes, 1)
+			return d.groupSync(syncDirs, filepath.Dir(s.record))
+		}
+	}
+}
+
+func (d *Driver) stagePut(op *operation, collection, resource string, v interface{}) (*stagedWrite, error) {
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return nil, err
+	}
+	if err := d.checkPreconditions(op, collection, resource); err != nil {
+		return nil, err
+	}
+
+	op.staged = nil
+	if err := d.write(op, collection, resource, v); err != nil {
+		return nil, err
+	}
+	if op.staged == nil {
+		return nil, d.setExpiry(op, collection, resource)
+	}
+	return op.staged, nil
+}
+
+// placeStaged finishes a staged write whose temp file is durable. If the
+// record changed since the write was staged, what the write checked and
+// computed may no longer hold, so it discards the temp file and reports
+// false for the write to start over.
+func (d *Driver) placeStaged(op *operation, collection, resource string, s *stagedWrite) (bool, error) {
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if after, _ := os.Lstat(s.record); !sameRecordFile(s.before, after) {
+		os.Remove(s.tmp)
+		return false, nil
+	}
+	if err := s.finish(); err != nil {
+		return false, err
+	}
+	return true, d.setExpiry(op, collection, resource)
+}
+
+func sameRecordFile(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// groupSync syncs paths, files or directories, along with those of every
+// other write within Options.GroupCommit.
+func (d *Driver) groupSync(kind int, paths ...string) error {
+	g := d.commits
+	start := time.Now()
+	defer func() { atomic.AddInt64(&g.waited, int64(time.Since(start))) }()
+
+	g.mutex.Lock()
+	b := g.pending[kind]
+	if b == nil {
+		b = &syncBatch{paths: make(map[string]error), done: make(chan struct{})}
+		g.pending[kind] = b
+		switch {
+		case kind == syncFiles:
+			time.AfterFunc(d.opts.GroupCommit, func() { d.commitGroup(kind, b) })
+		case !g.syncingDirs:
+			g.syncingDirs = true
+			go d.commitGroup(kind, b)
+		}
+	}
+	for _, path := range paths {
+		b.paths[path] = nil
+	}
+	g.mutex.Unlock()
+
+	<-b.done
+	for _, path := range paths {
+		if err := b.paths[path]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitGroup syncs the paths of a batch concurrently, so the filesystem
+// can fold them into as few journal commits as it likes.
+func (d *Driver) commitGroup(kind int, b *syncBatch) {
+	g := d.commits
+	g.mutex.Lock()
+	g.pending[kind] = nil
+	paths := make([]string, 0, len(b.paths))
+	for path := range b.paths {
+		paths = append(paths, path)
+	}
+	g.mutex.Unlock()
+
+	syncPath := d.syncFile
+	if kind == syncDirs {
+		syncPath = d.syncDir
+	} else {
+		atomic.AddUint64(&g.batches, 1)
+	}
+
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			errs[i] = syncPath(path)
+		}(i, path)
+	}
+	wg.Wait()
+	for i, path := range paths {
+		b.paths[path] = errs[i]
+	}
+	close(b.done)
+
+	if kind == syncDirs {
+		g.mutex.Lock()
+		if next := g.pending[syncDirs]; next != nil {
+			go d.commitGroup(syncDirs, next)
+		} else {
+			g.syncingDirs = false
+		}
+		g.mutex.Unlock()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A group-committed write only renames its record into place once the
+// batch's temp files are synced, and meanwhile leaves the collection to
+// other writers.
+func TestGroupCommitStagesRecord(t *testing.T) {
+	d := openTest(t, &Options{GroupCommit: 200 * time.Millisecond})
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() { done <- d.Put(ctx, "user", "John", testUsers[0], WithSync()) }()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(d.recordPath("user", "John")); !os.IsNotExist(err) {
+		t.Fatal("the record was renamed before its temp file was synced")
+	}
+	if err := d.Write("user", "Paul", testUsers[1]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("the write returned before its batch was committed: %v", err)
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var u User
+	if err := d.Read("user", "John", &u); err != nil || u.Name != "John" {
+		t.Fatalf("read %+v (%v)", u, err)
+	}
+}
+
+func TestGroupCommitConcurrent(t *testing.T) {
+	d := openTest(t, &Options{GroupCommit: 5 * time.Millisecond})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Half the writers race on one record, which makes staged
+			// writes start over when another one lands first.
+			resource := "shared"
+			if i%2 == 0 {
+				resource = fmt.Sprint(i)
+			}
+			if err := d.Put(ctx, "n", resource, i, WithSync()); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n, err := d.Count("n"); err != nil || n != 17 {
+		t.Fatalf("Count is %d (%v)", n, err)
+	}
+	var v int
+	if err := d.Read("n", "shared", &v); err != nil || v%2 != 1 {
+		t.Fatalf("shared holds %d (%v)", v, err)
+	}
+	stats := d.IOStats()
+	if stats.GroupCommitWrites != 32 || stats.GroupCommits == 0 || stats.GroupCommits >= 32 {
+		t.Fatalf("%d writes in %d group commits", stats.GroupCommitWrites, stats.GroupCommits)
+	}
+	files, _ := os.ReadDir(d.dir + "/n")
+	if len(files) != 17 {
+		t.Fatalf("%d files left in the collection", len(files))
+	}
+}
+
+func benchmarkSyncWrites(b *testing.B, window time.Duration) {
+	d := openTest(b, &Options{GroupCommit: window})
+	ctx := context.Background()
+	b.SetParallelism(16)
+	b.ResetTimer()
+
+	var n int64
+	var mutex sync.Mutex
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mutex.Lock()
+			n++
+			resource := fmt.Sprint(n)
+			mutex.Unlock()
+			if err := d.Put(ctx, "bench", resource, testUsers[0], WithSync()); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "writes/s")
+}
+
+func BenchmarkSyncWrites(b *testing.B) {
+	b.Run("PerWrite", func(b *testing.B) { benchmarkSyncWrites(b, 0) })
+	b.Run("GroupCommit5ms", func(b *testing.B) { benchmarkSyncWrites(b, 5*time.Millisecond) })
+}
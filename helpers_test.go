@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+type nopLog struct{}
+
+func (nopLog) Fatal(string, ...interface{}) {}
+func (nopLog) Error(string, ...interface{}) {}
+func (nopLog) Warn(string, ...interface{})  {}
+func (nopLog) Info(string, ...interface{})  {}
+func (nopLog) Debug(string, ...interface{}) {}
+func (nopLog) Trace(string, ...interface{}) {}
+
+// openTest opens a database in a fresh temporary directory, filling in a
+// silent logger when opts leaves it unset.
+func openTest(t testing.TB, opts *Options) *Driver {
+	t.Helper()
+	return reopenTest(t, t.TempDir(), opts)
+}
+
+func reopenTest(t testing.TB, dir string, opts *Options) *Driver {
+	t.Helper()
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLog{}
+	}
+	d, err := New(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+var testUsers = []User{
+	{"John", "23", "23344333", "Myrl Tech", Address{"bangalore", "karnataka", "india", "410013"}},
+	{"Paul", "25", "23344333", "Google", Address{"san francisco", "california", "USA", "410013"}},
+	{"Robert", "27", "23344333", "Microsoft", Address{"bangalore", "karnataka", "india", "410013"}},
+	{"Vince", "29", "23344333", "Facebook", Address{"bangalore", "karnataka", "india", "410013"}},
+	{"Neo", "31", "23344333", "Remote-Teams", Address{"bangalore", "karnataka", "india", "410013"}},
+	{"Albert", "32", "23344333", "Dominate", Address{"bangalore", "karnataka", "india", "410013"}},
+}
+
+func seedUsers(t testing.TB, d *Driver) {
+	t.Helper()
+	for _, u := range testUsers {
+		if err := d.Write("user", u.Name, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
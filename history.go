@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type version struct {
+	path string
+	time time.Time
+}
+
+// archive copies the current record into BackupDir, named after the time it
+// was written, before it gets replaced.
+func (d *Driver) archive(collection, resource, path string) error {
+	if d.opts.BackupDir == "" {
+		return nil
+	}
+
+	fi, err := d.statFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := d.readStored(path)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(d.opts.BackupDir, collection, d.fileKey(resource))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return d.writeFile(filepath.Join(dir, strconv.FormatInt(fi.ModTime().UnixNano(), 10)+d.ext()), b, false)
+}
+
+func (d *Driver) versions(collection, resource string) ([]version, error) {
+	dir := filepath.Join(d.opts.BackupDir, collection, d.fileKey(resource))
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []version
+	for _, file := range files {
+		ns, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), d.ext()), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version{filepath.Join(dir, file.Name()), time.Unix(0, ns)})
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].time.Before(versions[j].time) })
+	return versions, nil
+}
+
+func (d *Driver) ReadAsOf(collection, resource string, t time.Time, v interface{}) (err error) {
+	op := d.trace(nil, "ReadAsOf", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+	if d.opts.BackupDir == "" {
+		return fmt.Errorf("BackupDir is not configured")
+	}
+
+	mutex := d.getOrCreateNewMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	record := d.recordPath(collection, resource)
+	if fi, err := d.statFile(record); err == nil && !fi.ModTime().After(t) {
+		return d.readVersion(op.log, collection, record, v)
+	}
+
+	versions, err := d.versions(collection, resource)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].time.After(t) {
+			return d.readVersion(op.log, collection, versions[i].path, v)
+		}
+	}
+
+	return d.readVersion(op.log, collection, record, v)
+}
+
+func (d *Driver) readVersion(log Logger, collection, path string, v interface{}) error {
+	b, err := d.readRecord(log, path)
+	if err != nil {
+		return err
+	}
+
+	if b, err = d.transform(collection, b); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ReadAsOf a time between two writes reads the first, and a time after the
+// second reads the record as it is.
+func TestReadAsOf(t *testing.T) {
+	d := openTest(t, &Options{BackupDir: t.TempDir()})
+	u := testUsers[0]
+	if err := d.Write("user", u.Name, u); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(20 * time.Millisecond)
+
+	u.Company = "Elsewhere"
+	if err := d.Write("user", u.Name, u); err != nil {
+		t.Fatal(err)
+	}
+
+	var got User
+	if err := d.ReadAsOf("user", u.Name, between, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Company != testUsers[0].Company {
+		t.Errorf("ReadAsOf between the writes read company %q, want %q", got.Company, testUsers[0].Company)
+	}
+	if err := d.ReadAsOf("user", u.Name, time.Now(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Company != "Elsewhere" {
+		t.Errorf("ReadAsOf now read company %q, want %q", got.Company, "Elsewhere")
+	}
+}
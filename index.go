@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type (
+	// index maps the JSON encoding of a field's value to the resources
+	// holding it. It is built on first use after a restart and then kept
+	// current by every mutation, under the collection mutex.
+	index struct {
+		built   bool
+		unique  bool
+		entries map[string]map[string]bool
+		values  map[string]string
+	}
+	indexSet struct {
+		mutex   sync.Mutex
+		indexes map[string]map[string]*index
+	}
+)
+
+func newIndexSet() *indexSet {
+	return &indexSet{indexes: make(map[string]map[string]*index)}
+}
+
+func (s *indexSet) of(collection string) map[string]*index {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.indexes[collection]
+}
+
+func (s *indexSet) add(collection, field string) *index {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.indexes[collection] == nil {
+		s.indexes[collection] = make(map[string]*index)
+	}
+	idx, ok := s.indexes[collection][field]
+	if !ok {
+		idx = &index{}
+		s.indexes[collection][field] = idx
+	}
+	return idx
+}
+
+func (s *indexSet) remove(collection, field string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.indexes[collection][field]; !ok {
+		return false
+	}
+	delete(s.indexes[collection], field)
+	return true
+}
+
+func (s *indexSet) forget(collection string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.indexes, collection)
+}
+
+// indexKey is the canonical encoding of a field value, so values written
+// from Go and decoded from disk compare equal.
+func indexKey(v interface{}) (string, error) {
+	g, err := generic(v)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(g)
+	return string(b), err
+}
+
+func (idx *index) set(resource, key string, ok bool) {
+	if old, had := idx.values[resource]; had {
+		delete(idx.entries[old], resource)
+		if len(idx.entries[old]) == 0 {
+			delete(idx.entries, old)
+		}
+		delete(idx.values, resource)
+	}
+	if !ok {
+		return
+	}
+	if idx.entries[key] == nil {
+		idx.entries[key] = make(map[string]bool)
+	}
+	idx.entries[key][resource] = true
+	idx.values[resource] = key
+}
+
+func (idx *index) put(field, resource string, doc map[string]interface{}) error {
+	v, ok := lookup(doc, field)
+	if !ok {
+		idx.set(resource, "", false)
+		return nil
+	}
+	key, err := indexKey(v)
+	if err != nil {
+		return err
+	}
+	idx.set(resource, key, true)
+	return nil
+}
+
+// buildIndex must be called with the collection mutex held.
+func (d *Driver) buildIndex(collection, field string, idx *index) error {
+	idx.entries = make(map[string]map[string]bool)
+	idx.values = make(map[string]string)
+	idx.built = false
+
+	err := d.eachRecord(d.log, collection, func(resource string, b []byte) error {
+		doc, err := decodeDocument(b)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %s", collection, resource, err)
+		}
+		return idx.put(field, resource, doc)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	idx.built = true
+	return nil
+}
+
+// updateIndexes records a stored document in the built indexes of its
+// collection. It must be called with the collection mutex held.
+func (d *Driver) updateIndexes(collection, resource string, b []byte) error {
+	indexes := d.indexes.of(collection)
+	if len(indexes) == 0 {
+		return nil
+	}
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return err
+	}
+	for field, idx := range indexes {
+		if !idx.built {
+			continue
+		}
+		if err := idx.put(field, resource, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindex drops a removed record from the indexes of its collection.
+func (d *Driver) unindex(collection, resource string) {
+	for _, idx := range d.indexes.of(collection) {
+		if idx.built {
+			idx.set(resource, "", false)
+		}
+	}
+}
+
+// invalidateIndexes makes the indexes of collection rebuild on next use,
+// for changes made without going through store or remove.
+func (d *Driver) invalidateIndexes(collection string) {
+	for _, idx := range d.indexes.of(collection) {
+		idx.built = false
+	}
+}
+
+// CreateIndex indexes the records of collection by a dotted field path.
+// Writes and deletes keep the index current, and it is recorded in the
+// collection's _meta.json so it is rebuilt after a restart.
+func (d *Driver) CreateIndex(collection, field string) (err error) {
+	op := d.trace(nil, "CreateIndex", collection, field)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if field == "" {
+		return fmt.Errorf("field is required")
+	}
+	if err := validateName(collection, ""); err != nil {
+		return err
+	}
+	if err := d.checkOccupied(metaPath(collection)); err != nil {
+		return err
+	}
+	defer d.lockCollections(collection)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	idx := d.indexes.add(collection, field)
+	if err := d.buildIndex(collection, field, idx); err != nil {
+		d.indexes.remove(collection, field)
+		return err
+	}
+	return d.saveIndexes(collection)
+}
+
+// DropIndex removes the index of collection on field.
+func (d *Driver) DropIndex(collection, field string) (err error) {
+	op := d.trace(nil, "DropIndex", collection, field)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	defer d.lockCollections(collection)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+	if !d.indexes.remove(collection, field) {
+		return fmt.Errorf("%w: index %s on %s", ErrNotFound, field, collection)
+	}
+	return d.saveIndexes(collection)
+}
+
+// Indexes lists the indexed fields of collection.
+func (d *Driver) Indexes(collection string) (_ []string, err error) {
+	op := d.trace(nil, "Indexes", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+	indexes := d.indexes.of(collection)
+	fields := make([]string, 0, len(indexes))
+	for field := range indexes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// FindBy returns the resources whose field equals value, in name order,
+// using the index on field if there is one and scanning otherwise.
+func (d *Driver) FindBy(collection, field string, value interface{}) (_ []string, err error) {
+	op := d.trace(nil, "FindBy", collection, field)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+	key, err := indexKey(value)
+	if err != nil {
+		return nil, err
+	}
+
+	defer d.lockCollections(collection)()
+
+	idx, ok := d.indexes.of(collection)[field]
+	if !ok {
+		idx = &index{}
+		op.log.Debug("No index on %s.%s; scanning", collection, field)
+	}
+	if !idx.built {
+		if err := d.buildIndex(collection, field, idx); err != nil {
+			return nil, err
+		}
+	}
+
+	resources := make([]string, 0, len(idx.entries[key]))
+	for resource := range idx.entries[key] {
+		// Expiry does not update the index.
+		if d.checkExpiry(collection, resource) == nil {
+			resources = append(resources, resource)
+		}
+	}
+	sort.Strings(resources)
+	return resources, nil
+}
+
+// saveIndexes persists the indexed fields of collection. It must be called
+// with the collection mutex held.
+func (d *Driver) saveIndexes(collection string) error {
+	fields, _ := d.Indexes(collection)
+	if err := os.MkdirAll(filepath.Join(d.dir, collection), 0755); err != nil {
+		return err
+	}
+	meta, err := d.readMeta(collection)
+	if err != nil {
+		return err
+	}
+	meta.Indexes = fields
+	meta.Unique = nil
+	for _, field := range fields {
+		if d.indexes.of(collection)[field].unique {
+			meta.Unique = append(meta.Unique, field)
+		}
+	}
+	return d.writeMeta(collection, meta)
+}
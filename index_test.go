@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// After writes and deletes an index answers FindBy exactly, without
+// reading a record, and a dropped index falls back to a scan.
+func TestIndexMaintained(t *testing.T) {
+	d := openTest(t, nil)
+	if err := d.CreateIndex("user", "Address.Country"); err != nil {
+		t.Fatal(err)
+	}
+	seedUsers(t, d)
+
+	moved := testUsers[2]
+	moved.Address.Country = "USA"
+	if err := d.Write("user", moved.Name, moved); err != nil {
+		t.Fatal(err)
+	}
+	back := testUsers[1]
+	back.Address.Country = "india"
+	if err := d.Write("user", back.Name, back); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("user", "Neo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("user", "Ann", User{Name: "Ann", Age: "40", Address: Address{Country: "india", Code: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"india": {"Albert", "Ann", "John", "Paul", "Vince"},
+		"USA":   {"Robert"},
+	}
+	reads := d.IOStats().Reads
+	for country, names := range want {
+		got, err := d.FindBy("user", "Address.Country", country)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, names) {
+			t.Errorf("FindBy %s = %v, want %v", country, got, names)
+		}
+	}
+	if n := d.IOStats().Reads - reads; n != 0 {
+		t.Errorf("FindBy read %d records through the index", n)
+	}
+
+	if fields, err := d.Indexes("user"); err != nil || !reflect.DeepEqual(fields, []string{"Address.Country"}) {
+		t.Errorf("Indexes = %v, %v", fields, err)
+	}
+	if err := d.DropIndex("user", "Address.Country"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.FindBy("user", "Address.Country", "USA"); err != nil || !reflect.DeepEqual(got, want["USA"]) {
+		t.Errorf("FindBy after DropIndex = %v, %v", got, err)
+	}
+	if fields, _ := d.Indexes("user"); len(fields) != 0 {
+		t.Errorf("Indexes after DropIndex = %v", fields)
+	}
+}
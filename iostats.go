@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	IOStats struct {
+		Reads        uint64
+		BytesRead    uint64
+		Writes       uint64
+		BytesWritten uint64
+		TempFiles    uint64
+		Renames      uint64
+		Syncs        uint64
+
+		// GroupCommits counts the syncs shared by the GroupCommitWrites
+		// they made durable, which waited GroupCommitWait in total.
+		GroupCommits      uint64
+		GroupCommitWrites uint64
+		GroupCommitWait   time.Duration
+	}
+	ioCounters struct {
+		reads        uint64
+		bytesRead    uint64
+		writes       uint64
+		bytesWritten uint64
+		tempFiles    uint64
+		renames      uint64
+		syncs        uint64
+		consistency  [4]uint64
+
+		replicaDivergences uint64
+	}
+)
+
+// IOStats reports the file operations performed since the Driver was created.
+func (d *Driver) IOStats() IOStats {
+	c := &d.io
+	stats := IOStats{
+		Reads:        atomic.LoadUint64(&c.reads),
+		BytesRead:    atomic.LoadUint64(&c.bytesRead),
+		Writes:       atomic.LoadUint64(&c.writes),
+		BytesWritten: atomic.LoadUint64(&c.bytesWritten),
+		TempFiles:    atomic.LoadUint64(&c.tempFiles),
+		Renames:      atomic.LoadUint64(&c.renames),
+		Syncs:        atomic.LoadUint64(&c.syncs),
+	}
+	stats.GroupCommits = atomic.LoadUint64(&d.commits.batches)
+	stats.GroupCommitWrites = atomic.LoadUint64(&d.commits.writes)
+	stats.GroupCommitWait = time.Duration(atomic.LoadInt64(&d.commits.waited))
+	return stats
+}
+
+func (d *Driver) readFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&d.io.reads, 1)
+	atomic.AddUint64(&d.io.bytesRead, uint64(len(b)))
+	return b, nil
+}
+
+func (d *Driver) writeFile(path string, b []byte, temp bool) error {
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.io.writes, 1)
+	atomic.AddUint64(&d.io.bytesWritten, uint64(len(b)))
+	if temp {
+		atomic.AddUint64(&d.io.tempFiles, 1)
+	}
+	return nil
+}
+
+func (d *Driver) rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.io.renames, 1)
+	return nil
+}
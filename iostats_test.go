@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// IOStats counts each file operation of a known sequence of calls.
+func TestIOStats(t *testing.T) {
+	d := openTest(t, nil)
+	base := d.IOStats()
+
+	u := testUsers[0]
+	b, err := d.marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := uint64(len(b) + 1)
+	if err := d.Write("user", u.Name, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(context.Background(), "user", u.Name, u, WithSync()); err != nil {
+		t.Fatal(err)
+	}
+	var got User
+	for i := 0; i < 3; i++ {
+		if err := d.Read("user", u.Name, &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := d.IOStats()
+	for _, c := range []struct {
+		name      string
+		got, want uint64
+	}{
+		{"Writes", stats.Writes - base.Writes, 2},
+		{"BytesWritten", stats.BytesWritten - base.BytesWritten, 2 * size},
+		{"TempFiles", stats.TempFiles - base.TempFiles, 2},
+		{"Renames", stats.Renames - base.Renames, 2},
+		{"Syncs", stats.Syncs - base.Syncs, 2},
+		{"Reads", stats.Reads - base.Reads, 3},
+		{"BytesRead", stats.BytesRead - base.BytesRead, 3 * size},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %d, want %d", c.name, c.got, c.want)
+		}
+	}
+	if n := d.Stats().CollectionWrites["user"]; n != 2 {
+		t.Errorf("CollectionWrites = %d, want 2", n)
+	}
+}
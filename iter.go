@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+)
+
+// All iterates over the records of collection in name order, reading each
+// one only when the loop reaches it:
+//
+//	for resource, data := range db.All("user") { ... }
+//
+// Iteration stops at the first error, which is logged; use AllErr to get
+// it. Records deleted during the iteration are skipped.
+func (d *Driver) All(collection string) iter.Seq2[string, []byte] {
+	seq, errf := d.AllErr(collection)
+	return func(yield func(string, []byte) bool) {
+		seq(yield)
+		if err := errf(); err != nil {
+			d.log.Error("Iterating %s: %s", collection, err)
+		}
+	}
+}
+
+// AllErr is All returning the error that ended the iteration, if any,
+// through the second return value, to be called after the loop.
+func (d *Driver) AllErr(collection string) (iter.Seq2[string, []byte], func() error) {
+	var err error
+	seq := func(yield func(string, []byte) bool) {
+		err = nil
+		op := d.trace(nil, "All", collection, "")
+		defer func() { op.end(err) }()
+		defer func() { err = wrapOp("read", collection, "", err) }()
+
+		if collection == "" {
+			err = fmt.Errorf("collection is required")
+			return
+		}
+		if err = d.readLimit.take(d.ctx, op.log, collection); err != nil {
+			return
+		}
+
+		dir := filepath.Join(d.dir, collection)
+		var files []os.FileInfo
+		if files, err = d.readDir(dir); err != nil {
+			return
+		}
+		files, _ = d.unexpired(dir, files)
+
+		for _, file := range files {
+			resource := d.resourceOf(file.Name())
+
+			b, rerr := d.readRecord(op.log, filepath.Join(dir, file.Name()))
+			if errors.Is(rerr, os.ErrNotExist) {
+				continue
+			}
+			if isTorn(b, rerr) {
+				if b, rerr = d.recoverTorn(op, collection, resource); errors.Is(rerr, ErrTornWrite) {
+					op.log.Warn("Skipping %s", rerr)
+					continue
+				}
+			}
+			if rerr != nil {
+				err = rerr
+				return
+			}
+			op.bytes += int64(len(b))
+			d.readRepair(op.log, collection, resource, b)
+
+			if b, err = d.transform(collection, b); err != nil {
+				return
+			}
+
+			if !yield(resource, b) {
+				return
+			}
+		}
+	}
+	return seq, func() error { return err }
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Ranging over All yields the records in name order, reading each only
+// when the loop reaches it, and AllErr reports what ended the iteration.
+func TestAllRangeOverFunc(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	var keys []string
+	for resource, b := range d.All("user") {
+		var u User
+		if err := json.Unmarshal(b, &u); err != nil || u.Name != resource {
+			t.Errorf("%s yielded %s, %v", resource, b, err)
+		}
+		keys = append(keys, resource)
+	}
+	if want := []string{"Albert", "John", "Neo", "Paul", "Robert", "Vince"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("iterated %v, want %v", keys, want)
+	}
+
+	reads := d.IOStats().Reads
+	n := 0
+	for resource := range d.All("user") {
+		if resource == "John" {
+			if err := d.Delete("user", "Neo"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if resource == "Neo" {
+			t.Error("yielded a record deleted during the iteration")
+		}
+		if n++; n == 3 {
+			break
+		}
+	}
+	if got := d.IOStats().Reads - reads; got != 3 {
+		t.Errorf("iterating three records read %d files", got)
+	}
+
+	seq, errf := d.AllErr("")
+	for range seq {
+		t.Error("iterated a collection without a name")
+	}
+	if errf() == nil {
+		t.Error("AllErr reported no error for an empty collection name")
+	}
+}
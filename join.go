@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type (
+	JoinSpec struct {
+		Collection string
+		Target     string
+		Required   bool
+	}
+	join struct {
+		field string
+		spec  JoinSpec
+	}
+	// joinCache holds the referenced documents read during one call so each
+	// distinct reference is read once.
+	joinCache map[refKey]interface{}
+)
+
+func (d *Driver) ReadJoined(collection, resource string, v interface{}, joins map[string]JoinSpec) (err error) {
+	op := d.trace(nil, "ReadJoined", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+
+	b, err := d.readRetrying(op.log, filepath.Join(d.dir, collection, d.fileKey(resource)))
+	if err != nil {
+		return err
+	}
+	if b, err = d.transform(collection, b); err != nil {
+		return err
+	}
+
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return err
+	}
+
+	fields := make([]string, 0, len(joins))
+	for field := range joins {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	specs := make([]join, len(fields))
+	for i, field := range fields {
+		specs[i] = join{field, joins[field]}
+	}
+
+	if err := d.join(op.log, doc, specs, make(joinCache)); err != nil {
+		return err
+	}
+
+	if b, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (q *QueryBuilder) Join(field, collection, target string) *QueryBuilder {
+	return q.JoinWith(field, JoinSpec{Collection: collection, Target: target})
+}
+
+func (q *QueryBuilder) JoinWith(field string, spec JoinSpec) *QueryBuilder {
+	q.joins = append(q.joins, join{field, spec})
+	return q
+}
+
+func (d *Driver) join(log Logger, doc map[string]interface{}, joins []join, cache joinCache) error {
+	for _, j := range joins {
+		target := j.spec.Target
+		if target == "" {
+			target = j.field
+		}
+
+		value, ok := lookup(doc, j.field)
+		if !ok || value == nil {
+			if j.spec.Required {
+				return fmt.Errorf("%w: %s is not set", ErrBrokenReference, j.field)
+			}
+			assign(doc, target, nil)
+			continue
+		}
+
+		key, err := keyString(value)
+		if err != nil {
+			return err
+		}
+		ref := refKey{j.spec.Collection, key}
+
+		embedded, ok := cache[ref]
+		if !ok {
+			b, err := d.readRecord(log, d.recordPath(ref.collection, ref.resource))
+			switch {
+			case os.IsNotExist(err):
+				embedded = nil
+			case err != nil:
+				return err
+			default:
+				if b, err = d.transform(ref.collection, b); err != nil {
+					return err
+				}
+				if embedded, err = decodeValue(b); err != nil {
+					return fmt.Errorf("%s: %s", ref, err)
+				}
+			}
+			cache[ref] = embedded
+		}
+
+		if embedded == nil && j.spec.Required {
+			return fmt.Errorf("%w: %s references missing %s", ErrBrokenReference, j.field, ref)
+		}
+		assign(doc, target, embedded)
+	}
+	return nil
+}
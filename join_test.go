@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// A join embeds the referenced users, reading each distinct one once.
+func TestJoin(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	for i := 0; i < 12; i++ {
+		id := fmt.Sprintf("o%02d", i)
+		if err := d.Write("order", id, order{id, testUsers[i%3].Name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reads := d.IOStats().Reads
+	var orders []struct {
+		ID   string
+		User User
+	}
+	if err := d.Find("order").Join("User", "user", "User").Collect(&orders); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.IOStats().Reads - reads; n != 12+3 {
+		t.Errorf("the join read %d files, want 12 orders and 3 users", n)
+	}
+	if len(orders) != 12 {
+		t.Fatalf("joined %d orders, want 12", len(orders))
+	}
+	for i, o := range orders {
+		if o.User != testUsers[i%3] {
+			t.Errorf("order %s embeds %+v, want %+v", o.ID, o.User, testUsers[i%3])
+		}
+	}
+
+	var one struct{ User User }
+	if err := d.ReadJoined("order", "o01", &one, map[string]JoinSpec{"User": {Collection: "user"}}); err != nil {
+		t.Fatal(err)
+	}
+	if one.User != testUsers[1] {
+		t.Errorf("ReadJoined embeds %+v, want %+v", one.User, testUsers[1])
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled JSONPath expression. It supports $, .name,
+// ['name'], [n], [*], .*, recursive descent with .., and filters such as
+// [?(@.a.b == 'x' && @.n > 3)]. A filter applied to an object tests the
+// object itself, so $.Address[?(@.Country=='india')] matches documents
+// whose Address has that country.
+type (
+	jsonPath []pathStep
+	pathStep struct {
+		kind      stepKind
+		name      string
+		index     int
+		filter    [][]pathCondition
+		recursive bool
+	}
+	stepKind      int
+	pathCondition struct {
+		path  jsonPath
+		op    string
+		value interface{}
+	}
+)
+
+const (
+	stepChild stepKind = iota
+	stepIndex
+	stepWildcard
+	stepFilter
+)
+
+func compileJSONPath(expr string) (jsonPath, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("jsonpath %q must start with $", expr)
+	}
+
+	var path jsonPath
+	for i := 1; i < len(expr); {
+		var step pathStep
+		switch {
+		case strings.HasPrefix(expr[i:], ".."):
+			step.recursive = true
+			i += 2
+			if i < len(expr) && expr[i] == '[' {
+				break
+			}
+			fallthrough
+		case expr[i] == '.':
+			if expr[i] == '.' {
+				i++
+			}
+			end := i
+			for end < len(expr) && expr[end] != '.' && expr[end] != '[' {
+				end++
+			}
+			name := expr[i:end]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("jsonpath %q: empty name at %d", expr, i)
+			case "*":
+				step.kind = stepWildcard
+			default:
+				step.kind, step.name = stepChild, name
+			}
+			path = append(path, step)
+			i = end
+			continue
+		case expr[i] != '[':
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q at %d", expr, expr[i], i)
+		}
+
+		end, err := parseBracket(expr, i, &step)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, step)
+		i = end
+	}
+	return path, nil
+}
+
+// parseBracket parses the [...] starting at expr[i] into step and returns
+// the index just past it.
+func parseBracket(expr string, i int, step *pathStep) (int, error) {
+	if strings.HasPrefix(expr[i:], "[?(") {
+		end := closing(expr, i+2, '(', ')')
+		if end < 0 || end+1 >= len(expr) || expr[end+1] != ']' {
+			return 0, fmt.Errorf("jsonpath %q: unterminated filter at %d", expr, i)
+		}
+		filter, err := parseFilter(expr[i+3 : end])
+		if err != nil {
+			return 0, fmt.Errorf("jsonpath %q: %w", expr, err)
+		}
+		step.kind, step.filter = stepFilter, filter
+		return end + 2, nil
+	}
+
+	end := closing(expr, i, '[', ']')
+	if end < 0 {
+		return 0, fmt.Errorf("jsonpath %q: unterminated [ at %d", expr, i)
+	}
+	inner := strings.TrimSpace(expr[i+1 : end])
+
+	switch {
+	case inner == "*":
+		step.kind = stepWildcard
+	case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+		step.kind, step.name = stepChild, inner[1:len(inner)-1]
+	default:
+		n, err := strconv.Atoi(inner)
+		if err != nil {
+			return 0, fmt.Errorf("jsonpath %q: unsupported selector [%s]", expr, inner)
+		}
+		step.kind, step.index = stepIndex, n
+	}
+	return end + 1, nil
+}
+
+// closing returns the index of the delimiter closing the one at expr[i],
+// skipping quoted strings.
+func closing(expr string, i int, open, close byte) int {
+	depth := 0
+	var quote byte
+	for ; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == open:
+			depth++
+		case c == close:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitOutsideQuotes splits s on sep wherever sep is not inside quotes.
+func splitOutsideQuotes(s, sep string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseFilter parses a filter body into alternatives of conjunctions.
+func parseFilter(body string) ([][]pathCondition, error) {
+	var filter [][]pathCondition
+	for _, alternative := range splitOutsideQuotes(body, "||") {
+		var conditions []pathCondition
+		for _, term := range splitOutsideQuotes(alternative, "&&") {
+			cond, err := parseCondition(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, cond)
+		}
+		filter = append(filter, conditions)
+	}
+	return filter, nil
+}
+
+func parseCondition(term string) (pathCondition, error) {
+	if !strings.HasPrefix(term, "@") {
+		return pathCondition{}, fmt.Errorf("filter term %q must start with @", term)
+	}
+
+	left, right := term, ""
+	var op string
+	for _, candidate := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if parts := splitOutsideQuotes(term, candidate); len(parts) == 2 {
+			left, op, right = strings.TrimSpace(parts[0]), candidate, strings.TrimSpace(parts[1])
+			break
+		}
+	}
+
+	path, err := compileJSONPath("$" + left[1:])
+	if err != nil {
+		return pathCondition{}, err
+	}
+	cond := pathCondition{path: path, op: op}
+	if op == "" {
+		return cond, nil
+	}
+
+	switch {
+	case len(right) >= 2 && (right[0] == '\'' || right[0] == '"') && right[len(right)-1] == right[0]:
+		cond.value = right[1 : len(right)-1]
+	case right == "true", right == "false":
+		cond.value = right == "true"
+	case right == "null":
+		cond.value = nil
+	default:
+		f, err := strconv.ParseFloat(right, 64)
+		if err != nil {
+			return pathCondition{}, fmt.Errorf("filter term %q: unsupported literal %s", term, right)
+		}
+		cond.value = f
+	}
+	return cond, nil
+}
+
+// eval returns the nodes of doc selected by the path.
+func (p jsonPath) eval(doc interface{}) []interface{} {
+	nodes := []interface{}{doc}
+	for _, step := range p {
+		var next []interface{}
+		for _, node := range nodes {
+			candidates := []interface{}{node}
+			if step.recursive {
+				candidates = descendants(node, candidates)
+			}
+			for _, c := range candidates {
+				next = append(next, step.apply(c)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func (s pathStep) apply(node interface{}) []interface{} {
+	switch s.kind {
+	case stepChild:
+		if m, ok := node.(map[string]interface{}); ok {
+			if v, ok := m[s.name]; ok {
+				return []interface{}{v}
+			}
+		}
+	case stepIndex:
+		if a, ok := node.([]interface{}); ok {
+			i := s.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				return []interface{}{a[i]}
+			}
+		}
+	case stepWildcard:
+		return children(node)
+	case stepFilter:
+		if a, ok := node.([]interface{}); ok {
+			var matched []interface{}
+			for _, elem := range a {
+				if s.matches(elem) {
+					matched = append(matched, elem)
+				}
+			}
+			return matched
+		}
+		if _, ok := node.(map[string]interface{}); ok && s.matches(node) {
+			return []interface{}{node}
+		}
+	}
+	return nil
+}
+
+func (s pathStep) matches(node interface{}) bool {
+	for _, conditions := range s.filter {
+		all := true
+		for _, cond := range conditions {
+			if !cond.matches(node) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func (c pathCondition) matches(node interface{}) bool {
+	values := c.path.eval(node)
+	if c.op == "" {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if compareJSON(v, c.op, c.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareJSON(v interface{}, op string, want interface{}) bool {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return false
+		}
+		v = f
+	}
+
+	switch w := want.(type) {
+	case float64:
+		f, ok := v.(float64)
+		if !ok {
+			return op == "!="
+		}
+		switch op {
+		case "==":
+			return f == w
+		case "!=":
+			return f != w
+		case "<":
+			return f < w
+		case "<=":
+			return f <= w
+		case ">":
+			return f > w
+		case ">=":
+			return f >= w
+		}
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return op == "!="
+		}
+		switch op {
+		case "==":
+			return s == w
+		case "!=":
+			return s != w
+		case "<":
+			return s < w
+		case "<=":
+			return s <= w
+		case ">":
+			return s > w
+		case ">=":
+			return s >= w
+		}
+	default:
+		switch op {
+		case "==":
+			return v == want
+		case "!=":
+			return v != want
+		}
+	}
+	return false
+}
+
+// children returns the members of an object, in key order, or the
+// elements of an array.
+func children(node interface{}) []interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]interface{}, len(keys))
+		for i, k := range keys {
+			values[i] = n[k]
+		}
+		return values
+	case []interface{}:
+		return n
+	}
+	return nil
+}
+
+func descendants(node interface{}, acc []interface{}) []interface{} {
+	for _, child := range children(node) {
+		acc = append(acc, child)
+		acc = descendants(child, acc)
+	}
+	return acc
+}
+
+// FindByJSONPath returns the records of collection for which the JSONPath
+// expression selects at least one node. Records that cannot be decoded are
+// skipped.
+func (d *Driver) FindByJSONPath(collection, path string) (_ []json.RawMessage, err error) {
+	op := d.trace(nil, "FindByJSONPath", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+	compiled, err := compileJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []json.RawMessage
+	err = d.eachRecord(op.log, collection, func(resource string, b []byte) error {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			op.log.Debug("Skipping %s/%s: %s", collection, resource, err)
+			return nil
+		}
+		if len(compiled.eval(doc)) > 0 {
+			op.bytes += int64(len(b))
+			records = append(records, json.RawMessage(b))
+		}
+		return nil
+	})
+	return records, err
+}
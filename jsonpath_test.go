@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// A JSONPath filter on the nested address selects the matching users and
+// skips records that cannot be decoded.
+func TestFindByJSONPath(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	if err := os.WriteFile(d.recordPath("user", "broken"), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string][]string{
+		"$.Address[?(@.Country=='india')]": {"Albert", "John", "Neo", "Robert", "Vince"},
+		"$.Address[?(@.Country=='USA')]":   {"Paul"},
+		"$.Address[?(@.City=='nowhere')]":  nil,
+	} {
+		records, err := d.FindByJSONPath("user", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range records {
+			var u User
+			if err := json.Unmarshal(r, &u); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, u.Name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s matched %v, want %v", path, names, want)
+		}
+	}
+
+	if _, err := d.FindByJSONPath("user", "Address.Country"); err == nil {
+		t.Error("an expression without $ compiled")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keysDir = "_keys"
+
+// keyIndexSlack is how long a collection directory must have been left
+// alone before a scan of it can be trusted: a change made within the
+// filesystem's timestamp resolution of the scan may not alter its mtime.
+const keyIndexSlack = time.Second
+
+type (
+	// keyIndex is the persisted listing of a collection's record files.
+	// Generation is the modification time of the collection directory when
+	// it was scanned, which any create, rename or delete inside it changes.
+	// Expiring names the records with a TTL, whose expiry Keys checks.
+	keyIndex struct {
+		Generation int64
+		Checksum   string
+		Names      []string
+		Expiring   []string `json:",omitempty"`
+	}
+	keyIndexSet struct {
+		mutex   sync.Mutex
+		indexes map[string]*keyIndex
+	}
+)
+
+func (idx *keyIndex) checksum() string {
+	h := sha256.New()
+	for _, name := range idx.Names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+	}
+	// Indexes written before Expiring existed fail the check and are
+	// rebuilt, rather than hide expiring records.
+	h.Write([]byte{1})
+	for _, name := range idx.Expiring {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (d *Driver) keyIndexPath(collection string) string {
+	return filepath.Join(d.dir, keysDir, d.fileKey(collection)+".idx")
+}
+
+// Keys returns the resource names of collection in file name order.
+//
+// The listing is kept in memory and in _keys/<collection>.idx, and reused
+// as long as the collection directory's modification time shows it has not
+// changed, so a cold open of a large collection needs one stat instead of
+// a directory scan. A missing, corrupt or stale index falls back to a scan,
+// which is persisted for next time.
+func (d *Driver) Keys(collection string) (_ []string, err error) {
+	op := d.trace(nil, "Keys", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+
+	idx, err := d.keyIndex(op, collection)
+	if err != nil {
+		return nil, wrapOp("read", collection, "", err)
+	}
+	expiring := make(map[string]bool, len(idx.Expiring))
+	for _, name := range idx.Expiring {
+		expiring[name] = true
+	}
+	keys := make([]string, 0, len(idx.Names))
+	for _, name := range idx.Names {
+		resource := d.resourceOf(name)
+		if expiring[name] && d.checkExpiry(collection, resource) != nil {
+			continue
+		}
+		keys = append(keys, resource)
+	}
+	return keys, nil
+}
+
+// Count returns the number of records in collection, see Keys.
+func (d *Driver) Count(collection string) (int, error) {
+	keys, err := d.Keys(collection)
+	return len(keys), err
+}
+
+func (d *Driver) keyIndex(op *operation, collection string) (*keyIndex, error) {
+	dir := filepath.Join(d.dir, collection)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	generation := fi.ModTime().UnixNano()
+	// Appending to a pack leaves the directory as it was, so the listing
+	// of a packed collection comes from its in-memory index every time.
+	packed := d.packed(collection)
+
+	s := d.keyIndexes
+	s.mutex.Lock()
+	idx := s.indexes[collection]
+	s.mutex.Unlock()
+	if idx != nil && idx.Generation == generation && !packed {
+		return idx, nil
+	}
+
+	if !packed {
+		if idx, err := d.loadKeyIndex(collection); err == nil && idx.Generation == generation {
+			s.mutex.Lock()
+			s.indexes[collection] = idx
+			s.mutex.Unlock()
+			return idx, nil
+		} else if err != nil && !os.IsNotExist(err) {
+			op.log.Warn("Rebuilding key index of %s: %s", collection, err)
+		}
+	}
+
+	started := time.Now()
+	files, err := d.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	idx = &keyIndex{Generation: generation}
+	expires := make(map[string]bool)
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), expiresExt) {
+			expires[strings.TrimSuffix(file.Name(), expiresExt)] = true
+		}
+	}
+	for _, file := range files {
+		if !file.IsDir() && d.isRecord(file.Name()) {
+			idx.Names = append(idx.Names, file.Name())
+			if expires[strings.TrimSuffix(file.Name(), d.ext())] {
+				idx.Expiring = append(idx.Expiring, file.Name())
+			}
+		}
+	}
+
+	// Only a scan of a directory that was already quiet, and still is,
+	// can be reused.
+	if packed || started.Sub(fi.ModTime()) < keyIndexSlack {
+		return idx, nil
+	}
+	if after, err := os.Stat(dir); err != nil || after.ModTime().UnixNano() != generation {
+		return idx, nil
+	}
+
+	s.mutex.Lock()
+	s.indexes[collection] = idx
+	s.mutex.Unlock()
+	if !d.readOnly {
+		if err := d.saveKeyIndex(collection, idx); err != nil {
+			op.log.Warn("Unable to save key index of %s: %s", collection, err)
+		}
+	}
+	return idx, nil
+}
+
+func (d *Driver) loadKeyIndex(collection string) (*keyIndex, error) {
+	b, err := ioutil.ReadFile(d.keyIndexPath(collection))
+	if err != nil {
+		return nil, err
+	}
+	idx := &keyIndex{}
+	if err := json.Unmarshal(b, idx); err != nil {
+		return nil, err
+	}
+	if idx.Checksum != idx.checksum() {
+		return nil, fmt.Errorf("%w: %s", ErrChecksumMismatch, d.keyIndexPath(collection))
+	}
+	return idx, nil
+}
+
+func (d *Driver) saveKeyIndex(collection string, idx *keyIndex) error {
+	if err := os.MkdirAll(filepath.Join(d.dir, keysDir), 0755); err != nil {
+		return err
+	}
+	idx.Checksum = idx.checksum()
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	path := d.keyIndexPath(collection)
+	if err := d.writeFile(path+".tmp", b, true); err != nil {
+		return err
+	}
+	return d.rename(path+".tmp", path)
+}
+
+// dropKeyIndex removes the key index of a dropped collection. A stale one
+// would be ignored anyway, this only saves the space.
+func (d *Driver) dropKeyIndex(collection string) error {
+	s := d.keyIndexes
+	s.mutex.Lock()
+	delete(s.indexes, collection)
+	s.mutex.Unlock()
+
+	err := os.Remove(d.keyIndexPath(collection))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
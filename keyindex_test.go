@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Keys on a reopened database is served from the persisted key index while
+// the collection directory is unchanged, and a stale or corrupt index is
+// detected and rebuilt from a scan.
+func TestKeyIndex(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	writeN(t, d, 0, 2000)
+	collection := filepath.Join(dir, "n")
+	quiet := time.Now().Add(-time.Hour)
+	setGeneration := func(mtime time.Time) {
+		t.Helper()
+		if err := os.Chtimes(collection, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(d *Driver, want int, what string) {
+		t.Helper()
+		if n, err := d.Count("n"); err != nil || n != want {
+			t.Fatalf("%s: Count = %d, %v, want %d", what, n, err, want)
+		}
+	}
+
+	setGeneration(quiet)
+	count(d, 2000, "after the scan")
+	d.Close()
+	if _, err := os.Stat(d.keyIndexPath("n")); err != nil {
+		t.Fatalf("no key index was saved: %s", err)
+	}
+
+	// A record slipped in behind the index's back, leaving the directory
+	// looking unchanged, shows that Keys does not scan.
+	if err := os.WriteFile(filepath.Join(collection, "hidden.json"), []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setGeneration(quiet)
+	d = reopenTest(t, dir, nil)
+	started := time.Now()
+	count(d, 2000, "from the index")
+	t.Logf("cold Keys of 2000 records from the index took %s", time.Since(started))
+	d.Close()
+
+	setGeneration(quiet.Add(time.Second))
+	d = reopenTest(t, dir, nil)
+	count(d, 2001, "with the index stale")
+	d.Close()
+	if idx, err := d.loadKeyIndex("n"); err != nil || len(idx.Names) != 2001 {
+		t.Errorf("the stale index was not rebuilt: %v", err)
+	}
+
+	if err := os.WriteFile(d.keyIndexPath("n"), []byte(`{"Generation":1,"Names":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := &lineLog{}
+	d = reopenTest(t, dir, &Options{Logger: log})
+	count(d, 2001, "with the index corrupt")
+	if len(log.lines) != 1 || !strings.Contains(log.lines[0], "Rebuilding key index of n") {
+		t.Errorf("warnings %q, want one about rebuilding the index", log.lines)
+	}
+}
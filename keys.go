@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const keySeparator = "~"
+
+var keyEscaper = strings.NewReplacer("%", "%25", keySeparator, "%7E", "/", "%2F", "\\", "%5C")
+
+// CompositeKey joins key parts with a separator, escaping it (and path
+// separators) inside the parts so distinct tuples never share a key.
+func CompositeKey(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = keyEscaper.Replace(part)
+	}
+	return strings.Join(escaped, keySeparator)
+}
+
+// KeyFor derives the resource name for v from the collection's KeyFields.
+func (d *Driver) KeyFor(collection string, v interface{}) (string, error) {
+	fields := d.keyFields(collection)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no key fields configured for %s", collection)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		value, ok := lookup(doc, field)
+		if !ok || value == nil {
+			return "", fmt.Errorf("%w: %s", ErrMissingKeyField, field)
+		}
+		if parts[i], err = keyString(value); err != nil {
+			return "", err
+		}
+	}
+	return CompositeKey(parts...), nil
+}
+
+// keyString renders a decoded JSON value as a resource name.
+func keyString(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *Driver) Insert(collection string, v interface{}) (string, error) {
+	if collection == "" {
+		return "", fmt.Errorf("collection is required")
+	}
+
+	resource, err := d.KeyFor(collection, v)
+	if err != nil {
+		return "", err
+	}
+	return resource, d.Write(collection, resource, v)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Users keyed by (Country, City, Name) get stable keys that do not collide,
+// even when a part holds the separator, and read back under them.
+func TestCompositeKeys(t *testing.T) {
+	d := openTest(t, &Options{KeyFields: map[string][]string{
+		"user": {"Address.Country", "Address.City", "Name"},
+	}})
+
+	users := append([]User(nil), testUsers...)
+	users = append(users,
+		User{Name: "b~c", Age: "1", Address: Address{City: "a", Country: "x", Code: "1"}},
+		User{Name: "c", Age: "1", Address: Address{City: "a~b", Country: "x", Code: "1"}},
+	)
+	keys := make(map[string]User)
+	for _, u := range users {
+		key, err := d.Insert("user", u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, err := d.KeyFor("user", u); err != nil || again != key {
+			t.Errorf("KeyFor %s = %q, %v, want the stable %q", u.Name, again, err, key)
+		}
+		if other, ok := keys[key]; ok {
+			t.Errorf("%s and %s share the key %q", other.Name, u.Name, key)
+		}
+		keys[key] = u
+	}
+	if want := CompositeKey("india", "bangalore", "John"); keys[want].Name != "John" {
+		t.Errorf("John is not keyed %q", want)
+	}
+
+	for key, want := range keys {
+		var got User
+		if err := d.Read("user", key, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%q read back %+v, want %+v", key, got, want)
+		}
+	}
+
+	if _, err := d.Insert("user", map[string]string{"Name": "NoAddress"}); !errors.Is(err, ErrMissingKeyField) {
+		t.Errorf("Insert without the key fields = %v, want ErrMissingKeyField", err)
+	}
+}
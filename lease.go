@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role is a driver's part in single-writer election between processes
+// sharing a directory. The zero value, RoleStandalone, disables election.
+type Role int
+
+const (
+	RoleStandalone Role = iota
+	// RoleAuto takes the writer lease when it is free or stale.
+	RoleAuto
+	// RolePrimary is RoleAuto, except that New fails with ErrNotPrimary if
+	// the lease cannot be taken within LeaseStale.
+	RolePrimary
+	// RoleReplica never writes.
+	RoleReplica
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleStandalone:
+		return "standalone"
+	case RoleAuto:
+		return "auto"
+	case RolePrimary:
+		return "primary"
+	case RoleReplica:
+		return "replica"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+const (
+	leaseDir     = "_lease"
+	leaseCurrent = "current"
+
+	defaultLeaseHeartbeat = time.Second
+	defaultLeaseStale     = 5 * time.Second
+)
+
+type (
+	leaseRecord struct {
+		Epoch    uint64
+		Owner    string
+		Beat     uint64
+		Released bool `json:",omitempty"`
+	}
+	// lease implements the election. The newest _lease/epoch-<n> claim
+	// names the current epoch, and its owner bumps Beat in _lease/current
+	// every heartbeat. Standbys never compare clocks: they time how long
+	// the claims and Beat have gone unchanged on their own monotonic clock,
+	// and once that exceeds LeaseStale they race to create the next claim
+	// exclusively. The winner becomes primary. A primary that finds a newer
+	// claim, or has missed its own heartbeats for too long, steps down.
+	// Because the claims rather than current decide the epoch, a stale
+	// heartbeat overwriting a newer current, or a claimer dying before it
+	// writes current, cannot wedge the election.
+	lease struct {
+		d         *Driver
+		id        string
+		heartbeat time.Duration
+		stale     time.Duration
+
+		mutex     sync.Mutex
+		epoch     uint64
+		beat      uint64
+		lastBeat  time.Time
+		seen      leaseRecord
+		seenClaim uint64
+		seenAt    time.Time
+		callbacks []func(Role)
+	}
+)
+
+func (d *Driver) startLease() error {
+	if d.opts.Role == RoleStandalone {
+		return nil
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	l := &lease{
+		d:         d,
+		id:        hex.EncodeToString(b),
+		heartbeat: d.opts.LeaseHeartbeat,
+		stale:     d.opts.LeaseStale,
+	}
+	if l.heartbeat <= 0 {
+		l.heartbeat = defaultLeaseHeartbeat
+	}
+	if l.stale <= 0 {
+		l.stale = defaultLeaseStale
+	}
+	if l.stale <= l.heartbeat {
+		return fmt.Errorf("LeaseStale (%s) must be longer than LeaseHeartbeat (%s)", l.stale, l.heartbeat)
+	}
+	if err := os.MkdirAll(filepath.Join(d.dir, leaseDir), 0755); err != nil {
+		return err
+	}
+	d.lease = l
+
+	l.tick()
+	if d.opts.Role == RolePrimary {
+		deadline := time.Now().Add(l.stale + l.heartbeat)
+		for !d.IsPrimary() {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%w: the writer lease of %s is held by another process", ErrNotPrimary, d.dir)
+			}
+			time.Sleep(l.heartbeat)
+			l.tick()
+		}
+	}
+
+	go l.run()
+	return nil
+}
+
+// IsPrimary reports whether this driver may write. Without election it
+// always may.
+func (d *Driver) IsPrimary() bool {
+	l := d.lease
+	if l == nil {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.primary()
+}
+
+// OnRoleChange registers fn to be called with RolePrimary when this driver
+// takes the writer lease and RoleReplica when it loses it.
+func (d *Driver) OnRoleChange(fn func(Role)) {
+	if l := d.lease; l != nil {
+		l.mutex.Lock()
+		l.callbacks = append(l.callbacks, fn)
+		l.mutex.Unlock()
+	}
+}
+
+// primary must be called with l.mutex held. A primary that has not
+// heartbeated for longer than stale minus one interval no longer trusts
+// its lease, so it stops writing before a standby can take over.
+func (l *lease) primary() bool {
+	return l.epoch > 0 && time.Since(l.lastBeat) < l.stale-l.heartbeat
+}
+
+func (l *lease) path(name string) string {
+	return filepath.Join(l.d.dir, leaseDir, name)
+}
+
+func (l *lease) claimPath(epoch uint64) string {
+	return l.path("epoch-" + strconv.FormatUint(epoch, 10))
+}
+
+func (l *lease) read() (leaseRecord, bool, error) {
+	var rec leaseRecord
+	b, err := ioutil.ReadFile(l.path(leaseCurrent))
+	if os.IsNotExist(err) {
+		return rec, false, nil
+	}
+	if err != nil {
+		return rec, false, err
+	}
+	return rec, true, json.Unmarshal(b, &rec)
+}
+
+func (l *lease) write(rec leaseRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp := l.path(leaseCurrent + "." + l.id + ".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path(leaseCurrent))
+}
+
+func (l *lease) run() {
+	ticker := time.NewTicker(l.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.tick()
+		case <-l.d.ctx.Done():
+			l.release()
+			return
+		}
+	}
+}
+
+func (l *lease) tick() {
+	l.mutex.Lock()
+	before := l.primary()
+	if err := l.step(); err != nil {
+		l.d.log.Error("Writer lease of %s: %s", l.d.dir, err)
+	}
+	after := l.primary()
+	callbacks := append([]func(Role){}, l.callbacks...)
+	l.mutex.Unlock()
+
+	if before == after {
+		return
+	}
+	role := RoleReplica
+	if after {
+		role = RolePrimary
+	}
+	l.d.log.Info("%s is now %s for %s", l.id, role, l.d.dir)
+	for _, fn := range callbacks {
+		fn(role)
+	}
+}
+
+// step runs one round of the election with l.mutex held.
+func (l *lease) step() error {
+	rec, exists, err := l.read()
+	if err != nil {
+		return err
+	}
+	claimed, err := l.lastClaim()
+	if err != nil {
+		return err
+	}
+
+	if l.epoch > 0 {
+		// Renewing a lease that has lapsed could overlap a standby that
+		// already took over, so a primary that missed its heartbeats steps
+		// down and stands for election again instead.
+		if claimed != l.epoch || !l.primary() {
+			l.epoch = 0
+			return nil
+		}
+		l.beat++
+		if err := l.write(leaseRecord{Epoch: l.epoch, Owner: l.id, Beat: l.beat}); err != nil {
+			return err
+		}
+		l.lastBeat = time.Now()
+		return nil
+	}
+
+	if l.d.opts.Role == RoleReplica {
+		return nil
+	}
+
+	now := time.Now()
+	if rec != l.seen || claimed != l.seenClaim || l.seenAt.IsZero() {
+		l.seen, l.seenClaim, l.seenAt = rec, claimed, now
+	}
+	// A release only frees the lease if no newer claimer, who may be about
+	// to write current, has come along since.
+	released := rec.Released && rec.Epoch == claimed
+	if (exists || claimed > 0) && !released && now.Sub(l.seenAt) < l.stale {
+		return nil
+	}
+
+	epoch := claimed + 1
+	f, err := os.OpenFile(l.claimPath(epoch), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	if err := l.write(leaseRecord{Epoch: epoch, Owner: l.id}); err != nil {
+		return err
+	}
+	l.epoch, l.beat, l.lastBeat = epoch, 0, time.Now()
+	l.removeClaimsBefore(epoch)
+	return nil
+}
+
+// lastClaim returns the newest claimed epoch, or 0 if there is none.
+func (l *lease) lastClaim() (uint64, error) {
+	files, err := ioutil.ReadDir(filepath.Join(l.d.dir, leaseDir))
+	if err != nil {
+		return 0, err
+	}
+	var last uint64
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, "epoch-") {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimPrefix(name, "epoch-"), 10, 64); err == nil && n > last {
+			last = n
+		}
+	}
+	return last, nil
+}
+
+func (l *lease) removeClaimsBefore(epoch uint64) {
+	files, err := ioutil.ReadDir(filepath.Join(l.d.dir, leaseDir))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, "epoch-") {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimPrefix(name, "epoch-"), 10, 64); err == nil && n < epoch {
+			os.Remove(l.path(name))
+		}
+	}
+}
+
+// release hands the lease back on Close so a standby can take over at its
+// next heartbeat instead of waiting for the lease to go stale.
+func (l *lease) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.primary() {
+		return
+	}
+	if err := l.write(leaseRecord{Epoch: l.epoch, Owner: l.id, Beat: l.beat, Released: true}); err != nil {
+		l.d.log.Error("Unable to release writer lease of %s: %s", l.d.dir, err)
+	}
+	l.epoch = 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openUnshared opens dir without the registry, as another process would.
+func openUnshared(t *testing.T, dir string, opts Options) *Driver {
+	t.Helper()
+	opts.Logger = nopLog{}
+	d, err := open(dir, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.cancel()
+		d.events.close()
+		time.Sleep(3 * opts.LeaseHeartbeat)
+	})
+	return d
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestLeaseFailover(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Role: RoleAuto, LeaseHeartbeat: 10 * time.Millisecond, LeaseStale: 60 * time.Millisecond}
+	a := openUnshared(t, dir, opts)
+	b := openUnshared(t, dir, opts)
+
+	if a.IsPrimary() == b.IsPrimary() {
+		t.Fatalf("primary: a %v, b %v; want exactly one", a.IsPrimary(), b.IsPrimary())
+	}
+	primary, standby := a, b
+	if b.IsPrimary() {
+		primary, standby = b, a
+	}
+	if err := standby.Write("user", "John", testUsers[0]); !errors.Is(err, ErrNotPrimary) {
+		t.Fatalf("standby write: expected ErrNotPrimary, got %v", err)
+	}
+	if err := primary.Write("user", "John", testUsers[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stall the primary's heartbeat; the standby takes over once the lease
+	// goes stale, and the old primary steps down rather than renewing.
+	primary.lease.mutex.Lock()
+	stalled := time.Now()
+	waitFor(t, "failover", standby.IsPrimary)
+	if waited := time.Since(stalled); waited < opts.LeaseStale {
+		t.Fatalf("standby took over after %s, before the lease went stale", waited)
+	}
+	primary.lease.mutex.Unlock()
+
+	if primary.IsPrimary() {
+		t.Fatal("the stalled primary still claims the lease")
+	}
+	time.Sleep(5 * opts.LeaseHeartbeat)
+	if primary.IsPrimary() || !standby.IsPrimary() {
+		t.Fatalf("after failover: old primary %v, new primary %v", primary.IsPrimary(), standby.IsPrimary())
+	}
+}
+
+func TestLeaseRecoversFromRaces(t *testing.T) {
+	dir := t.TempDir()
+	d := openUnshared(t, dir, Options{})
+	if err := os.MkdirAll(filepath.Join(dir, leaseDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	newLease := func(id string) *lease {
+		return &lease{d: d, id: id, heartbeat: 10 * time.Millisecond, stale: 50 * time.Millisecond}
+	}
+
+	a := newLease("a")
+	if err := a.step(); err != nil || a.epoch != 1 {
+		t.Fatalf("a: epoch %d, %v", a.epoch, err)
+	}
+
+	// b claims epoch 2 and dies before writing current.
+	if err := os.WriteFile(a.claimPath(2), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if a.step(); a.epoch != 0 {
+		t.Fatal("a kept its lease after a newer claim")
+	}
+
+	c := newLease("c")
+	waitFor(t, "c to take the orphaned lease", func() bool {
+		if err := c.step(); err != nil {
+			t.Fatal(err)
+		}
+		return c.epoch > 0
+	})
+	if c.epoch != 3 {
+		t.Fatalf("c claimed epoch %d, want 3", c.epoch)
+	}
+
+	// A heartbeat from a, written before it saw c's claim, lands late.
+	if err := a.write(leaseRecord{Epoch: 1, Owner: "a", Beat: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.step(); err != nil || c.epoch != 3 {
+		t.Fatalf("c lost its lease to a stale heartbeat: epoch %d, %v", c.epoch, err)
+	}
+	if rec, _, _ := c.read(); rec.Epoch != 3 || rec.Owner != "c" {
+		t.Fatalf("current is %+v", rec)
+	}
+
+	// A primary whose own heartbeats lapsed must not renew.
+	c.lastBeat = time.Now().Add(-c.stale)
+	before, _, _ := c.read()
+	if c.step(); c.epoch != 0 {
+		t.Fatal("c renewed a lapsed lease")
+	}
+	if after, _, _ := c.read(); after != before {
+		t.Fatalf("c heartbeated a lapsed lease: %+v", after)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// AppendToList treats a record as a JSON array and appends v to it under
+// the collection lock, creating the record if it does not exist.
+func (d *Driver) AppendToList(collection, resource string, v interface{}) (err error) {
+	op := d.trace(nil, "AppendToList", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+	if err := validateName(collection, resource); err != nil {
+		return err
+	}
+
+	elem, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return err
+	}
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	var list []json.RawMessage
+	b, err := d.readRecord(op.log, d.recordPath(collection, resource))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(b, &list); err != nil {
+			return fmt.Errorf("%s/%s is not a list: %w", collection, resource, err)
+		}
+	}
+
+	if err := d.write(op, collection, resource, append(list, elem)); err != nil {
+		return err
+	}
+	return d.setExpiry(op, collection, resource)
+}
+
+// ReadList reads a record written with AppendToList. It is a function
+// rather than a method because methods cannot have type parameters.
+func ReadList[T any](d *Driver, collection, resource string) ([]T, error) {
+	var list []T
+	if err := d.Read(collection, resource, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
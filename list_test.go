@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// Appended elements read back in the order they were appended, and
+// concurrent appends lose none of them.
+func TestAppendToList(t *testing.T) {
+	d := openTest(t, nil)
+	tags := []string{"go", "json", "files"}
+	for _, tag := range tags {
+		if err := d.AppendToList("tags", "John", tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := ReadList[string](d, "tags", "John")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tags) {
+		t.Errorf("read %v, want %v", got, tags)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := d.AppendToList("n", "list", i); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	list, err := ReadList[int](d, "n", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 50 {
+		t.Errorf("the list holds %d elements after 50 appends", len(list))
+	}
+
+	seedUsers(t, d)
+	if err := d.AppendToList("user", "John", "x"); err == nil {
+		t.Error("AppendToList on a record that is not a list succeeded")
+	}
+}
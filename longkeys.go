@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// longKeysDir maps the hashes in the file names of records whose keys are
+// longer than MaxKeyLength back to the keys. Entries are shared by all
+// collections and never removed.
+const longKeysDir = "_longkeys"
+
+// hashedMarker separates the readable prefix of a hashed file name from
+// the hash. SanitizeKey never produces it, as it follows every % with two
+// hex digits.
+const hashedMarker = "%%"
+
+var hashedLength = len(hashedMarker) + sha256.Size*2
+
+type longKeySet struct {
+	mutex sync.Mutex
+	keys  map[string]string
+}
+
+func keyHash(resource string) string {
+	sum := sha256.Sum256([]byte(resource))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashedName shortens the file name of a long key to at most max bytes, or
+// to the length of the hash if max is smaller, keeping as much of the
+// sanitized name as fits in front for readability.
+func hashedName(name, resource string, max int) string {
+	prefix := name[:0]
+	if n := max - hashedLength; n > 0 {
+		prefix = name[:n]
+		// Do not cut an escape in half.
+		if i := strings.LastIndexByte(prefix, '%'); i >= 0 && i > len(prefix)-3 {
+			prefix = prefix[:i]
+		}
+	}
+	return prefix + hashedMarker + keyHash(resource)
+}
+
+// recordLongKey makes the key of a record about to be written recoverable
+// from its file name.
+func (d *Driver) recordLongKey(resource string) error {
+	name := d.fileKey(resource)
+	i := strings.Index(name, hashedMarker)
+	if d.opts.MaxKeyLength <= 0 || i < 0 {
+		return nil
+	}
+	hash := name[i+len(hashedMarker):]
+
+	s := d.longKeys
+	s.mutex.Lock()
+	_, known := s.keys[hash]
+	s.mutex.Unlock()
+	if known {
+		return nil
+	}
+
+	dir := filepath.Join(d.dir, longKeysDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, hash)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := d.writeFile(path+".tmp", []byte(resource), true); err != nil {
+			return err
+		}
+		if err := d.rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	s.keys[hash] = resource
+	s.mutex.Unlock()
+	return nil
+}
+
+// longKey returns the key of a hashed file name.
+func (d *Driver) longKey(name string) (string, bool) {
+	i := strings.Index(name, hashedMarker)
+	if d.opts.MaxKeyLength <= 0 || i < 0 {
+		return "", false
+	}
+	hash := name[i+len(hashedMarker):]
+
+	s := d.longKeys
+	s.mutex.Lock()
+	key, ok := s.keys[hash]
+	s.mutex.Unlock()
+	if ok {
+		return key, true
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(d.dir, longKeysDir, hash))
+	if err != nil || keyHash(string(b)) != hash {
+		d.log.Warn("No key recorded for %s", name)
+		return "", false
+	}
+	s.mutex.Lock()
+	s.keys[hash] = string(b)
+	s.mutex.Unlock()
+	return string(b), true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A 1000-character key is stored under a hashed file name within
+// MaxKeyLength and reads, lists and deletes under its own name, also after
+// a reopen.
+func TestLongKey(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{MaxKeyLength: 100}
+	d := reopenTest(t, dir, opts)
+	long := strings.Repeat("k", 990) + "/ünïcode"
+	want := testUsers[0]
+	if err := d.Write("user", long, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("user", "short", want); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if name := strings.TrimSuffix(f.Name(), d.ext()); len(name) > 100 {
+			t.Errorf("file name %s is longer than MaxKeyLength", f.Name())
+		}
+	}
+	d.Close()
+
+	d = reopenTest(t, dir, opts)
+	var got User
+	if err := d.Read("user", long, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("the long key read back %+v", got)
+	}
+	keys, err := d.Keys("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{long, "short"}) && !reflect.DeepEqual(keys, []string{"short", long}) {
+		t.Errorf("Keys = %q, want the long key and short", keys)
+	}
+
+	if err := d.Delete("user", long); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := d.Keys("user"); err != nil || !reflect.DeepEqual(keys, []string{"short"}) {
+		t.Errorf("Keys after the delete = %q, %v", keys, err)
+	}
+}
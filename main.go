@@ -1,17 +1,61 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jcelliott/lumber"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 const Version = "1.0.1"
 
+var (
+	// ErrNotFound is fs.ErrNotExist, so errors.Is matches it both for a
+	// missing record, the *fs.PathError of its file as os.IsNotExist
+	// expects, and for a missing view, index, backup or key.
+	ErrNotFound           = fs.ErrNotExist
+	ErrMissingKeyField    = errors.New("missing key field")
+	ErrBrokenReference    = errors.New("broken reference")
+	ErrReferenced         = errors.New("record is referenced")
+	ErrTornWrite          = errors.New("torn write")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrFrozen             = errors.New("collection is frozen")
+	ErrSymlink            = errors.New("refusing to follow symlink")
+	ErrConflict           = errors.New("version conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrReadOnly           = errors.New("database is read-only")
+	ErrUnsupportedValue   = errors.New("value cannot be encoded as JSON")
+	ErrNotPrimary         = errors.New("not the primary writer")
+	ErrTooManyCollections = errors.New("too many collections")
+	ErrGuarded            = errors.New("key prefix is guarded")
+
+	ErrReservedName         = errors.New("name is reserved")
+	ErrReservedNameOccupied = errors.New("reserved name occupied")
+	ErrChecksumMismatch     = errors.New("checksum mismatch")
+	ErrInvalidEncoding      = errors.New("invalid encoding")
+	ErrDuplicateValue       = errors.New("duplicate value")
+	ErrStaleView            = errors.New("view is stale")
+	ErrUnauthenticated      = errors.New("invalid or missing token")
+	ErrPermissionDenied     = errors.New("permission denied")
+)
+
+const (
+	readRetries = 3
+	readBackoff = 5 * time.Millisecond
+)
+
 type (
 	Logger interface {
 		Fatal(string, ...interface{})
@@ -21,16 +65,246 @@ type (
 		Debug(string, ...interface{})
 		Trace(string, ...interface{})
 	}
+	// Driver is a handle on a database. Every New of a directory returns a
+	// handle of its own, closed independently, on one shared database.
 	Driver struct {
-		mutex   sync.Mutex
-		mutexes map[string]*sync.Mutex
-		dir     string
-		log     Logger
+		*database
+		closed bool
+	}
+	database struct {
+		mutex          sync.Mutex
+		mutexes        map[string]*collectionMutex
+		frozen         map[string]bool
+		occupied       []string
+		defs           map[string]Definitions
+		pipelines      map[string]*pipeline
+		access         *accessTracker
+		lease          *lease
+		views          *viewSet
+		readOnly       bool
+		rawKeys        bool
+		snapshot       string
+		ctx            context.Context
+		cancel         context.CancelFunc
+		dir            string
+		log            Logger
+		opts           Options
+		listings       *listingCache
+		collections    *collectionSet
+		indexes        *indexSet
+		maint          *maintenance
+		aead           cipher.AEAD
+		guards         *guardSet
+		auditLog       *auditLog
+		commits        *groupCommit
+		longKeys       *longKeySet
+		keyIndexes     *keyIndexSet
+		readTransforms *readTransformSet
+		packs          *packSet
+		cursors        *cursorSet
+		tokens         *tokenSet
+		events         *eventBus
+		references     *refIndex
+		writeLimit     *limiter
+		readLimit      *limiter
+		key            string
+		refs           int
+
+		dropToken string
+
+		io ioCounters
 	}
 )
 
 type Options struct {
 	Logger
+
+	ListingCacheBytes int64
+	ListingCacheTTL   time.Duration
+
+	// ReadAllSubdirectories makes ReadAll include the records of nested
+	// collections, keyed by their path below the collection, instead of
+	// skipping subdirectories. Such listings are not cached.
+	ReadAllSubdirectories bool
+
+	ReadTransform func(collection string, data []byte) ([]byte, error)
+
+	// ReadRepair writes records changed by a transform registered with
+	// RegisterReadTransform back in their new shape after they are read.
+	ReadRepair bool
+
+	ChangeLog bool
+	// ChangeLogRetain is how many of the newest events the change log
+	// keeps, 100000 by default. Older ones are pruned once the log holds
+	// twice as many, so a Watch or view replaying from before them misses
+	// them.
+	ChangeLogRetain int
+
+	BackupDir string
+	Strict    bool
+
+	TimeLayouts map[string]string
+
+	TraceHook func(ev TraceEvent)
+
+	Compression Compression
+
+	ReadRetryOnMissing bool
+
+	Normalizers map[string]Normalizer
+	KeyFields   map[string][]string
+
+	VerifyRoundTrip bool
+
+	References map[string]map[string]Reference
+
+	AutoRecoverTornWrites bool
+	FollowSymlinks        bool
+
+	// RecoverTempFiles decides what New does with temp files left by
+	// interrupted writes.
+	RecoverTempFiles TempFilePolicy
+
+	VersionField string
+
+	// AuditLog, when set, receives a JSON AuditEntry line per record
+	// written, listing the fields added, changed and removed.
+	AuditLog io.Writer
+
+	// KeyObfuscator, when set, is what PublicKey and ResolvePublicKey use
+	// to hide resource keys from outside callers. Records are still stored
+	// and listed under their real keys.
+	KeyObfuscator KeyObfuscator
+
+	// MirrorDir, when set, receives a copy of every record written or
+	// deleted, laid out like the database directory. A write fails when the
+	// mirror cannot be updated unless MirrorBestEffort is set, in which case
+	// the failure is only logged.
+	MirrorDir        string
+	MirrorBestEffort bool
+
+	// VerifyReplicaReads is the fraction of reads, between 0 and 1, that
+	// also compare the record with its MirrorDir copy. A divergence is
+	// counted in IOStats, logged, and repaired from the newer side when
+	// VersionField or UpdatedAtField tells which one that is.
+	VerifyReplicaReads float64
+
+	// GroupCommit, when set, makes WithSync writes share their fsyncs with
+	// the others made within that window: the temp files of a batch are
+	// synced together, then renamed into place, then their directories are
+	// synced together, so a write waits the window plus two syncs. The
+	// collection lock is released meanwhile, and a write whose record
+	// changed before its rename starts over. Collections with indexes or
+	// references, and VerifyChecksums, keep one sync per write.
+	GroupCommit time.Duration
+
+	// VerifyChecksums stores a SHA-256 sidecar with every record written
+	// and makes reads fail with ErrChecksumMismatch when the record file no
+	// longer matches it.
+	VerifyChecksums bool
+
+	// UpdatedAtField, a dotted field path, is set to the time of every
+	// write, in RFC 3339 UTC. KeysModifiedSince prefers it to file times.
+	UpdatedAtField string
+
+	// SanitizeKey maps resource names to file names and UnsanitizeKey maps
+	// them back for listings. Both default to the package functions of the
+	// same name, except in a database created before keys were encoded,
+	// which keeps storing them as they are until it is opened once with
+	// MigrateKeyEncoding.
+	SanitizeKey        func(string) string
+	UnsanitizeKey      func(string) string
+	MigrateKeyEncoding bool
+
+	// Packed lists the collections whose records are appended to pack
+	// files of about PackSize bytes (4 MB by default) under _packs instead
+	// of taking a file, and a filesystem block, each. Reads fetch a record
+	// from its pack through an in-memory index, deletes append tombstones
+	// and Compact reclaims the space they leave. The index is saved on
+	// Close and Compact, and whatever was appended since is rescanned on
+	// open, so only one process may use a packed collection at a time.
+	Packed   map[string]bool
+	PackSize int64
+
+	// MaxKeyLength, when set, caps the length of record file names, minus
+	// their extension. Longer ones are replaced by a hash, at least 66
+	// bytes long, and the key is kept under _longkeys.
+	MaxKeyLength int
+
+	// TrackReads lists the collections whose last-read times are kept,
+	// flushed to disk every AccessFlushInterval (10s by default).
+	TrackReads          map[string]bool
+	AccessFlushInterval time.Duration
+
+	// Role enables single-writer election among processes sharing the
+	// directory. The primary renews its lease every LeaseHeartbeat (1s by
+	// default); standbys take over once it has not been renewed for
+	// LeaseStale (5s by default).
+	Role           Role
+	LeaseHeartbeat time.Duration
+	LeaseStale     time.Duration
+
+	// Maintenance, when its Interval and Tasks are set, runs maintenance
+	// tasks in the background.
+	Maintenance MaintenanceConfig
+
+	// TTLSweepInterval, when set, deletes records whose WithTTL has passed
+	// from every collection at that interval, instead of only hiding them
+	// from reads. The sweep stops when the driver is closed.
+	TTLSweepInterval time.Duration
+
+	// MaxCollections, when set, makes a write that would create one
+	// top-level collection too many fail with ErrTooManyCollections.
+	MaxCollections int
+
+	// MutexIdleTTL, when set, periodically evicts the locks of collections
+	// that have not been accessed for that long.
+	MutexIdleTTL time.Duration
+
+	// DisableHTMLEscape stores <, > and & literally instead of as \u003c
+	// and friends, and Indent indents stored records. With StreamWrites,
+	// records are encoded straight into the temp file unless compression,
+	// a normalizer, references, an index, VersionField, UpdatedAtField or
+	// VerifyRoundTrip need the encoded bytes first.
+	DisableHTMLEscape bool
+	Indent            string
+	StreamWrites      bool
+
+	// SyncViews applies changes to views registered with RegisterView
+	// within the mutation instead of from the change stream.
+	SyncViews bool
+
+	// EncryptionKey, an AES key of 16, 24 or 32 bytes, encrypts records
+	// with AES-GCM after compression. History versions are encrypted too;
+	// metadata, views and the change log are not.
+	EncryptionKey []byte
+
+	// AllowNull lets Write store a nil value as null instead of failing
+	// with ErrUnsupportedValue.
+	AllowNull bool
+
+	// Masks holds, per collection, named lists of dotted field paths that
+	// reads made with that profile, through WithMaskProfile or WithMask,
+	// replace with MaskPlaceholder, or remove if it is empty. Masks apply
+	// to the records returned by Get, ReadAllCtx and masked queries; writes
+	// are unaffected.
+	Masks           map[string]map[string][]string
+	MaskPlaceholder string
+
+	// ValidateUTF8 makes writes of documents that are not valid UTF-8,
+	// such as raw JSON from Modify or ImportNDJSON, fail with
+	// ErrInvalidEncoding instead of being stored as they are.
+	ValidateUTF8 bool
+
+	ReadOnly bool
+	// WritableClone opens a database created by CloneTo for writing;
+	// clones are read-only by default.
+	WritableClone bool
+
+	WriteRateLimit            *RateLimit
+	CollectionWriteRateLimits map[string]RateLimit
+	ReadRateLimit             *RateLimit
+	CollectionReadRateLimits  map[string]RateLimit
 }
 
 func New(dir string, options *Options) (*Driver, error) {
@@ -41,148 +315,823 @@ func New(dir string, options *Options) (*Driver, error) {
 		opts = *options
 	}
 
+	key, err := registryKey(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if driver, ok := registry.drivers[key]; ok {
+		if err := driver.opts.conflicts(opts); err != nil {
+			return nil, err
+		}
+		driver.refs++
+		return &Driver{database: driver.database}, nil
+	}
+
 	if opts.Logger == nil {
 		opts.Logger = lumber.NewConsoleLogger(lumber.INFO)
 	}
 
-	driver := &Driver{
-		dir:     dir,
-		mutexes: make(map[string]*sync.Mutex),
-		log:     opts.Logger,
+	driver, err := open(dir, key, opts)
+	if err != nil {
+		return driver, err
+	}
+
+	driver.refs = 1
+	registry.drivers[key] = driver
+
+	return driver, nil
+}
+
+// open creates a driver for dir without consulting the registry.
+func open(dir, key string, opts Options) (driver *Driver, err error) {
+	aead, err := newAEAD(opts.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// A driver that fails to open stops whatever it already started.
+	defer func() {
+		if err != nil {
+			cancel()
+			driver.events.close()
+		}
+	}()
+
+	driver = &Driver{database: &database{
+		ctx:            ctx,
+		cancel:         cancel,
+		dir:            dir,
+		mutexes:        make(map[string]*collectionMutex),
+		frozen:         make(map[string]bool),
+		defs:           make(map[string]Definitions),
+		pipelines:      make(map[string]*pipeline),
+		log:            opts.Logger,
+		opts:           opts,
+		listings:       newListingCache(opts.ListingCacheBytes, opts.ListingCacheTTL),
+		collections:    &collectionSet{},
+		indexes:        newIndexSet(),
+		maint:          &maintenance{writes: make(map[string]uint64), seen: make(map[string]uint64)},
+		references:     newRefIndex(),
+		key:            key,
+		aead:           aead,
+		guards:         &guardSet{guards: make(map[*prefixGuard]bool)},
+		auditLog:       &auditLog{},
+		commits:        &groupCommit{},
+		longKeys:       &longKeySet{keys: make(map[string]string)},
+		keyIndexes:     &keyIndexSet{indexes: make(map[string]*keyIndex)},
+		readTransforms: newReadTransformSet(),
+		packs:          newPackSet(),
+		cursors:        &cursorSet{open: make(map[*Cursor]bool)},
+		tokens:         newTokenSet(),
+	}}
+	if driver.writeLimit, err = newLimiter("write", opts.WriteRateLimit, opts.CollectionWriteRateLimits); err != nil {
+		return driver, err
+	}
+	if driver.readLimit, err = newLimiter("read", opts.ReadRateLimit, opts.CollectionReadRateLimits); err != nil {
+		return driver, err
 	}
 
 	if _, err := os.Stat(dir); err == nil {
 		opts.Logger.Debug("Database already exists", dir)
-		return driver, nil
+	} else {
+		opts.Logger.Debug("Creating database directory", dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return driver, err
+		}
+	}
+
+	if err := driver.checkFormat(); err != nil {
+		return driver, err
+	}
+	driver.readOnly = opts.ReadOnly
+	if m, err := readManifest(dir); err == nil && m.Clone != nil && !opts.WritableClone {
+		opts.Logger.Info("%s is a clone of %s taken at %s; opening read-only", dir, m.Clone.Source, m.Clone.Time.Format(time.RFC3339))
+		driver.readOnly = true
+	}
+	if driver.occupied, err = driver.findOccupied(); err != nil {
+		return driver, err
+	}
+	if opts.ChangeLog {
+		if err := driver.checkOccupied(changeLogDir); err != nil {
+			return driver, err
+		}
+	}
+	if err := driver.loadMeta(); err != nil {
+		return driver, err
 	}
 
-	opts.Logger.Debug("Creating database directory", dir)
+	events, err := newEventBus(dir, opts)
+	if err != nil {
+		return driver, err
+	}
+	driver.events = events
+	driver.events.hook = driver.applySyncViews
+	driver.events.load = driver.viewDoc
+	driver.views = &viewSet{views: make(map[string]*view)}
+
+	if driver.access = newAccessTracker(driver); driver.access != nil {
+		go driver.access.run()
+	}
+
+	if opts.MutexIdleTTL > 0 {
+		go driver.sweepMutexes()
+	}
 
-	return driver, os.MkdirAll(dir, 0755)
+	if err := driver.startLease(); err != nil {
+		return driver, err
+	}
+
+	if err := driver.recoverTempFiles(); err != nil {
+		return driver, err
+	}
+	if opts.MigrateKeyEncoding && driver.rawKeys {
+		if err := driver.checkWritable(); err != nil {
+			return driver, err
+		}
+		if err := driver.migrateKeyEncoding(); err != nil {
+			return driver, err
+		}
+	}
+
+	if driver.opts.Maintenance.Interval > 0 && len(driver.opts.Maintenance.Tasks) > 0 {
+		go driver.runMaintenance()
+	}
+	if driver.opts.TTLSweepInterval > 0 {
+		go driver.sweepExpired()
+	}
+
+	return driver, nil
 }
 
 func (d *Driver) Write(collection, resourse string, v interface{}) error {
-	if collection == "" {
-		return fmt.Errorf("collection is required")
+	return d.Put(d.ctx, collection, resourse, v)
+}
+
+func (d *Driver) write(op *operation, collection, resource string, v interface{}) error {
+	if d.streamable(collection) && !op.call.dryRun {
+		return d.storeStream(op, collection, resource, v)
 	}
-	if resourse == "" {
-		return fmt.Errorf("resourse is required")
+
+	b, err := d.marshal(v)
+
+	if err != nil {
+		return err
 	}
-	mutex := d.getOrCreateNewMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
 
+	if d.opts.VerifyRoundTrip {
+		if err := verifyRoundTrip(v, b, d.marshal); err != nil {
+			op.log.Warn("Refusing to write %s/%s: %s", collection, resource, err)
+			return err
+		}
+	}
+
+	if d.opts.VersionField != "" {
+		if b, err = d.bumpVersion(op, collection, resource, b); err != nil {
+			return err
+		}
+	}
+	if d.opts.UpdatedAtField != "" {
+		if b, err = stampUpdatedAt(b, d.opts.UpdatedAtField); err != nil {
+			return err
+		}
+	}
+
+	return d.store(op, collection, resource, b)
+}
+
+// store atomically replaces a record with the JSON document b.
+func (d *Driver) store(op *operation, collection, resource string, b []byte) error {
 	dir := filepath.Join(d.dir, collection)
-	fnlPath := filepath.Join(dir, resourse+".json")
-	tmpPath := fnlPath + ".tmp"
+	fnlPath := d.recordPath(collection, resource)
+	tmpPath := d.tempPath(op, fnlPath)
 
+	if err := d.checkGuards(op, collection, resource); err != nil {
+		return err
+	}
+	if d.opts.ValidateUTF8 && !utf8.Valid(b) {
+		return fmt.Errorf("%w: %s/%s is not valid UTF-8", ErrInvalidEncoding, collection, resource)
+	}
+	if err := d.admitCollection(collection); err != nil {
+		return err
+	}
+	if err := d.recordLongKey(resource); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
+	if err := d.rejectSymlinks(op.log, dir, fnlPath, tmpPath); err != nil {
+		return err
+	}
 
-	b, err := json.Marshal(v)
+	b, err := d.normalize(collection, b)
+	if err != nil {
+		return err
+	}
+	if err := d.checkUnique(collection, resource, b); err != nil {
+		return err
+	}
 
+	targets, err := d.resolveReferences(collection, b, true)
+	if err != nil || op.call.dryRun {
+		return err
+	}
+	doc := b
+	before, err := d.previousDocument(op, collection, resource)
 	if err != nil {
 		return err
 	}
 
 	b = append(b, byte('\n'))
+	op.bytes = int64(len(b))
 
-	if err = ioutil.WriteFile(tmpPath, b, 0644); err != nil {
+	if b, err = d.encodeForStore(collection, b); err != nil {
 		return err
 	}
+	then := func() error {
+		if err := d.updateIndexes(collection, resource, doc); err != nil {
+			return err
+		}
+		return d.audit(collection, resource, before, doc)
+	}
+	if ps, err := d.packsOf(collection); err != nil {
+		return err
+	} else if ps != nil {
+		return d.storePacked(op, ps, collection, resource, b, targets, then)
+	}
 
-	return os.Rename(tmpPath, fnlPath)
+	if err = d.writeFile(tmpPath, b, true); err != nil {
+		return err
+	}
+	if err := failpoint(FailpointAfterTempWrite); err != nil {
+		return err
+	}
+	if d.opts.VerifyChecksums {
+		if err := d.writeChecksum(collection, resource, b); err != nil {
+			return err
+		}
+	}
+
+	return d.commitRecord(op, collection, resource, tmpPath, fnlPath, targets, then)
 }
 
-func (d *Driver) Read(collection, resource string, v interface{}) error {
-	if collection == "" {
-		return fmt.Errorf("collection is required")
+// commitRecord moves a fully written temp file over the record, then runs
+// then, if set, for the rest of the write. A staged write leaves both to
+// its group commit.
+func (d *Driver) commitRecord(op *operation, collection, resource, tmpPath, fnlPath string, targets map[string]refKey, then func() error) error {
+	if op.stage {
+		before, _ := os.Lstat(fnlPath)
+		op.staged = &stagedWrite{tmp: tmpPath, record: fnlPath, before: before, finish: func() error {
+			return d.placeRecord(op, collection, resource, tmpPath, fnlPath, targets, then)
+		}}
+		return nil
 	}
-
-	if resource == "" {
-		return fmt.Errorf("resource is required")
+	if op.call.sync {
+		if err := d.syncFile(tmpPath); err != nil {
+			return err
+		}
 	}
+	return d.placeRecord(op, collection, resource, tmpPath, fnlPath, targets, then)
+}
 
-	record := filepath.Join(d.dir, collection, resource)
-
-	if _, err := os.Stat(record); os.IsNotExist(err) {
+func (d *Driver) placeRecord(op *operation, collection, resource, tmpPath, fnlPath string, targets map[string]refKey, then func() error) error {
+	if err := d.archive(collection, resource, fnlPath); err != nil {
 		return err
 	}
 
-	b, err := ioutil.ReadFile(record + ".json")
+	defer d.listings.invalidate(collection)
 
-	if err != nil {
+	if err := failpoint(FailpointBeforeRename); err != nil {
+		return err
+	}
+	if err := d.rename(tmpPath, fnlPath); err != nil {
+		return err
+	}
+	if err := failpoint(FailpointAfterRenameBeforeDirSync); err != nil {
 		return err
 	}
+	if err := d.mirror(op.log, fnlPath, d.checksumPath(collection, resource)); err != nil {
+		return err
+	}
+	if op.call.sync && !op.stage {
+		if err := d.syncDir(filepath.Dir(fnlPath)); err != nil {
+			return err
+		}
+	}
+	d.references.update(refKey{collection, resource}, targets)
+	d.countWrite(collection)
 
-	return json.Unmarshal(b, v)
+	d.events.emit(EventWrite, collection, resource)
+	if then == nil {
+		return nil
+	}
+	return then()
+}
+
+func (d *Driver) Read(collection, resource string, v interface{}) error {
+	return d.ReadCtx(d.ctx, collection, resource, v)
+}
+
+// ReadCtx is Read with a context, which can select a consistency level
+// through WithConsistency.
+func (d *Driver) ReadCtx(ctx context.Context, collection, resource string, v interface{}) error {
+	return d.Get(ctx, collection, resource, v)
 }
 
 func (d *Driver) ReadAll(collection string) ([]string, error) {
+	return d.ReadAllCtx(d.ctx, collection)
+}
+
+// ReadAllCtx is ReadAll with a context, which can select a mask profile
+// through WithMaskProfile.
+func (d *Driver) ReadAllCtx(ctx context.Context, collection string) ([]string, error) {
+	records, err := d.readAll(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	paths := d.maskPaths(collection, maskProfileOf(ctx))
+	if len(paths) == 0 {
+		return records, nil
+	}
+	masked := make([]string, len(records))
+	for i, record := range records {
+		b, err := d.maskRecord([]byte(record), paths)
+		if err != nil {
+			return nil, wrapOp("read", collection, "", err)
+		}
+		masked[i] = string(b)
+	}
+	return masked, nil
+}
+
+func (d *Driver) readAll(ctx context.Context, collection string) (_ []string, err error) {
+	op := d.trace(ctx, "ReadAll", collection, "")
+	defer func() { op.end(err) }()
+	defer func() { err = wrapOp("read", collection, "", err) }()
+
 	if collection == "" {
 		return nil, fmt.Errorf("collection is required")
 	}
 
-	dir := filepath.Join(d.dir, collection)
+	if err := d.readLimit.take(ctx, op.log, collection); err != nil {
+		return nil, err
+	}
+
+	recursive := d.opts.ReadAllSubdirectories
+	if !recursive {
+		if records, ok := d.listings.get(collection); ok {
+			return records, nil
+		}
+	}
+	gen := d.listings.generation(collection)
 
-	if _, err := stat(dir); err != nil {
+	if _, err := d.stat(filepath.Join(d.dir, collection)); err != nil {
 		return nil, err
 	}
-	files, err := ioutil.ReadDir(dir)
+	files, expiring, err := d.recordFiles(collection, recursive)
 	if err != nil {
 		return nil, err
 	}
 
-	var records []string
+	var records, keys []string
 
 	for _, file := range files {
-		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		b, err := d.readRecord(op.log, filepath.Join(d.dir, file.collection, file.name))
+		if isTorn(b, err) {
+			if b, err = d.recoverTorn(op, file.collection, d.resourceOf(file.name)); errors.Is(err, ErrTornWrite) {
+				op.log.Warn("Skipping %s", err)
+				continue
+			}
+		}
 		if err != nil {
 			return nil, err
 		}
+		op.bytes += int64(len(b))
+		d.readRepair(op.log, file.collection, d.resourceOf(file.name), b)
+
+		if b, err = d.transform(file.collection, b); err != nil {
+			return nil, err
+		}
 
 		records = append(records, string(b))
+		keys = append(keys, file.key(d, collection))
+	}
+
+	// Expiry changes a listing without touching the directory, so only
+	// listings without TTLs are cached.
+	if !recursive && !expiring {
+		d.listings.put(collection, gen, records, keys)
 	}
 
 	return records, nil
 }
 
+type recordFile struct {
+	collection string
+	name       string
+}
+
+// key is the resource name of f as seen from collection: a path for
+// records of nested collections.
+func (f recordFile) key(d *Driver, collection string) string {
+	resource := d.resourceOf(f.name)
+	if f.collection == collection {
+		return resource
+	}
+	return filepath.ToSlash(filepath.Join(strings.TrimPrefix(f.collection, collection+string(filepath.Separator)), resource))
+}
+
+// recordFiles lists the unexpired record files of collection in name
+// order, skipping subdirectories or, with recursive, descending into them.
+// It also reports whether any record listed has a TTL.
+func (d *Driver) recordFiles(collection string, recursive bool) ([]recordFile, bool, error) {
+	dir := filepath.Join(d.dir, collection)
+	entries, err := d.readDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	live, expiring := d.unexpired(dir, entries)
+	isLive := make(map[string]bool, len(live))
+	for _, entry := range live {
+		isLive[entry.Name()] = true
+	}
+
+	var files []recordFile
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir() && recursive:
+			nested, e, err := d.recordFiles(filepath.Join(collection, entry.Name()), true)
+			if err != nil {
+				return nil, false, err
+			}
+			files = append(files, nested...)
+			expiring = expiring || e
+		case isLive[entry.Name()]:
+			files = append(files, recordFile{collection, entry.Name()})
+		}
+	}
+	return files, expiring, nil
+}
+
+func (d *Driver) CollectionModTime(collection string) (_ time.Time, err error) {
+	op := d.trace(nil, "CollectionModTime", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return time.Time{}, fmt.Errorf("collection is required")
+	}
+
+	dir := filepath.Join(d.dir, collection)
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	files, err := d.readDir(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var latest time.Time
+	for _, file := range files {
+		if !file.IsDir() && d.isRecord(file.Name()) && file.ModTime().After(latest) {
+			latest = file.ModTime()
+		}
+	}
+
+	if latest.IsZero() {
+		return fi.ModTime(), nil
+	}
+	return latest, nil
+}
+
 func (d *Driver) Delete(collection, resource string) error {
-	path := filepath.Join(d.dir, collection, resource)
+	return d.Del(d.ctx, collection, resource)
+}
+
+// delete removes a record after applying the delete actions of any records
+// referencing it. The caller must hold the delete scope locks.
+func (d *Driver) delete(op *operation, collection, resource string) error {
+	var deletes []refKey
+	var nulls []refNull
+	if err := d.planDelete(refKey{collection, resource}, nil, make(map[refKey]bool), &deletes, &nulls); err != nil || op.call.dryRun {
+		return err
+	}
+	return d.applyDelete(op, deletes, nulls, nil)
+}
+
+// applyDelete carries out a delete plan, leaving the records of the
+// dropping collections to the caller, which removes them wholesale.
+func (d *Driver) applyDelete(op *operation, deletes []refKey, nulls []refNull, dropping map[string]bool) error {
+	deleted := make(map[refKey]bool, len(deletes))
+	for _, k := range deletes {
+		deleted[k] = true
+	}
+
+	for _, n := range nulls {
+		if deleted[n.key] || dropping[n.key.collection] {
+			continue
+		}
+		b, err := d.readRecord(op.log, d.recordPath(n.key.collection, n.key.resource))
+		if err != nil {
+			return err
+		}
+		doc, err := decodeDocument(b)
+		if err != nil {
+			return err
+		}
+		assign(doc, n.field, nil)
+		if b, err = json.Marshal(doc); err != nil {
+			return err
+		}
+		if err := d.store(op, n.key.collection, n.key.resource, b); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range deletes {
+		if dropping[k.collection] {
+			continue
+		}
+		if err := d.remove(op, k.collection, k.resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) remove(op *operation, collection, resource string) error {
+	if err := d.removeRecord(d.recordPath(collection, resource), op.call.sync); err != nil {
+		return err
+	}
+	if err := os.Remove(d.expiryPath(collection, resource)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(d.checksumPath(collection, resource)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := d.mirror(op.log, d.recordPath(collection, resource), d.expiryPath(collection, resource), d.checksumPath(collection, resource)); err != nil {
+		return err
+	}
+	d.unindex(collection, resource)
+	d.countWrite(collection)
+	d.references.update(refKey{collection, resource}, nil)
+	d.listings.invalidate(collection)
+
+	d.events.emit(EventDelete, collection, resource)
+	return nil
+}
+
+func (d *Driver) DeleteIfMatch(collection, resource string, expected []byte) (_ bool, err error) {
+	op := d.trace(nil, "DeleteIfMatch", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return false, fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return false, fmt.Errorf("resource is required")
+	}
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return false, err
+	}
+	scope := d.deleteScope(collection)
+	defer d.lockCollections(scope...)()
+
+	if err := d.checkWritable(scope...); err != nil {
+		return false, err
+	}
+
+	path := d.recordPath(collection, resource)
+
+	b, err := d.readRecord(op.log, path)
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(bytes.TrimSuffix(b, []byte("\n")), bytes.TrimSuffix(expected, []byte("\n"))) {
+		return false, nil
+	}
+
+	if err := d.delete(op, collection, resource); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *Driver) Swap(collection, resourceA, resourceB string) (err error) {
+	op := d.trace(nil, "Swap", collection, resourceA)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resourceA == "" || resourceB == "" {
+		return fmt.Errorf("resource is required")
+	}
+	if resourceA == resourceB {
+		return nil
+	}
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return err
+	}
 	mutex := d.getOrCreateNewMutex(collection)
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	switch fi, err := stat(path); {
-	case fi == nil, err != nil:
-		return fmt.Errorf("%s does not exist", path)
-	case fi.Mode().IsDir():
-		return os.RemoveAll(path)
-	case fi.Mode().IsRegular():
-		return os.RemoveAll(path + ".json")
+	if err := d.checkWritable(collection); err != nil {
+		return err
 	}
+
+	pathA := d.recordPath(collection, resourceA)
+	pathB := d.recordPath(collection, resourceB)
+	swapPath := pathA + ".swap"
+
+	for _, path := range []string{pathA, pathB} {
+		if _, err := d.statFile(path); err != nil {
+			return err
+		}
+	}
+
+	if err := d.archive(collection, resourceA, pathA); err != nil {
+		return err
+	}
+	if err := d.archive(collection, resourceB, pathB); err != nil {
+		return err
+	}
+
+	defer d.listings.invalidate(collection)
+
+	// Only renames are used, so an interrupted swap leaves one record
+	// missing with its content parked in the .swap sidecar, never both
+	// records holding the same value.
+	if err := d.renameRecord(pathA, swapPath); err != nil {
+		return err
+	}
+	if err := d.renameRecord(pathB, pathA); err != nil {
+		return err
+	}
+	if err := d.renameRecord(swapPath, pathB); err != nil {
+		return err
+	}
+
+	// The expiry and checksum sidecars follow their content.
+	paths := []string{pathA, pathB}
+	for _, sidecar := range []func(collection, resource string) string{d.expiryPath, d.checksumPath} {
+		a, b := sidecar(collection, resourceA), sidecar(collection, resourceB)
+		if err := d.swapFiles(a, b); err != nil {
+			return err
+		}
+		paths = append(paths, a, b)
+	}
+	if err := d.mirror(op.log, paths...); err != nil {
+		return err
+	}
+	d.references.reset(collection)
+	d.invalidateIndexes(collection)
+
+	d.events.emit(EventWrite, collection, resourceA)
+	d.events.emit(EventWrite, collection, resourceB)
 	return nil
 }
 
-func stat(path string) (f os.FileInfo, err error) {
-	if f, err = os.Stat(path); os.IsNotExist(err) {
-		f, err = os.Stat(path + ".json")
+// swapFiles exchanges two files either of which may be missing.
+func (d *Driver) swapFiles(a, b string) error {
+	_, errA := os.Stat(a)
+	_, errB := os.Stat(b)
+	for _, err := range []error{errA, errB} {
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	switch {
+	case errA == nil && errB == nil:
+		if err := d.rename(a, a+".swap"); err != nil {
+			return err
+		}
+		if err := d.rename(b, a); err != nil {
+			return err
+		}
+		return d.rename(a+".swap", b)
+	case errA == nil:
+		return d.rename(a, b)
+	case errB == nil:
+		return d.rename(b, a)
+	}
+	return nil
+}
+
+// readRetrying reads a record, optionally retrying a few times when it is
+// missing to cover the window between a writer's temp file and its rename on
+// filesystems where that rename is not atomic for readers.
+func (d *Driver) readRetrying(log Logger, record string) ([]byte, error) {
+	backoff := readBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := d.stat(record)
+		if err == nil {
+			var b []byte
+			if b, err = d.readRecord(log, record+d.ext()); err == nil {
+				return b, nil
+			}
+		}
+		if !os.IsNotExist(err) || !d.opts.ReadRetryOnMissing || attempt == readRetries {
+			return nil, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *Driver) stat(path string) (f os.FileInfo, err error) {
+	if f, err = d.statFile(path); os.IsNotExist(err) {
+		f, err = d.statFile(path + d.ext())
 	}
 	return
 }
 
-func (d *Driver) getOrCreateNewMutex(collection string) *sync.Mutex {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	m, ok := d.mutexes[collection]
+func (d *Driver) ext() string {
+	return ".json" + d.opts.Compression.ext()
+}
+
+func (d *Driver) recordPath(collection, resource string) string {
+	return filepath.Join(d.dir, collection, d.fileKey(resource)+d.ext())
+}
+
+func (d *Driver) isRecord(name string) bool {
+	return strings.HasSuffix(name, d.ext()) && !collectionReserved[name]
+}
+
+// contains reports whether path is the database directory or lies below
+// it, however either is spelled.
+func (d *Driver) contains(path string) bool {
+	dir, err := filepath.Abs(d.dir)
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (d *Driver) resourceOf(name string) string {
+	return d.keyOf(strings.TrimSuffix(name, d.ext()))
+}
+
+func (d *Driver) readRecord(log Logger, path string) ([]byte, error) {
+	if err := d.rejectSymlinks(log, filepath.Dir(path), path); err != nil {
+		return nil, err
+	}
+	b, err := d.readStored(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.verifyChecksum(path, b); errors.Is(err, ErrChecksumMismatch) {
+		// A write replaces the sidecar just before the record, so give
+		// one in progress the time to finish.
+		time.Sleep(readBackoff)
+		if b, err = d.readStored(path); err != nil {
+			return nil, err
+		}
+		if err := d.verifyChecksum(path, b); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return d.decodeStored(b)
+}
+
+// lockCollections locks the given collections in name order so that
+// operations spanning several collections cannot deadlock, and returns the
+// function that unlocks them.
+func (d *Driver) lockCollections(collections ...string) func() {
+	sorted := append([]string(nil), collections...)
+	sort.Strings(sorted)
 
-	if !ok {
-		m = &sync.Mutex{}
-		d.mutexes[collection] = m
+	var mutexes []*collectionMutex
+	for i, collection := range sorted {
+		if i > 0 && collection == sorted[i-1] {
+			continue
+		}
+		mutex := d.getOrCreateNewMutex(collection)
+		mutex.Lock()
+		mutexes = append(mutexes, mutex)
 	}
 
-	return m
+	return func() {
+		for i := len(mutexes) - 1; i >= 0; i-- {
+			mutexes[i].Unlock()
+		}
+	}
 }
 
 type Address struct {
@@ -201,6 +1150,21 @@ type User struct {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "defs":
+			os.Exit(runDefs(os.Args[2:]))
+		case "get":
+			os.Exit(runGet(os.Args[2:]))
+		case "push":
+			os.Exit(runPush(os.Args[2:]))
+		case "tokens":
+			os.Exit(runTokens(os.Args[2:]))
+		}
+	}
+
 	dir := "./db"
 
 	db, err := New(dir, nil)
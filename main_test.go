@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// DeleteIfMatch deletes only while the record holds the expected content.
+func TestDeleteIfMatch(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	b, err := d.marshal(testUsers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := testUsers[0]
+	changed.Company = "Elsewhere"
+	if err := d.Write("user", "John", changed); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := d.DeleteIfMatch("user", "John", b)
+	if err != nil || deleted {
+		t.Fatalf("DeleteIfMatch with stale content = %v, %v, want false", deleted, err)
+	}
+	var u User
+	if err := d.Read("user", "John", &u); err != nil || u.Company != "Elsewhere" {
+		t.Fatalf("record after a skipped delete = %+v, %v", u, err)
+	}
+
+	if b, err = d.marshal(changed); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err = d.DeleteIfMatch("user", "John", b); err != nil || !deleted {
+		t.Fatalf("DeleteIfMatch with current content = %v, %v, want true", deleted, err)
+	}
+	if err := d.Read("user", "John", &u); !os.IsNotExist(err) {
+		t.Errorf("Read after DeleteIfMatch = %v, want a not-exist error", err)
+	}
+}
+
+// CollectionModTime moves to the time of the latest write.
+func TestCollectionModTime(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	before, err := d.CollectionModTime("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := d.Write("user", "John", testUsers[0]); err != nil {
+		t.Fatal(err)
+	}
+	after, err := d.CollectionModTime("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(d.recordPath("user", "John"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.After(before) || !after.Equal(fi.ModTime()) {
+		t.Errorf("CollectionModTime went from %s to %s, want the latest write's %s", before, after, fi.ModTime())
+	}
+}
+
+// Swap exchanges the contents of two records.
+func TestSwap(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	if err := d.Swap("user", "John", "Paul"); err != nil {
+		t.Fatal(err)
+	}
+	var john, paul User
+	if err := d.Read("user", "John", &john); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("user", "Paul", &paul); err != nil {
+		t.Fatal(err)
+	}
+	if john != testUsers[1] || paul != testUsers[0] {
+		t.Errorf("after Swap John holds %+v and Paul %+v", john, paul)
+	}
+	if err := d.Swap("user", "John", "Nobody"); !os.IsNotExist(err) {
+		t.Errorf("Swap with a missing record = %v, want a not-exist error", err)
+	}
+}
+
+// With ReadRetryOnMissing a read racing the rename of a new record finds
+// it; without, it fails at once.
+func TestReadRetryOnMissing(t *testing.T) {
+	for _, retry := range []bool{false, true} {
+		d := openTest(t, &Options{ReadRetryOnMissing: retry})
+		if err := d.Write("user", "Paul", testUsers[1]); err != nil {
+			t.Fatal(err)
+		}
+		path := d.recordPath("user", "John")
+		if err := os.Rename(d.recordPath("user", "Paul"), path+".tmp"); err != nil {
+			t.Fatal(err)
+		}
+
+		var u User
+		if !retry {
+			if err := d.Read("user", "John", &u); !os.IsNotExist(err) {
+				t.Errorf("Read without retries = %v, want a not-exist error", err)
+			}
+			continue
+		}
+		renamed := make(chan error, 1)
+		go func() {
+			time.Sleep(readBackoff / 2)
+			renamed <- os.Rename(path+".tmp", path)
+		}()
+		if err := d.Read("user", "John", &u); err != nil || u.Name != "Paul" {
+			t.Errorf("Read with retries = %+v, %v, want the record renamed in meanwhile", u, err)
+		}
+		if err := <-renamed; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceTask names a job the maintenance scheduler runs per
+// collection.
+type MaintenanceTask string
+
+const (
+	// TaskExpire deletes records whose WithTTL has passed.
+	TaskExpire MaintenanceTask = "expire"
+	// TaskTempFiles handles temp files left by interrupted writes as
+	// RecoverTempFiles says, promoting them if it is TempFilesKeep.
+	TaskTempFiles MaintenanceTask = "tempfiles"
+	// TaskOrphans removes sidecar files whose record is gone.
+	TaskOrphans MaintenanceTask = "orphans"
+	// TaskIndexes rebuilds indexes invalidated by bulk changes.
+	TaskIndexes MaintenanceTask = "indexes"
+)
+
+// MaintenanceConfig enables the background maintenance scheduler. Every
+// Interval it visits the collections round-robin, running Tasks on each,
+// until MaxDuration has passed; the next run resumes where it stopped.
+// Concurrency collections are visited at once. Frozen collections are
+// skipped, and so are those that took more than BusyWrites writes since
+// their last visit.
+type MaintenanceConfig struct {
+	Interval    time.Duration
+	Tasks       []MaintenanceTask
+	MaxDuration time.Duration
+	Concurrency int
+	BusyWrites  uint64
+}
+
+type TaskResult struct {
+	Task       MaintenanceTask
+	Collection string
+	Start      time.Time
+	Duration   time.Duration
+	// Items counts what the task removed, promoted or rebuilt.
+	Items int
+	// Skipped says why the collection was not maintained, in which case
+	// Task is empty.
+	Skipped string
+	Err     error
+}
+
+type maintenance struct {
+	mutex  sync.Mutex
+	cursor int
+	last   []TaskResult
+	writes map[string]uint64
+	seen   map[string]uint64
+}
+
+func (d *Driver) countWrite(collection string) {
+	m := d.maint
+	m.mutex.Lock()
+	m.writes[collection]++
+	m.mutex.Unlock()
+}
+
+// LastMaintenance returns the results of the latest maintenance run.
+func (d *Driver) LastMaintenance() []TaskResult {
+	m := d.maint
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]TaskResult(nil), m.last...)
+}
+
+func (d *Driver) runMaintenance() {
+	interval := d.opts.Maintenance.Interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+		d.maintain()
+	}
+}
+
+// maintain performs one maintenance run.
+func (d *Driver) maintain() {
+	cfg := d.opts.Maintenance
+	if d.readOnly || !d.IsPrimary() {
+		return
+	}
+	collections, err := d.Collections()
+	if err != nil {
+		d.log.Error("Maintenance: %s", err)
+		return
+	}
+	if len(collections) == 0 {
+		return
+	}
+
+	m := d.maint
+	m.mutex.Lock()
+	start := m.cursor % len(collections)
+	m.mutex.Unlock()
+
+	var deadline time.Time
+	if cfg.MaxDuration > 0 {
+		deadline = time.Now().Add(cfg.MaxDuration)
+	}
+	workers := cfg.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var (
+		mutex   sync.Mutex
+		results []TaskResult
+		visited int
+		next    = start
+		wg      sync.WaitGroup
+	)
+	take := func() (string, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if visited == len(collections) || d.ctx.Err() != nil {
+			return "", false
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return "", false
+		}
+		collection := collections[next]
+		next = (next + 1) % len(collections)
+		visited++
+		return collection, true
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				collection, ok := take()
+				if !ok {
+					return
+				}
+				r := d.maintainCollection(collection, cfg)
+				mutex.Lock()
+				results = append(results, r...)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	m.mutex.Lock()
+	m.cursor = next
+	m.last = results
+	m.mutex.Unlock()
+
+	var items, failed, skipped int
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			failed++
+			d.log.Error("Maintenance %s of %s failed: %s", r.Task, r.Collection, r.Err)
+		case r.Skipped != "":
+			skipped++
+		}
+		items += r.Items
+	}
+	d.log.Info("Maintenance visited %d of %d collections: %d items, %d skipped, %d failed", visited, len(collections), items, skipped, failed)
+}
+
+func (d *Driver) maintainCollection(collection string, cfg MaintenanceConfig) []TaskResult {
+	d.mutex.Lock()
+	frozen := d.frozen[collection]
+	d.mutex.Unlock()
+	if frozen {
+		return []TaskResult{{Collection: collection, Start: time.Now(), Skipped: "frozen"}}
+	}
+
+	m := d.maint
+	m.mutex.Lock()
+	writes := m.writes[collection] - m.seen[collection]
+	m.seen[collection] = m.writes[collection]
+	m.mutex.Unlock()
+	if cfg.BusyWrites > 0 && writes > cfg.BusyWrites {
+		return []TaskResult{{Collection: collection, Start: time.Now(), Skipped: "busy"}}
+	}
+
+	results := make([]TaskResult, 0, len(cfg.Tasks))
+	for _, task := range cfg.Tasks {
+		r := TaskResult{Task: task, Collection: collection, Start: time.Now()}
+		switch task {
+		case TaskExpire:
+			r.Items, r.Err = d.expireRecords(collection)
+		case TaskTempFiles:
+			policy := d.opts.RecoverTempFiles
+			if policy == TempFilesKeep {
+				policy = TempFilesPromote
+			}
+			unlock := d.lockCollections(collection)
+			r.Items, r.Err = d.recoverCollectionTempFiles(collection, policy)
+			unlock()
+		case TaskOrphans:
+			r.Items, r.Err = d.CleanOrphans(collection)
+		case TaskIndexes:
+			r.Items, r.Err = d.rebuildIndexes(collection)
+		default:
+			r.Skipped = "unknown task"
+		}
+		if os.IsNotExist(r.Err) {
+			r.Err = nil
+		}
+		r.Duration = time.Since(r.Start)
+		results = append(results, r)
+	}
+	return results
+}
+
+// sweepExpired deletes the expired records of every collection each
+// TTLSweepInterval until the driver is closed.
+func (d *Driver) sweepExpired() {
+	ticker := time.NewTicker(d.opts.TTLSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+		if d.readOnly || !d.IsPrimary() {
+			continue
+		}
+		collections, err := d.Collections()
+		if err != nil {
+			d.log.Error("TTL sweep: %s", err)
+			continue
+		}
+		for _, collection := range collections {
+			if d.ctx.Err() != nil {
+				return
+			}
+			d.mutex.Lock()
+			frozen := d.frozen[collection]
+			d.mutex.Unlock()
+			if frozen {
+				continue
+			}
+			n, err := d.expireRecords(collection)
+			if err != nil && !os.IsNotExist(err) {
+				d.log.Error("TTL sweep of %s: %s", collection, err)
+			}
+			if n > 0 {
+				d.log.Info("TTL sweep deleted %d expired records from %s", n, collection)
+			}
+		}
+	}
+}
+
+// expireRecords deletes the records of collection whose TTL has passed.
+// Each delete rechecks the expiry, so a record rewritten meanwhile stays.
+func (d *Driver) expireRecords(collection string) (int, error) {
+	files, err := d.readDir(filepath.Join(d.dir, collection))
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	stillExpired := WithPrecondition(func(current json.RawMessage) (bool, error) {
+		return current == nil, nil
+	})
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), expiresExt) {
+			continue
+		}
+		resource := d.keyOf(strings.TrimSuffix(file.Name(), expiresExt))
+		if d.checkExpiry(collection, resource) == nil {
+			continue
+		}
+		switch err := d.Del(d.ctx, collection, resource, stillExpired); {
+		case err == nil:
+			expired++
+		case errors.Is(err, os.ErrNotExist), errors.Is(err, ErrPreconditionFailed):
+		default:
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// rebuildIndexes rebuilds the invalidated indexes of collection.
+func (d *Driver) rebuildIndexes(collection string) (int, error) {
+	defer d.lockCollections(collection)()
+
+	rebuilt := 0
+	for field, idx := range d.indexes.of(collection) {
+		if idx.built {
+			continue
+		}
+		if err := d.buildIndex(collection, field, idx); err != nil {
+			return rebuilt, err
+		}
+		rebuilt++
+	}
+	return rebuilt, nil
+}
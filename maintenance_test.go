@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// diskUsage counts the files under dir and their bytes, skipping those
+// removed while it walks.
+func diskUsage(t *testing.T, dir string) (files int, bytes int64) {
+	t.Helper()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err == nil && !fi.IsDir() {
+			files++
+			bytes += fi.Size()
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files, bytes
+}
+
+// With the scheduler running, a stream of short-lived records and deletes
+// leaves disk usage flat, with no maintenance call made by hand.
+func TestMaintenanceSoak(t *testing.T) {
+	d := openTest(t, &Options{Maintenance: MaintenanceConfig{
+		Interval: 10 * time.Millisecond,
+		Tasks:    []MaintenanceTask{TaskExpire, TaskTempFiles, TaskOrphans},
+	}})
+	dir := filepath.Join(d.dir, "session")
+
+	const rounds, perRound = 40, 20
+	peak := 0
+	for round := 0; round < rounds; round++ {
+		for i := 0; i < perRound; i++ {
+			key := fmt.Sprintf("r%d-%d", round, i)
+			if err := d.Put(context.Background(), "session", key, packedDoc{N: i}, WithTTL(20*time.Millisecond)); err != nil {
+				t.Fatal(err)
+			}
+			if i%5 == 0 {
+				if err := d.Delete("session", key); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if files, _ := diskUsage(t, dir); files > peak {
+			peak = files
+		}
+	}
+
+	// Without maintenance every record and its expiry sidecar would stay.
+	if written := rounds * perRound * 2; peak > written/4 {
+		t.Errorf("disk held up to %d files of the %d written", peak, written)
+	}
+	waitFor(t, "the last records to be expired", func() bool {
+		files, _ := diskUsage(t, dir)
+		return files == 0
+	})
+
+	results := d.LastMaintenance()
+	if len(results) == 0 {
+		t.Error("no maintenance run was reported")
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("maintenance %s of %s failed: %s", r.Task, r.Collection, r.Err)
+		}
+	}
+}
+
+// The TTL sweep deletes an expired record, sidecar and all, without anyone
+// reading it, and leaves alone records without a TTL or rewritten without
+// one.
+func TestTTLSweep(t *testing.T) {
+	d := openTest(t, &Options{TTLSweepInterval: 10 * time.Millisecond})
+	ctx := context.Background()
+	for _, name := range []string{"John", "Paul"} {
+		if err := d.Put(ctx, "user", name, testUsers[0], WithTTL(30*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Write("user", "Paul", testUsers[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("user", "Robert", testUsers[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	gone := func(path string) bool {
+		_, err := os.Stat(path)
+		return os.IsNotExist(err)
+	}
+	record := d.recordPath("user", "John")
+	waitFor(t, "the sweep to delete John", func() bool {
+		return gone(record) && gone(filepath.Join(d.dir, "user", d.fileKey("John")+expiresExt))
+	})
+	time.Sleep(30 * time.Millisecond)
+	for _, name := range []string{"Paul", "Robert"} {
+		if gone(d.recordPath("user", name)) {
+			t.Errorf("the sweep deleted %s, which has no TTL", name)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const manifestFile = "_manifest.json"
+
+// reserved holds the root-level names the driver itself writes to.
+var reserved = map[string]bool{
+	manifestFile: true,
+	changeLogDir: true,
+	countersDir:  true,
+	leaseDir:     true,
+	viewsDir:     true,
+	keysDir:      true,
+	longKeysDir:  true,
+	pushDir:      true,
+	authDir:      true,
+}
+
+type Manifest struct {
+	Version     string
+	Created     time.Time
+	Compression string     `json:",omitempty"`
+	KeyEncoding string     `json:",omitempty"`
+	Adopted     bool       `json:",omitempty"`
+	Clone       *CloneInfo `json:",omitempty"`
+}
+
+func readManifest(dir string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", filepath.Join(dir, manifestFile), err)
+	}
+	return m, nil
+}
+
+func writeManifest(dir string, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, manifestFile)
+	if err := ioutil.WriteFile(path+".tmp", append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// detectFormat checks a directory without a manifest for files that could not
+// have been written by the driver.
+func detectFormat(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var foreign []string
+	for _, entry := range entries {
+		if reserved[entry.Name()] {
+			continue
+		}
+		if !entry.IsDir() {
+			foreign = append(foreign, entry.Name())
+			continue
+		}
+		found, err := detectCollection(dir, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		foreign = append(foreign, found...)
+	}
+	return foreign, nil
+}
+
+// detectCollection lists the files of a collection, and of the collections
+// nested in it, that the driver does not write.
+func detectCollection(dir, collection string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(dir, collection))
+	if err != nil {
+		return nil, err
+	}
+
+	var foreign []string
+	for _, file := range files {
+		name := filepath.Join(collection, file.Name())
+		if !file.IsDir() {
+			if !ownFile(file.Name()) {
+				foreign = append(foreign, name)
+			}
+			continue
+		}
+		found, err := detectCollection(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		foreign = append(foreign, found...)
+	}
+	return foreign, nil
+}
+
+// ownFile reports whether a file in a collection is one the driver writes:
+// a record in any codec, its sidecars, the collection's own files, or what
+// an interrupted write leaves behind.
+func ownFile(name string) bool {
+	if collectionReserved[name] || strings.Contains(name, ".json") {
+		return true
+	}
+	for _, ext := range []string{expiresExt, checksumExt, remapExt, ".swap", ".tmp"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Driver) checkFormat() error {
+	m, err := readManifest(d.dir)
+	if err == nil {
+		d.rawKeys = m.rawKeys()
+		return d.checkCompression(m)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return writeManifest(d.dir, Manifest{Version: Version, Created: time.Now().UTC(), Compression: d.opts.Compression.String(), KeyEncoding: keyEncodingPercent})
+	}
+	d.rawKeys = true
+	if err := d.checkCompression(nil); err != nil {
+		return err
+	}
+
+	foreign, err := detectFormat(d.dir)
+	if err != nil {
+		return err
+	}
+	if len(foreign) == 0 {
+		// Nothing but the driver's own files: a database written before
+		// manifests existed, which is adopted as it is opened.
+		if d.opts.ReadOnly || d.opts.Role == RoleReplica {
+			d.log.Debug("%s has no manifest", d.dir)
+			return nil
+		}
+		d.log.Info("Adopting %s, a database without a manifest", d.dir)
+		return writeManifest(d.dir, Manifest{Version: Version, Created: time.Now().UTC(), Compression: d.opts.Compression.String(), KeyEncoding: keyEncodingRaw, Adopted: true})
+	}
+
+	sample := foreign
+	if len(sample) > 5 {
+		sample = sample[:5]
+	}
+	msg := fmt.Sprintf("%s does not look like a database: found %d unexpected files (%s); move them out, use FindOrphans/CleanOrphans on affected collections, or open a different directory",
+		d.dir, len(foreign), strings.Join(sample, ", "))
+
+	if d.opts.Strict {
+		return fmt.Errorf("%s", msg)
+	}
+	d.log.Warn("%s", msg)
+	return nil
+}
+
+// checkCompression refuses to open a database whose records were written
+// with another Options.Compression, since their extension would hide them
+// from every read. The manifest records the codec of databases it created;
+// older ones are scanned for records with a foreign extension instead.
+func (d *Driver) checkCompression(m *Manifest) error {
+	want := d.opts.Compression
+	if m != nil && m.Compression != "" {
+		if m.Compression != want.String() {
+			return fmt.Errorf("%s was written with compression %s; open it with Options.Compression set to match, not %s", d.dir, m.Compression, want)
+		}
+		return nil
+	}
+
+	c, path, err := d.foreignCompression()
+	if err != nil || path == "" {
+		return err
+	}
+	return fmt.Errorf("%s holds records written with compression %s (e.g. %s); open it with Options.Compression set to match, not %s", d.dir, c, path, want)
+}
+
+// foreignCompression returns the first record file written with a codec
+// other than the configured one, and that codec.
+func (d *Driver) foreignCompression() (Compression, string, error) {
+	var (
+		codec Compression
+		found string
+	)
+	err := filepath.Walk(d.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(d.dir, path)
+		if info.IsDir() {
+			if reserved[rel] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		for c := CompressionNone; c <= CompressionZstd; c++ {
+			if c != d.opts.Compression && strings.HasSuffix(info.Name(), ".json"+c.ext()) {
+				codec, found = c, rel
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return codec, found, err
+}
+
+func (d *Driver) AdoptExisting() error {
+	if _, err := readManifest(d.dir); err == nil {
+		return nil
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+
+	foreign, err := detectFormat(d.dir)
+	if err != nil {
+		return err
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("cannot adopt %s: found %d unexpected files, e.g. %s", d.dir, len(foreign), foreign[0])
+	}
+
+	return writeManifest(d.dir, Manifest{Version: Version, Created: time.Now().UTC(), Compression: d.opts.Compression.String(), KeyEncoding: keyEncodingRaw, Adopted: true})
+}
+
+// ManifestError reports a database whose manifest could not be read.
+type ManifestError struct {
+	Database string
+	Err      error
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("database %s: %s", e.Database, e.Err)
+}
+
+func (e *ManifestError) Unwrap() error { return e.Err }
+
+// ListDatabases returns, in name order, the subdirectories of parentDir
+// holding a database, that is a manifest. Databases without one, such as
+// those not yet adopted with AdoptExisting, are not listed. A database
+// whose manifest cannot be read is left out too, and reported in the
+// returned error as a *ManifestError; the others are listed regardless.
+func ListDatabases(parentDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(parentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var databases []string
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := readManifest(filepath.Join(parentDir, entry.Name())); err == nil {
+			databases = append(databases, entry.Name())
+		} else if !os.IsNotExist(err) {
+			errs = append(errs, &ManifestError{Database: entry.Name(), Err: err})
+		}
+	}
+	return databases, errors.Join(errs...)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A directory holding only what the driver writes, sidecars and nested
+// collections included, opens in strict mode and is adopted.
+func TestFormatDetectionLegacy(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, &Options{VerifyChecksums: true})
+	seedUsers(t, d)
+	if err := d.Put(context.Background(), "user", "John", testUsers[0], WithTTL(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("user/nested", "Paul", testUsers[1]); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	os.WriteFile(d.recordPath("user", "Vince")+".tmp", []byte("{"), 0644)
+	os.Remove(filepath.Join(dir, manifestFile))
+
+	reopenTest(t, dir, &Options{Strict: true, VerifyChecksums: true}).Close()
+	m, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Adopted {
+		t.Fatalf("manifest %+v", m)
+	}
+}
+
+func TestFormatDetectionForeign(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "holiday"), 0755)
+	os.WriteFile(filepath.Join(dir, "holiday", "beach.jpg"), []byte{0xff, 0xd8}, 0644)
+	os.WriteFile(filepath.Join(dir, "notes.pdf"), []byte("%PDF"), 0644)
+
+	_, err := New(dir, &Options{Logger: nopLog{}, Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "does not look like a database") || !strings.Contains(err.Error(), "beach.jpg") {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := readManifest(dir); !os.IsNotExist(err) {
+		t.Fatal("a manifest was written into a foreign directory")
+	}
+}
+
+// A corrupt manifest is reported without hiding the other databases.
+func TestListDatabasesCorrupt(t *testing.T) {
+	parent := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		reopenTest(t, filepath.Join(parent, name), nil).Close()
+	}
+	os.WriteFile(filepath.Join(parent, "b", manifestFile), []byte("{"), 0644)
+	os.MkdirAll(filepath.Join(parent, "plain"), 0755)
+
+	databases, err := ListDatabases(parent)
+	var merr *ManifestError
+	if !errors.As(err, &merr) || merr.Database != "b" {
+		t.Errorf("ListDatabases error = %v, want a ManifestError for b", err)
+	}
+	if strings.Join(databases, ",") != "a,c" {
+		t.Errorf("ListDatabases = %q, want a and c", databases)
+	}
+}
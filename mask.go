@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+type maskProfileKey struct{}
+
+// WithMaskProfile makes the reads done with ctx apply the mask profile of
+// that name, see Options.Masks.
+func WithMaskProfile(ctx context.Context, profile string) context.Context {
+	return context.WithValue(ctx, maskProfileKey{}, profile)
+}
+
+func maskProfileOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	profile, _ := ctx.Value(maskProfileKey{}).(string)
+	return profile
+}
+
+// WithMask overrides the mask profile carried by the context.
+func WithMask(profile string) ReadOption {
+	return func(c *callOptions) error {
+		c.mask = &profile
+		return c.set("WithMask", "Get")
+	}
+}
+
+// maskPaths returns the paths profile masks in collection, or nil.
+func (d *Driver) maskPaths(collection, profile string) [][]string {
+	if profile == "" {
+		return nil
+	}
+	fields := d.opts.Masks[collection][profile]
+	paths := make([][]string, 0, len(fields))
+	for _, field := range fields {
+		paths = append(paths, strings.Split(field, "."))
+	}
+	return paths
+}
+
+// maskRecord applies paths to the JSON document b.
+func (d *Driver) maskRecord(b []byte, paths [][]string) ([]byte, error) {
+	if len(paths) == 0 {
+		return b, nil
+	}
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	d.maskDocument(doc, paths)
+	return json.Marshal(doc)
+}
+
+func (d *Driver) maskDocument(doc map[string]interface{}, paths [][]string) {
+	for _, path := range paths {
+		mask(doc, path, d.opts.MaskPlaceholder)
+	}
+}
+
+// mask replaces the value at path with placeholder, or removes it if
+// placeholder is empty. A path reaching into an array applies to each of
+// its elements.
+func mask(node interface{}, path []string, placeholder string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[path[0]]
+		switch {
+		case !ok:
+		case len(path) > 1:
+			mask(child, path[1:], placeholder)
+		case placeholder == "":
+			delete(n, path[0])
+		default:
+			n[path[0]] = placeholder
+		}
+	case []interface{}:
+		for _, element := range n {
+			mask(element, path, placeholder)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func maskTest(t *testing.T, placeholder string) *Driver {
+	t.Helper()
+	d := openTest(t, &Options{
+		Masks: map[string]map[string][]string{
+			"user": {"support": {"Contact", "Address.Code"}},
+			"team": {"support": {"Members.Contact"}},
+		},
+		MaskPlaceholder: placeholder,
+	})
+	seedUsers(t, d)
+	team := map[string]interface{}{"Members": []map[string]string{
+		{"Name": "John", "Contact": "1"},
+		{"Name": "Paul", "Contact": "2"},
+	}}
+	if err := d.Write("team", "core", team); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// masked reports whether doc has the support profile's fields replaced by
+// the placeholder and the rest of John's record intact.
+func masked(doc map[string]interface{}) bool {
+	address, _ := doc["Address"].(map[string]interface{})
+	return doc["Name"] == "John" && doc["Contact"] == "***" && address["Code"] == "***" && address["City"] == "bangalore"
+}
+
+// The support profile replaces Contact and Address.Code with the
+// placeholder on every read path, inside arrays too, and reads without a
+// profile are unchanged.
+func TestMaskProfiles(t *testing.T) {
+	d := maskTest(t, "***")
+	support := WithMaskProfile(context.Background(), "support")
+
+	var doc map[string]interface{}
+	if err := d.ReadCtx(support, "user", "John", &doc); err != nil || !masked(doc) {
+		t.Errorf("John under the support profile %v, %v", doc, err)
+	}
+	doc = nil
+	if err := d.Get(context.Background(), "user", "John", &doc, WithMask("support")); err != nil || !masked(doc) {
+		t.Errorf("Get WithMask = %v, %v", doc, err)
+	}
+	var u User
+	if err := d.Read("user", "John", &u); err != nil || u != testUsers[0] {
+		t.Errorf("John without a profile %+v, %v", u, err)
+	}
+
+	records, err := d.ReadAllCtx(support, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if strings.Contains(record, "23344333") || strings.Contains(record, "410013") {
+			t.Errorf("ReadAllCtx returned the unmasked %s", record)
+		}
+	}
+	var found []map[string]interface{}
+	if err := d.Find("user").Mask("support").Where("Name", "=", "John").Collect(&found); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || !masked(found[0]) {
+		t.Errorf("masked Find = %v", found)
+	}
+
+	var team struct{ Members []map[string]string }
+	if err := d.ReadCtx(support, "team", "core", &team); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{{"Name": "John", "Contact": "***"}, {"Name": "Paul", "Contact": "***"}}
+	if !reflect.DeepEqual(team.Members, want) {
+		t.Errorf("masked team members %v, want %v", team.Members, want)
+	}
+}
+
+// Without a placeholder masked fields are removed.
+func TestMaskRemoves(t *testing.T) {
+	d := maskTest(t, "")
+	var doc map[string]interface{}
+	if err := d.ReadCtx(WithMaskProfile(context.Background(), "support"), "user", "John", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["Contact"]; ok {
+		t.Errorf("Contact was not removed: %v", doc)
+	}
+	if address := doc["Address"].(map[string]interface{}); address["Code"] != nil || address["City"] != "bangalore" {
+		t.Errorf("Address after removing Code: %v", address)
+	}
+}
+
+// TokenAuth applies the mask profile it picks for a request's token to
+// the reads the request makes.
+func TestMaskByToken(t *testing.T) {
+	d := maskTest(t, "***")
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var doc map[string]interface{}
+		if err := d.ReadCtx(r.Context(), "user", strings.TrimPrefix(r.URL.Path, "/user/"), &doc); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(doc)
+	})
+	srv := httptest.NewServer(d.TokenAuth(handler, AuthOptions{
+		MaskProfile: func(t TokenInfo) string {
+			if !t.Allows("user", AccessWrite) {
+				return "support"
+			}
+			return ""
+		},
+	}))
+	defer srv.Close()
+
+	reader, _, err := d.CreateToken("support", map[string]Access{"user": AccessRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := d.CreateToken("admin", map[string]Access{"*": AccessReadWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(token string) map[string]interface{} {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/user/John", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var doc map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+			t.Fatalf("status %d: %s", resp.StatusCode, err)
+		}
+		return doc
+	}
+	if doc := get(reader); !masked(doc) {
+		t.Errorf("the read-only token saw %v", doc)
+	}
+	if doc := get(admin); doc["Contact"] != "23344333" {
+		t.Errorf("the admin token saw %v", doc)
+	}
+}
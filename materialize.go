@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type (
+	// MaterializeOptions adjusts MaterializeQuery.
+	MaterializeOptions struct {
+		// Replace swaps an existing destination for the results instead of
+		// failing.
+		Replace bool
+		// RecordSource notes the source collection and time of the query in
+		// the destination's _meta.json.
+		RecordSource bool
+	}
+
+	// materializedFrom is the RecordSource note of a materialized
+	// collection.
+	materializedFrom struct {
+		Collection string    `json:"collection"`
+		At         time.Time `json:"at"`
+		Records    int       `json:"records"`
+	}
+)
+
+// MaterializeQuery writes every match of q into the collection dst, keyed
+// by the resource each came from, and returns how many were written. The
+// results are written aside with a Batch and then renamed into place, so
+// dst is never seen partially populated; with Replace, the previous dst and
+// its indexes, definitions and codecs are swapped out in the same step.
+func (d *Driver) MaterializeQuery(dst string, q *QueryBuilder, opts MaterializeOptions) (_ int, err error) {
+	op := d.trace(nil, "MaterializeQuery", dst, "")
+	defer func() { op.end(err) }()
+
+	if dst == "" {
+		return 0, fmt.Errorf("collection is required")
+	}
+	if err := validateName(dst, ""); err != nil {
+		return 0, err
+	}
+	if dst == q.collection {
+		return 0, fmt.Errorf("cannot materialize %s into itself", dst)
+	}
+	if err := d.checkWritable(dst); err != nil {
+		return 0, err
+	}
+	path := filepath.Join(d.dir, dst)
+	if _, err := os.Stat(path); err == nil && !opts.Replace {
+		return 0, fmt.Errorf("collection %s already exists; set Replace to overwrite it", dst)
+	}
+
+	matches, err := q.run(op)
+	if err != nil {
+		return 0, err
+	}
+
+	staging := dst + "~materialize"
+	stagingPath := filepath.Join(d.dir, staging)
+	if err := os.RemoveAll(stagingPath); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(stagingPath)
+		}
+	}()
+
+	batch := d.Batch()
+	seen := make(map[string]bool, len(matches))
+	for _, m := range matches {
+		if seen[m.resource] {
+			return 0, fmt.Errorf("%s/%s matches more than once", q.collection, m.resource)
+		}
+		seen[m.resource] = true
+		batch.Write(staging, m.resource, m.b)
+	}
+	if err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		return 0, err
+	}
+	if opts.RecordSource {
+		meta := collectionMeta{Source: &materializedFrom{Collection: q.collection, At: time.Now().UTC(), Records: len(matches)}}
+		if err := d.writeMeta(staging, meta); err != nil {
+			return 0, err
+		}
+	}
+
+	defer d.lockCollections(dst, staging)()
+
+	if err := d.checkWritable(dst); err != nil {
+		return 0, err
+	}
+	replaced := dst + "~replaced"
+	if _, err := os.Stat(path); err == nil {
+		if !opts.Replace {
+			return 0, fmt.Errorf("collection %s already exists; set Replace to overwrite it", dst)
+		}
+		if err := d.rename(path, filepath.Join(d.dir, replaced)); err != nil {
+			return 0, err
+		}
+	}
+	if err := d.rename(stagingPath, path); err != nil {
+		return 0, err
+	}
+	if err := os.RemoveAll(filepath.Join(d.dir, replaced)); err != nil {
+		return 0, err
+	}
+	if err := d.mirror(op.log, stagingPath, path); err != nil {
+		return 0, err
+	}
+
+	// dst now has the fresh _meta.json of the staged collection.
+	d.collections.forget(staging)
+	d.listings.invalidate(staging)
+	d.listings.invalidate(dst)
+	d.references.reset(dst)
+	d.indexes.forget(dst)
+	d.packs.forget(dst)
+	d.packs.forget(staging)
+	d.mutex.Lock()
+	delete(d.defs, dst)
+	delete(d.pipelines, dst)
+	d.mutex.Unlock()
+	if err := d.dropKeyIndex(dst); err != nil {
+		return 0, err
+	}
+	d.events.emit(EventDelete, dst, "")
+	for _, m := range matches {
+		d.events.emit(EventWrite, dst, m.resource)
+	}
+	return len(matches), nil
+}
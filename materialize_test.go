@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Materializing the users in india gives a collection of just them, which
+// is not overwritten without Replace, and is swapped whole with it: a
+// reader never sees it partially populated.
+func TestMaterializeQuery(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	india := func() *QueryBuilder { return d.Find("user").Where("Address.Country", "=", "india") }
+
+	n, err := d.MaterializeQuery("campaign", india(), MaterializeOptions{RecordSource: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(testUsers)-1 {
+		t.Errorf("materialized %d users, want %d", n, len(testUsers)-1)
+	}
+	for _, want := range testUsers {
+		var got User
+		err := d.Read("campaign", want.Name, &got)
+		switch {
+		case want.Address.Country != "india":
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s, not in india, was materialized: %v", want.Name, err)
+			}
+		case err != nil || got != want:
+			t.Errorf("%s materialized as %+v, %v", want.Name, got, err)
+		}
+	}
+	meta, err := d.readMeta("campaign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Source == nil || meta.Source.Collection != "user" || meta.Source.Records != n {
+		t.Errorf("the recorded source is %+v", meta.Source)
+	}
+
+	if _, err := d.MaterializeQuery("campaign", india(), MaterializeOptions{}); err == nil {
+		t.Error("MaterializeQuery over an existing collection without Replace succeeded")
+	}
+
+	paul := testUsers[1]
+	paul.Address.Country = "india"
+	if err := d.Write("user", "Paul", paul); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg      sync.WaitGroup
+		stop    int32
+		partial int32
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for atomic.LoadInt32(&stop) == 0 {
+			records, err := d.ReadAll("campaign")
+			if err == nil && len(records) != n && len(records) != n+1 {
+				atomic.StoreInt32(&partial, int32(len(records)))
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if m, err := d.MaterializeQuery("campaign", india(), MaterializeOptions{Replace: true}); err != nil || m != n+1 {
+			t.Fatalf("MaterializeQuery with Replace = %d, %v, want %d", m, err, n+1)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	if p := atomic.LoadInt32(&partial); p != 0 {
+		t.Errorf("a reader saw the destination with %d records", p)
+	}
+	if count, err := d.Count("campaign"); err != nil || count != n+1 {
+		t.Errorf("Count after the Replace = %d, %v, want %d", count, err, n+1)
+	}
+}
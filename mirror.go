@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// mirror copies a file or directory under the database directory to the
+// same place under Options.MirrorDir with the temp-rename scheme used for
+// records, or removes the copy when the path no longer exists.
+func (d *Driver) mirror(log Logger, paths ...string) error {
+	if d.opts.MirrorDir == "" {
+		return nil
+	}
+	for _, path := range paths {
+		if err := d.mirrorFile(path); err != nil {
+			if !d.opts.MirrorBestEffort {
+				return fmt.Errorf("mirror: %w", err)
+			}
+			log.Warn("Unable to mirror %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// mirrorPath returns where the copy of a file under the database
+// directory lives in the mirror.
+func (d *Driver) mirrorPath(path string) (string, error) {
+	rel, err := filepath.Rel(d.dir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.opts.MirrorDir, rel), nil
+}
+
+func (d *Driver) mirrorFile(path string) error {
+	to, err := d.mirrorPath(path)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && fi.IsDir() {
+		return d.mirrorTree(path, to)
+	}
+
+	// A packed record is mirrored as a record file.
+	b, err := d.readStored(path)
+	if os.IsNotExist(err) {
+		return os.RemoveAll(to)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := d.writeFile(to+".tmp", b, true); err != nil {
+		return err
+	}
+	return d.rename(to+".tmp", to)
+}
+
+// mirrorTree replaces the copy of a directory with a copy of what it holds
+// now.
+func (d *Driver) mirrorTree(path, to string) error {
+	if err := os.RemoveAll(to); err != nil {
+		return err
+	}
+	return filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		return d.mirrorFile(p)
+	})
+}
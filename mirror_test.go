@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	mirror := t.TempDir()
+	d := openTest(t, &Options{MirrorDir: mirror})
+	ctx := context.Background()
+	seedUsers(t, d)
+
+	copyOf := func(collection, resource string) string {
+		t.Helper()
+		to, err := d.mirrorPath(d.recordPath(collection, resource))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return to
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	b, err := os.ReadFile(copyOf("user", "John"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := os.ReadFile(d.recordPath("user", "John")); string(b) != string(want) {
+		t.Fatalf("mirror holds %q, want %q", b, want)
+	}
+
+	if err := d.Delete("user", "John"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(copyOf("user", "John")) {
+		t.Fatal("Delete left the mirror copy")
+	}
+
+	if err := d.Write("user/nested", "Paul", testUsers[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Del(ctx, "user", "nested"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(mirror, "user", "nested")) {
+		t.Fatal("Del of a directory left its mirror copy")
+	}
+
+	if err := d.Truncate("user"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(copyOf("user", "Paul")) {
+		t.Fatal("Truncate left the mirror copies")
+	}
+
+	seedUsers(t, d)
+	if _, err := d.DeleteCollection("user"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(mirror, "user")) {
+		t.Fatal("DeleteCollection left the mirror copy")
+	}
+
+	seedUsers(t, d)
+	if _, err := d.Drop(d.dir); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(mirror, "user")) {
+		t.Fatal("Drop left the mirror copy")
+	}
+}
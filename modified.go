@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+func stampUpdatedAt(b []byte, field string) ([]byte, error) {
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	assign(doc, field, time.Now().UTC().Format(time.RFC3339Nano))
+	return json.Marshal(doc)
+}
+
+// KeysModifiedSince returns, in name order, the records of collection
+// written after t.
+//
+// With UpdatedAtField set, the time is read from each record. Otherwise it
+// is the file modification time, which is cheaper but less reliable: copies
+// and restores that do not preserve mtimes make records look new, clock
+// changes can hide them, and filesystems differ in timestamp resolution.
+// Records without the field fall back to mtime too. Deletions are not
+// reported; consumers that need them should use the change log.
+func (d *Driver) KeysModifiedSince(collection string, t time.Time) (_ []string, err error) {
+	op := d.trace(nil, "KeysModifiedSince", collection, "")
+	defer func() { op.end(err) }()
+
+	var resources []string
+	err = d.modifiedSince(op, collection, t, false, func(resource string, _ []byte) error {
+		resources = append(resources, resource)
+		return nil
+	})
+	return resources, err
+}
+
+// ForEachModifiedSince calls fn with every record of collection that
+// KeysModifiedSince would return, stopping at the first error.
+func (d *Driver) ForEachModifiedSince(collection string, t time.Time, fn func(resource string, b []byte) error) (err error) {
+	op := d.trace(nil, "ForEachModifiedSince", collection, "")
+	defer func() { op.end(err) }()
+
+	return d.modifiedSince(op, collection, t, true, fn)
+}
+
+func (d *Driver) modifiedSince(op *operation, collection string, t time.Time, read bool, fn func(resource string, b []byte) error) (err error) {
+	defer func() { err = wrapOp("read", collection, "", err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+
+	dir := filepath.Join(d.dir, collection)
+	files, err := d.readDir(dir)
+	if err != nil {
+		return err
+	}
+	files, _ = d.unexpired(dir, files)
+
+	field := d.opts.UpdatedAtField
+	for _, file := range files {
+		resource := d.resourceOf(file.Name())
+
+		var b []byte
+		if read || field != "" {
+			if b, err = d.readRecord(op.log, filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+			op.bytes += int64(len(b))
+		}
+
+		modified := file.ModTime()
+		if field != "" {
+			if updated, ok, err := updatedAt(b, field); err != nil {
+				return fmt.Errorf("%s/%s: %s", collection, resource, err)
+			} else if ok {
+				modified = updated
+			}
+		}
+		if !modified.After(t) {
+			continue
+		}
+
+		if read {
+			if b, err = d.transform(collection, b); err != nil {
+				return err
+			}
+		}
+		if err := fn(resource, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updatedAt(b []byte, field string) (time.Time, bool, error) {
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	v, ok := lookup(doc, field)
+	s, isString := v.(string)
+	if !ok || !isString {
+		return time.Time{}, false, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil, nil
+}
+
+// ReadRecent returns the limit most recently modified records of
+// collection, newest first, by file modification time with the caveats of
+// KeysModifiedSince. Only the returned records are read.
+func (d *Driver) ReadRecent(collection string, limit int) (_ []json.RawMessage, err error) {
+	op := d.trace(nil, "ReadRecent", collection, "")
+	defer func() { op.end(err) }()
+	defer func() { err = wrapOp("read", collection, "", err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+
+	dir := filepath.Join(d.dir, collection)
+	files, err := d.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	records, _ := d.unexpired(dir, files)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ModTime().After(records[j].ModTime())
+	})
+
+	recent := make([]json.RawMessage, 0, limit)
+	for _, file := range records {
+		if len(recent) == limit {
+			break
+		}
+		b, err := d.readRecord(op.log, filepath.Join(dir, file.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		op.bytes += int64(len(b))
+		if b, err = d.transform(collection, b); err != nil {
+			return nil, err
+		}
+		recent = append(recent, json.RawMessage(bytes.TrimSpace(b)))
+	}
+	return recent, nil
+}
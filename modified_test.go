@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Of three records written before a timestamp and two after, only the two
+// are modified since it, going by mtime or by the stored UpdatedAt field,
+// which still holds after the mtimes are lost to a restore.
+func TestKeysModifiedSince(t *testing.T) {
+	for _, field := range []string{"", "Meta.UpdatedAt"} {
+		d := openTest(t, &Options{UpdatedAtField: field})
+		for _, key := range []string{"a", "b", "c"} {
+			if err := d.Write("n", key, map[string]int{"N": 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		since := time.Now()
+		time.Sleep(20 * time.Millisecond)
+		for _, key := range []string{"d", "e"} {
+			if err := d.Write("n", key, map[string]int{"N": 2}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := []string{"d", "e"}
+		check := func(what string) {
+			t.Helper()
+			keys, err := d.KeysModifiedSince("n", since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var read []string
+			err = d.ForEachModifiedSince("n", since, func(resource string, _ []byte) error {
+				read = append(read, resource)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, want) || !reflect.DeepEqual(read, want) {
+				t.Errorf("%s with field %q: modified %v and %v, want %v", what, field, keys, read, want)
+			}
+		}
+		check("after the writes")
+
+		if field == "" {
+			continue
+		}
+		restored := time.Now().Add(time.Minute)
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			if err := os.Chtimes(d.recordPath("n", key), restored, restored); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check("after a restore")
+	}
+}
+
+// ReadRecent returns the newest records first, reading only those.
+func TestReadRecent(t *testing.T) {
+	d := openTest(t, nil)
+	writeN(t, d, 0, 10)
+	// Stagger the mtimes so that 7 is the newest, then 3, then 5.
+	base := time.Now().Add(-time.Hour)
+	for i, n := range []int{0, 1, 2, 4, 6, 8, 9, 5, 3, 7} {
+		mtime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(d.recordPath("n", fmt.Sprint(n)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reads := d.IOStats().Reads
+	recent, err := d.ReadRecent("n", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := d.IOStats().Reads - reads; n != 3 {
+		t.Errorf("ReadRecent read %d records, want 3", n)
+	}
+	var got []int
+	for _, raw := range recent {
+		var n int
+		if err := json.Unmarshal(raw, &n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	if want := []int{7, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadRecent = %v, want %v", got, want)
+	}
+
+	if all, err := d.ReadRecent("n", 100); err != nil || len(all) != 10 {
+		t.Errorf("ReadRecent past the end = %d records, %v", len(all), err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Modify replaces a record with the result of fn applied to its current
+// contents while holding the collection lock, so concurrent modifications
+// never lose updates. If fn fails the record is left as it was. The result
+// is written as by Write, so it clears any TTL.
+func (d *Driver) Modify(collection, resource string, fn func(current []byte) ([]byte, error)) (err error) {
+	op := d.trace(nil, "Modify", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return err
+	}
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	err = d.checkExpiry(collection, resource)
+	var b []byte
+	if err == nil {
+		b, err = d.readRecord(op.log, d.recordPath(collection, resource))
+	}
+	if err != nil {
+		return err
+	}
+	if b, err = d.transform(collection, b); err != nil {
+		return err
+	}
+
+	if b, err = fn(b); err != nil {
+		return err
+	}
+	b = bytes.TrimSpace(b)
+	if !json.Valid(b) {
+		return fmt.Errorf("%s/%s: modified record is not valid JSON", collection, resource)
+	}
+
+	if err := d.write(op, collection, resource, json.RawMessage(b)); err != nil {
+		return err
+	}
+	return d.setExpiry(op, collection, resource)
+}
+
+// CompareAndWrite writes v only if predicate accepts the current contents
+// of the record, which are nil when it does not exist. A rejected write
+// returns ErrPreconditionFailed; a predicate error is returned unchanged.
+func (d *Driver) CompareAndWrite(collection, resource string, predicate func(current json.RawMessage) (bool, error), v interface{}) error {
+	return d.put(d.ctx, "CompareAndWrite", collection, resource, v, []WriteOption{WithPrecondition(predicate)})
+}
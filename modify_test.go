@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Concurrent Modify calls incrementing one record lose no update, and a
+// failing fn leaves the record as it was.
+func TestModifyConcurrent(t *testing.T) {
+	d := openTest(t, nil)
+	if err := d.Write("n", "count", packedDoc{}); err != nil {
+		t.Fatal(err)
+	}
+	increment := func(b []byte) ([]byte, error) {
+		var doc packedDoc
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		doc.N++
+		return json.Marshal(doc)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := d.Modify("n", "count", increment); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := errors.New("failed")
+	if err := d.Modify("n", "count", func([]byte) ([]byte, error) { return []byte("{}"), failed }); err != failed {
+		t.Errorf("Modify with a failing fn = %v, want its error", err)
+	}
+	var doc packedDoc
+	if err := d.Read("n", "count", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.N != 200 {
+		t.Errorf("the record counts %d, want 200", doc.N)
+	}
+
+	if err := d.Modify("n", "missing", increment); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Modify of a missing record = %v, want ErrNotFound", err)
+	}
+}
+
+type job struct {
+	State string
+}
+
+// Writers racing to move a job between states with CompareAndWrite only
+// ever make legal transitions: the job goes pending, then running or
+// cancelled, then done from running, each step taken once.
+func TestCompareAndWriteTransitions(t *testing.T) {
+	d := openTest(t, nil)
+	legal := map[string][]string{"": {"pending"}, "pending": {"running", "cancelled"}, "running": {"done"}}
+
+	// The predicate runs under the write lock, so the transitions it
+	// admits are recorded in the order they are written.
+	var history []string
+	transition := func(to string) error {
+		return d.CompareAndWrite("job", "j", func(current json.RawMessage) (bool, error) {
+			var j job
+			if current != nil {
+				if err := json.Unmarshal(current, &j); err != nil {
+					return false, err
+				}
+			}
+			for _, next := range legal[j.State] {
+				if next == to {
+					history = append(history, to)
+					return true, nil
+				}
+			}
+			return false, nil
+		}, job{to})
+	}
+
+	var (
+		won int32
+		wg  sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		for _, to := range []string{"pending", "running", "cancelled", "done"} {
+			wg.Add(1)
+			go func(to string) {
+				defer wg.Done()
+				switch err := transition(to); {
+				case err == nil:
+					atomic.AddInt32(&won, 1)
+				case !errors.Is(err, ErrPreconditionFailed):
+					t.Error(err)
+				}
+			}(to)
+		}
+	}
+	wg.Wait()
+
+	if len(history) == 0 || int(won) != len(history) {
+		t.Errorf("%d writes succeeded for %d admitted transitions", won, len(history))
+	}
+	state := ""
+	for _, to := range history {
+		ok := false
+		for _, next := range legal[state] {
+			ok = ok || next == to
+		}
+		if !ok {
+			t.Fatalf("transitions %v include %s → %s", history, state, to)
+		}
+		state = to
+	}
+	var j job
+	if err := d.Read("job", "j", &j); err != nil {
+		t.Fatal(err)
+	}
+	if j.State != state {
+		t.Errorf("the job is %s after transitions %v", j.State, history)
+	}
+}
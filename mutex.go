@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// collectionMutex is a collection lock that counts its users, from the
+// moment getOrCreateNewMutex hands it out until Unlock, so the idle sweep
+// never evicts a mutex that someone holds or is about to lock.
+type collectionMutex struct {
+	sync.Mutex
+	users int32
+	used  time.Time
+}
+
+func (m *collectionMutex) Unlock() {
+	m.Mutex.Unlock()
+	atomic.AddInt32(&m.users, -1)
+}
+
+func (d *Driver) getOrCreateNewMutex(collection string) *collectionMutex {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	m, ok := d.mutexes[collection]
+
+	if !ok {
+		m = &collectionMutex{}
+		d.mutexes[collection] = m
+	}
+	atomic.AddInt32(&m.users, 1)
+	m.used = time.Now()
+
+	return m
+}
+
+// lockDropping locks scope like lockCollections for dropping collection.
+// On unlock the collection's mutex goes too, unless someone else already
+// waits on it. Dropping it while held would let the next caller lock a
+// fresh mutex alongside the holder.
+func (d *Driver) lockDropping(collection string, scope ...string) func() {
+	unlock := d.lockCollections(scope...)
+	return func() {
+		unlock()
+
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if m, ok := d.mutexes[collection]; ok && atomic.LoadInt32(&m.users) == 0 {
+			delete(d.mutexes, collection)
+		}
+	}
+}
+
+// evictIdleMutexes drops the mutexes of collections unused for longer than
+// idle. They are recreated on next access.
+func (d *Driver) evictIdleMutexes(idle time.Duration) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	evicted := 0
+	for collection, m := range d.mutexes {
+		if atomic.LoadInt32(&m.users) == 0 && time.Since(m.used) >= idle {
+			delete(d.mutexes, collection)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (d *Driver) sweepMutexes() {
+	idle := d.opts.MutexIdleTTL
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+		if n := d.evictIdleMutexes(idle); n > 0 {
+			d.log.Debug("Evicted %d idle collection mutexes", n)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Two drivers on one directory writing the same record concurrently leave
+// it whole, holding one of the writes, with no temp file behind.
+func TestConcurrentDriversSameRecord(t *testing.T) {
+	dir := t.TempDir()
+	handles := []*Driver{reopenTest(t, dir, nil), reopenTest(t, dir, nil)}
+	const writes = 100
+
+	var wg sync.WaitGroup
+	for i, d := range handles {
+		wg.Add(1)
+		go func(i int, d *Driver) {
+			defer wg.Done()
+			for n := 0; n < writes; n++ {
+				if err := d.Write("user", "John", packedDoc{N: i*writes + n}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	var doc packedDoc
+	if err := handles[0].Read("user", "John", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.N != writes-1 && doc.N != 2*writes-1 {
+		t.Errorf("record holds write %d, want the last of either driver", doc.N)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, "user", "*.tmp")); len(temps) != 0 {
+		t.Errorf("temp files left behind: %v", temps)
+	}
+
+	// Reopening does not carry the mutexes of the closed drivers over.
+	for _, d := range handles {
+		d.Close()
+	}
+	d := reopenTest(t, dir, nil)
+	if n := len(d.mutexes); n != 0 {
+		t.Errorf("a reopened driver starts with %d mutexes", n)
+	}
+	if _, err := os.Stat(d.recordPath("user", "John")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// With MutexIdleTTL the sweep evicts the mutex of an idle collection, never
+// one that is held, and the next write recreates it.
+func TestIdleMutexEviction(t *testing.T) {
+	d := openTest(t, &Options{MutexIdleTTL: 20 * time.Millisecond})
+	writeN(t, d, 0, 1)
+	mutex := func(collection string) *collectionMutex {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		return d.mutexes[collection]
+	}
+	idle := mutex("n")
+	if idle == nil {
+		t.Fatal("a write created no mutex")
+	}
+
+	unlock := d.lockCollections("held")
+	held := mutex("held")
+	waitFor(t, "the idle mutex to be evicted", func() bool { return mutex("n") == nil })
+	time.Sleep(40 * time.Millisecond)
+	if mutex("held") != held {
+		t.Error("the sweep evicted a held mutex")
+	}
+	unlock()
+
+	writeN(t, d, 1, 2)
+	if m := mutex("n"); m == nil || m == idle {
+		t.Errorf("the next write did not recreate the evicted mutex")
+	}
+}
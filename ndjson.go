@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+func (d *Driver) ExportNDJSON(collection string, w io.Writer) (err error) {
+	op := d.trace(nil, "ExportNDJSON", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+
+	dir := filepath.Join(d.dir, collection)
+
+	files, err := d.readDir(dir)
+	if err != nil {
+		return err
+	}
+	files, _ = d.unexpired(dir, files)
+
+	bw := bufio.NewWriter(w)
+	for _, file := range files {
+		b, err := d.readRecord(op.log, filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+
+		if b, err = d.transform(collection, b); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, b); err != nil {
+			return fmt.Errorf("%s/%s: %s", collection, file.Name(), err)
+		}
+		buf.WriteByte('\n')
+
+		if _, err := bw.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		op.bytes += int64(buf.Len())
+	}
+
+	return bw.Flush()
+}
+
+func (d *Driver) ImportNDJSON(collection string, r io.Reader, key func(json.RawMessage) (string, error)) (err error) {
+	op := d.trace(nil, "ImportNDJSON", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("record %d: %s", line, err)
+		}
+
+		resource, err := key(raw)
+		if err != nil {
+			return fmt.Errorf("record %d: %s", line, err)
+		}
+
+		if err := d.Write(collection, resource, raw); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A collection exported as NDJSON and imported into another database reads
+// back the same, one compact line per record.
+func TestNDJSONRoundTrip(t *testing.T) {
+	src := openTest(t, &Options{Indent: "  "})
+	seedUsers(t, src)
+
+	var buf bytes.Buffer
+	if err := src.ExportNDJSON("user", &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(testUsers) {
+		t.Fatalf("exported %d lines, want %d", len(lines), len(testUsers))
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "\n") || strings.HasPrefix(line, " ") {
+			t.Fatalf("line is not compact: %q", line)
+		}
+	}
+
+	dst := openTest(t, nil)
+	err := dst.ImportNDJSON("user", &buf, func(raw json.RawMessage) (string, error) {
+		var u User
+		err := json.Unmarshal(raw, &u)
+		return u.Name, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range testUsers {
+		var got User
+		if err := dst.Read("user", want.Name, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("imported %+v, want %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+type Normalizer func(raw json.RawMessage) (json.RawMessage, error)
+
+func (d *Driver) normalize(collection string, b []byte) ([]byte, error) {
+	n, ok := d.opts.Normalizers[collection]
+	if !ok || n == nil {
+		return b, nil
+	}
+	return n(b)
+}
+
+func ChainNormalizers(normalizers ...Normalizer) Normalizer {
+	return func(raw json.RawMessage) (json.RawMessage, error) {
+		var err error
+		for _, n := range normalizers {
+			if raw, err = n(raw); err != nil {
+				return nil, err
+			}
+		}
+		return raw, nil
+	}
+}
+
+// TrimStringWhitespace trims leading and trailing whitespace from every
+// string value in the document, at any depth.
+func TrimStringWhitespace(raw json.RawMessage) (json.RawMessage, error) {
+	v, err := decodeValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(trimStrings(v))
+}
+
+func trimStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = trimStrings(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = trimStrings(e)
+		}
+	}
+	return v
+}
+
+// LowercaseTopLevelKeys lowercases the keys of a top-level object. Documents
+// that are not objects are returned unchanged.
+func LowercaseTopLevelKeys(raw json.RawMessage) (json.RawMessage, error) {
+	v, err := decodeValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return raw, nil
+	}
+
+	lower := make(map[string]interface{}, len(m))
+	for k, e := range m {
+		lower[strings.ToLower(k)] = e
+	}
+	return json.Marshal(lower)
+}
+
+func decodeValue(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
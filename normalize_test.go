@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Values are normalized before the unique check and before they are stored,
+// so " Google " collides with "Google" and exports show the trimmed form.
+func TestNormalizeBeforeUnique(t *testing.T) {
+	d := openTest(t, &Options{Normalizers: map[string]Normalizer{
+		"company": ChainNormalizers(TrimStringWhitespace, LowercaseTopLevelKeys),
+	}})
+	if err := d.SetUnique("company", "name"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("company", "a", map[string]string{"Name": " Google "}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("company", "b", map[string]string{"Name": "Google"}); !errors.Is(err, ErrDuplicateValue) {
+		t.Errorf("second Google = %v, want ErrDuplicateValue", err)
+	}
+
+	var buf bytes.Buffer
+	if err := d.ExportNDJSON("company", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != `{"name":"Google"}` {
+		t.Errorf("exported %s, want the normalized document", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// KeyObfuscator turns resource keys into the opaque keys shown outside the
+// database and back. It is applied only by PublicKey, ResolvePublicKey and
+// the CLI, never to what is stored.
+type KeyObfuscator interface {
+	Encode(resource string) string
+	Decode(public string) (string, error)
+}
+
+// hmacObfuscator encrypts keys deterministically: the public key is an
+// HMAC tag of the resource followed by the resource XORed with a keystream
+// derived from that tag, so it cannot be forged or enumerated without the
+// secret. Only the length of the resource shows through.
+type hmacObfuscator struct {
+	secret []byte
+}
+
+const obfuscatorTagSize = 12
+
+// HMACKeyObfuscator returns the built-in KeyObfuscator keyed by secret.
+func HMACKeyObfuscator(secret []byte) KeyObfuscator {
+	return hmacObfuscator{secret: append([]byte(nil), secret...)}
+}
+
+func (o hmacObfuscator) Encode(resource string) string {
+	tag := o.tag(resource)
+	b := append(tag, o.xor(tag, []byte(resource))...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (o hmacObfuscator) Decode(public string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(public)
+	if err != nil || len(b) < obfuscatorTagSize {
+		return "", fmt.Errorf("invalid public key")
+	}
+	tag := b[:obfuscatorTagSize]
+	resource := string(o.xor(tag, b[obfuscatorTagSize:]))
+	if !hmac.Equal(tag, o.tag(resource)) {
+		return "", fmt.Errorf("invalid public key")
+	}
+	return resource, nil
+}
+
+func (o hmacObfuscator) tag(resource string) []byte {
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte("key\x00"))
+	mac.Write([]byte(resource))
+	return mac.Sum(nil)[:obfuscatorTagSize]
+}
+
+// xor applies the keystream of tag to b.
+func (o hmacObfuscator) xor(tag, b []byte) []byte {
+	out := make([]byte, len(b))
+	var block []byte
+	for i := range b {
+		if i%sha256.Size == 0 {
+			mac := hmac.New(sha256.New, o.secret)
+			mac.Write([]byte("stream\x00"))
+			mac.Write(tag)
+			binary.Write(mac, binary.BigEndian, uint32(i/sha256.Size))
+			block = mac.Sum(nil)
+		}
+		out[i] = b[i] ^ block[i%sha256.Size]
+	}
+	return out
+}
+
+// PublicKey returns the key to show for resource outside the database,
+// which is resource itself unless a KeyObfuscator is set.
+func (d *Driver) PublicKey(resource string) string {
+	if d.opts.KeyObfuscator == nil {
+		return resource
+	}
+	return d.opts.KeyObfuscator.Encode(resource)
+}
+
+// ResolvePublicKey returns the resource behind a key from PublicKey. A key
+// that does not decode fails with ErrNotFound, the same as one naming a
+// missing record, so callers cannot probe which keys are well formed.
+func (d *Driver) ResolvePublicKey(public string) (string, error) {
+	if d.opts.KeyObfuscator == nil {
+		return public, nil
+	}
+	resource, err := d.opts.KeyObfuscator.Decode(public)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, public)
+	}
+	return resource, nil
+}
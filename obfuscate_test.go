@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Public keys round-trip through the HMAC obfuscator, hide the real ones,
+// and a tampered or foreign key resolves to ErrNotFound, while Keys and
+// the files on disk keep the real keys.
+func TestKeyObfuscator(t *testing.T) {
+	d := openTest(t, &Options{KeyObfuscator: HMACKeyObfuscator([]byte("secret"))})
+	seedUsers(t, d)
+
+	keys, err := d.Keys("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Albert", "John", "Neo", "Paul", "Robert", "Vince"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys = %v, want the real keys %v", keys, want)
+	}
+	if _, err := os.Stat(d.recordPath("user", "John")); err != nil {
+		t.Errorf("John is not stored under his own key: %s", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		public := d.PublicKey(key)
+		if strings.Contains(public, key) || seen[public] {
+			t.Errorf("public key %q of %s gives it away", public, key)
+		}
+		seen[public] = true
+		if public != d.PublicKey(key) {
+			t.Errorf("the public key of %s is not stable", key)
+		}
+		if got, err := d.ResolvePublicKey(public); err != nil || got != key {
+			t.Errorf("ResolvePublicKey(%q) = %q, %v, want %s", public, got, err, key)
+		}
+	}
+
+	public := d.PublicKey("John")
+	tampered := "A" + public[1:]
+	if public[0] == 'A' {
+		tampered = "B" + public[1:]
+	}
+	other := HMACKeyObfuscator([]byte("other")).Encode("John")
+	for _, bad := range []string{tampered, other, "John", "!!"} {
+		if _, err := d.ResolvePublicKey(bad); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ResolvePublicKey(%q) = %v, want ErrNotFound", bad, err)
+		}
+	}
+
+	plain := openTest(t, nil)
+	if plain.PublicKey("John") != "John" {
+		t.Error("without an obfuscator the public key is not the key")
+	}
+}
+
+// An HTTP API that goes through PublicKey and ResolvePublicKey lists and
+// serves records by obfuscated key only.
+func TestKeyObfuscatorHTTP(t *testing.T) {
+	d := openTest(t, &Options{KeyObfuscator: HMACKeyObfuscator([]byte("secret"))})
+	seedUsers(t, d)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		public := strings.TrimPrefix(r.URL.Path, "/user/")
+		if public == "" {
+			keys, err := d.Keys("user")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for i, key := range keys {
+				keys[i] = d.PublicKey(key)
+			}
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+		var u User
+		resource, err := d.ResolvePublicKey(public)
+		if err == nil {
+			err = d.Read("user", resource, &u)
+		}
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(u)
+	}))
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var publics []string
+	get("/user/", &publics)
+	if len(publics) != len(testUsers) {
+		t.Fatalf("listed %v", publics)
+	}
+	for _, public := range publics {
+		var u User
+		if code := get("/user/"+public, &u); code != http.StatusOK || d.PublicKey(u.Name) != public {
+			t.Errorf("GET %s = %d, %+v", public, code, u)
+		}
+	}
+	if code := get("/user/John", nil); code != http.StatusNotFound {
+		t.Errorf("GET by the real key = %d, want 404", code)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// OpError reports a filesystem failure during a CRUD operation, much like
+// net.OpError. Err is the underlying os error.
+type OpError struct {
+	Op         string
+	Collection string
+	Resource   string
+	Err        error
+}
+
+func (e *OpError) Error() string {
+	return e.Op + " " + path.Join(e.Collection, e.Resource) + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// wrapOp wraps err in an *OpError when it comes from the filesystem and
+// leaves the driver's own errors as they are. A missing record is left
+// bare, so os.IsNotExist keeps working on it.
+func wrapOp(op, collection, resource string, err error) error {
+	var (
+		opErr   *OpError
+		pathErr *fs.PathError
+		linkErr *os.LinkError
+		sysErr  *os.SyscallError
+	)
+	switch {
+	case err == nil, os.IsNotExist(err), errors.As(err, &opErr):
+		return err
+	case errors.As(err, &pathErr), errors.As(err, &linkErr), errors.As(err, &sysErr):
+		return &OpError{Op: op, Collection: collection, Resource: resource, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestOpError(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	if err := os.Remove(d.recordPath("user", "Robert")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(d.recordPath("user", "Robert"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var u User
+	err := d.Read("user", "Robert", &u)
+	var opErr *OpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Read returned %T %v", err, err)
+	}
+	if opErr.Op != "read" || opErr.Collection != "user" || opErr.Resource != "Robert" {
+		t.Fatalf("OpError %+v", opErr)
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != d.recordPath("user", "Robert") {
+		t.Fatalf("%v does not wrap the failed path", err)
+	}
+}
+
+// A missing record is reported the same way by every call, as the error of
+// its file.
+func TestNotFound(t *testing.T) {
+	d := openTest(t, nil)
+	var u User
+
+	errs := map[string]error{"Read": d.Read("user", "John", &u), "Pop": d.Pop("user", "John", &u)}
+	errs["Modify"] = d.Modify("user", "John", func(b []byte) ([]byte, error) { return b, nil })
+	_, errs["PopAny"] = d.PopAny("user", &u)
+	for name, err := range errs {
+		if !os.IsNotExist(err) || !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s of a missing record: %T %v", name, err, err)
+		}
+	}
+
+	if err := d.ReadView("missing", &u); !errors.Is(err, ErrNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadView of a missing view: %v", err)
+	}
+}
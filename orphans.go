@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sidecarOf returns the resource a sidecar belongs to. Sidecars are named
+// either <resource>.json<suffix> (e.g. .json.tmp) or <resource>.<ext>.
+func sidecarOf(name string) string {
+	if i := strings.Index(name, ".json."); i >= 0 {
+		return name[:i]
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func (d *Driver) FindOrphans(collection string) (_ []string, err error) {
+	op := d.trace(nil, "FindOrphans", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+
+	mutex := d.getOrCreateNewMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return d.findOrphans(collection)
+}
+
+func (d *Driver) CleanOrphans(collection string) (_ int, err error) {
+	op := d.trace(nil, "CleanOrphans", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return 0, fmt.Errorf("collection is required")
+	}
+
+	mutex := d.getOrCreateNewMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := d.checkWritable(collection); err != nil {
+		return 0, err
+	}
+	orphans, err := d.findOrphans(collection)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, orphan := range orphans {
+		path := filepath.Join(d.dir, collection, orphan)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return i, err
+		}
+		if err := d.mirror(op.log, path); err != nil {
+			return i, err
+		}
+	}
+	return len(orphans), nil
+}
+
+func (d *Driver) findOrphans(collection string) ([]string, error) {
+	files, err := d.readDir(filepath.Join(d.dir, collection))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]bool)
+	for _, file := range files {
+		if !file.IsDir() && d.isRecord(file.Name()) {
+			records[strings.TrimSuffix(file.Name(), d.ext())] = true
+		}
+	}
+
+	var orphans []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || d.isRecord(name) || collectionReserved[name] {
+			continue
+		}
+		if !records[sidecarOf(name)] {
+			orphans = append(orphans, name)
+		}
+	}
+	return orphans, nil
+}
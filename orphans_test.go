@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrphans(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	if err := d.Put(context.Background(), "user", "John", testUsers[0], WithTTL(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(d.recordPath("user", "John")); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := d.FindOrphans("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"John" + expiresExt}; !reflect.DeepEqual(orphans, want) {
+		t.Fatalf("found %v, want %v", orphans, want)
+	}
+	if n, err := d.CleanOrphans("user"); err != nil || n != 1 {
+		t.Fatalf("cleaned %d (%v)", n, err)
+	}
+	if orphans, _ := d.FindOrphans("user"); len(orphans) != 0 {
+		t.Fatalf("left %v", orphans)
+	}
+}
+
+// Maintenance calls that change the directory refuse to run on a driver
+// that may not write.
+func TestMaintenanceReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	seedUsers(t, reopenTest(t, dir, nil))
+	d, err := open(dir, dir, Options{Logger: nopLog{}, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.cancel() })
+
+	calls := map[string]func() error{
+		"CleanOrphans": func() error {
+			_, err := d.CleanOrphans("user")
+			return err
+		},
+		"MigrateReservedNames": func() error { return d.MigrateReservedNames(nil) },
+		"AdoptExisting": func() error {
+			os.Remove(filepath.Join(dir, manifestFile))
+			return d.AdoptExisting()
+		},
+		"DumpFixtures": func() error { return d.DumpFixtures(dir) },
+		"RegisterView": func() error {
+			return d.RegisterView("count", "user", func(acc json.RawMessage, _ string, _ json.RawMessage, _ EventType) (json.RawMessage, error) {
+				return acc, nil
+			})
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s on a read-only driver: %v", name, err)
+		}
+	}
+	if err := d.DumpFixtures(t.TempDir()); err != nil {
+		t.Errorf("DumpFixtures outside the database: %v", err)
+	}
+}
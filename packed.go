@@ -0,0 +1,1147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// packsDir holds the pack files of a packed collection.
+	packsDir        = "_packs"
+	packExt         = ".pack"
+	packIndexFile   = "index"
+	defaultPackSize = 4 << 20
+
+	// An entry is a header, the record's file name and its stored bytes.
+	// The header is the CRC-32C of the rest of the entry, the name and
+	// value lengths, the modification time in Unix nanoseconds and flags.
+	packHeaderSize = 4 + 4 + 4 + 8 + 1
+
+	packTombstone = 1 << 0
+	// packContinued marks an entry whose batch goes on with the next one.
+	// A batch cut short by a crash is discarded as a whole.
+	packContinued = 1 << 1
+)
+
+var (
+	packIndexMagic = []byte("GDBPACK1")
+	crc32c         = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type (
+	// PackStats describes the pack files of a packed collection. DeadBytes
+	// is the space held by overwritten and deleted records, which Compact
+	// reclaims.
+	PackStats struct {
+		Packs     int
+		Records   int
+		LiveBytes int64
+		DeadBytes int64
+	}
+
+	packEntry struct {
+		pack    uint32
+		offset  int64
+		length  int64
+		size    int64
+		modTime int64
+	}
+	pack struct {
+		id   uint32
+		file *os.File
+		size int64
+	}
+	packWrite struct {
+		name      string
+		value     []byte
+		modTime   int64
+		tombstone bool
+	}
+
+	// packStore is the index and the open pack files of a packed
+	// collection. Appends are serialized by appending, and only hold mutex
+	// to publish what they wrote, so reads go on while a write hits the
+	// disk.
+	//
+	// Packs replaced by Compact, or dropped with the collection, are kept
+	// open in retired while a pin still reads them.
+	packStore struct {
+		dir      string
+		limit    int64
+		readOnly bool
+
+		appending sync.Mutex
+		mutex     sync.RWMutex
+		packs     map[uint32]*pack
+		active    *pack
+		next      uint32
+		entries   map[string]packEntry
+		dead      int64
+		dirty     bool
+		pins      map[*packPin]bool
+		retired   []*pack
+	}
+
+	// packPin is a generation of a pack store, as it was when a reader
+	// pinned it: the index and the packs it points into.
+	packPin struct {
+		packs   map[uint32]*pack
+		entries map[string]packEntry
+	}
+
+	packSet struct {
+		mutex  sync.Mutex
+		stores map[string]*packStore
+		plain  map[string]bool
+	}
+
+	// packedFile describes a packed record the way os.Stat describes a
+	// record file.
+	packedFile struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+)
+
+func (f packedFile) Name() string       { return f.name }
+func (f packedFile) Size() int64        { return f.size }
+func (f packedFile) Mode() os.FileMode  { return 0644 }
+func (f packedFile) ModTime() time.Time { return f.modTime }
+func (f packedFile) IsDir() bool        { return false }
+func (f packedFile) Sys() interface{}   { return nil }
+
+func newPackSet() *packSet {
+	return &packSet{stores: make(map[string]*packStore), plain: make(map[string]bool)}
+}
+
+func packName(id uint32) string {
+	return fmt.Sprintf("%08d%s", id, packExt)
+}
+
+// packsOf returns the pack store of collection, or nil if it is not
+// packed: it is in Options.Packed or, so its records are never hidden by a
+// change of options, it already has pack files.
+func (d *Driver) packsOf(collection string) (*packStore, error) {
+	s := d.packs
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ps, ok := s.stores[collection]; ok {
+		return ps, nil
+	}
+	if s.plain[collection] {
+		return nil, nil
+	}
+	dir := filepath.Join(d.dir, collection, packsDir)
+	if !d.opts.Packed[collection] {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			s.plain[collection] = true
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	limit := d.opts.PackSize
+	if limit <= 0 {
+		limit = defaultPackSize
+	}
+	ps, err := openPackStore(d.log, dir, limit, d.readOnly)
+	if err != nil {
+		return nil, fmt.Errorf("packs of %s: %w", collection, err)
+	}
+	s.stores[collection] = ps
+	return ps, nil
+}
+
+// packed reports whether collection is packed.
+func (d *Driver) packed(collection string) bool {
+	if d.opts.Packed[collection] {
+		return true
+	}
+	ps, _ := d.packsOf(collection)
+	return ps != nil
+}
+
+// packedRecord returns the pack store holding the record file at path and
+// the file's name, or a nil store if the record is not packed.
+func (d *Driver) packedRecord(path string) (*packStore, string, error) {
+	collection, err := filepath.Rel(d.dir, filepath.Dir(path))
+	if err != nil || collection == "." || collection == ".." || strings.HasPrefix(collection, ".."+string(filepath.Separator)) {
+		return nil, "", nil
+	}
+	ps, err := d.packsOf(collection)
+	return ps, filepath.Base(path), err
+}
+
+// statFile is os.Stat for record files, packed or not.
+func (d *Driver) statFile(path string) (os.FileInfo, error) {
+	ps, name, err := d.packedRecord(path)
+	if err != nil {
+		return nil, err
+	}
+	if ps != nil {
+		if e, ok := ps.lookup(name); ok {
+			return e.info(name), nil
+		}
+	}
+	return os.Stat(path)
+}
+
+// readStored returns the stored bytes of the record file at path, packed
+// or not.
+func (d *Driver) readStored(path string) ([]byte, error) {
+	ps, name, err := d.packedRecord(path)
+	if err != nil {
+		return nil, err
+	}
+	if ps != nil {
+		b, ok, err := ps.get(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if ok {
+			atomic.AddUint64(&d.io.reads, 1)
+			atomic.AddUint64(&d.io.bytesRead, uint64(len(b)))
+			return b, nil
+		}
+	}
+	return d.readFile(path)
+}
+
+// readDir lists a collection directory like ioutil.ReadDir, with the
+// records of a packed collection listed as files and its packs hidden.
+func (d *Driver) readDir(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := filepath.Rel(d.dir, dir)
+	if err != nil {
+		return files, nil
+	}
+	ps, err := d.packsOf(collection)
+	if err != nil || ps == nil {
+		return files, err
+	}
+
+	listed := make(map[string]bool, len(files))
+	all := files[:0]
+	for _, file := range files {
+		if file.Name() == packsDir && file.IsDir() {
+			continue
+		}
+		listed[file.Name()] = true
+		all = append(all, file)
+	}
+	all = append(all, ps.list(listed)...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Name() < all[j].Name() })
+	return all, nil
+}
+
+// renameRecord is d.rename for record files, packed or not.
+func (d *Driver) renameRecord(from, to string) error {
+	ps, name, err := d.packedRecord(from)
+	if err != nil {
+		return err
+	}
+	if ps != nil {
+		if _, ok := ps.lookup(name); ok {
+			return ps.move(name, filepath.Base(to))
+		}
+	}
+	return d.rename(from, to)
+}
+
+// removeRecord is os.Remove for record files, packed or not. A packed
+// record gets a tombstone, fsynced with sync.
+func (d *Driver) removeRecord(path string, sync bool) error {
+	ps, name, err := d.packedRecord(path)
+	if err != nil {
+		return err
+	}
+	found := false
+	if ps != nil {
+		if found, err = ps.remove(name, sync); err != nil {
+			return err
+		}
+	}
+	// A record file written before the collection was packed may be left.
+	if err := os.Remove(path); err != nil && !(found && os.IsNotExist(err)) {
+		return err
+	}
+	return nil
+}
+
+// storePacked is placeRecord for a packed collection: the stored bytes b
+// are appended to the collection's active pack instead of renamed into a
+// file of their own.
+func (d *Driver) storePacked(op *operation, ps *packStore, collection, resource string, b []byte, targets map[string]refKey, then func() error) error {
+	fnlPath := d.recordPath(collection, resource)
+
+	if d.opts.VerifyChecksums {
+		if err := d.writeChecksum(collection, resource, b); err != nil {
+			return err
+		}
+	}
+	if err := d.archive(collection, resource, fnlPath); err != nil {
+		return err
+	}
+
+	defer d.listings.invalidate(collection)
+
+	if err := ps.put(filepath.Base(fnlPath), b, op.call.sync); err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.io.writes, 1)
+	atomic.AddUint64(&d.io.bytesWritten, uint64(len(b)))
+	if op.call.sync {
+		atomic.AddUint64(&d.io.syncs, 1)
+	}
+	// The record file of a collection packed since is superseded.
+	if err := os.Remove(fnlPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := d.mirror(op.log, fnlPath, d.checksumPath(collection, resource)); err != nil {
+		return err
+	}
+	d.references.update(refKey{collection, resource}, targets)
+	d.countWrite(collection)
+
+	d.events.emit(EventWrite, collection, resource)
+	if then == nil {
+		return nil
+	}
+	return then()
+}
+
+// Compact rewrites the packs of a packed collection without the space held
+// by overwritten and deleted records. The new packs and the index naming
+// them are made durable before the old packs are removed, so a crash at any
+// point leaves either the old or the new packs in use.
+func (d *Driver) Compact(collection string) (err error) {
+	op := d.trace(nil, "Compact", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	defer d.lockCollections(collection)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+	ps, err := d.packsOf(collection)
+	if err != nil {
+		return err
+	}
+	if ps == nil {
+		return fmt.Errorf("collection %s is not packed", collection)
+	}
+
+	reclaimed, err := ps.compact()
+	if err != nil {
+		return err
+	}
+	op.log.Info("Compacted %s, reclaiming %d bytes", collection, reclaimed)
+	return nil
+}
+
+// PackStats describes the packs of a packed collection.
+func (d *Driver) PackStats(collection string) (PackStats, error) {
+	if collection == "" {
+		return PackStats{}, fmt.Errorf("collection is required")
+	}
+	ps, err := d.packsOf(collection)
+	if err != nil {
+		return PackStats{}, err
+	}
+	if ps == nil {
+		return PackStats{}, fmt.Errorf("collection %s is not packed", collection)
+	}
+	return ps.stats(), nil
+}
+
+// forget closes the pack stores of collection and the collections nested
+// in it, which no longer exist.
+func (s *packSet) forget(collection string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for name, ps := range s.stores {
+		if name == collection || strings.HasPrefix(name, collection+string(filepath.Separator)) {
+			ps.drop()
+			delete(s.stores, name)
+		}
+	}
+	for name := range s.plain {
+		if name == collection || strings.HasPrefix(name, collection+string(filepath.Separator)) {
+			delete(s.plain, name)
+		}
+	}
+}
+
+// close saves the index of every pack store changed since it was loaded
+// and closes their files.
+func (s *packSet) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var first error
+	for name, ps := range s.stores {
+		if err := ps.close(); err != nil && first == nil {
+			first = fmt.Errorf("packs of %s: %w", name, err)
+		}
+		delete(s.stores, name)
+	}
+	return first
+}
+
+func (e packEntry) info(name string) os.FileInfo {
+	return packedFile{name: name, size: e.size, modTime: time.Unix(0, e.modTime)}
+}
+
+// openPackStore loads the pack store kept in dir: the index saved last,
+// then whatever was appended since. A torn entry at the end of a pack,
+// and any batch it cut short, is truncated away unless readOnly.
+func openPackStore(log Logger, dir string, limit int64, readOnly bool) (*packStore, error) {
+	ps := &packStore{
+		dir:      dir,
+		limit:    limit,
+		readOnly: readOnly,
+		packs:    make(map[uint32]*pack),
+		entries:  make(map[string]packEntry),
+		pins:     make(map[*packPin]bool),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, file := range files {
+		if id, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), packExt), 10, 32); err == nil && strings.HasSuffix(file.Name(), packExt) {
+			ids = append(ids, uint32(id))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	scanned, err := ps.loadIndex(ids)
+	if err != nil {
+		log.Warn("Rescanning the packs in %s: %s", dir, err)
+		scanned = nil
+		ps.entries = make(map[string]packEntry)
+		ps.dead = 0
+		ps.next = 0
+	}
+
+	for _, id := range ids {
+		from, indexed := scanned[id]
+		if !indexed && id < ps.next {
+			// Left by a compaction that saved its index but was
+			// interrupted before it removed the packs it replaced.
+			if !readOnly {
+				if err := os.Remove(filepath.Join(dir, packName(id))); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		p, err := ps.openPack(id)
+		if err != nil {
+			ps.closeFiles()
+			return nil, err
+		}
+		if err := ps.scan(log, p, from); err != nil {
+			ps.closeFiles()
+			return nil, err
+		}
+		if id >= ps.next {
+			ps.next = id + 1
+		}
+		ps.active = p
+	}
+	return ps, nil
+}
+
+func (ps *packStore) openPack(id uint32) (*pack, error) {
+	flag := os.O_RDWR
+	if ps.readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(filepath.Join(ps.dir, packName(id)), flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	p := &pack{id: id, file: f, size: fi.Size()}
+	ps.packs[id] = p
+	return p, nil
+}
+
+// scan adds the entries of p from offset on to the index.
+func (ps *packStore) scan(log Logger, p *pack, offset int64) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(p.file, offset, p.size-offset), 64*1024)
+
+	var batch []packEntry
+	var names []string
+	var tombstones []bool
+	end := offset
+	for {
+		header := make([]byte, packHeaderSize)
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		nameLen := int64(binary.LittleEndian.Uint32(header[4:]))
+		size := int64(binary.LittleEndian.Uint32(header[8:]))
+		if offset+packHeaderSize+nameLen+size > p.size {
+			break
+		}
+		body := make([]byte, nameLen+size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			break
+		}
+		if crc := crc32.Update(crc32.Checksum(header[4:], crc32c), crc32c, body); crc != binary.LittleEndian.Uint32(header) {
+			break
+		}
+
+		flags := header[20]
+		batch = append(batch, packEntry{
+			pack:    p.id,
+			offset:  offset,
+			length:  packHeaderSize + nameLen + size,
+			size:    size,
+			modTime: int64(binary.LittleEndian.Uint64(header[12:])),
+		})
+		names = append(names, string(body[:nameLen]))
+		tombstones = append(tombstones, flags&packTombstone != 0)
+		offset += packHeaderSize + nameLen + size
+
+		if flags&packContinued == 0 {
+			for i, e := range batch {
+				ps.apply(names[i], e, tombstones[i])
+			}
+			batch, names, tombstones = batch[:0], names[:0], tombstones[:0]
+			end = offset
+		}
+	}
+
+	if end < p.size {
+		log.Warn("Discarding %d bytes torn from the end of %s", p.size-end, p.file.Name())
+		if !ps.readOnly {
+			if err := p.file.Truncate(end); err != nil {
+				return err
+			}
+			ps.dirty = true
+		}
+		p.size = end
+	}
+	return nil
+}
+
+// apply records in the index an entry read or written for name.
+func (ps *packStore) apply(name string, e packEntry, tombstone bool) {
+	if old, ok := ps.entries[name]; ok {
+		ps.dead += old.length
+	}
+	if tombstone {
+		delete(ps.entries, name)
+		ps.dead += e.length
+		return
+	}
+	ps.entries[name] = e
+}
+
+func (ps *packStore) lookup(name string) (packEntry, bool) {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	e, ok := ps.entries[name]
+	return e, ok
+}
+
+// list returns the packed records not in skip.
+func (ps *packStore) list(skip map[string]bool) []os.FileInfo {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	files := make([]os.FileInfo, 0, len(ps.entries))
+	for name, e := range ps.entries {
+		if !skip[name] {
+			files = append(files, e.info(name))
+		}
+	}
+	return files
+}
+
+// get returns the stored bytes of name, checked against their CRC.
+func (ps *packStore) get(name string) ([]byte, bool, error) {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	e, ok := ps.entries[name]
+	if !ok {
+		return nil, false, nil
+	}
+	b, err := readPackEntry(ps.packs[e.pack], e, name)
+	return b, err == nil, err
+}
+
+func readPackEntry(p *pack, e packEntry, name string) ([]byte, error) {
+	b := make([]byte, e.length)
+	if _, err := p.file.ReadAt(b, e.offset); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(b[4:], crc32c) != binary.LittleEndian.Uint32(b) {
+		return nil, fmt.Errorf("%w: %s in %s", ErrChecksumMismatch, name, p.file.Name())
+	}
+	nameEnd := e.length - e.size
+	if string(b[packHeaderSize:nameEnd]) != name {
+		return nil, fmt.Errorf("%w: %s in %s holds another record", ErrChecksumMismatch, name, p.file.Name())
+	}
+	return b[nameEnd:], nil
+}
+
+func (ps *packStore) put(name string, value []byte, sync bool) error {
+	return ps.append([]packWrite{{name: name, value: value}}, sync)
+}
+
+// remove appends a tombstone for name, if it is packed.
+func (ps *packStore) remove(name string, sync bool) (bool, error) {
+	ps.appending.Lock()
+	defer ps.appending.Unlock()
+
+	if _, ok := ps.lookup(name); !ok {
+		return false, nil
+	}
+	return true, ps.appendLocked([]packWrite{{name: name, tombstone: true}}, sync)
+}
+
+// move renames a packed record: its bytes under the new name and a
+// tombstone under the old one are appended as one batch.
+func (ps *packStore) move(from, to string) error {
+	ps.appending.Lock()
+	defer ps.appending.Unlock()
+
+	b, ok, err := ps.get(from)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &os.PathError{Op: "rename", Path: filepath.Join(filepath.Dir(ps.dir), from), Err: os.ErrNotExist}
+	}
+	e, _ := ps.lookup(from)
+	return ps.appendLocked([]packWrite{
+		{name: to, value: b, modTime: e.modTime},
+		{name: from, tombstone: true},
+	}, false)
+}
+
+func (ps *packStore) append(batch []packWrite, sync bool) error {
+	ps.appending.Lock()
+	defer ps.appending.Unlock()
+	return ps.appendLocked(batch, sync)
+}
+
+// appendLocked writes batch at the end of the active pack, starting a new
+// one first if it would grow past the pack size.
+func (ps *packStore) appendLocked(batch []packWrite, sync bool) error {
+	now := time.Now().UnixNano()
+	var buf bytes.Buffer
+	for i, w := range batch {
+		var flags byte
+		if w.tombstone {
+			flags |= packTombstone
+		}
+		if i < len(batch)-1 {
+			flags |= packContinued
+		}
+		if w.modTime == 0 {
+			batch[i].modTime = now
+		}
+		encodePackEntry(&buf, w.name, w.value, batch[i].modTime, flags)
+	}
+
+	p := ps.active
+	if p == nil || p.size > 0 && p.size+int64(buf.Len()) > ps.limit {
+		var err error
+		if p, err = ps.newPack(); err != nil {
+			return err
+		}
+	}
+	if _, err := p.file.WriteAt(buf.Bytes(), p.size); err != nil {
+		return err
+	}
+	if sync {
+		if err := p.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	offset := p.size
+	for _, w := range batch {
+		length := packHeaderSize + int64(len(w.name)) + int64(len(w.value))
+		ps.apply(w.name, packEntry{pack: p.id, offset: offset, length: length, size: int64(len(w.value)), modTime: w.modTime}, w.tombstone)
+		offset += length
+	}
+	p.size = offset
+	ps.dirty = true
+	return nil
+}
+
+func encodePackEntry(buf *bytes.Buffer, name string, value []byte, modTime int64, flags byte) {
+	start := buf.Len()
+	header := make([]byte, packHeaderSize)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(name)))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(value)))
+	binary.LittleEndian.PutUint64(header[12:], uint64(modTime))
+	header[20] = flags
+	buf.Write(header)
+	buf.WriteString(name)
+	buf.Write(value)
+
+	b := buf.Bytes()[start:]
+	binary.LittleEndian.PutUint32(b, crc32.Checksum(b[4:], crc32c))
+}
+
+// newPack creates the next pack and makes it the active one. The caller
+// must hold appending.
+func (ps *packStore) newPack() (*pack, error) {
+	if err := os.MkdirAll(ps.dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(ps.dir, packName(ps.next)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syncPath(ps.dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	p := &pack{id: ps.next, file: f}
+	ps.mutex.Lock()
+	ps.packs[p.id] = p
+	ps.active = p
+	ps.next++
+	ps.mutex.Unlock()
+	return p, nil
+}
+
+// compact copies the live entries into new packs, saves the index naming
+// only those, then removes the old packs. It returns the bytes reclaimed.
+func (ps *packStore) compact() (int64, error) {
+	ps.appending.Lock()
+	defer ps.appending.Unlock()
+
+	ps.mutex.RLock()
+	type live struct {
+		name string
+		packEntry
+	}
+	entries := make([]live, 0, len(ps.entries))
+	for name, e := range ps.entries {
+		entries = append(entries, live{name, e})
+	}
+	var before int64
+	old := ps.sortedPacks()
+	for _, p := range old {
+		before += p.size
+	}
+	next := ps.next
+	ps.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].pack != entries[j].pack {
+			return entries[i].pack < entries[j].pack
+		}
+		return entries[i].offset < entries[j].offset
+	})
+
+	packs := make(map[uint32]*pack)
+	index := make(map[string]packEntry, len(entries))
+	var (
+		p   *pack
+		buf bytes.Buffer
+	)
+	abandon := func() {
+		for _, p := range packs {
+			p.file.Close()
+			os.Remove(p.file.Name())
+		}
+	}
+	flush := func() error {
+		if p == nil || buf.Len() == 0 {
+			return nil
+		}
+		if _, err := p.file.WriteAt(buf.Bytes(), p.size); err != nil {
+			return err
+		}
+		p.size += int64(buf.Len())
+		buf.Reset()
+		return p.file.Sync()
+	}
+
+	for _, e := range entries {
+		value, err := readPackEntry(ps.packs[e.pack], e.packEntry, e.name)
+		if err != nil {
+			abandon()
+			return 0, err
+		}
+		length := packHeaderSize + int64(len(e.name)) + int64(len(value))
+		if p == nil || p.size+int64(buf.Len())+length > ps.limit && p.size+int64(buf.Len()) > 0 {
+			if err := flush(); err != nil {
+				abandon()
+				return 0, err
+			}
+			f, err := os.OpenFile(filepath.Join(ps.dir, packName(next)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				abandon()
+				return 0, err
+			}
+			p = &pack{id: next, file: f}
+			packs[p.id] = p
+			next++
+		}
+		index[e.name] = packEntry{pack: p.id, offset: p.size + int64(buf.Len()), length: length, size: int64(len(value)), modTime: e.modTime}
+		encodePackEntry(&buf, e.name, value, e.modTime, 0)
+	}
+	if err := flush(); err != nil {
+		abandon()
+		return 0, err
+	}
+	if err := syncPath(ps.dir); err != nil && !os.IsNotExist(err) {
+		abandon()
+		return 0, err
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.packs, ps.entries, ps.dead, ps.next, ps.active = packs, index, 0, next, p
+	if err := ps.saveIndex(); err != nil {
+		return 0, err
+	}
+
+	var after int64
+	for _, p := range packs {
+		after += p.size
+	}
+	// Older packs go first, so a crash midway never leaves a record
+	// without the tombstone that deleted it.
+	return before - after, ps.retire(old)
+}
+
+// retire closes and removes packs no longer in use, or keeps them for
+// release if a pin still reads them. The caller must hold mutex.
+func (ps *packStore) retire(packs []*pack) error {
+	var first error
+	for _, p := range packs {
+		if ps.pinned(p) {
+			ps.retired = append(ps.retired, p)
+			continue
+		}
+		p.file.Close()
+		if err := os.Remove(p.file.Name()); err != nil && !os.IsNotExist(err) && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ps *packStore) pinned(p *pack) bool {
+	for pin := range ps.pins {
+		if pin.packs[p.id] == p {
+			return true
+		}
+	}
+	return false
+}
+
+// pin returns the current generation of the store, whose packs stay
+// readable until it is released, whatever Compact or a drop does meanwhile.
+func (ps *packStore) pin() *packPin {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	pin := &packPin{packs: make(map[uint32]*pack, len(ps.packs)), entries: make(map[string]packEntry, len(ps.entries))}
+	for id, p := range ps.packs {
+		pin.packs[id] = p
+	}
+	for name, e := range ps.entries {
+		pin.entries[name] = e
+	}
+	ps.pins[pin] = true
+	return pin
+}
+
+// release unpins pin, removing the retired packs only it still read.
+func (ps *packStore) release(pin *packPin) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if !ps.pins[pin] {
+		return nil
+	}
+	delete(ps.pins, pin)
+	retired := ps.retired
+	ps.retired = nil
+	return ps.retire(retired)
+}
+
+// get returns the stored bytes of name as of the pinned generation.
+func (pin *packPin) get(name string) ([]byte, bool, error) {
+	e, ok := pin.entries[name]
+	if !ok {
+		return nil, false, nil
+	}
+	b, err := readPackEntry(pin.packs[e.pack], e, name)
+	return b, err == nil, err
+}
+
+// saveIndex writes the index to disk after syncing the packs it covers.
+// The caller must hold mutex.
+func (ps *packStore) saveIndex() error {
+	var buf bytes.Buffer
+	buf.Write(packIndexMagic)
+	putUint32(&buf, ps.next)
+	putUint32(&buf, uint32(len(ps.packs)))
+	for _, p := range ps.packs {
+		if !ps.readOnly {
+			if err := p.file.Sync(); err != nil {
+				return err
+			}
+		}
+		putUint32(&buf, p.id)
+		putUint64(&buf, uint64(p.size))
+	}
+	putUint64(&buf, uint64(ps.dead))
+	putUint32(&buf, uint32(len(ps.entries)))
+	for name, e := range ps.entries {
+		putUint32(&buf, uint32(len(name)))
+		buf.WriteString(name)
+		putUint32(&buf, e.pack)
+		putUint64(&buf, uint64(e.offset))
+		putUint64(&buf, uint64(e.size))
+		putUint64(&buf, uint64(e.modTime))
+	}
+	putUint32(&buf, crc32.Checksum(buf.Bytes(), crc32c))
+
+	if err := os.MkdirAll(ps.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(ps.dir, packIndexFile)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	ps.dirty = false
+	return syncPath(ps.dir)
+}
+
+// loadIndex reads the saved index and returns how far it covers each of
+// the packs in ids. An index naming a pack that is missing or shorter than
+// it says is not used.
+func (ps *packStore) loadIndex(ids []uint32) (map[uint32]int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(ps.dir, packIndexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < len(packIndexMagic)+4 || !bytes.HasPrefix(b, packIndexMagic) {
+		return nil, fmt.Errorf("index is not a pack index")
+	}
+	body := b[:len(b)-4]
+	if crc32.Checksum(body, crc32c) != binary.LittleEndian.Uint32(b[len(body):]) {
+		return nil, fmt.Errorf("%w: pack index", ErrChecksumMismatch)
+	}
+	r := &indexReader{b: body[len(packIndexMagic):]}
+
+	sizes := make(map[uint32]int64, len(ids))
+	for _, id := range ids {
+		if fi, err := os.Stat(filepath.Join(ps.dir, packName(id))); err == nil {
+			sizes[id] = fi.Size()
+		}
+	}
+
+	ps.next = r.uint32()
+	scanned := make(map[uint32]int64)
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		id, size := r.uint32(), int64(r.uint64())
+		if have, ok := sizes[id]; !ok || have < size {
+			return nil, fmt.Errorf("pack %s is shorter than indexed", packName(id))
+		}
+		scanned[id] = size
+	}
+	ps.dead = int64(r.uint64())
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		name := string(r.bytes(int(r.uint32())))
+		e := packEntry{pack: r.uint32(), offset: int64(r.uint64()), size: int64(r.uint64()), modTime: int64(r.uint64())}
+		e.length = packHeaderSize + int64(len(name)) + e.size
+		ps.entries[name] = e
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return scanned, nil
+}
+
+type indexReader struct {
+	b   []byte
+	err error
+}
+
+func (r *indexReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = fmt.Errorf("pack index is truncated")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *indexReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *indexReader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func putUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func putUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+// reset empties a truncated collection's packs.
+func (ps *packStore) reset() error {
+	ps.appending.Lock()
+	defer ps.appending.Unlock()
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if err := ps.retire(ps.sortedPacks()); err != nil {
+		return err
+	}
+	ps.packs = make(map[uint32]*pack)
+	ps.entries = make(map[string]packEntry)
+	ps.active, ps.dead, ps.dirty = nil, 0, false
+	return os.RemoveAll(ps.dir)
+}
+
+func (ps *packStore) stats() PackStats {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	stats := PackStats{Packs: len(ps.packs), Records: len(ps.entries), DeadBytes: ps.dead}
+	for _, p := range ps.packs {
+		stats.LiveBytes += p.size
+	}
+	stats.LiveBytes -= ps.dead
+	return stats
+}
+
+// close saves the index if it changed, so the next open need not rescan
+// the packs, and closes them.
+func (ps *packStore) close() error {
+	ps.appending.Lock()
+	defer ps.appending.Unlock()
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	var err error
+	if ps.dirty && !ps.readOnly {
+		err = ps.saveIndex()
+	}
+	// Retired packs are not in the index, so the next open removes them.
+	for _, p := range append(ps.sortedPacks(), ps.retired...) {
+		p.file.Close()
+	}
+	ps.packs = make(map[uint32]*pack)
+	ps.entries = make(map[string]packEntry)
+	ps.active, ps.retired = nil, nil
+	return err
+}
+
+// drop closes the packs of a dropped collection, once no pin reads them.
+func (ps *packStore) drop() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.retire(ps.sortedPacks())
+}
+
+func (ps *packStore) closeFiles() {
+	for _, p := range ps.packs {
+		p.file.Close()
+	}
+}
+
+// sortedPacks returns the packs in id order. The caller must hold mutex.
+func (ps *packStore) sortedPacks() []*pack {
+	packs := make([]*pack, 0, len(ps.packs))
+	for _, p := range ps.packs {
+		packs = append(packs, p)
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].id < packs[j].id })
+	return packs
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
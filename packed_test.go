@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type packedDoc struct {
+	N    int
+	Body string
+}
+
+func writePacked(t *testing.T, d *Driver, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := d.Write("p", fmt.Sprintf("r%04d", i), packedDoc{i, strings.Repeat("x", 150)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkPacked(t *testing.T, d *Driver, want map[string]int) {
+	t.Helper()
+	keys, err := d.Keys("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("Keys returned %d records, want %d", len(keys), len(want))
+	}
+	for resource, n := range want {
+		var doc packedDoc
+		if err := d.Read("p", resource, &doc); err != nil {
+			t.Fatalf("Read %s: %s", resource, err)
+		}
+		if doc.N != n {
+			t.Errorf("%s holds %d, want %d", resource, doc.N, n)
+		}
+	}
+	records, err := d.ReadAll("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(want) {
+		t.Errorf("ReadAll returned %d records, want %d", len(records), len(want))
+	}
+}
+
+// A packed collection behaves like any other through the public API, keeps
+// no record files, and survives a reopen.
+func TestPackedCollection(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{Packed: map[string]bool{"p": true}, PackSize: 16 << 10}
+	d := reopenTest(t, dir, opts)
+
+	writePacked(t, d, 0, 300)
+	want := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		want[fmt.Sprintf("r%04d", i)] = i
+	}
+	for i := 0; i < 300; i += 3 {
+		if err := d.Delete("p", fmt.Sprintf("r%04d", i)); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, fmt.Sprintf("r%04d", i))
+	}
+	if err := d.Write("p", "r0001", packedDoc{N: -1}); err != nil {
+		t.Fatal(err)
+	}
+	want["r0001"] = -1
+	if err := d.Swap("p", "r0001", "r0002"); err != nil {
+		t.Fatal(err)
+	}
+	want["r0001"], want["r0002"] = 2, -1
+	if err := d.Delete("p", "r0000"); !os.IsNotExist(err) {
+		t.Errorf("Delete of a deleted record = %v, want a not-exist error", err)
+	}
+	checkPacked(t, d, want)
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.Name() != packsDir {
+			t.Errorf("packed collection holds %s", file.Name())
+		}
+	}
+	stats, err := d.PackStats("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Packs < 2 || stats.Records != len(want) {
+		t.Errorf("PackStats = %+v, want several packs holding %d records", stats, len(want))
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkPacked(t, reopenTest(t, dir, opts), want)
+}
+
+// Records appended after the index was saved are found by a rescan, and a
+// torn batch at the end of a pack is discarded as a whole.
+func TestPackedTornTail(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Packed: map[string]bool{"p": true}}
+
+	d := reopenTest(t, dir, &opts)
+	writePacked(t, d, 0, 10)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	crashed := openUnshared(t, dir, opts)
+	writePacked(t, crashed, 10, 20)
+	if err := crashed.Swap("p", "r0001", "r0002"); err != nil {
+		t.Fatal(err)
+	}
+	// The swap's last rename is a batch of two entries; cut it in the
+	// middle of the second one.
+	pack := filepath.Join(dir, "p", packsDir, packName(0))
+	fi, err := os.Stat(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(pack, fi.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	d = reopenTest(t, dir, &Options{Packed: opts.Packed})
+	want := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		want[fmt.Sprintf("r%04d", i)] = i
+	}
+	// r0001 was parked aside and r0002 moved over it; the batch moving
+	// r0001's content into r0002 is gone, leaving it under its .swap name.
+	want["r0001"] = 2
+	delete(want, "r0002")
+	checkPacked(t, d, want)
+
+	writePacked(t, d, 20, 21)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want["r0020"] = 20
+	checkPacked(t, reopenTest(t, dir, &Options{Packed: opts.Packed}), want)
+}
+
+// Compact drops the space of overwritten and deleted records, and what it
+// leaves reads back the same, also after a reopen.
+func TestPackedCompact(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{Packed: map[string]bool{"p": true}, PackSize: 16 << 10}
+	d := reopenTest(t, dir, opts)
+
+	writePacked(t, d, 0, 200)
+	writePacked(t, d, 0, 100)
+	want := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		want[fmt.Sprintf("r%04d", i)] = i
+	}
+	for i := 100; i < 150; i++ {
+		if err := d.Delete("p", fmt.Sprintf("r%04d", i)); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, fmt.Sprintf("r%04d", i))
+	}
+
+	before, err := d.PackStats("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Compact("p"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := d.PackStats("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.DeadBytes != 0 || after.LiveBytes != before.LiveBytes || after.Packs >= before.Packs {
+		t.Errorf("PackStats went from %+v to %+v, want the dead bytes and some packs gone", before, after)
+	}
+	checkPacked(t, d, want)
+
+	packs, err := ioutil.ReadDir(filepath.Join(dir, "p", packsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var onDisk int64
+	for _, pack := range packs {
+		if strings.HasSuffix(pack.Name(), packExt) {
+			onDisk += pack.Size()
+		}
+	}
+	if onDisk != after.LiveBytes {
+		t.Errorf("packs hold %d bytes, want %d", onDisk, after.LiveBytes)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkPacked(t, reopenTest(t, dir, opts), want)
+}
+
+// Small records take about their own size on disk instead of a block each.
+func TestPackedSpace(t *testing.T) {
+	const records = 2000
+	dir := t.TempDir()
+	d := reopenTest(t, dir, &Options{Packed: map[string]bool{"p": true}})
+
+	var logical int64
+	for i := 0; i < records; i++ {
+		doc := packedDoc{i, strings.Repeat("x", 150)}
+		if err := d.Write("p", fmt.Sprintf("r%04d", i), doc); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := d.marshal(doc)
+		logical += int64(len(b)) + 1
+	}
+
+	stats, err := d.PackStats("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overhead := int64(records * (packHeaderSize + len("r0000.json")))
+	if stats.LiveBytes != logical+overhead {
+		t.Errorf("packs hold %d bytes for %d bytes of records, want %d", stats.LiveBytes, logical, logical+overhead)
+	}
+}
+
+// Point reads from packs should cost about what reads of record files do.
+func BenchmarkPackedRead(b *testing.B) {
+	for _, packed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("packed=%v", packed), func(b *testing.B) {
+			d := openTest(b, &Options{Packed: map[string]bool{"p": packed}})
+			for i := 0; i < 1000; i++ {
+				if err := d.Write("p", fmt.Sprintf("r%04d", i), packedDoc{i, strings.Repeat("x", 150)}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			var doc packedDoc
+			for i := 0; i < b.N; i++ {
+				if err := d.Read("p", fmt.Sprintf("r%04d", i%1000), &doc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
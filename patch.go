@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Patch sets the value at a dotted path of a record under the collection
+// lock. Path segments index into arrays when they are numbers, and a last
+// segment of "-" appends to an array, so "-" alone appends to a record that
+// is a top-level array and "2.level" sets a field of its third element.
+// Missing objects along the path are created; missing array elements are
+// not.
+func (d *Driver) Patch(collection, resource, path string, value interface{}) error {
+	if path == "" {
+		return fmt.Errorf("path is required")
+	}
+	v, err := generic(value)
+	if err != nil {
+		return err
+	}
+
+	return d.Modify(collection, resource, func(current []byte) ([]byte, error) {
+		dec := json.NewDecoder(bytes.NewReader(current))
+		dec.UseNumber()
+
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if doc, err = patch(doc, strings.Split(path, "."), v); err != nil {
+			return nil, fmt.Errorf("%s/%s: patch %s: %w", collection, resource, path, err)
+		}
+		return json.Marshal(doc)
+	})
+}
+
+// patch returns node with value set at the path parts.
+func patch(node interface{}, parts []string, value interface{}) (interface{}, error) {
+	if len(parts) == 0 {
+		return value, nil
+	}
+	part, rest := parts[0], parts[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[part]
+		if !ok && len(rest) > 0 {
+			child = make(map[string]interface{})
+		}
+		var err error
+		if n[part], err = patch(child, rest, value); err != nil {
+			return nil, err
+		}
+		return n, nil
+
+	case []interface{}:
+		if part == "-" {
+			if len(rest) > 0 {
+				return nil, fmt.Errorf("- must be the last segment")
+			}
+			return append(n, value), nil
+		}
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || i >= len(n) {
+			return nil, fmt.Errorf("index %s out of range [0,%d)", part, len(n))
+		}
+		if n[i], err = patch(n[i], rest, value); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%s: cannot index %T", part, node)
+}
+
+// UpdateWhere sets the fields of patch, which may be dotted paths, in every
+// record of collection whose fields equal those of match, as FindWhere
+// selects them, and returns how many records it updated. It holds the
+// collection lock throughout and computes every update before writing the
+// first, so a record that cannot be patched leaves the collection as it
+// was; a failing write can still leave earlier ones applied.
+func (d *Driver) UpdateWhere(collection string, match map[string]interface{}, patch map[string]interface{}) (_ int, err error) {
+	op := d.trace(nil, "UpdateWhere", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return 0, fmt.Errorf("collection is required")
+	}
+	if len(patch) == 0 {
+		return 0, fmt.Errorf("patch is required")
+	}
+
+	q := d.Find(collection)
+	for field, value := range match {
+		q.whereEqual(field, value)
+	}
+	values := make(map[string]interface{}, len(patch))
+	for field, value := range patch {
+		if values[field], err = generic(value); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return 0, err
+	}
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return 0, err
+	}
+
+	type update struct {
+		resource string
+		b        []byte
+	}
+	var updates []update
+	err = d.eachRecord(op.log, collection, func(resource string, b []byte) error {
+		doc, err := decodeDocument(b)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %s", collection, resource, err)
+		}
+		if !q.match(resource, doc) {
+			return nil
+		}
+		for field, value := range values {
+			assign(doc, field, value)
+		}
+		if b, err = json.Marshal(doc); err != nil {
+			return err
+		}
+		updates = append(updates, update{resource, b})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, u := range updates {
+		if err := d.store(op, collection, u.resource, u.b); err != nil {
+			return i, wrapOp("write", collection, u.resource, err)
+		}
+	}
+	return len(updates), nil
+}
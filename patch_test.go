@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type event struct {
+	Level string
+	Msg   string
+}
+
+// Records that are arrays of events are queried element by element with
+// Unwind, and appended to atomically by concurrent Patches.
+func TestArrayRecords(t *testing.T) {
+	d := openTest(t, nil)
+	batches := map[string][]event{
+		"b1": {{"info", "started"}, {"error", "disk full"}},
+		"b2": {{"info", "started"}, {"info", "stopped"}},
+	}
+	for key, batch := range batches {
+		if err := d.Write("batch", key, batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var errs []event
+	if err := d.Find("batch").Unwind("").Where("Level", "=", "error").Collect(&errs); err != nil {
+		t.Fatal(err)
+	}
+	if want := []event{{"error", "disk full"}}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("unwound errors %v, want %v", errs, want)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := d.Patch("batch", "b2", "-", event{"error", fmt.Sprint(i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := d.Patch("batch", "b2", "0.Level", "debug"); err != nil {
+		t.Fatal(err)
+	}
+
+	var b2 []event
+	if err := d.Read("batch", "b2", &b2); err != nil {
+		t.Fatal(err)
+	}
+	if len(b2) != 22 || b2[0].Level != "debug" || b2[1] != batches["b2"][1] {
+		t.Fatalf("b2 after the patches: %v", b2)
+	}
+	appended := make(map[string]bool)
+	for _, e := range b2[2:] {
+		appended[e.Msg] = true
+	}
+	if len(appended) != 20 {
+		t.Errorf("%d of 20 appended events survived", len(appended))
+	}
+
+	errs = nil
+	if err := d.Find("batch").Unwind("").Where("Level", "=", "error").Collect(&errs); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 21 {
+		t.Errorf("unwound %d errors after the appends, want 21", len(errs))
+	}
+}
+
+// UpdateWhere sets a field, and a nested one, on the users of Google only,
+// and reports how many records it updated.
+func TestUpdateWhere(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	n, err := d.UpdateWhere("user", map[string]interface{}{"Company": "Google"}, map[string]interface{}{
+		"Active":       false,
+		"Address.Code": "94105",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("UpdateWhere updated %d users, want 1", n)
+	}
+
+	for _, u := range testUsers {
+		var got struct {
+			User
+			Active *bool
+		}
+		if err := d.Read("user", u.Name, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := u
+		if u.Company == "Google" {
+			want.Address.Code = "94105"
+			if got.Active == nil || *got.Active {
+				t.Errorf("%s is not deactivated", u.Name)
+			}
+		} else if got.Active != nil {
+			t.Errorf("%s, not of Google, has Active set", u.Name)
+		}
+		if got.User != want {
+			t.Errorf("%s after the update %+v, want %+v", u.Name, got.User, want)
+		}
+	}
+
+	if n, err := d.UpdateWhere("user", map[string]interface{}{"Company": "Nobody"}, map[string]interface{}{"Active": false}); err != nil || n != 0 {
+		t.Errorf("UpdateWhere matching no one = %d, %v", n, err)
+	}
+}
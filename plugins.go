@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// Codec translates the JSON documents the driver works with to and
+	// from another serialization. Queries, indexes and transforms still see
+	// JSON; only the bytes on disk change.
+	Codec interface {
+		Encode(doc json.RawMessage) ([]byte, error)
+		Decode(data []byte) (json.RawMessage, error)
+	}
+
+	Compressor interface {
+		Compress(b []byte) ([]byte, error)
+		Decompress(b []byte) ([]byte, error)
+	}
+
+	Cipher interface {
+		Seal(plaintext []byte) ([]byte, error)
+		Open(sealed []byte) ([]byte, error)
+	}
+
+	// CodecConfig names the registered codec, compressor and cipher a
+	// collection's records are written with. Empty names are skipped.
+	CodecConfig struct {
+		Codec      string `json:"codec,omitempty"`
+		Compressor string `json:"compressor,omitempty"`
+		Cipher     string `json:"cipher,omitempty"`
+	}
+
+	pipeline struct {
+		config     CodecConfig
+		codec      Codec
+		compressor Compressor
+		cipher     Cipher
+	}
+)
+
+// pipelineMagic prefixes records written through a CodecConfig. It is
+// followed by the config's names, comma separated, and a newline.
+var pipelineMagic = []byte("GDBP1\x00")
+
+var plugins = struct {
+	sync.RWMutex
+	codecs      map[string]Codec
+	compressors map[string]Compressor
+	ciphers     map[string]Cipher
+}{
+	codecs:      make(map[string]Codec),
+	compressors: make(map[string]Compressor),
+	ciphers:     make(map[string]Cipher),
+}
+
+// RegisterCodec makes c available under name to CodecConfig. Like the
+// other Register functions it is meant to be called from an init function
+// and panics if name is empty, contains a comma or is already registered.
+func RegisterCodec(name string, c Codec) {
+	plugins.Lock()
+	defer plugins.Unlock()
+	checkPluginName("codec", name, c == nil, plugins.codecs[name] != nil)
+	plugins.codecs[name] = c
+}
+
+func RegisterCompressor(name string, c Compressor) {
+	plugins.Lock()
+	defer plugins.Unlock()
+	checkPluginName("compressor", name, c == nil, plugins.compressors[name] != nil)
+	plugins.compressors[name] = c
+}
+
+func RegisterCipher(name string, c Cipher) {
+	plugins.Lock()
+	defer plugins.Unlock()
+	checkPluginName("cipher", name, c == nil, plugins.ciphers[name] != nil)
+	plugins.ciphers[name] = c
+}
+
+func checkPluginName(kind, name string, isNil, exists bool) {
+	switch {
+	case name == "" || strings.ContainsAny(name, ",\n"):
+		panic(fmt.Sprintf("godb: invalid %s name %q", kind, name))
+	case isNil:
+		panic(fmt.Sprintf("godb: Register%s%s %s is nil", strings.ToUpper(kind[:1]), kind[1:], name))
+	case exists:
+		panic(fmt.Sprintf("godb: %s %s is already registered", kind, name))
+	}
+}
+
+// Plugins lists the registered codecs, compressors and ciphers by name.
+func Plugins() (codecs, compressors, ciphers []string) {
+	plugins.RLock()
+	defer plugins.RUnlock()
+	for name := range plugins.codecs {
+		codecs = append(codecs, name)
+	}
+	for name := range plugins.compressors {
+		compressors = append(compressors, name)
+	}
+	for name := range plugins.ciphers {
+		ciphers = append(ciphers, name)
+	}
+	sort.Strings(codecs)
+	sort.Strings(compressors)
+	sort.Strings(ciphers)
+	return
+}
+
+func notRegistered(kind, name string) error {
+	return fmt.Errorf("%s %s not registered; import the package that registers it", kind, name)
+}
+
+// resolve looks the names of c up in the registries.
+func (c CodecConfig) resolve() (*pipeline, error) {
+	plugins.RLock()
+	defer plugins.RUnlock()
+
+	p := &pipeline{config: c}
+	if c.Codec != "" {
+		if p.codec = plugins.codecs[c.Codec]; p.codec == nil {
+			return nil, notRegistered("codec", c.Codec)
+		}
+	}
+	if c.Compressor != "" {
+		if p.compressor = plugins.compressors[c.Compressor]; p.compressor == nil {
+			return nil, notRegistered("compressor", c.Compressor)
+		}
+	}
+	if c.Cipher != "" {
+		if p.cipher = plugins.ciphers[c.Cipher]; p.cipher == nil {
+			return nil, notRegistered("cipher", c.Cipher)
+		}
+	}
+	return p, nil
+}
+
+// SetCodecs makes later writes to collection go through the registered
+// plugins named by config; a zero config goes back to plain JSON. Existing
+// records are read with whatever they were written with and converted when
+// next written. The names are persisted with the collection, and opening
+// the database fails if one of them is not registered.
+func (d *Driver) SetCodecs(collection string, config CodecConfig) (err error) {
+	op := d.trace(nil, "SetCodecs", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if err := validateName(collection, ""); err != nil {
+		return err
+	}
+	if err := d.checkOccupied(metaPath(collection)); err != nil {
+		return err
+	}
+	p, err := config.resolve()
+	if err != nil {
+		return err
+	}
+	defer d.lockCollections(collection)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(d.dir, collection), 0755); err != nil {
+		return err
+	}
+	meta, err := d.readMeta(collection)
+	if err != nil {
+		return err
+	}
+	meta.Codecs = nil
+	if config != (CodecConfig{}) {
+		meta.Codecs = &config
+	}
+	if err := d.writeMeta(collection, meta); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	if meta.Codecs == nil {
+		delete(d.pipelines, collection)
+	} else {
+		d.pipelines[collection] = p
+	}
+	d.mutex.Unlock()
+	return nil
+}
+
+// Codecs returns the CodecConfig of collection.
+func (d *Driver) Codecs(collection string) CodecConfig {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if p := d.pipelines[collection]; p != nil {
+		return p.config
+	}
+	return CodecConfig{}
+}
+
+func (d *Driver) pipelineOf(collection string) *pipeline {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.pipelines[collection]
+}
+
+func (p *pipeline) encode(b []byte) ([]byte, error) {
+	var err error
+	if p.codec != nil {
+		if b, err = p.codec.Encode(bytes.TrimSpace(b)); err != nil {
+			return nil, fmt.Errorf("codec %s: %w", p.config.Codec, err)
+		}
+	}
+	if p.compressor != nil {
+		if b, err = p.compressor.Compress(b); err != nil {
+			return nil, fmt.Errorf("compressor %s: %w", p.config.Compressor, err)
+		}
+	}
+	if p.cipher != nil {
+		if b, err = p.cipher.Seal(b); err != nil {
+			return nil, fmt.Errorf("cipher %s: %w", p.config.Cipher, err)
+		}
+	}
+
+	header := strings.Join([]string{p.config.Codec, p.config.Compressor, p.config.Cipher}, ",")
+	out := make([]byte, 0, len(pipelineMagic)+len(header)+1+len(b))
+	out = append(out, pipelineMagic...)
+	out = append(out, header...)
+	out = append(out, '\n')
+	return append(out, b...), nil
+}
+
+// decodePipeline reverses pipeline.encode using the names recorded in the
+// record itself, so it does not depend on the collection's current config.
+func decodePipeline(b []byte) ([]byte, error) {
+	b = b[len(pipelineMagic):]
+	i := bytes.IndexByte(b, '\n')
+	names := strings.Split(string(b[:max(i, 0)]), ",")
+	if i < 0 || len(names) != 3 {
+		return nil, fmt.Errorf("record has an invalid codec header")
+	}
+
+	config := CodecConfig{Codec: names[0], Compressor: names[1], Cipher: names[2]}
+	p, err := config.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	b = b[i+1:]
+	if p.cipher != nil {
+		if b, err = p.cipher.Open(b); err != nil {
+			return nil, fmt.Errorf("cipher %s: %w", config.Cipher, err)
+		}
+	}
+	if p.compressor != nil {
+		if b, err = p.compressor.Decompress(b); err != nil {
+			return nil, fmt.Errorf("compressor %s: %w", config.Compressor, err)
+		}
+	}
+	if p.codec != nil {
+		if b, err = p.codec.Decode(b); err != nil {
+			return nil, fmt.Errorf("codec %s: %w", config.Codec, err)
+		}
+	}
+	return b, nil
+}
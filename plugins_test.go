@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// reverseCodec stores a document's JSON backwards.
+type reverseCodec struct{}
+
+func (reverseCodec) Encode(doc json.RawMessage) ([]byte, error)  { return reversed(doc), nil }
+func (reverseCodec) Decode(data []byte) (json.RawMessage, error) { return reversed(data), nil }
+
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[len(b)-1-i] = c
+	}
+	return out
+}
+
+var registerToy sync.Once
+
+// A collection configured with a registered codec by name keeps it across
+// a reopen and round-trips its records; registering the name twice panics
+// and reopening without the codec registered names it.
+func TestRegisteredCodec(t *testing.T) {
+	registerToy.Do(func() { RegisterCodec("toy", reverseCodec{}) })
+	if codecs, _, _ := Plugins(); !strings.Contains(fmt.Sprint(codecs), "toy") {
+		t.Fatalf("registered codecs %v lack toy", codecs)
+	}
+
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	if err := d.SetCodecs("user", CodecConfig{Codec: "toy"}); err != nil {
+		t.Fatal(err)
+	}
+	seedUsers(t, d)
+	d.Close()
+
+	d = reopenTest(t, dir, nil)
+	if got := d.Codecs("user"); got != (CodecConfig{Codec: "toy"}) {
+		t.Errorf("reopened with codecs %+v", got)
+	}
+	b, err := os.ReadFile(d.recordPath("user", "John"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, pipelineMagic) || bytes.Contains(b, []byte(`"Name"`)) {
+		t.Errorf("the record was not written through the codec: %q", b)
+	}
+	for _, want := range testUsers {
+		var got User
+		if err := d.Read("user", want.Name, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s read back %+v", want.Name, got)
+		}
+	}
+	d.Close()
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "already registered") {
+				t.Errorf("registering toy twice recovered %v", r)
+			}
+		}()
+		RegisterCodec("toy", reverseCodec{})
+	}()
+
+	meta := filepath.Join(dir, "user", metaFile)
+	b, err = os.ReadFile(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(meta, bytes.Replace(b, []byte(`"toy"`), []byte(`"protobuf"`), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir, &Options{Logger: nopLog{}}); err == nil || !strings.Contains(err.Error(), "codec protobuf not registered") {
+		t.Errorf("opening with an unregistered codec = %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	prefixGuard struct {
+		collection string
+		prefix     string
+		// owner, when set, is the operation the guard protects, whose own
+		// writes, such as the reference actions of its deletes, it lets
+		// through.
+		owner *operation
+	}
+	guardSet struct {
+		mutex  sync.Mutex
+		guards map[*prefixGuard]bool
+	}
+)
+
+// GuardPrefix makes writes to keys of collection starting with prefix fail
+// with ErrGuarded until release is called, e.g. around DeletePrefix so a
+// concurrent writer cannot recreate what is being deleted.
+func (d *Driver) GuardPrefix(collection, prefix string) (release func()) {
+	return d.guardPrefix(nil, collection, prefix)
+}
+
+func (d *Driver) guardPrefix(owner *operation, collection, prefix string) (release func()) {
+	g := &prefixGuard{collection, prefix, owner}
+
+	s := d.guards
+	s.mutex.Lock()
+	s.guards[g] = true
+	s.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			delete(s.guards, g)
+			s.mutex.Unlock()
+		})
+	}
+}
+
+func (d *Driver) checkGuards(op *operation, collection, resource string) error {
+	s := d.guards
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for g := range s.guards {
+		if g.collection == collection && strings.HasPrefix(resource, g.prefix) && (g.owner == nil || g.owner != op) {
+			return fmt.Errorf("%w: %s/%s* is being deleted", ErrGuarded, collection, g.prefix)
+		}
+	}
+	return nil
+}
+
+// DeletePrefix deletes every record of collection whose key starts with
+// prefix in one pass under the collection's delete locks, applying
+// reference actions and dropping sidecars and index entries, and returns
+// how many records it deleted. It guards the prefix, as GuardPrefix does,
+// until it returns.
+func (d *Driver) DeletePrefix(collection, prefix string) (_ int, err error) {
+	op := d.trace(nil, "DeletePrefix", collection, prefix)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return 0, fmt.Errorf("collection is required")
+	}
+	if prefix == "" {
+		return 0, fmt.Errorf("prefix is required; use Truncate to empty a collection")
+	}
+	defer d.guardPrefix(op, collection, prefix)()
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return 0, err
+	}
+
+	scope := d.deleteScope(collection)
+	defer d.lockCollections(scope...)()
+	defer d.listings.invalidate(collection)
+
+	if err := d.checkWritable(scope...); err != nil {
+		return 0, err
+	}
+
+	files, err := d.readDir(filepath.Join(d.dir, collection))
+	if err != nil {
+		return 0, err
+	}
+	var resources []string
+	for _, file := range files {
+		if file.IsDir() || !d.isRecord(file.Name()) {
+			continue
+		}
+		if resource := d.resourceOf(file.Name()); strings.HasPrefix(resource, prefix) {
+			resources = append(resources, resource)
+		}
+	}
+	sort.Strings(resources)
+
+	deleted := 0
+	for _, resource := range resources {
+		// A record already removed by the cascade of an earlier delete
+		// still counts.
+		if err := d.delete(op, collection, resource); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
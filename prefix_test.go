@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// DeletePrefix guards its prefix for its whole run, yet lets through the
+// writes of its own reference actions.
+func TestDeletePrefixGuard(t *testing.T) {
+	d := openTest(t, &Options{References: map[string]map[string]Reference{
+		"n": {"parent": {Collection: "n", OnDelete: SetNull}},
+	}})
+	if err := d.Write("n", "p1", map[string]interface{}{"name": "root"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("n", "p2", map[string]interface{}{"parent": "p1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := d.DeletePrefix("n", "p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("DeletePrefix deleted %d records, want 2", n)
+	}
+
+	if err := d.Write("n", "p3", map[string]interface{}{}); err != nil {
+		t.Errorf("Write after DeletePrefix: %s", err)
+	}
+
+	release := d.GuardPrefix("n", "q")
+	if err := d.Write("n", "q1", map[string]interface{}{}); !errors.Is(err, ErrGuarded) {
+		t.Errorf("Write under a guard = %v, want ErrGuarded", err)
+	}
+	release()
+	if err := d.Write("n", "q1", map[string]interface{}{}); err != nil {
+		t.Errorf("Write after release: %s", err)
+	}
+}
+
+// With a writer racing to add prefixed keys, a guarded DeletePrefix leaves
+// none of them behind and counts every one it removed; other keys stay.
+func TestDeletePrefixConcurrentWriter(t *testing.T) {
+	d := openTest(t, nil)
+	if err := d.Write("n", "other", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		written int64
+		stop    = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			switch err := d.Write("n", fmt.Sprintf("tenant-%d", i), i); {
+			case err == nil:
+				atomic.AddInt64(&written, 1)
+			case !errors.Is(err, ErrGuarded):
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	waitFor(t, "the writer to get going", func() bool { return atomic.LoadInt64(&written) >= 50 })
+
+	release := d.GuardPrefix("n", "tenant-")
+	n, err := d.DeletePrefix("n", "tenant-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := d.Keys("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+	release()
+
+	if !reflect.DeepEqual(keys, []string{"other"}) {
+		t.Errorf("keys after DeletePrefix: %v, want [other]", keys)
+	}
+	if int64(n) != written {
+		t.Errorf("DeletePrefix counted %d records of the %d written", n, written)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ReadProjection returns a record reduced to the given dotted field paths,
+// nested as in the record. Missing fields are omitted, a path reaching
+// into an array is applied to each element, and no fields yield an empty
+// object. Only the objects along the requested paths are decoded.
+func (d *Driver) ReadProjection(collection, resource string, fields []string) (json.RawMessage, error) {
+	var raw json.RawMessage
+	if err := d.Get(d.ctx, collection, resource, &raw); err != nil {
+		return nil, err
+	}
+
+	paths := make([][]string, 0, len(fields))
+	for _, field := range fields {
+		if field == "" {
+			return nil, fmt.Errorf("empty field path")
+		}
+		paths = append(paths, strings.Split(field, "."))
+	}
+
+	out, ok, err := project(raw, paths)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", collection, resource, err)
+	}
+	if !ok {
+		return json.RawMessage("{}"), nil
+	}
+	return out, nil
+}
+
+// project keeps the parts of raw named by paths. ok is false when raw has
+// none of them.
+func project(raw json.RawMessage, paths [][]string) (json.RawMessage, bool, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, false, nil
+	}
+
+	switch raw[0] {
+	case '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, false, err
+		}
+		out := make([]json.RawMessage, 0, len(elems))
+		for _, elem := range elems {
+			b, ok, err := project(elem, paths)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				out = append(out, b)
+			}
+		}
+		if len(out) == 0 {
+			return nil, false, nil
+		}
+		b, err := json.Marshal(out)
+		return b, true, err
+	case '{':
+	default:
+		return nil, false, nil
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, false, err
+	}
+
+	children := make(map[string][][]string)
+	whole := make(map[string]bool)
+	for _, path := range paths {
+		if len(path) == 1 {
+			whole[path[0]] = true
+		} else {
+			children[path[0]] = append(children[path[0]], path[1:])
+		}
+	}
+
+	out := make(map[string]json.RawMessage)
+	for key, v := range obj {
+		switch {
+		case whole[key]:
+			out[key] = v
+		case children[key] != nil:
+			b, ok, err := project(v, children[key])
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				out[key] = b
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, false, nil
+	}
+
+	b, err := json.Marshal(out)
+	return b, true, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// A projection keeps exactly the requested paths, nested as in the record,
+// applies paths into arrays to each element and omits missing fields.
+func TestReadProjection(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	if err := d.Write("cart", "c", map[string]interface{}{
+		"Items": []map[string]int{{"A": 1, "B": 2}, {"A": 3}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		collection, resource string
+		fields               []string
+		want                 string
+	}{
+		{"user", "John", []string{"Name", "Address.City"}, `{"Name": "John", "Address": {"City": "bangalore"}}`},
+		{"user", "John", []string{"Name", "Missing.Field"}, `{"Name": "John"}`},
+		{"user", "John", nil, `{}`},
+		{"cart", "c", []string{"Items.A"}, `{"Items": [{"A": 1}, {"A": 3}]}`},
+	} {
+		b, err := d.ReadProjection(c.collection, c.resource, c.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal([]byte(c.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("projecting %v gave %s, want %s", c.fields, b, c.want)
+		}
+	}
+}
+
+// godb get -fields prints the projection, indented.
+func TestGetCommandFields(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	seedUsers(t, d)
+	d.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	code := runGet([]string{"user", "John", "-dir", dir, "-fields", "Name,Address.City", "-indent", "\t", "-color=false"})
+	os.Stdout = stdout
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "{\n\t\"Address\": {\n\t\t\"City\": \"bangalore\"\n\t},\n\t\"Name\": \"John\"\n}\n"
+	if code != 0 || string(out) != want {
+		t.Errorf("godb get exited %d and printed %q, want %q", code, out, want)
+	}
+}
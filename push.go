@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	pushDir = "_push"
+
+	// pushManifest is the object naming every record of a pushed
+	// database and the object holding its content.
+	pushManifest = "manifest.json"
+
+	defaultPushConcurrency = 4
+)
+
+type (
+	// ObjectStore is a remote that Push uploads to. Put must replace key
+	// atomically: a reader sees either the old object or all of the new
+	// one.
+	ObjectStore interface {
+		Put(ctx context.Context, key string, b []byte) error
+	}
+
+	// DirObjectStore is an ObjectStore keeping objects as files under a
+	// directory, e.g. a mounted bucket or a backup disk.
+	DirObjectStore string
+
+	PushOptions struct {
+		// Concurrency bounds the uploads in flight, 4 by default.
+		Concurrency int
+		// DryRun computes the delta without uploading anything.
+		DryRun bool
+	}
+
+	PushReport struct {
+		Records   int
+		Unchanged int
+		Removed   int
+		// Uploaded and Bytes count the objects the push uploads, or with
+		// DryRun would upload.
+		Uploaded int
+		Bytes    int64
+	}
+
+	// pushState is what the last successful push of a remote left there:
+	// the content hash of every record, by its path in the database.
+	pushState struct {
+		Records map[string]string
+	}
+)
+
+// Push uploads the database to store, recording it under name, and
+// uploads only what changed since the last successful push there. Records
+// are compared by the SHA-256 of their stored bytes, kept locally in
+// _push/<name>.json, never by modification times, so a restore that only
+// touches timestamps uploads nothing. Each distinct content is uploaded
+// once, as objects/<hash>. The remote manifest naming every record's
+// object is written last, so an interrupted push leaves the remote
+// claiming only records it holds; objects it no longer names are left in
+// place. Records are read one collection at a time under its lock.
+func (d *Driver) Push(ctx context.Context, name string, store ObjectStore, opts PushOptions) (_ PushReport, err error) {
+	op := d.trace(ctx, "Push", "", name)
+	defer func() { op.end(err) }()
+
+	if name == "" {
+		return PushReport{}, fmt.Errorf("remote name is required")
+	}
+	if !opts.DryRun {
+		if err := d.checkWritable(); err != nil {
+			return PushReport{}, err
+		}
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultPushConcurrency
+	}
+
+	last, err := d.readPushState(name)
+	if err != nil {
+		return PushReport{}, err
+	}
+	uploaded := make(map[string]bool, len(last.Records))
+	for _, sum := range last.Records {
+		uploaded[sum] = true
+	}
+
+	records, err := d.hashRecords()
+	if err != nil {
+		return PushReport{}, err
+	}
+
+	report := PushReport{Records: len(records)}
+	var delta []string
+	for path, sum := range records {
+		if last.Records[path] == sum {
+			report.Unchanged++
+			continue
+		}
+		if !uploaded[sum] {
+			uploaded[sum] = true
+			delta = append(delta, path)
+		}
+	}
+	for path := range last.Records {
+		if _, ok := records[path]; !ok {
+			report.Removed++
+		}
+	}
+	sort.Strings(delta)
+
+	if opts.DryRun {
+		for _, path := range delta {
+			if fi, err := d.statFile(filepath.Join(d.dir, path)); err == nil {
+				report.Uploaded++
+				report.Bytes += fi.Size()
+			}
+		}
+		return report, nil
+	}
+
+	if err := d.upload(ctx, store, delta, records, opts.Concurrency, &report); err != nil {
+		return report, err
+	}
+	op.bytes = report.Bytes
+
+	manifest, err := json.Marshal(pushState{Records: records})
+	if err != nil {
+		return report, err
+	}
+	if err := store.Put(ctx, pushManifest, manifest); err != nil {
+		return report, err
+	}
+	if err := d.writePushState(name, manifest); err != nil {
+		return report, err
+	}
+	op.log.Info("Pushed %s to %s: %d uploaded, %d unchanged, %d removed", d.dir, name, report.Uploaded, report.Unchanged, report.Removed)
+	return report, nil
+}
+
+// hashRecords returns the content hash of every record, by its path in
+// the database.
+func (d *Driver) hashRecords() (map[string]string, error) {
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+		collection := entry.Name()
+		err := func() error {
+			defer d.lockCollections(collection)()
+			files, err := d.listRecords(collection)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				path := filepath.Join(collection, file)
+				b, err := d.readStored(filepath.Join(d.dir, path))
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				records[filepath.ToSlash(path)] = contentHash(b)
+			}
+			return nil
+		}()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// listRecords returns the record file names of collection.
+func (d *Driver) listRecords(collection string) ([]string, error) {
+	files, err := d.readDir(filepath.Join(d.dir, collection))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && d.isRecord(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+// upload puts the content of each record in paths to store, at most
+// concurrency at a time, stopping at the first failure. A record that
+// changed since it was hashed is uploaded as it is now, and records takes
+// its new hash.
+func (d *Driver) upload(ctx context.Context, store ObjectStore, paths []string, records map[string]string, concurrency int, report *PushReport) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mutex    sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mutex.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mutex.Unlock()
+		cancel()
+	}
+
+	slots := make(chan struct{}, concurrency)
+	for _, path := range paths {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(path string) {
+			defer func() { <-slots; wg.Done() }()
+
+			b, err := d.readStored(filepath.Join(d.dir, filepath.FromSlash(path)))
+			if os.IsNotExist(err) {
+				mutex.Lock()
+				delete(records, path)
+				mutex.Unlock()
+				return
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			sum := contentHash(b)
+			if err := store.Put(ctx, "objects/"+sum, b); err != nil {
+				fail(fmt.Errorf("%s: %w", path, err))
+				return
+			}
+
+			mutex.Lock()
+			records[path] = sum
+			report.Uploaded++
+			report.Bytes += int64(len(b))
+			mutex.Unlock()
+		}(path)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *Driver) pushStatePath(name string) string {
+	return filepath.Join(d.dir, pushDir, d.fileKey(name)+".json")
+}
+
+func (d *Driver) readPushState(name string) (pushState, error) {
+	state := pushState{Records: make(map[string]string)}
+	b, err := ioutil.ReadFile(d.pushStatePath(name))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, fmt.Errorf("invalid push state %s: %w", d.pushStatePath(name), err)
+	}
+	if state.Records == nil {
+		state.Records = make(map[string]string)
+	}
+	return state, nil
+}
+
+func (d *Driver) writePushState(name string, b []byte) error {
+	if err := os.MkdirAll(filepath.Join(d.dir, pushDir), 0755); err != nil {
+		return err
+	}
+	path := d.pushStatePath(name)
+	if err := d.writeFile(path+".tmp", b, true); err != nil {
+		return err
+	}
+	if err := d.syncFile(path + ".tmp"); err != nil {
+		return err
+	}
+	return d.rename(path+".tmp", path)
+}
+
+// Put writes the object to a temp file and renames it into place.
+func (s DirObjectStore) Put(ctx context.Context, key string, b []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path := filepath.Join(string(s), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
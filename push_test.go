@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Push uploads by content: a restore that only moves timestamps uploads
+// nothing, and one changed byte uploads exactly one object.
+func TestPushByContent(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	remote := t.TempDir()
+	store := DirObjectStore(remote)
+	ctx := context.Background()
+
+	report, err := d.Push(ctx, "backup", store, PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Uploaded != len(testUsers) {
+		t.Fatalf("first push uploaded %d objects, want %d", report.Uploaded, len(testUsers))
+	}
+
+	// A restore from backup rewrites every file with its old content.
+	later := time.Now().Add(time.Hour)
+	for _, u := range testUsers {
+		path := d.recordPath("user", u.Name)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, later, later)
+	}
+	if report, err = d.Push(ctx, "backup", store, PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Uploaded != 0 || report.Unchanged != len(testUsers) {
+		t.Fatalf("push after a restore = %+v, want nothing uploaded", report)
+	}
+
+	path := d.recordPath("user", "Neo")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-3] ^= 1
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dry, err := d.Push(ctx, "backup", store, PushOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.Uploaded != 1 || dry.Bytes != int64(len(b)) {
+		t.Errorf("dry run = %+v, want one object of %d bytes", dry, len(b))
+	}
+	objects, _ := ioutil.ReadDir(filepath.Join(remote, "objects"))
+	if len(objects) != len(testUsers) {
+		t.Errorf("dry run left %d objects, want %d", len(objects), len(testUsers))
+	}
+
+	if report, err = d.Push(ctx, "backup", store, PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Uploaded != 1 {
+		t.Errorf("push after one flipped byte uploaded %d objects, want 1", report.Uploaded)
+	}
+
+	var manifest pushState
+	mb, err := ioutil.ReadFile(filepath.Join(remote, pushManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(mb, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Records) != len(testUsers) {
+		t.Errorf("remote manifest names %d records, want %d", len(manifest.Records), len(testUsers))
+	}
+	for record, sum := range manifest.Records {
+		if _, err := os.Stat(filepath.Join(remote, "objects", sum)); err != nil {
+			t.Errorf("remote manifest names %s, missing its object: %s", record, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jcelliott/lumber"
+)
+
+// runPush implements `godb push NAME TARGET`, pushing the database to the
+// directory TARGET and recording it as the remote NAME.
+func runPush(args []string) int {
+	flags := flag.NewFlagSet("push", flag.ContinueOnError)
+	dir := flags.String("dir", "./db", "database directory")
+	dryRun := flags.Bool("dry-run", false, "report what would be uploaded without uploading")
+	concurrency := flags.Int("concurrency", defaultPushConcurrency, "uploads in flight")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: godb push [-dir DIR] [-dry-run] [-concurrency N] NAME TARGET")
+		return 2
+	}
+
+	db, err := New(*dir, &Options{Logger: lumber.NewConsoleLogger(lumber.WARN)})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	store := DirObjectStore(flags.Arg(1))
+	report, err := db.Push(context.Background(), flags.Arg(0), store, PushOptions{Concurrency: *concurrency, DryRun: *dryRun})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	verb := "uploaded"
+	if *dryRun {
+		verb = "to upload"
+	}
+	fmt.Printf("%d records: %d objects %s (%d bytes), %d unchanged, %d removed\n", report.Records, report.Uploaded, verb, report.Bytes, report.Unchanged, report.Removed)
+	return 0
+}
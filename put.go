@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// WriteOption adjusts a single Put or Del call.
+	WriteOption func(*callOptions) error
+	// ReadOption adjusts a single Get call.
+	ReadOption func(*callOptions) error
+
+	callOptions struct {
+		method       string
+		given        map[string]bool
+		ttl          time.Duration
+		sync         bool
+		dryRun       bool
+		ifMatch      *string
+		ifAbsent     bool
+		precondition func(current json.RawMessage) (bool, error)
+		consistency  *Consistency
+		etag         *string
+		mask         *string
+	}
+)
+
+// expiresExt names the sidecar holding the expiry time of a record written
+// with WithTTL.
+const expiresExt = ".expires"
+
+func (c *callOptions) set(name string, methods ...string) error {
+	if c.given[name] {
+		return fmt.Errorf("%s given more than once", name)
+	}
+	c.given[name] = true
+	for _, method := range methods {
+		if method == c.method {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not apply to %s", name, c.method)
+}
+
+// WithTTL makes the record read as missing once d has passed. A later Put
+// without WithTTL clears it.
+func WithTTL(d time.Duration) WriteOption {
+	return func(c *callOptions) error {
+		if d <= 0 {
+			return fmt.Errorf("WithTTL needs a positive duration, got %s", d)
+		}
+		c.ttl = d
+		return c.set("WithTTL", "Put")
+	}
+}
+
+// WithSync fsyncs the record and its directory before returning.
+func WithSync() WriteOption {
+	return func(c *callOptions) error {
+		c.sync = true
+		return c.set("WithSync", "Put", "Del")
+	}
+}
+
+// WithDryRun runs every check of the call, including reference
+// constraints, without changing anything.
+func WithDryRun() WriteOption {
+	return func(c *callOptions) error {
+		c.dryRun = true
+		return c.set("WithDryRun", "Put", "Del")
+	}
+}
+
+// WithIfMatch only applies the call if the record exists and its ETag, as
+// reported by Get's WithETag, is etag. Otherwise it fails with
+// ErrPreconditionFailed.
+func WithIfMatch(etag string) WriteOption {
+	return func(c *callOptions) error {
+		c.ifMatch = &etag
+		return c.set("WithIfMatch", "Put", "Del")
+	}
+}
+
+// WithIfAbsent only writes the record if it does not exist yet.
+func WithIfAbsent() WriteOption {
+	return func(c *callOptions) error {
+		c.ifAbsent = true
+		return c.set("WithIfAbsent", "Put")
+	}
+}
+
+// WithPrecondition only applies the call if fn accepts the current
+// contents of the record, which are nil when it does not exist.
+func WithPrecondition(fn func(current json.RawMessage) (bool, error)) WriteOption {
+	return func(c *callOptions) error {
+		if fn == nil {
+			return fmt.Errorf("WithPrecondition needs a function")
+		}
+		c.precondition = fn
+		return c.set("WithPrecondition", "Put", "Del")
+	}
+}
+
+// WithReadConsistency overrides the consistency level carried by the
+// context.
+func WithReadConsistency(level Consistency) ReadOption {
+	return func(c *callOptions) error {
+		c.consistency = &level
+		return c.set("WithReadConsistency", "Get")
+	}
+}
+
+// WithETag stores the ETag of the record read in *etag, for use with
+// WithIfMatch.
+func WithETag(etag *string) ReadOption {
+	return func(c *callOptions) error {
+		if etag == nil {
+			return fmt.Errorf("WithETag needs a destination")
+		}
+		c.etag = etag
+		return c.set("WithETag", "Get")
+	}
+}
+
+func writeOptions(method string, opts []WriteOption) (callOptions, error) {
+	c := callOptions{method: method, given: make(map[string]bool)}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return c, err
+		}
+	}
+	switch {
+	case c.ifMatch != nil && c.ifAbsent:
+		return c, fmt.Errorf("WithIfMatch and WithIfAbsent conflict")
+	case c.dryRun && c.sync:
+		return c, fmt.Errorf("WithDryRun and WithSync conflict")
+	case c.dryRun && c.ttl > 0:
+		return c, fmt.Errorf("WithDryRun and WithTTL conflict")
+	}
+	return c, nil
+}
+
+func readOptions(opts []ReadOption) (callOptions, error) {
+	c := callOptions{method: "Get", given: make(map[string]bool)}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// Put is the canonical write; Write is Put without options.
+func (d *Driver) Put(ctx context.Context, collection, resource string, v interface{}, opts ...WriteOption) error {
+	return d.put(ctx, "Write", collection, resource, v, opts)
+}
+
+func (d *Driver) put(ctx context.Context, name, collection, resource string, v interface{}, opts []WriteOption) (err error) {
+	op := d.trace(ctx, name, collection, resource)
+	defer func() { op.end(err) }()
+	defer func() { err = wrapOp("write", collection, resource, err) }()
+
+	if op.call, err = writeOptions("Put", opts); err != nil {
+		return err
+	}
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+	if err := validateName(collection, resource); err != nil {
+		return err
+	}
+	if err := d.checkEncodable(v); err != nil {
+		return err
+	}
+	if err := d.writeLimit.take(ctx, op.log, collection); err != nil {
+		return err
+	}
+	if op.call.sync && d.opts.GroupCommit > 0 && d.stageable(collection) {
+		return d.putStaged(op, collection, resource, v)
+	}
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+	if err := d.checkPreconditions(op, collection, resource); err != nil {
+		return err
+	}
+
+	if err := d.write(op, collection, resource, v); err != nil || op.call.dryRun {
+		return err
+	}
+	return d.setExpiry(op, collection, resource)
+}
+
+// Get is the canonical read; ReadCtx is Get without options.
+func (d *Driver) Get(ctx context.Context, collection, resource string, v interface{}, opts ...ReadOption) (err error) {
+	op := d.trace(ctx, "Read", collection, resource)
+	defer func() { op.end(err) }()
+	defer func() { err = wrapOp("read", collection, resource, err) }()
+
+	if op.call, err = readOptions(opts); err != nil {
+		return err
+	}
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+
+	if err := d.readLimit.take(ctx, op.log, collection); err != nil {
+		return err
+	}
+
+	if err := d.verifyReplica(op, collection, resource); err != nil {
+		return err
+	}
+
+	level := consistencyOf(ctx)
+	if op.call.consistency != nil {
+		level = *op.call.consistency
+	}
+	b, err := d.readConsistent(op, level, collection, resource)
+	if err != nil {
+		return err
+	}
+	if err := d.checkExpiry(collection, resource); err != nil {
+		return err
+	}
+	op.bytes = int64(len(b))
+	d.access.touch(collection, resource)
+
+	if op.call.etag != nil {
+		*op.call.etag = etagOf(b)
+	}
+
+	profile := maskProfileOf(ctx)
+	if op.call.mask != nil {
+		profile = *op.call.mask
+	}
+	if b, err = d.maskRecord(b, d.maskPaths(collection, profile)); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Del is the canonical delete; Delete is Del without options.
+func (d *Driver) Del(ctx context.Context, collection, resource string, opts ...WriteOption) (err error) {
+	op := d.trace(ctx, "Delete", collection, resource)
+	defer func() { op.end(err) }()
+	defer func() { err = wrapOp("delete", collection, resource, err) }()
+
+	if op.call, err = writeOptions("Del", opts); err != nil {
+		return err
+	}
+	if err := d.writeLimit.take(ctx, op.log, collection); err != nil {
+		return err
+	}
+
+	path := filepath.Join(d.dir, collection, d.fileKey(resource))
+	scope := d.deleteScope(collection)
+	defer d.lockCollections(scope...)()
+	defer d.listings.invalidate(collection)
+
+	if err := d.checkWritable(scope...); err != nil {
+		return err
+	}
+
+	switch fi, err := d.stat(path); {
+	case err != nil:
+		return err
+	case fi.Mode().IsDir():
+		if op.call.dryRun {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if err := d.mirror(op.log, path); err != nil {
+			return err
+		}
+		d.references.reset(collection)
+		d.invalidateIndexes(collection)
+		d.packs.forget(filepath.Join(collection, d.fileKey(resource)))
+	case fi.Mode().IsRegular():
+		if err := d.checkPreconditions(op, collection, resource); err != nil {
+			return err
+		}
+		if err := d.delete(op, collection, resource); err != nil || !op.call.sync {
+			return err
+		}
+		return d.syncDir(filepath.Join(d.dir, collection))
+	default:
+		return nil
+	}
+	d.events.emit(EventDelete, collection, resource)
+	return nil
+}
+
+// checkPreconditions evaluates WithIfMatch, WithIfAbsent and
+// WithPrecondition against the current record. An expired record counts as
+// missing.
+func (d *Driver) checkPreconditions(op *operation, collection, resource string) error {
+	call := op.call
+	if call.ifMatch == nil && !call.ifAbsent && call.precondition == nil {
+		return nil
+	}
+
+	var current json.RawMessage
+	b, err := d.readRecord(op.log, d.recordPath(collection, resource))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if current, err = d.transform(collection, b); err != nil {
+			return err
+		}
+		if d.checkExpiry(collection, resource) != nil {
+			current = nil
+		}
+	}
+
+	failed := fmt.Errorf("%w: %s/%s", ErrPreconditionFailed, collection, resource)
+	if call.ifAbsent && current != nil {
+		return failed
+	}
+	if call.ifMatch != nil && (current == nil || etagOf(current) != *call.ifMatch) {
+		return failed
+	}
+	if call.precondition != nil {
+		ok, err := call.precondition(current)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return failed
+		}
+	}
+	return nil
+}
+
+func etagOf(b []byte) string {
+	sum := sha256.Sum256(trimNewline(b))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func trimNewline(b []byte) []byte {
+	if n := len(b); n > 0 && b[n-1] == '\n' {
+		return b[:n-1]
+	}
+	return b
+}
+
+func (d *Driver) expiryPath(collection, resource string) string {
+	return filepath.Join(d.dir, collection, d.fileKey(resource)+expiresExt)
+}
+
+// setExpiry records the WithTTL of a write, or clears a previous one.
+func (d *Driver) setExpiry(op *operation, collection, resource string) error {
+	path := d.expiryPath(collection, resource)
+	if op.call.ttl <= 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return d.mirror(op.log, path)
+	}
+	at := time.Now().Add(op.call.ttl).UTC().Format(time.RFC3339Nano)
+	if err := d.writeFile(path+".tmp", []byte(at), true); err != nil {
+		return err
+	}
+	if err := d.rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return d.mirror(op.log, path)
+}
+
+// expiry returns when a record written with WithTTL expires, or the zero
+// time if it does not.
+func (d *Driver) expiry(collection, resource string) (time.Time, error) {
+	b, err := ioutil.ReadFile(d.expiryPath(collection, resource))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, string(b))
+}
+
+// unexpired filters a directory listing down to its record files whose
+// TTL has not passed, reading only the expiry sidecars the listing holds.
+// It also reports whether any record listed has a TTL.
+func (d *Driver) unexpired(dir string, files []os.FileInfo) ([]os.FileInfo, bool) {
+	expires := make(map[string]bool)
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), expiresExt) {
+			expires[strings.TrimSuffix(file.Name(), expiresExt)] = true
+		}
+	}
+
+	now := time.Now()
+	live := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !d.isRecord(file.Name()) {
+			continue
+		}
+		if stem := strings.TrimSuffix(file.Name(), d.ext()); expires[stem] {
+			b, err := ioutil.ReadFile(filepath.Join(dir, stem+expiresExt))
+			if at, perr := time.Parse(time.RFC3339Nano, string(b)); err == nil && perr == nil && !now.Before(at) {
+				continue
+			}
+		}
+		live = append(live, file)
+	}
+	return live, len(expires) > 0
+}
+
+// checkExpiry returns a not-exist error for an expired record.
+func (d *Driver) checkExpiry(collection, resource string) error {
+	at, err := d.expiry(collection, resource)
+	if err != nil {
+		return err
+	}
+	if !at.IsZero() && !time.Now().Before(at) {
+		return &os.PathError{Op: "open", Path: d.recordPath(collection, resource), Err: os.ErrNotExist}
+	}
+	return nil
+}
+
+func (d *Driver) syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.io.syncs, 1)
+	return nil
+}
+
+func (d *Driver) syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.io.syncs, 1)
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteOptionsConflict(t *testing.T) {
+	d := openTest(t, nil)
+	ctx := context.Background()
+
+	conflicts := [][]WriteOption{
+		{WithIfMatch("x"), WithIfAbsent()},
+		{WithDryRun(), WithSync()},
+		{WithDryRun(), WithTTL(time.Hour)},
+	}
+	for _, opts := range conflicts {
+		if err := d.Put(ctx, "user", "John", testUsers[0], opts...); err == nil || !strings.Contains(err.Error(), "conflict") {
+			t.Fatalf("expected a conflict, got %v", err)
+		}
+	}
+	if _, err := os.Stat(d.recordPath("user", "John")); !os.IsNotExist(err) {
+		t.Fatal("a conflicting write was applied")
+	}
+}
+
+func TestPutOptions(t *testing.T) {
+	d := openTest(t, nil)
+	ctx := context.Background()
+
+	if err := d.Put(ctx, "user", "John", testUsers[0], WithDryRun()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.recordPath("user", "John")); !os.IsNotExist(err) {
+		t.Fatal("a dry run wrote the record")
+	}
+
+	if err := d.Put(ctx, "user", "John", testUsers[0], WithIfAbsent()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, "user", "John", testUsers[0], WithIfAbsent()); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed, got %v", err)
+	}
+
+	var etag string
+	if err := d.Get(ctx, "user", "John", &User{}, WithETag(&etag)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, "user", "John", testUsers[1], WithIfMatch(etag)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, "user", "John", testUsers[2], WithIfMatch(etag)); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected a stale etag to fail, got %v", err)
+	}
+}
+
+// Every listing and iteration path hides records whose TTL has passed,
+// not only Get.
+func TestExpiredRecordsHidden(t *testing.T) {
+	d := openTest(t, &Options{ListingCacheBytes: 1 << 20})
+	ctx := context.Background()
+	seedUsers(t, d)
+	if err := d.Put(ctx, "user", "John", testUsers[0], WithTTL(20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.FindBy("user", "Name", "John"); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := d.ReadAll("user"); len(records) != len(testUsers) {
+		t.Fatalf("before expiry ReadAll returned %d records", len(records))
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	live := len(testUsers) - 1
+	if records, err := d.ReadAll("user"); err != nil || len(records) != live {
+		t.Fatalf("ReadAll returned %d records (%v)", len(records), err)
+	}
+	if n, err := d.Count("user"); err != nil || n != live {
+		t.Fatalf("Count is %d (%v)", n, err)
+	}
+	if keys, _ := d.Keys("user"); len(keys) != live {
+		t.Fatalf("Keys returned %v", keys)
+	}
+	var users []User
+	if err := d.ReadAllInto("user", &users); err != nil || len(users) != live {
+		t.Fatalf("ReadAllInto returned %d records (%v)", len(users), err)
+	}
+	if found, _ := d.Find("user").Where("Name", "==", "John").Raw(); len(found) != 0 {
+		t.Fatalf("Find returned %d records", len(found))
+	}
+	if found, _ := d.FindBy("user", "Name", "John"); len(found) != 0 {
+		t.Fatalf("FindBy returned %v", found)
+	}
+	for resource := range d.All("user") {
+		if resource == "John" {
+			t.Fatal("All yielded an expired record")
+		}
+	}
+	var buf bytes.Buffer
+	if err := d.ExportNDJSON("user", &buf); err != nil || bytes.Count(buf.Bytes(), []byte("\n")) != live {
+		t.Fatalf("ExportNDJSON wrote %q (%v)", buf.String(), err)
+	}
+	if keys, _ := d.KeysModifiedSince("user", time.Time{}); len(keys) != live {
+		t.Fatalf("KeysModifiedSince returned %v", keys)
+	}
+
+	var u User
+	if err := d.Pop("user", "John", &u); !os.IsNotExist(err) {
+		t.Fatalf("Pop of an expired record: %v", err)
+	}
+	err := d.Modify("user", "John", func(current []byte) ([]byte, error) { return current, nil })
+	if !os.IsNotExist(err) {
+		t.Fatalf("Modify of an expired record: %v", err)
+	}
+	if resource, err := d.PopAny("user", &u); err != nil || resource == "John" {
+		t.Fatalf("PopAny returned %s (%v)", resource, err)
+	}
+	if u.Name == "John" {
+		t.Fatalf("PopAny popped %+v", u)
+	}
+}
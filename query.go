@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type (
+	QueryBuilder struct {
+		d          *Driver
+		collection string
+		filters    []filter
+		joins      []join
+		order      []ordering
+		limit      int
+		offset     int
+		unwind     *string
+		mask       [][]string
+		unparsed   []TimeError
+	}
+	filter   func(q *QueryBuilder, resource string, doc map[string]interface{}) bool
+	ordering struct {
+		field string
+		asc   bool
+	}
+	match struct {
+		resource string
+		doc      map[string]interface{}
+		b        json.RawMessage
+	}
+)
+
+func (d *Driver) Find(collection string) *QueryBuilder {
+	return &QueryBuilder{d: d, collection: collection, limit: -1}
+}
+
+// FindWhere returns the records whose fields equal every given value. Field
+// names may be dotted paths.
+func (d *Driver) FindWhere(collection string, conditions map[string]interface{}) ([]json.RawMessage, error) {
+	fields := make([]string, 0, len(conditions))
+	for field := range conditions {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	q := d.Find(collection)
+	for _, field := range fields {
+		q.whereEqual(field, conditions[field])
+	}
+	return q.Raw()
+}
+
+func (q *QueryBuilder) whereEqual(field string, value interface{}) *QueryBuilder {
+	want, err := generic(value)
+	q.filters = append(q.filters, func(q *QueryBuilder, resource string, doc map[string]interface{}) bool {
+		if err != nil {
+			return false
+		}
+		got, ok := lookup(doc, field)
+		if !ok {
+			return false
+		}
+		got, _ = generic(got)
+		return reflect.DeepEqual(got, want)
+	})
+	return q
+}
+
+// Where keeps the records whose field compares to value with op, one of
+// =, !=, <, <=, >, >= and contains. contains matches a substring of a
+// string field or an element of an array field. Records missing the field
+// never match.
+func (q *QueryBuilder) Where(field, op string, value interface{}) *QueryBuilder {
+	if op == "=" || op == "==" {
+		return q.whereEqual(field, value)
+	}
+	want, err := generic(value)
+	q.filters = append(q.filters, func(q *QueryBuilder, resource string, doc map[string]interface{}) bool {
+		if err != nil {
+			return false
+		}
+		got, ok := lookup(doc, field)
+		if !ok {
+			return false
+		}
+		got, _ = generic(got)
+
+		switch op {
+		case "contains":
+			switch g := got.(type) {
+			case string:
+				s, ok := want.(string)
+				return ok && strings.Contains(g, s)
+			case []interface{}:
+				for _, e := range g {
+					if reflect.DeepEqual(e, want) {
+						return true
+					}
+				}
+			}
+			return false
+		case "!=":
+			return !reflect.DeepEqual(got, want)
+		}
+		return compareJSON(got, op, want)
+	})
+	return q
+}
+
+// OrderBy sorts the results by field. Later calls break ties of earlier
+// ones. Records missing the field sort first in ascending order.
+func (q *QueryBuilder) OrderBy(field string, asc bool) *QueryBuilder {
+	q.order = append(q.order, ordering{field, asc})
+	return q
+}
+
+// Limit caps the number of results; a negative n removes the cap.
+func (q *QueryBuilder) Limit(n int) *QueryBuilder {
+	q.limit = n
+	return q
+}
+
+// Offset skips the first n results, after ordering.
+func (q *QueryBuilder) Offset(n int) *QueryBuilder {
+	q.offset = n
+	return q
+}
+
+// Unwind makes the query treat each element of the array at path, or of
+// the record itself when path is empty, as a record of its own: filters,
+// joins, ordering and limits apply to the elements, and the matching
+// elements are returned. Elements that are not objects are skipped, as are
+// records without an array at path. Indexes, FindBy, KeyFields,
+// VersionField and UpdatedAtField only work with object records.
+func (q *QueryBuilder) Unwind(path string) *QueryBuilder {
+	q.unwind = &path
+	return q
+}
+
+// Mask applies the mask profile of that name, see Options.Masks, to the
+// records before they are filtered, so masked fields can neither be
+// returned nor matched on.
+func (q *QueryBuilder) Mask(profile string) *QueryBuilder {
+	q.mask = q.d.maskPaths(q.collection, profile)
+	return q
+}
+
+// Collect runs the query and decodes the results into dest, which must
+// point to a slice.
+func (q *QueryBuilder) Collect(dest interface{}) error {
+	records, err := q.Raw()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dest)
+}
+
+// generic converts v to the form json.Unmarshal produces for an
+// interface{}, so values from Go code and from decoded documents compare
+// equal when their JSON encodings match.
+func generic(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var g interface{}
+	err = json.Unmarshal(b, &g)
+	return g, err
+}
+
+func (q *QueryBuilder) Raw() (_ []json.RawMessage, err error) {
+	op := q.d.trace(nil, "Find", q.collection, "")
+	defer func() { op.end(err) }()
+
+	matches, err := q.run(op)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]json.RawMessage, len(matches))
+	for i, m := range matches {
+		records[i] = m.b
+	}
+	return records, nil
+}
+
+// run returns the matches of the query in order, with their resources.
+func (q *QueryBuilder) run(op *operation) ([]match, error) {
+	if q.collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+	q.unparsed = nil
+
+	dir := filepath.Join(q.d.dir, q.collection)
+
+	files, err := q.d.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, _ = q.d.unexpired(dir, files)
+
+	cache := make(joinCache)
+
+	var matches []match
+	for _, file := range files {
+		resource := q.d.resourceOf(file.Name())
+
+		b, err := q.d.readRecord(op.log, filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		op.bytes += int64(len(b))
+		q.d.readRepair(op.log, q.collection, resource, b)
+		if b, err = q.d.transform(q.collection, b); err != nil {
+			return nil, err
+		}
+
+		var docs []map[string]interface{}
+		if q.unwind != nil {
+			docs, err = unwind(b, *q.unwind)
+		} else {
+			var doc map[string]interface{}
+			doc, err = decodeDocument(b)
+			docs = append(docs, doc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %s", q.collection, resource, err)
+		}
+
+		for _, doc := range docs {
+			q.d.maskDocument(doc, q.mask)
+			if !q.match(resource, doc) {
+				continue
+			}
+
+			if len(q.joins) == 0 && q.unwind == nil && len(q.mask) == 0 {
+				matches = append(matches, match{resource, doc, json.RawMessage(bytes.TrimSpace(b))})
+				continue
+			}
+			if err := q.d.join(op.log, doc, q.joins, cache); err != nil {
+				return nil, fmt.Errorf("%s/%s: %s", q.collection, resource, err)
+			}
+			b, err := json.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, match{resource, doc, b})
+		}
+	}
+
+	q.sort(matches)
+	if offset := q.offset; offset > 0 {
+		if offset > len(matches) {
+			offset = len(matches)
+		}
+		matches = matches[offset:]
+	}
+	if q.limit >= 0 && q.limit < len(matches) {
+		matches = matches[:q.limit]
+	}
+
+	if len(q.unparsed) > 0 {
+		op.log.Warn("%d records in %s have unparseable time fields, e.g. %s", len(q.unparsed), q.collection, q.unparsed[0])
+	}
+
+	return matches, nil
+}
+
+func (q *QueryBuilder) sort(matches []match) {
+	if len(q.order) == 0 {
+		return
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, o := range q.order {
+			a, aok := lookup(matches[i].doc, o.field)
+			b, bok := lookup(matches[j].doc, o.field)
+			c := compareOrdered(a, aok, b, bok)
+			if c == 0 {
+				continue
+			}
+			if o.asc {
+				return c < 0
+			}
+			return c > 0
+		}
+		return false
+	})
+}
+
+// compareOrdered orders missing fields first, then null, booleans, numbers
+// and strings, comparing values of the same kind naturally. Other kinds
+// compare equal.
+func compareOrdered(a interface{}, aok bool, b interface{}, bok bool) int {
+	rank := func(v interface{}, ok bool) int {
+		if !ok {
+			return 0
+		}
+		switch v.(type) {
+		case nil:
+			return 1
+		case bool:
+			return 2
+		case json.Number, float64:
+			return 3
+		case string:
+			return 4
+		}
+		return 5
+	}
+	ra, rb := rank(a, aok), rank(b, bok)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case json.Number, float64:
+		f, _ := generic(a)
+		g, _ := generic(b)
+		switch x, y := f.(float64), g.(float64); {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+	return 0
+}
+
+func (q *QueryBuilder) match(resource string, doc map[string]interface{}) bool {
+	for _, f := range q.filters {
+		if !f(q, resource, doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeDocument(b []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// unwind returns the object elements of the array at path in the document
+// b, which may itself be the array when path is empty.
+func unwind(b []byte, path string) ([]map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if path != "" {
+		doc, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		v, _ = lookup(doc, path)
+	}
+
+	elements, _ := v.([]interface{})
+	docs := make([]map[string]interface{}, 0, len(elements))
+	for _, element := range elements {
+		if doc, ok := element.(map[string]interface{}); ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// assign sets a dotted field path, creating intermediate objects as needed.
+func assign(doc map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			doc[part] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = value
+}
+
+// lookup resolves a dotted field path such as "Address.City".
+func lookup(doc map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
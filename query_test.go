@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFindWhere(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	records, err := d.FindWhere("user", map[string]interface{}{"Address.Country": "india"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range records {
+		var u User
+		if err := json.Unmarshal(b, &u); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, u.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"Albert", "John", "Neo", "Robert", "Vince"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("users in india: %v, want %v", names, want)
+	}
+
+	records, err = d.FindWhere("user", map[string]interface{}{"Address.Country": "india", "Company": "Microsoft"})
+	if err != nil || len(records) != 1 {
+		t.Errorf("FindWhere on two fields returned %d records, %v, want 1", len(records), err)
+	}
+}
+
+// Where clauses combine with ordering, an offset and a limit.
+func TestFindOrderLimit(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	names := func(q *QueryBuilder) []string {
+		t.Helper()
+		var users []User
+		if err := q.Collect(&users); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+		return names
+	}
+
+	for _, c := range []struct {
+		q    *QueryBuilder
+		want []string
+	}{
+		{d.Find("user").Where("Address.Country", "=", "india").Where("Age", ">", 24).OrderBy("Age", false).Limit(2), []string{"Albert", "Neo"}},
+		{d.Find("user").Where("Address.Country", "=", "india").OrderBy("Age", false).Offset(1).Limit(2), []string{"Neo", "Vince"}},
+		{d.Find("user").Where("Company", "!=", "Google").Where("Age", "<", 28).OrderBy("Name", true), []string{"John", "Robert"}},
+		{d.Find("user").Where("Company", "contains", "soft").Limit(5), []string{"Robert"}},
+	} {
+		if got := names(c.q); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("query returned %v, want %v", got, c.want)
+		}
+	}
+}
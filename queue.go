@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Pop reads a record into v and deletes it in one step, so concurrent
+// callers never both receive the same record.
+func (d *Driver) Pop(collection, resource string, v interface{}) (err error) {
+	op := d.trace(nil, "Pop", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+	if err := d.readLimit.take(d.ctx, op.log, collection); err != nil {
+		return err
+	}
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return err
+	}
+	scope := d.deleteScope(collection)
+	defer d.lockCollections(scope...)()
+
+	if err := d.checkWritable(scope...); err != nil {
+		return err
+	}
+
+	return d.pop(op, collection, resource, v)
+}
+
+func (d *Driver) pop(op *operation, collection, resource string, v interface{}) error {
+	err := d.checkExpiry(collection, resource)
+	var b []byte
+	if err == nil {
+		b, err = d.readRecord(op.log, d.recordPath(collection, resource))
+	}
+	if err != nil {
+		return err
+	}
+	op.bytes = int64(len(b))
+
+	if b, err = d.transform(collection, b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+
+	return d.delete(op, collection, resource)
+}
+
+// PopAny pops the lexicographically first record in the collection and
+// returns its resource name, letting a collection act as a work queue. On
+// an empty collection it fails like Pop of a missing record.
+func (d *Driver) PopAny(collection string, v interface{}) (_ string, err error) {
+	op := d.trace(nil, "PopAny", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return "", fmt.Errorf("collection is required")
+	}
+	if err := d.readLimit.take(d.ctx, op.log, collection); err != nil {
+		return "", err
+	}
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return "", err
+	}
+	scope := d.deleteScope(collection)
+	defer d.lockCollections(scope...)()
+
+	if err := d.checkWritable(scope...); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(d.dir, collection)
+	files, err := d.readDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	files, _ = d.unexpired(dir, files)
+
+	for _, file := range files {
+		resource := d.resourceOf(file.Name())
+		op.ev.Resource = resource
+		return resource, d.pop(op, collection, resource, v)
+	}
+
+	return "", &os.PathError{Op: "pop", Path: dir, Err: os.ErrNotExist}
+}
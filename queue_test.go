@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Of many goroutines popping the same record exactly one gets it; the rest
+// find it gone.
+func TestPopConcurrent(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	var won int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var u User
+			switch err := d.Pop("user", "John", &u); {
+			case err == nil:
+				atomic.AddInt32(&won, 1)
+				if u != testUsers[0] {
+					t.Errorf("popped %+v, want %+v", u, testUsers[0])
+				}
+			case !errors.Is(err, ErrNotFound):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if won != 1 {
+		t.Errorf("%d goroutines popped the record, want 1", won)
+	}
+	var u User
+	if err := d.Read("user", "John", &u); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read after the pop = %v, want ErrNotFound", err)
+	}
+}
+
+// Consumers draining a collection with PopAny claim every record exactly
+// once, then find it empty.
+func TestPopAnyDrain(t *testing.T) {
+	d := openTest(t, nil)
+	writeN(t, d, 0, 200)
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[string]int)
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var n int
+				key, err := d.PopAny("n", &n)
+				if errors.Is(err, ErrNotFound) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				claimed[key]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != 200 {
+		t.Errorf("claimed %d records, want 200", len(claimed))
+	}
+	for key, n := range claimed {
+		if n != 1 {
+			t.Errorf("%s was claimed %d times", key, n)
+		}
+	}
+}
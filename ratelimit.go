@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	RateLimit struct {
+		PerSecond float64
+		Burst     int
+		Wait      bool
+	}
+	bucket struct {
+		mutex   sync.Mutex
+		name    string
+		limit   RateLimit
+		tokens  float64
+		last    time.Time
+		engaged bool
+	}
+	// limiter throttles one kind of operation. A collection listed in
+	// limits gets its own bucket; all others share the global one.
+	limiter struct {
+		kind      string
+		global    *bucket
+		limits    map[string]RateLimit
+		mutex     sync.Mutex
+		buckets   map[string]*bucket
+		throttled uint64
+		rejected  uint64
+	}
+)
+
+func newLimiter(kind string, global *RateLimit, limits map[string]RateLimit) (*limiter, error) {
+	if global == nil && len(limits) == 0 {
+		return nil, nil
+	}
+	if global != nil && global.PerSecond <= 0 {
+		return nil, fmt.Errorf("%s rate limit must allow more than 0 per second", kind)
+	}
+	for collection, limit := range limits {
+		if limit.PerSecond <= 0 {
+			return nil, fmt.Errorf("%s rate limit of %s must allow more than 0 per second", kind, collection)
+		}
+	}
+
+	l := &limiter{kind: kind, limits: limits, buckets: make(map[string]*bucket)}
+	if global != nil {
+		l.global = newBucket(kind, *global)
+	}
+	return l, nil
+}
+
+func newBucket(name string, limit RateLimit) *bucket {
+	if limit.Burst <= 0 {
+		limit.Burst = int(math.Max(1, limit.PerSecond))
+	}
+	return &bucket{name: name, limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+}
+
+func (l *limiter) bucket(collection string) *bucket {
+	limit, ok := l.limits[collection]
+	if !ok {
+		return l.global
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	b, ok := l.buckets[collection]
+	if !ok {
+		b = newBucket(l.kind+" "+collection, limit)
+		l.buckets[collection] = b
+	}
+	return b
+}
+
+func (l *limiter) take(ctx context.Context, log Logger, collection string) error {
+	if l == nil {
+		return nil
+	}
+	b := l.bucket(collection)
+	if b == nil {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	wait, err := b.reserve(log)
+	if err != nil {
+		atomic.AddUint64(&l.rejected, 1)
+		return err
+	}
+	if wait <= 0 {
+		return nil
+	}
+	atomic.AddUint64(&l.throttled, 1)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.refund()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, returning how long the caller has to wait for it
+// when the bucket allows waiting.
+func (b *bucket) reserve(log Logger) (time.Duration, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.PerSecond)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		if b.engaged {
+			b.engaged = false
+			log.Info("%s throttling disengaged", b.name)
+		}
+		return 0, nil
+	}
+
+	if !b.engaged {
+		b.engaged = true
+		log.Warn("%s throttling engaged at %.f/s", b.name, b.limit.PerSecond)
+	}
+	if !b.limit.Wait {
+		return 0, fmt.Errorf("%w: %s", ErrRateLimited, b.name)
+	}
+
+	b.tokens--
+	return time.Duration(-b.tokens / b.limit.PerSecond * float64(time.Second)), nil
+}
+
+// refund returns the token of a reservation whose caller gave up waiting,
+// so an abandoned wait does not delay the callers queued behind it.
+func (b *bucket) refund() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+1)
+}
+
+func (l *limiter) engaged() []string {
+	if l == nil {
+		return nil
+	}
+
+	var names []string
+	for _, b := range append(l.snapshot(), l.global) {
+		if b == nil {
+			continue
+		}
+		b.mutex.Lock()
+		if b.engaged {
+			names = append(names, b.name)
+		}
+		b.mutex.Unlock()
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *limiter) snapshot() []*bucket {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	buckets := make([]*bucket, 0, len(l.buckets))
+	for _, b := range l.buckets {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+func (l *limiter) counts() (throttled, rejected uint64) {
+	if l == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&l.throttled), atomic.LoadUint64(&l.rejected)
+}
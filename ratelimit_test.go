@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWriteRateLimit(t *testing.T) {
+	d := openTest(t, &Options{CollectionWriteRateLimits: map[string]RateLimit{
+		"slow": {PerSecond: 100, Burst: 1, Wait: true},
+	}})
+
+	start := time.Now()
+	for i := 0; i < 31; i++ {
+		if err := d.Write("slow", fmt.Sprint(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 270*time.Millisecond || elapsed > 600*time.Millisecond {
+		t.Fatalf("31 writes at 100/s took %s", elapsed)
+	}
+
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		if err := d.Write("fast", fmt.Sprint(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("100 unthrottled writes took %s", elapsed)
+	}
+	if stats := d.Stats(); stats.ThrottledWrites == 0 {
+		t.Fatalf("no throttled writes in %+v", stats)
+	}
+}
+
+func TestWriteRateLimitReject(t *testing.T) {
+	d := openTest(t, &Options{WriteRateLimit: &RateLimit{PerSecond: 1, Burst: 2}})
+	for i := 0; i < 2; i++ {
+		if err := d.Write("n", fmt.Sprint(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Write("n", "2", 2); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if stats := d.Stats(); stats.RejectedWrites != 1 || len(stats.Throttling) != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+// A write that gives up waiting hands its token back, so the next one
+// waits for one token, not two.
+func TestWriteRateLimitRefund(t *testing.T) {
+	d := openTest(t, &Options{WriteRateLimit: &RateLimit{PerSecond: 10, Burst: 1, Wait: true}})
+	if err := d.Write("n", "0", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Put(ctx, "n", "1", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to time out, got %v", err)
+	}
+
+	start := time.Now()
+	if err := d.Write("n", "2", 2); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("the next write waited %s", elapsed)
+	}
+}
+
+func TestRateLimitInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{WriteRateLimit: &RateLimit{}},
+		{CollectionReadRateLimits: map[string]RateLimit{"user": {PerSecond: -1}}},
+	} {
+		opts.Logger = nopLog{}
+		if _, err := New(t.TempDir(), &opts); err == nil {
+			t.Errorf("New accepted %+v", opts)
+		}
+	}
+}
+
+// The calls that change records take from the write limit like Put, and
+// the pops, which read them too, from the read limit as well.
+func TestRateLimitCoversChanges(t *testing.T) {
+	calls := map[string]func(d *Driver, i int) error{
+		"Pop": func(d *Driver, i int) error {
+			var v int
+			return d.Pop("n", fmt.Sprint(i), &v)
+		},
+		"PopAny": func(d *Driver, i int) error {
+			var v int
+			_, err := d.PopAny("n", &v)
+			return err
+		},
+		"DeleteIfMatch": func(d *Driver, i int) error {
+			_, err := d.DeleteIfMatch("n", fmt.Sprint(i), []byte(fmt.Sprint(i)))
+			return err
+		},
+		"Swap":        func(d *Driver, i int) error { return d.Swap("n", "0", "1") },
+		"Truncate":    func(d *Driver, i int) error { return d.Truncate("n") },
+		"Counter.Inc": func(d *Driver, i int) error { _, err := d.Counter("n", "c").Inc(1); return err },
+	}
+	limit := map[string]RateLimit{"n": {PerSecond: 0.001, Burst: 1}}
+	for name, call := range calls {
+		opts := []Options{{CollectionWriteRateLimits: limit}}
+		if name == "Pop" || name == "PopAny" {
+			opts = append(opts, Options{CollectionReadRateLimits: limit})
+		}
+		for _, o := range opts {
+			dir := t.TempDir()
+			d := reopenTest(t, dir, nil)
+			writeN(t, d, 0, 4)
+			d.Close()
+			d = reopenTest(t, dir, &o)
+			if err := call(d, 0); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if err := call(d, 1); !errors.Is(err, ErrRateLimited) {
+				t.Errorf("%s past the limit of %+v = %v, want ErrRateLimited", name, o, err)
+			}
+		}
+	}
+}
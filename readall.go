@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+)
+
+type RecordError struct {
+	Collection string
+	Resource   string
+	Offset     int64
+	Err        error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("%s/%s at offset %d: %s", e.Collection, e.Resource, e.Offset, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// eachRecord calls fn with the decoded bytes of every record in collection,
+// in resource name order.
+func (d *Driver) eachRecord(log Logger, collection string, fn func(resource string, b []byte) error) error {
+	dir := filepath.Join(d.dir, collection)
+
+	files, err := d.readDir(dir)
+	if err != nil {
+		return err
+	}
+	files, _ = d.unexpired(dir, files)
+
+	for _, file := range files {
+		b, err := d.readRecord(log, filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		d.readRepair(log, collection, d.resourceOf(file.Name()), b)
+		if b, err = d.transform(collection, b); err != nil {
+			return err
+		}
+
+		if err := fn(d.resourceOf(file.Name()), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) ReadAllInto(collection string, dest interface{}) (err error) {
+	op := d.trace(nil, "ReadAllInto", collection, "")
+	defer func() { op.end(err) }()
+
+	_, err = d.readAllInto(op.log, collection, dest, false)
+	return err
+}
+
+// ReadAllIntoLenient decodes every record it can into dest and returns the
+// records that failed to decode instead of aborting. Only I/O problems are
+// returned as an error.
+func (d *Driver) ReadAllIntoLenient(collection string, dest interface{}) (_ []RecordError, err error) {
+	op := d.trace(nil, "ReadAllIntoLenient", collection, "")
+	defer func() { op.end(err) }()
+
+	return d.readAllInto(op.log, collection, dest, true)
+}
+
+func (d *Driver) readAllInto(log Logger, collection string, dest interface{}, lenient bool) ([]RecordError, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("dest must be a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+	elem := slice.Type().Elem()
+
+	var failed []RecordError
+	err := d.eachRecord(log, collection, func(resource string, b []byte) error {
+		v := reflect.New(elem)
+		if err := json.Unmarshal(b, v.Interface()); err != nil {
+			rerr := RecordError{Collection: collection, Resource: resource, Offset: errorOffset(err), Err: err}
+			if !lenient {
+				return &rerr
+			}
+			failed = append(failed, rerr)
+			return nil
+		}
+		slice.Set(reflect.Append(slice, v.Elem()))
+		return nil
+	})
+	return failed, err
+}
+
+func errorOffset(err error) int64 {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		return syntax.Offset
+	case errors.As(err, &typ):
+		return typ.Offset
+	}
+	return -1
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// One record that drifted from the struct is reported on its own by
+// ReadAllIntoLenient, and fails ReadAllInto.
+func TestReadAllIntoLenient(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	if err := d.Write("user", "Neo", map[string]interface{}{"Name": "Neo", "Address": "bangalore"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var users []User
+	failed, err := d.ReadAllIntoLenient("user", &users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != len(testUsers)-1 {
+		t.Errorf("decoded %d users, want %d", len(users), len(testUsers)-1)
+	}
+	if len(failed) != 1 || failed[0].Collection != "user" || failed[0].Resource != "Neo" || failed[0].Offset == 0 || failed[0].Err == nil {
+		t.Errorf("ReadAllIntoLenient reported %+v, want one error for user/Neo", failed)
+	}
+
+	users = nil
+	var rerr *RecordError
+	if err := d.ReadAllInto("user", &users); !errors.As(err, &rerr) || rerr.Resource != "Neo" {
+		t.Errorf("ReadAllInto = %v, want a RecordError for Neo", err)
+	}
+}
+
+// A stray subdirectory, even one named like a record, is skipped by ReadAll
+// unless ReadAllSubdirectories asks for the records nested in it.
+func TestReadAllSubdirectory(t *testing.T) {
+	for _, descend := range []bool{false, true} {
+		d := openTest(t, &Options{ReadAllSubdirectories: descend})
+		seedUsers(t, d)
+		if err := os.Mkdir(filepath.Join(d.dir, "user", "stray.json"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Write("user/archive", "Zed", User{Name: "Zed"}); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := d.ReadAll("user")
+		if err != nil {
+			t.Fatalf("ReadAll with ReadAllSubdirectories %v: %s", descend, err)
+		}
+		want := len(testUsers)
+		if descend {
+			want++
+		}
+		if len(records) != want {
+			t.Errorf("ReadAll with ReadAllSubdirectories %v read %d records, want %d", descend, len(records), want)
+		}
+		if nested := strings.Contains(strings.Join(records, ""), "Zed"); nested != descend {
+			t.Errorf("ReadAll with ReadAllSubdirectories %v included the nested record: %v", descend, nested)
+		}
+	}
+}
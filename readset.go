@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ReadRequest names one record for ReadSet. After ReadSet returns, Found
+// reports whether the record existed; V is only filled in when it did.
+type ReadRequest struct {
+	Collection string
+	Resource   string
+	V          interface{}
+	Found      bool
+}
+
+// ReadSet reads every requested record while holding the locks of all
+// involved collections, so the results form a consistent cut against
+// writes that span several collections. Missing records are reported
+// through Found rather than failing the batch.
+func (d *Driver) ReadSet(requests []ReadRequest) (err error) {
+	op := d.trace(nil, "ReadSet", "", "")
+	defer func() { op.end(err) }()
+
+	collections := make([]string, 0, len(requests))
+	for _, r := range requests {
+		if r.Collection == "" {
+			return fmt.Errorf("collection is required")
+		}
+		if r.Resource == "" {
+			return fmt.Errorf("resource is required")
+		}
+		if err := d.readLimit.take(d.ctx, op.log, r.Collection); err != nil {
+			return err
+		}
+		collections = append(collections, r.Collection)
+	}
+	defer d.lockCollections(collections...)()
+
+	for i := range requests {
+		r := &requests[i]
+		record := d.recordPath(r.Collection, r.Resource)
+
+		b, err := d.readRecord(op.log, record)
+		if os.IsNotExist(err) {
+			r.Found = false
+			continue
+		}
+		if isTorn(b, err) {
+			return &TornWriteError{Record: record}
+		}
+		if err != nil {
+			return err
+		}
+		op.bytes += int64(len(b))
+
+		if b, err = d.transform(r.Collection, b); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, r.V); err != nil {
+			return fmt.Errorf("%s/%s: %w", r.Collection, r.Resource, err)
+		}
+		r.Found = true
+	}
+
+	return nil
+}
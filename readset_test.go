@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// A ReadSet interleaved with batches writing two collections in step never
+// sees one half of a batch without the other.
+func TestReadSetConsistentCut(t *testing.T) {
+	d := openTest(t, nil)
+	if err := d.Batch().Write("user", "John", packedDoc{}).Write("settings", "John", packedDoc{}).Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 1; i <= 200; i++ {
+			doc := packedDoc{N: i}
+			if err := d.Batch().Write("user", "John", doc).Write("settings", "John", doc).Commit(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for reads := 0; ; reads++ {
+		select {
+		case <-done:
+			wg.Wait()
+			if reads == 0 {
+				t.Error("no ReadSet ran during the batches")
+			}
+			return
+		default:
+		}
+		var user, settings packedDoc
+		requests := []ReadRequest{
+			{Collection: "user", Resource: "John", V: &user},
+			{Collection: "settings", Resource: "John", V: &settings},
+			{Collection: "flags", Resource: "beta", V: new(bool)},
+		}
+		if err := d.ReadSet(requests); err != nil {
+			t.Fatal(err)
+		}
+		if user.N != settings.N {
+			t.Fatalf("ReadSet saw user at batch %d and settings at batch %d", user.N, settings.N)
+		}
+		if !requests[0].Found || !requests[1].Found || requests[2].Found {
+			t.Fatalf("Found = %v, %v, %v, want true, true, false", requests[0].Found, requests[1].Found, requests[2].Found)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+type (
+	// ReadTransformFunc up-converts a stored record to the shape the
+	// application currently expects, e.g. by filling in defaults for new
+	// fields or renaming old ones.
+	ReadTransformFunc func(raw json.RawMessage) (json.RawMessage, error)
+
+	readTransformSet struct {
+		mutex     sync.RWMutex
+		fns       map[string][]ReadTransformFunc
+		repairing map[string]bool
+	}
+)
+
+func newReadTransformSet() *readTransformSet {
+	return &readTransformSet{fns: make(map[string][]ReadTransformFunc), repairing: make(map[string]bool)}
+}
+
+// RegisterReadTransform adds fn to the transforms applied to every record
+// of collection read through Read, ReadAll, ReadAllInto, Find, All and the
+// other read paths. A record is first decrypted and decompressed, then
+// passed through the collection's transforms in registration order and
+// finally through Options.ReadTransform; only then is it unmarshalled.
+//
+// With Options.ReadRepair, a record that a transform changed is written
+// back in its new shape after it has been read, so the collection converges
+// without a migration pass. Options.ReadTransform is never written back.
+func (d *Driver) RegisterReadTransform(collection string, fn ReadTransformFunc) {
+	s := d.readTransforms
+	s.mutex.Lock()
+	s.fns[collection] = append(s.fns[collection], fn)
+	s.mutex.Unlock()
+
+	d.listings.invalidate(collection)
+}
+
+func (d *Driver) transform(collection string, b []byte) ([]byte, error) {
+	b, _, err := d.upconvert(collection, b)
+	if err != nil || d.opts.ReadTransform == nil {
+		return b, err
+	}
+	return d.opts.ReadTransform(collection, b)
+}
+
+// upconvert applies the transforms registered for collection and reports
+// whether they changed the record.
+func (d *Driver) upconvert(collection string, b []byte) ([]byte, bool, error) {
+	s := d.readTransforms
+	s.mutex.RLock()
+	fns := s.fns[collection]
+	s.mutex.RUnlock()
+
+	if len(fns) == 0 {
+		return b, false, nil
+	}
+	out := json.RawMessage(bytes.TrimSpace(b))
+	for _, fn := range fns {
+		var err error
+		if out, err = fn(out); err != nil {
+			return nil, false, err
+		}
+	}
+	return out, !bytes.Equal(out, bytes.TrimSpace(b)), nil
+}
+
+// readRepair schedules the write-back of a record read as raw if the
+// collection's transforms change it. The write happens in the background
+// under the collection lock, and only if the record is still as it was.
+func (d *Driver) readRepair(log Logger, collection, resource string, raw []byte) {
+	if !d.opts.ReadRepair || d.readOnly {
+		return
+	}
+	if _, changed, err := d.upconvert(collection, raw); err != nil || !changed {
+		return
+	}
+
+	s := d.readTransforms
+	key := collection + "/" + resource
+	s.mutex.Lock()
+	if s.repairing[key] {
+		s.mutex.Unlock()
+		return
+	}
+	s.repairing[key] = true
+	s.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.repairing, key)
+			s.mutex.Unlock()
+		}()
+		if err := d.repair(collection, resource, raw); err != nil {
+			log.Warn("Unable to repair %s/%s: %s", collection, resource, err)
+		}
+	}()
+}
+
+func (d *Driver) repair(collection, resource string, raw []byte) (err error) {
+	op := d.trace(nil, "ReadRepair", collection, resource)
+	defer func() { op.end(err) }()
+
+	if d.ctx.Err() != nil {
+		return nil
+	}
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	if d.checkWritable(collection) != nil {
+		return nil
+	}
+
+	current, err := d.readRecord(op.log, d.recordPath(collection, resource))
+	if err != nil || !bytes.Equal(current, raw) {
+		return nil
+	}
+	b, changed, err := d.upconvert(collection, current)
+	if err != nil || !changed {
+		return err
+	}
+	return d.store(op, collection, resource, b)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Options.ReadTransform fills in a field records were written without, for
+// Read and ReadAll alike, and leaves the files as they were.
+func TestReadTransform(t *testing.T) {
+	type account struct {
+		Name string
+		Plan string
+	}
+	d := openTest(t, &Options{ReadTransform: func(collection string, b []byte) ([]byte, error) {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		if _, ok := doc["Plan"]; !ok {
+			doc["Plan"] = "free"
+		}
+		return json.Marshal(doc)
+	}})
+	if err := d.Write("account", "a", map[string]string{"Name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var a account
+	if err := d.Read("account", "a", &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Plan != "free" {
+		t.Errorf("Read gave %+v, want the Plan filled in", a)
+	}
+	records, err := d.ReadAll("account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !strings.Contains(records[0], `"Plan":"free"`) {
+		t.Errorf("ReadAll gave %q, want the Plan filled in", records)
+	}
+
+	b, err := d.readRecord(d.log, d.recordPath("account", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "Plan") {
+		t.Errorf("the transform was written back: %s", b)
+	}
+}
+
+// renameFullname up-converts records of the old shape, which kept the name
+// in Fullname and had no Plan.
+func renameFullname(raw json.RawMessage) (json.RawMessage, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if name, ok := doc["Fullname"]; ok {
+		doc["Name"] = name
+		delete(doc, "Fullname")
+	}
+	if _, ok := doc["Plan"]; !ok {
+		doc["Plan"] = "free"
+	}
+	return json.Marshal(doc)
+}
+
+// Old-shaped records read as the new shape through Read, ReadAllInto and
+// Find, and with ReadRepair the files are upgraded after the first read.
+func TestRegisterReadTransform(t *testing.T) {
+	type account struct {
+		Name string
+		Plan string
+	}
+	want := account{Name: "Ann", Plan: "free"}
+
+	for _, repair := range []bool{false, true} {
+		d := openTest(t, &Options{ReadRepair: repair})
+		if err := d.Write("account", "a", map[string]string{"Fullname": "Ann"}); err != nil {
+			t.Fatal(err)
+		}
+		d.RegisterReadTransform("account", renameFullname)
+
+		var a account
+		if err := d.Read("account", "a", &a); err != nil || a != want {
+			t.Errorf("Read = %+v, %v, want %+v", a, err, want)
+		}
+		var all []account
+		if err := d.ReadAllInto("account", &all); err != nil || len(all) != 1 || all[0] != want {
+			t.Errorf("ReadAllInto = %+v, %v", all, err)
+		}
+		var found []account
+		if err := d.Find("account").Where("Name", "=", "Ann").Collect(&found); err != nil || len(found) != 1 || found[0] != want {
+			t.Errorf("Find = %+v, %v", found, err)
+		}
+
+		stored := func() string {
+			b, err := d.readRecord(d.log, d.recordPath("account", "a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(b)
+		}
+		if !repair {
+			if strings.Contains(stored(), `"Name"`) {
+				t.Errorf("without ReadRepair the record was rewritten: %s", stored())
+			}
+			continue
+		}
+		waitFor(t, "the record to be repaired", func() bool {
+			return strings.Contains(stored(), `"Name":"Ann"`) && !strings.Contains(stored(), "Fullname")
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type ReferenceAction int
+
+const (
+	Restrict ReferenceAction = iota
+	Cascade
+	SetNull
+)
+
+type (
+	Reference struct {
+		Collection string
+		OnDelete   ReferenceAction
+	}
+	ReferenceError struct {
+		Collection string
+		Resource   string
+		Referrers  []string
+	}
+	refKey struct {
+		collection string
+		resource   string
+	}
+	refNull struct {
+		key   refKey
+		field string
+	}
+	// refIndex maps records to the records they reference and back, so
+	// deleting a referenced record does not need to scan its referrers.
+	refIndex struct {
+		mutex   sync.Mutex
+		built   map[string]bool
+		forward map[refKey]map[string]refKey
+		reverse map[refKey]map[refKey]string
+	}
+)
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("%s/%s is referenced by %s", e.Collection, e.Resource, strings.Join(e.Referrers, ", "))
+}
+
+func (e *ReferenceError) Unwrap() error { return ErrReferenced }
+
+func (k refKey) String() string { return k.collection + "/" + k.resource }
+
+func newRefIndex() *refIndex {
+	return &refIndex{
+		built:   make(map[string]bool),
+		forward: make(map[refKey]map[string]refKey),
+		reverse: make(map[refKey]map[refKey]string),
+	}
+}
+
+// referrers returns the collections declaring a reference to target.
+func (d *Driver) referrers(target string) []string {
+	var collections []string
+	for _, collection := range d.referencingCollections() {
+		for _, ref := range d.referencesOf(collection) {
+			if ref.Collection == target {
+				collections = append(collections, collection)
+				break
+			}
+		}
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// writeScope lists the collections to lock for a write: the collection
+// itself and every collection it references.
+func (d *Driver) writeScope(collection string) []string {
+	scope := []string{collection}
+	for _, ref := range d.referencesOf(collection) {
+		scope = append(scope, ref.Collection)
+	}
+	return scope
+}
+
+// deleteScope lists the collections to lock for a delete: everything a
+// cascade or set-null could end up writing to.
+func (d *Driver) deleteScope(collection string) []string {
+	seen := map[string]bool{collection: true}
+	queue := []string{collection}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, referrer := range d.referrers(c) {
+			for _, s := range d.writeScope(referrer) {
+				if !seen[s] {
+					seen[s] = true
+					queue = append(queue, s)
+				}
+			}
+		}
+	}
+
+	scope := make([]string, 0, len(seen))
+	for c := range seen {
+		scope = append(scope, c)
+	}
+	return scope
+}
+
+// resolveReferences extracts the referenced keys from a document and, when
+// check is set, verifies that every referenced record exists.
+func (d *Driver) resolveReferences(collection string, b []byte, check bool) (map[string]refKey, error) {
+	refs := d.referencesOf(collection)
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]refKey)
+	for field, ref := range refs {
+		value, ok := lookup(doc, field)
+		if !ok || value == nil {
+			continue
+		}
+		key, err := keyString(value)
+		if err != nil {
+			return nil, err
+		}
+
+		target := refKey{ref.Collection, key}
+		if check {
+			if _, err := d.statFile(d.recordPath(target.collection, target.resource)); os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: %s references missing %s", ErrBrokenReference, field, target)
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		targets[field] = target
+	}
+	return targets, nil
+}
+
+// ensure builds the index for a referencing collection on first use. The
+// collection must be locked by the caller.
+func (d *Driver) ensureReferences(collection string) error {
+	idx := d.references
+	idx.mutex.Lock()
+	built := idx.built[collection]
+	idx.mutex.Unlock()
+	if built || len(d.referencesOf(collection)) == 0 {
+		return nil
+	}
+
+	err := d.eachRecord(d.log, collection, func(resource string, b []byte) error {
+		targets, err := d.resolveReferences(collection, b, false)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %s", collection, resource, err)
+		}
+		idx.set(refKey{collection, resource}, targets)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	idx.mutex.Lock()
+	idx.built[collection] = true
+	idx.mutex.Unlock()
+	return nil
+}
+
+func (idx *refIndex) set(referrer refKey, targets map[string]refKey) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	for _, target := range idx.forward[referrer] {
+		delete(idx.reverse[target], referrer)
+		if len(idx.reverse[target]) == 0 {
+			delete(idx.reverse, target)
+		}
+	}
+	delete(idx.forward, referrer)
+
+	if len(targets) == 0 {
+		return
+	}
+	idx.forward[referrer] = targets
+	for field, target := range targets {
+		if idx.reverse[target] == nil {
+			idx.reverse[target] = make(map[refKey]string)
+		}
+		idx.reverse[target][referrer] = field
+	}
+}
+
+// update records a write that already went through resolveReferences. It
+// is a no-op until the collection's index has been built from disk.
+func (idx *refIndex) update(referrer refKey, targets map[string]refKey) {
+	idx.mutex.Lock()
+	built := idx.built[referrer.collection]
+	idx.mutex.Unlock()
+	if built {
+		idx.set(referrer, targets)
+	}
+}
+
+// reset forgets a collection's entries so the next use rescans it.
+func (idx *refIndex) reset(collection string) {
+	idx.mutex.Lock()
+	built := idx.built[collection]
+	delete(idx.built, collection)
+	idx.mutex.Unlock()
+	if !built {
+		return
+	}
+
+	idx.mutex.Lock()
+	var referrers []refKey
+	for referrer := range idx.forward {
+		if referrer.collection == collection {
+			referrers = append(referrers, referrer)
+		}
+	}
+	idx.mutex.Unlock()
+
+	for _, referrer := range referrers {
+		idx.set(referrer, nil)
+	}
+}
+
+func (idx *refIndex) referencing(target refKey) map[refKey]string {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	referrers := make(map[refKey]string, len(idx.reverse[target]))
+	for referrer, field := range idx.reverse[target] {
+		referrers[referrer] = field
+	}
+	return referrers
+}
+
+// planDelete works out every record a delete removes through cascades and
+// every field it nulls, failing before anything changes if a restricting
+// reference is found. Referrers in the dropping collections, which go away
+// as a whole, are left out.
+func (d *Driver) planDelete(target refKey, dropping map[string]bool, seen map[refKey]bool, deletes *[]refKey, nulls *[]refNull) error {
+	if seen[target] {
+		return nil
+	}
+	seen[target] = true
+
+	for _, collection := range d.referrers(target.collection) {
+		if dropping[collection] {
+			continue
+		}
+		if err := d.ensureReferences(collection); err != nil {
+			return err
+		}
+	}
+
+	referrers := d.references.referencing(target)
+	keys := make([]refKey, 0, len(referrers))
+	for referrer := range referrers {
+		keys = append(keys, referrer)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	var restricted []string
+	for _, referrer := range keys {
+		if dropping[referrer.collection] {
+			continue
+		}
+		field := referrers[referrer]
+		switch d.referencesOf(referrer.collection)[field].OnDelete {
+		case Cascade:
+			if err := d.planDelete(referrer, dropping, seen, deletes, nulls); err != nil {
+				return err
+			}
+		case SetNull:
+			*nulls = append(*nulls, refNull{referrer, field})
+		default:
+			restricted = append(restricted, referrer.String())
+		}
+	}
+	if len(restricted) > 0 {
+		return &ReferenceError{Collection: target.collection, Resource: target.resource, Referrers: restricted}
+	}
+
+	*deletes = append(*deletes, target)
+	return nil
+}
+
+// planDrop is planDelete for removing every record of collection at once.
+// Referrers among the dropping collections go away with it and are left out
+// of the plan.
+func (d *Driver) planDrop(collection string, dropping map[string]bool) (deletes []refKey, nulls []refNull, err error) {
+	outside := false
+	for _, referrer := range d.referrers(collection) {
+		outside = outside || !dropping[referrer]
+	}
+	if !outside {
+		return nil, nil, nil
+	}
+
+	files, err := d.readDir(filepath.Join(d.dir, collection))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[refKey]bool)
+	for _, file := range files {
+		if file.IsDir() || !d.isRecord(file.Name()) {
+			continue
+		}
+		target := refKey{collection, d.resourceOf(file.Name())}
+		if err := d.planDelete(target, dropping, seen, &deletes, &nulls); err != nil {
+			return nil, nil, err
+		}
+	}
+	return deletes, nulls, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type order struct {
+	ID   string
+	User interface{}
+}
+
+func referencesTest(t *testing.T, action ReferenceAction) *Driver {
+	t.Helper()
+	d := openTest(t, &Options{References: map[string]map[string]Reference{
+		"order": {"User": {Collection: "user", OnDelete: action}},
+	}})
+	seedUsers(t, d)
+	for i, name := range []string{"John", "John", "Paul"} {
+		id := fmt.Sprint(i)
+		if err := d.Write("order", id, order{id, name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func TestReferencesRestrict(t *testing.T) {
+	d := referencesTest(t, Restrict)
+
+	var rerr *ReferenceError
+	if err := d.Delete("user", "John"); !errors.As(err, &rerr) || !errors.Is(err, ErrReferenced) || len(rerr.Referrers) != 2 {
+		t.Fatalf("Delete of a referenced user = %v, want a ReferenceError naming two orders", err)
+	}
+	var u User
+	if err := d.Read("user", "John", &u); err != nil {
+		t.Errorf("the restricted user is gone: %s", err)
+	}
+	if err := d.Write("order", "x", order{"x", "Nobody"}); !errors.Is(err, ErrBrokenReference) {
+		t.Errorf("an order for a missing user = %v, want ErrBrokenReference", err)
+	}
+	if err := d.Delete("user", "Robert"); err != nil {
+		t.Errorf("Delete of an unreferenced user = %v", err)
+	}
+}
+
+func TestReferencesCascade(t *testing.T) {
+	d := referencesTest(t, Cascade)
+	if err := d.Delete("user", "John"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := d.Keys("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "2" {
+		t.Errorf("orders after the cascade: %v, want [2]", keys)
+	}
+}
+
+func TestReferencesSetNull(t *testing.T) {
+	d := referencesTest(t, SetNull)
+	if err := d.Delete("user", "John"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"0", "1"} {
+		var o order
+		if err := d.Read("order", id, &o); err != nil || o.User != nil {
+			t.Errorf("order %s after the delete = %+v, %v, want its user null", id, o, err)
+		}
+	}
+}
+
+// Orders written for a user while it is deleted with a cascade either fail
+// or are removed with it: none is left pointing at the deleted user.
+func TestReferencesConcurrentReferrers(t *testing.T) {
+	for round := 0; round < 10; round++ {
+		d := referencesTest(t, Cascade)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				id := fmt.Sprintf("new%d", i)
+				if err := d.Write("order", id, order{id, "John"}); err != nil && !errors.Is(err, ErrBrokenReference) {
+					t.Error(err)
+				}
+			}
+		}()
+		if err := d.Delete("user", "John"); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+
+		var orders []order
+		if err := d.ReadAllInto("order", &orders); err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range orders {
+			if o.User == "John" {
+				t.Fatalf("round %d: order %s references the deleted user", round, o.ID)
+			}
+		}
+	}
+}
+
+// Truncating a referenced collection applies the references' delete
+// actions as deleting each record would: a restricting one refuses and
+// changes nothing, a cascade removes the orders and set-null clears them.
+func TestReferencesTruncate(t *testing.T) {
+	d := referencesTest(t, Restrict)
+	var rerr *ReferenceError
+	if err := d.Truncate("user"); !errors.As(err, &rerr) || len(rerr.Referrers) == 0 {
+		t.Errorf("Truncate of referenced users = %v, want a ReferenceError", err)
+	}
+	if n, err := d.Count("user"); err != nil || n != len(testUsers) {
+		t.Errorf("users after the refused Truncate: %d, %v", n, err)
+	}
+
+	d = referencesTest(t, Cascade)
+	if err := d.Truncate("user"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Count("order"); err != nil || n != 0 {
+		t.Errorf("orders after the cascading Truncate: %d, %v", n, err)
+	}
+
+	d = referencesTest(t, SetNull)
+	if err := d.Truncate("user"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"0", "1", "2"} {
+		var o order
+		if err := d.Read("order", id, &o); err != nil || o.User != nil {
+			t.Errorf("order %s after the Truncate = %+v, %v, want its user null", id, o, err)
+		}
+	}
+	if err := d.Write("user", "John", testUsers[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("user", "John"); err != nil {
+		t.Errorf("Delete of a user no order references any more = %v", err)
+	}
+}
+
+func TestReferencesDeleteCollection(t *testing.T) {
+	d := referencesTest(t, Restrict)
+	var rerr *ReferenceError
+	if _, err := d.DeleteCollection("user"); !errors.As(err, &rerr) || len(rerr.Referrers) == 0 {
+		t.Errorf("DeleteCollection of referenced users = %v, want a ReferenceError", err)
+	}
+	if n, err := d.Count("user"); err != nil || n != len(testUsers) {
+		t.Errorf("users after the refused DeleteCollection: %d, %v", n, err)
+	}
+	// Dropping the referrers along with the users leaves nothing to restrict.
+	if _, err := d.Drop(d.PrepareDrop()); err != nil {
+		t.Errorf("Drop of users and their orders = %v", err)
+	}
+
+	d = referencesTest(t, Cascade)
+	if _, err := d.DeleteCollection("user"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Count("order"); err != nil || n != 0 {
+		t.Errorf("orders after the cascading DeleteCollection: %d, %v", n, err)
+	}
+
+	d = referencesTest(t, SetNull)
+	if _, err := d.DeleteCollection("user"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"0", "1", "2"} {
+		var o order
+		if err := d.Read("order", id, &o); err != nil || o.User != nil {
+			t.Errorf("order %s after the DeleteCollection = %+v, %v, want its user null", id, o, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// Drivers opened on the same directory share one instance, so the
+// per-collection mutexes actually exclude each other.
+var registry = struct {
+	sync.Mutex
+	drivers map[string]*Driver
+}{drivers: make(map[string]*Driver)}
+
+// registryKey identifies a database directory independently of how its path
+// was spelled, resolving symlinks in the longest existing prefix so a
+// directory that New is about to create gets the same key it will have
+// afterwards.
+func registryKey(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				resolved = filepath.Join(resolved, missing[i])
+			}
+			return resolved, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return filepath.Abs(dir)
+		}
+		missing = append(missing, filepath.Base(abs))
+		abs = parent
+	}
+}
+
+func (o Options) conflicts(other Options) error {
+	a, b := reflect.ValueOf(o), reflect.ValueOf(other)
+	for i := 0; i < a.NumField(); i++ {
+		name := a.Type().Field(i).Name
+		if name == "Logger" {
+			continue
+		}
+		if !sameOption(a.Field(i), b.Field(i)) {
+			return fmt.Errorf("option %s conflicts with the driver already open on this directory", name)
+		}
+	}
+	return nil
+}
+
+// sameOption compares option values like reflect.DeepEqual, except that
+// functions, which DeepEqual only finds equal when both are nil, compare by
+// code pointer, also inside maps, slices and structs. Two maps holding the
+// same normalizers are the same option.
+func sameOption(x, y reflect.Value) bool {
+	switch x.Kind() {
+	case reflect.Func:
+		return x.Pointer() == y.Pointer()
+	case reflect.Map:
+		if x.Len() != y.Len() {
+			return false
+		}
+		for iter := x.MapRange(); iter.Next(); {
+			v := y.MapIndex(iter.Key())
+			if !v.IsValid() || !sameOption(iter.Value(), v) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !sameOption(x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			if !x.Field(i).CanInterface() {
+				return reflect.DeepEqual(x.Interface(), y.Interface())
+			}
+		}
+		for i := 0; i < x.NumField(); i++ {
+			if !sameOption(x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(x.Interface(), y.Interface())
+}
+
+func (d *Driver) Close() error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if d.closed || d.refs == 0 {
+		return nil
+	}
+	d.closed = true
+	d.refs--
+	if d.refs > 0 {
+		return nil
+	}
+
+	delete(registry.drivers, d.key)
+	d.cancel()
+	if err := d.access.flush(); err != nil {
+		d.log.Error("Unable to flush access times: %s", err)
+	}
+	if err := d.packs.close(); err != nil {
+		d.log.Error("Unable to save pack index: %s", err)
+	}
+	return d.events.close()
+}
+
+// Done is closed once the last Close of the driver has run. Background
+// work started by the driver stops at the same time.
+func (d *Driver) Done() <-chan struct{} {
+	return d.ctx.Done()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedHandles(t *testing.T) {
+	dir := t.TempDir()
+	a := reopenTest(t, dir, nil)
+	b := reopenTest(t, filepath.Join(dir, "."), nil)
+	if a.database != b.database {
+		t.Fatal("handles on one directory do not share a database")
+	}
+
+	// Both handles increment one counter; a lost update means the two did
+	// not exclude each other.
+	const n = 50
+	increment := func(current []byte) ([]byte, error) {
+		var v int
+		if err := json.Unmarshal(current, &v); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(v + 1)), nil
+	}
+	if err := a.Write("counter", "c", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range []*Driver{a, b} {
+		wg.Add(1)
+		go func(d *Driver) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := d.Modify("counter", "c", increment); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(d)
+	}
+	wg.Wait()
+
+	var v int
+	if err := b.Read("counter", "c", &v); err != nil || v != 2*n {
+		t.Fatalf("counter is %d, want %d (%v)", v, 2*n, err)
+	}
+
+	if err := b.Write("user", "John", testUsers[0]); err != nil {
+		t.Fatal(err)
+	}
+	var u User
+	if err := a.Read("user", "John", &u); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Writes hammered through two handles on one path are each visible through
+// the other handle as soon as they return. Run with -race.
+func TestSharedHandlesHammer(t *testing.T) {
+	dir := t.TempDir()
+	handles := []*Driver{reopenTest(t, dir, nil), reopenTest(t, dir, nil)}
+	const writes = 100
+
+	var wg sync.WaitGroup
+	for i, d := range handles {
+		other := handles[1-i]
+		wg.Add(1)
+		go func(i int, d, other *Driver) {
+			defer wg.Done()
+			for n := 0; n < writes; n++ {
+				own := strconv.Itoa(i) + "-" + strconv.Itoa(n)
+				if err := d.Write("hammer", own, n); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := d.Write("hammer", "shared", n); err != nil {
+					t.Error(err)
+					return
+				}
+				var v int
+				if err := other.Read("hammer", own, &v); err != nil || v != n {
+					t.Errorf("the other handle read %s as %d, %v, want %d", own, v, err, n)
+					return
+				}
+			}
+		}(i, d, other)
+	}
+	wg.Wait()
+
+	for _, d := range handles {
+		keys, err := d.Keys("hammer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2*writes+1 {
+			t.Errorf("a handle lists %d records, want %d", len(keys), 2*writes+1)
+		}
+		var v int
+		if err := d.Read("hammer", "shared", &v); err != nil || v != writes-1 {
+			t.Errorf("shared record is %d, %v, want %d", v, err, writes-1)
+		}
+	}
+}
+
+func TestHandleCloseTwice(t *testing.T) {
+	dir := t.TempDir()
+	a := reopenTest(t, dir, nil)
+	b := reopenTest(t, dir, nil)
+
+	a.Close()
+	a.Close()
+	select {
+	case <-b.Done():
+		t.Fatal("closing one handle twice closed the other")
+	default:
+	}
+	if err := b.Write("user", "John", testUsers[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Close()
+	select {
+	case <-b.Done():
+	default:
+		t.Fatal("closing the last handle left the driver running")
+	}
+}
+
+func identityNormalizer(raw json.RawMessage) (json.RawMessage, error) { return raw, nil }
+
+func TestOptionsConflict(t *testing.T) {
+	dir := t.TempDir()
+	opts := func() *Options {
+		return &Options{
+			Logger:      nopLog{},
+			Normalizers: map[string]Normalizer{"user": identityNormalizer},
+			Compression: CompressionGzip,
+		}
+	}
+	reopenTest(t, dir, opts())
+
+	// Equal options built anew, functions included, are no conflict.
+	reopenTest(t, dir, opts())
+
+	other := opts()
+	other.Compression = CompressionZstd
+	if _, err := New(dir, other); err == nil {
+		t.Fatal("expected conflicting compression to fail")
+	}
+	if _, err := New(dir, nil); err == nil {
+		t.Fatal("expected nil options to conflict with non-default ones")
+	}
+}
+
+func TestFailedOpenStops(t *testing.T) {
+	d, err := New(t.TempDir(), &Options{
+		Logger:         nopLog{},
+		TrackReads:     map[string]bool{"user": true},
+		Role:           RolePrimary,
+		LeaseHeartbeat: time.Second,
+		LeaseStale:     time.Second,
+	})
+	if err == nil {
+		d.Close()
+		t.Fatal("expected LeaseStale not above LeaseHeartbeat to fail")
+	}
+	select {
+	case <-d.Done():
+	case <-time.After(time.Second):
+		t.Fatal("a failed open left its background work running")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const remapExt = ".remap"
+
+// RemapKeys renames every record of collection to the key mapFn returns
+// for it, under the collection lock. All new keys are computed and checked
+// first: if mapFn fails, a new key is invalid, or two records would end up
+// under the same key, nothing is renamed. Keys may be swapped among
+// themselves. Collections referenced by others cannot be remapped, since
+// the references would dangle.
+func (d *Driver) RemapKeys(collection string, mapFn func(oldKey string) (newKey string, err error)) (err error) {
+	op := d.trace(nil, "RemapKeys", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if referrers := d.referrers(collection); len(referrers) > 0 {
+		return fmt.Errorf("%s is referenced by %s; remapping its keys would break the references", collection, strings.Join(referrers, ", "))
+	}
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return err
+	}
+	defer d.lockCollections(collection)()
+	defer d.listings.invalidate(collection)
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(d.dir, collection)
+	files, err := d.readDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var olds []string
+	for _, file := range files {
+		if !file.IsDir() && d.isRecord(file.Name()) {
+			olds = append(olds, d.resourceOf(file.Name()))
+		}
+	}
+	sort.Strings(olds)
+
+	// Every record claims its new key, so a key taken by a record that
+	// keeps its own is reported as a collision too.
+	moves := make(map[string]string)
+	claimed := make(map[string]string)
+	for _, old := range olds {
+		key, err := mapFn(old)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", collection, old, err)
+		}
+		if err := validateName(collection, key); err != nil {
+			return fmt.Errorf("%s/%s: %w", collection, old, err)
+		}
+		if err := d.checkGuards(op, collection, key); err != nil {
+			return err
+		}
+		file := d.fileKey(key)
+		if other, ok := claimed[file]; ok {
+			return fmt.Errorf("%w: %s/%s and %s/%s both map to %s", ErrConflict, collection, other, collection, old, key)
+		}
+		claimed[file] = old
+		if err := d.recordLongKey(key); err != nil {
+			return err
+		}
+		if file != d.fileKey(old) {
+			moves[old] = key
+		}
+	}
+	if len(moves) == 0 {
+		return nil
+	}
+
+	// Move every record aside first so swaps and chains cannot overwrite
+	// each other, then into place; a failure moves back what was moved.
+	var staged, placed []string
+	rollback := func() {
+		for _, old := range placed {
+			if err := d.moveRecord(collection, moves[old], old, "", remapExt); err != nil {
+				op.log.Error("Unable to move %s/%s back: %s", collection, moves[old], err)
+			}
+		}
+		for _, old := range append(placed, staged...) {
+			if err := d.moveRecord(collection, old, old, remapExt, ""); err != nil {
+				op.log.Error("Unable to move %s/%s back: %s", collection, old, err)
+			}
+		}
+	}
+	for _, old := range olds {
+		if _, ok := moves[old]; !ok {
+			continue
+		}
+		if err := d.moveRecord(collection, old, old, "", remapExt); err != nil {
+			rollback()
+			return err
+		}
+		staged = append(staged, old)
+	}
+	for len(staged) > 0 {
+		old := staged[0]
+		if err := d.moveRecord(collection, old, moves[old], remapExt, ""); err != nil {
+			rollback()
+			return err
+		}
+		staged, placed = staged[1:], append(placed, old)
+	}
+	if err := d.syncDir(dir); err != nil {
+		return err
+	}
+	var moved []string
+	for _, old := range placed {
+		for _, key := range []string{old, moves[old]} {
+			moved = append(moved, d.recordPath(collection, key), d.expiryPath(collection, key), d.checksumPath(collection, key))
+		}
+	}
+	if err := d.mirror(op.log, moved...); err != nil {
+		return err
+	}
+
+	d.invalidateIndexes(collection)
+	d.references.reset(collection)
+	d.countWrite(collection)
+	for _, old := range placed {
+		d.events.emit(EventDelete, collection, old)
+		d.events.emit(EventWrite, collection, moves[old])
+	}
+	op.log.Info("Remapped %d keys of %s", len(placed), collection)
+	return nil
+}
+
+// moveRecord renames a record and its sidecars, suffixing the
+// source and target file names with from and to.
+func (d *Driver) moveRecord(collection, old, key, from, to string) error {
+	if err := d.renameRecord(d.recordPath(collection, old)+from, d.recordPath(collection, key)+to); err != nil {
+		return err
+	}
+	for _, sidecar := range []func(collection, resource string) string{d.expiryPath, d.checksumPath} {
+		if err := os.Rename(sidecar(collection, old)+from, sidecar(collection, key)+to); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Remapping the users to upper-case keys moves every record intact, and a
+// mapping with a collision renames nothing.
+func TestRemapKeys(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+
+	upper := func(key string) (string, error) { return strings.ToUpper(key), nil }
+	if err := d.RemapKeys("user", upper); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := d.Keys("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ALBERT", "JOHN", "NEO", "PAUL", "ROBERT", "VINCE"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys after the remap %v, want %v", keys, want)
+	}
+	for _, want := range testUsers {
+		var got User
+		if err := d.Read("user", strings.ToUpper(want.Name), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s read back %+v", want.Name, got)
+		}
+	}
+
+	same := func(string) (string, error) { return "USER", nil }
+	if err := d.RemapKeys("user", same); !errors.Is(err, ErrConflict) {
+		t.Errorf("RemapKeys with a collision = %v, want ErrConflict", err)
+	}
+	if again, err := d.Keys("user"); err != nil || !reflect.DeepEqual(again, keys) {
+		t.Errorf("keys after the refused remap %v, %v, want %v", again, err, keys)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync/atomic"
+)
+
+// verifyReplica compares a record with its MirrorDir copy on a
+// VerifyReplicaReads sample of reads, repairing the stale side when it can
+// tell which one that is.
+func (d *Driver) verifyReplica(op *operation, collection, resource string) error {
+	if d.opts.MirrorDir == "" || d.opts.VerifyReplicaReads <= 0 || rand.Float64() >= d.opts.VerifyReplicaReads {
+		return nil
+	}
+	defer d.lockCollections(d.writeScope(collection)...)()
+
+	path := d.recordPath(collection, resource)
+	primary, err := d.readStored(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	replicaPath, err := d.mirrorPath(path)
+	if err != nil {
+		return err
+	}
+	replica, err := ioutil.ReadFile(replicaPath)
+	switch {
+	case os.IsNotExist(err):
+		replica = nil
+	case err != nil:
+		return err
+	case checksum(primary) == checksum(replica):
+		return nil
+	}
+
+	atomic.AddUint64(&d.io.replicaDivergences, 1)
+	op.log.Warn("Replica of %s/%s diverges from the primary", collection, resource)
+
+	if err := d.checkWritable(collection); err != nil {
+		return nil
+	}
+	switch d.newerCopy(primary, replica) {
+	case 1:
+		return d.mirrorFile(path)
+	case -1:
+		b, err := d.decodeStored(replica)
+		if err != nil {
+			return err
+		}
+		return d.store(op, collection, resource, bytes.TrimSpace(b))
+	}
+	op.log.Warn("Cannot tell whether %s/%s or its replica is newer; not repairing", collection, resource)
+	return nil
+}
+
+// newerCopy returns 1 if the primary copy of a record is newer, -1 if the
+// replica is, and 0 if neither VersionField nor UpdatedAtField tells. A
+// missing or unreadable replica is always older.
+func (d *Driver) newerCopy(primary, replica []byte) int {
+	if replica == nil {
+		return 1
+	}
+	a, err := d.decodeStored(primary)
+	if err != nil {
+		return 0
+	}
+	b, err := d.decodeStored(replica)
+	if err != nil {
+		return 1
+	}
+
+	if field := d.opts.VersionField; field != "" {
+		docA, errA := decodeDocument(a)
+		docB, errB := decodeDocument(b)
+		if errB != nil {
+			return 1
+		}
+		if errA == nil {
+			va, errA := versionOf(docA, field)
+			vb, errB := versionOf(docB, field)
+			if errA == nil && errB == nil && va != vb {
+				if va > vb {
+					return 1
+				}
+				return -1
+			}
+		}
+	}
+	if field := d.opts.UpdatedAtField; field != "" {
+		ta, okA, _ := updatedAt(a, field)
+		tb, okB, _ := updatedAt(b, field)
+		if okA && okB && !ta.Equal(tb) {
+			if ta.After(tb) {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
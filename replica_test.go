@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Sampled reads detect a replica record that diverges from the primary,
+// count and log it, and repair the older side, or only report it when
+// neither side can be told newer.
+func TestVerifyReplicaReads(t *testing.T) {
+	log := &lineLog{}
+	d := openTest(t, &Options{MirrorDir: t.TempDir(), VerifyReplicaReads: 1, VersionField: "V", Logger: log})
+	if err := d.Write("doc", "a", versioned{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("doc", "a", versioned{Name: "second", V: 1}); err != nil {
+		t.Fatal(err)
+	}
+	primary := d.recordPath("doc", "a")
+	replica, err := d.mirrorPath(primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(want string) {
+		t.Helper()
+		var got versioned
+		if err := d.Read("doc", "a", &got); err != nil || got.Name != want {
+			t.Fatalf("read %+v, %v, want %s", got, err, want)
+		}
+	}
+	same := func() bool {
+		a, _ := os.ReadFile(primary)
+		b, _ := os.ReadFile(replica)
+		return bytes.Equal(a, b)
+	}
+
+	read("second")
+	if n := d.Stats().ReplicaDivergences; n != 0 {
+		t.Fatalf("%d divergences in a healthy replica", n)
+	}
+
+	// A stale replica is repaired from the primary.
+	if err := os.WriteFile(replica, []byte(`{"Name":"rot","V":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read("second")
+	if !same() {
+		t.Error("the stale replica was not repaired")
+	}
+
+	// A newer replica repairs the primary.
+	if err := os.WriteFile(replica, []byte(`{"Name":"newer","V":3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read("newer")
+	if !same() {
+		t.Error("the stale primary was not repaired")
+	}
+
+	// With the same version on both sides, the divergence is only reported.
+	if err := os.WriteFile(replica, []byte(`{"Name":"other","V":3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read("newer")
+	if same() {
+		t.Error("a divergence of undecidable direction was repaired")
+	}
+
+	if n := d.Stats().ReplicaDivergences; n != 3 {
+		t.Errorf("counted %d divergences, want 3", n)
+	}
+	lines := strings.Join(log.lines, "\n")
+	if strings.Count(lines, "Replica of doc/a diverges") != 3 || !strings.Contains(lines, "not repairing") {
+		t.Errorf("warnings:\n%s", lines)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReservedNameError lists user data found at paths the driver needs for its
+// own files. Use MigrateReservedNames to move it out of the way.
+type ReservedNameError struct {
+	Paths []string
+}
+
+func (e *ReservedNameError) Error() string {
+	return fmt.Sprintf("reserved paths hold user data: %s", strings.Join(e.Paths, ", "))
+}
+
+func (e *ReservedNameError) Unwrap() error { return ErrReservedNameOccupied }
+
+// validateName rejects collection and resource names that would land on a
+// reserved path.
+func validateName(collection, resource string) error {
+	if root := strings.SplitN(filepath.ToSlash(collection), "/", 2)[0]; reserved[root] {
+		return fmt.Errorf("%w: collection %s", ErrReservedName, collection)
+	}
+	if resource != "" && collectionReserved[resource+".json"] {
+		return fmt.Errorf("%w: resource %s", ErrReservedName, resource)
+	}
+	for _, name := range strings.Split(filepath.ToSlash(filepath.Join(collection, resource)), "/") {
+		if name == packsDir {
+			return fmt.Errorf("%w: %s", ErrReservedName, packsDir)
+		}
+	}
+	return nil
+}
+
+// findOccupied returns the reserved paths, relative to the database, that
+// hold user records: JSON records inside _changes or _counters, and
+// _meta.json files that are not collection metadata.
+func (d *Driver) findOccupied() ([]string, error) {
+	var occupied []string
+
+	for _, dir := range []string{changeLogDir, countersDir} {
+		files, err := ioutil.ReadDir(filepath.Join(d.dir, dir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, file := range files {
+			if !file.IsDir() && strings.Contains(file.Name(), ".json") {
+				occupied = append(occupied, dir)
+				break
+			}
+		}
+	}
+
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(d.dir, entry.Name(), metaFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isMeta(b) {
+			occupied = append(occupied, metaPath(entry.Name()))
+		}
+	}
+
+	sort.Strings(occupied)
+	return occupied, nil
+}
+
+// metaPath names a collection's metadata file the way reserved paths are
+// reported.
+func metaPath(collection string) string {
+	return collection + "/" + strings.TrimSuffix(metaFile, ".json")
+}
+
+func isMeta(b []byte) bool {
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	var meta collectionMeta
+	return dec.Decode(&meta) == nil
+}
+
+// checkOccupied returns a *ReservedNameError if any of paths held user data
+// when the database was opened.
+func (d *Driver) checkOccupied(paths ...string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var clash []string
+	for _, path := range paths {
+		for _, occupied := range d.occupied {
+			if occupied == path {
+				clash = append(clash, path)
+			}
+		}
+	}
+	if len(clash) > 0 {
+		return &ReservedNameError{Paths: clash}
+	}
+	return nil
+}
+
+// MigrateReservedNames moves user data off reserved paths. mapping is keyed
+// by the paths reported in a ReservedNameError: "_changes" and "_counters"
+// map to the collection their records move into, and "<collection>/_meta"
+// maps to the new resource name within the same collection. Every move is a
+// rename, and existing targets are never overwritten.
+func (d *Driver) MigrateReservedNames(mapping map[string]string) (err error) {
+	op := d.trace(nil, "MigrateReservedNames", "", "")
+	defer func() { op.end(err) }()
+
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(mapping))
+	for path := range mapping {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		target := mapping[path]
+		if target == "" {
+			return fmt.Errorf("%s: target is required", path)
+		}
+
+		switch path {
+		case changeLogDir, countersDir:
+			if err := validateName(target, ""); err != nil {
+				return err
+			}
+			err = d.migrateRecords(path, target)
+		default:
+			collection := strings.TrimSuffix(path, metaPath(""))
+			if collection == path {
+				return fmt.Errorf("%s is not a reserved path", path)
+			}
+			if err := validateName(collection, target); err != nil {
+				return err
+			}
+			err = d.migrateMeta(collection, target)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		op.log.Info("Moved user data from %s to %s", path, target)
+	}
+
+	occupied, err := d.findOccupied()
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	d.occupied = occupied
+	d.mutex.Unlock()
+
+	return d.loadMeta()
+}
+
+func (d *Driver) migrateRecords(dir, collection string) error {
+	defer d.lockCollections(dir, collection)()
+	defer d.listings.invalidate(collection)
+	defer d.references.reset(collection)
+	defer d.invalidateIndexes(collection)
+
+	files, err := ioutil.ReadDir(filepath.Join(d.dir, dir))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(d.dir, collection), 0755); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.Contains(file.Name(), ".json") {
+			continue
+		}
+
+		to := filepath.Join(d.dir, collection, file.Name())
+		if _, err := os.Lstat(to); err == nil {
+			return fmt.Errorf("%s already exists", to)
+		}
+		if err := d.rename(filepath.Join(d.dir, dir, file.Name()), to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) migrateMeta(collection, resource string) error {
+	defer d.lockCollections(collection)()
+	defer d.listings.invalidate(collection)
+	defer d.references.reset(collection)
+	defer d.invalidateIndexes(collection)
+
+	from := filepath.Join(d.dir, collection, metaFile)
+	to := filepath.Join(d.dir, collection, d.fileKey(resource)+".json")
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("%s already exists", to)
+	}
+
+	b, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if isMeta(b) {
+		return fmt.Errorf("%s holds collection metadata", from)
+	}
+
+	if d.opts.Compression != CompressionNone || d.aead != nil || d.pipelineOf(collection) != nil {
+		if b, err = d.encodeForStore(collection, b); err != nil {
+			return err
+		}
+		to += d.opts.Compression.ext()
+		if err := d.writeFile(to+".tmp", b, true); err != nil {
+			return err
+		}
+		if err := d.rename(to+".tmp", to); err != nil {
+			return err
+		}
+		return os.Remove(from)
+	}
+	return d.rename(from, to)
+}
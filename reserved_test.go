@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Enabling the change log over a user collection named _changes fails with
+// a ReservedNameError until MigrateReservedNames moves the records out.
+func TestChangeLogOverUserData(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, changeLogDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b := []byte(`{"Name": "John"}`)
+	if err := os.WriteFile(filepath.Join(dir, changeLogDir, "John.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var rerr *ReservedNameError
+	_, err := New(dir, &Options{Logger: nopLog{}, ChangeLog: true})
+	if !errors.Is(err, ErrReservedNameOccupied) || !errors.As(err, &rerr) {
+		t.Fatalf("New with ChangeLog = %v, want a ReservedNameError", err)
+	}
+	if !reflect.DeepEqual(rerr.Paths, []string{changeLogDir}) {
+		t.Errorf("the error lists %v, want [%s]", rerr.Paths, changeLogDir)
+	}
+
+	d := reopenTest(t, dir, nil)
+	if err := d.MigrateReservedNames(map[string]string{changeLogDir: "changes"}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = reopenTest(t, dir, &Options{ChangeLog: true})
+	var u User
+	if err := d.Read("changes", "John", &u); err != nil || u.Name != "John" {
+		t.Errorf("the migrated record reads %+v, %v", u, err)
+	}
+}
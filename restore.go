@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// RestoreCollection replaces the records of collection with the ones it
+// has in a backup read from r, leaving every other collection untouched. The
+// backup is a JSON object mapping collections to resources to documents,
+// as written by encoding the result of Dump or read by LoadFixtures.
+func (d *Driver) RestoreCollection(collection string, r io.Reader) (err error) {
+	op := d.trace(nil, "RestoreCollection", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	docs, err := readBackup(r, collection)
+	if err != nil {
+		return err
+	}
+	_, err = d.load(map[string]map[string]json.RawMessage{collection: docs}, FixtureOptions{Truncate: true})
+	return err
+}
+
+// RestoreResource writes back a single record from a backup read from r.
+func (d *Driver) RestoreResource(collection, resource string, r io.Reader) (err error) {
+	op := d.trace(nil, "RestoreResource", collection, resource)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if resource == "" {
+		return fmt.Errorf("resource is required")
+	}
+	docs, err := readBackup(r, collection)
+	if err != nil {
+		return err
+	}
+	b, ok := docs[resource]
+	if !ok {
+		return fmt.Errorf("%w: %s/%s in backup", ErrNotFound, collection, resource)
+	}
+	return d.Write(collection, resource, b)
+}
+
+// readBackup returns the records of collection in a backup, decoding only
+// that collection.
+func readBackup(r io.Reader, collection string) (map[string]json.RawMessage, error) {
+	var backup map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	raw, ok := backup[collection]
+	if !ok {
+		return nil, fmt.Errorf("%w: collection %s in backup", ErrNotFound, collection)
+	}
+	var docs map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &docs); err != nil {
+		return nil, fmt.Errorf("backup of %s: %w", collection, err)
+	}
+	return docs, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Restoring the users from a full backup undoes every change made to them
+// since, leaving the orders as they are now, and a single order can then be
+// restored on its own.
+func TestRestoreCollection(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	for _, id := range []string{"0", "1"} {
+		if err := d.Write("order", id, order{id, "John"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dump, err := d.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := json.Marshal(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Delete("user", "John"); err != nil {
+		t.Fatal(err)
+	}
+	paul := testUsers[1]
+	paul.Company = "Microsoft"
+	if err := d.Write("user", "Paul", paul); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("user", "Zed", User{Name: "Zed"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"0", "1"} {
+		if err := d.Write("order", id, order{id, "Paul"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.RestoreCollection("user", bytes.NewReader(backup)); err != nil {
+		t.Fatal(err)
+	}
+	var users []User
+	if err := d.ReadAllInto("user", &users); err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]User)
+	for _, u := range users {
+		byName[u.Name] = u
+	}
+	want := make(map[string]User)
+	for _, u := range testUsers {
+		want[u.Name] = u
+	}
+	if !reflect.DeepEqual(byName, want) {
+		t.Errorf("users after the restore %+v", users)
+	}
+	for _, id := range []string{"0", "1"} {
+		var o order
+		if err := d.Read("order", id, &o); err != nil || o.User != "Paul" {
+			t.Errorf("restoring the users changed order %s to %+v, %v", id, o, err)
+		}
+	}
+
+	if err := d.RestoreResource("order", "0", bytes.NewReader(backup)); err != nil {
+		t.Fatal(err)
+	}
+	for id, user := range map[string]string{"0": "John", "1": "Paul"} {
+		var o order
+		if err := d.Read("order", id, &o); err != nil || o.User != user {
+			t.Errorf("order %s after restoring order 0 = %+v, %v, want its user %s", id, o, err, user)
+		}
+	}
+
+	if err := d.RestoreCollection("invoice", bytes.NewReader(backup)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restoring a collection missing from the backup = %v, want ErrNotFound", err)
+	}
+	if err := d.RestoreResource("order", "9", bytes.NewReader(backup)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restoring a record missing from the backup = %v, want ErrNotFound", err)
+	}
+}
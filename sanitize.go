@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// windowsDevices are file names Windows refuses regardless of extension.
+var windowsDevices = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeKey is the default key sanitizer. It percent-encodes characters
+// that are invalid in file names on some platform: control characters,
+// path separators, <>:"|?*, a trailing space or dot, and the first letter
+// of a Windows device name. '%' itself is encoded so UnsanitizeKey can
+// always reverse it.
+func SanitizeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		trailing := i == len(key)-1 && (c == ' ' || c == '.')
+		if c < 0x20 || c == 0x7f || strings.IndexByte(`<>:"/\|?*%`, c) >= 0 || trailing {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	s := b.String()
+
+	base := strings.ToUpper(s)
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if windowsDevices[base] {
+		s = fmt.Sprintf("%%%02X", s[0]) + s[1:]
+	}
+	return s
+}
+
+// UnsanitizeKey reverses SanitizeKey. Malformed escapes are kept as they
+// are.
+func UnsanitizeKey(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) {
+			if c, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// fileKey returns the file name, without extension, used for resource.
+func (d *Driver) fileKey(resource string) string {
+	if resource == "" {
+		return ""
+	}
+	name := SanitizeKey(resource)
+	switch {
+	case d.opts.SanitizeKey != nil:
+		name = d.opts.SanitizeKey(resource)
+	case d.rawKeys:
+		name = resource
+	}
+	if max := d.opts.MaxKeyLength; max > 0 && len(name) > max {
+		return hashedName(name, resource, max)
+	}
+	return name
+}
+
+// keyOf reverses fileKey. A custom SanitizeKey without UnsanitizeKey
+// lists resources under their sanitized names, and a database with raw
+// keys under their file names.
+func (d *Driver) keyOf(name string) string {
+	if key, ok := d.longKey(name); ok {
+		return key
+	}
+	switch {
+	case d.opts.UnsanitizeKey != nil:
+		return d.opts.UnsanitizeKey(name)
+	case d.opts.SanitizeKey != nil, d.rawKeys:
+		return name
+	}
+	return UnsanitizeKey(name)
+}
+
+// The manifest records how a database names its record files. Those
+// written before keys were encoded, which have no manifest or an adopted
+// one, store keys as they are and keep doing so until opened with
+// MigrateKeyEncoding, since the encoding would otherwise hide every record
+// whose key has a '%' or another encoded character.
+const (
+	keyEncodingPercent = "percent"
+	keyEncodingRaw     = "raw"
+)
+
+func (m *Manifest) rawKeys() bool {
+	return m.KeyEncoding == keyEncodingRaw || m.KeyEncoding == "" && m.Adopted
+}
+
+func (d *Driver) keyEncoding() string {
+	if d.rawKeys {
+		return keyEncodingRaw
+	}
+	return keyEncodingPercent
+}
+
+type keyMove struct {
+	dir, key, from, suffix string
+}
+
+// migrateKeyEncoding renames the files of a database with raw keys to
+// their SanitizeKey names: records and their sidecars in every collection,
+// nested ones included, and record backups. It runs within New, before the
+// driver is handed out.
+func (d *Driver) migrateKeyEncoding() error {
+	if d.opts.SanitizeKey != nil {
+		return fmt.Errorf("MigrateKeyEncoding does not apply with a custom SanitizeKey")
+	}
+
+	var moves []keyMove
+	err := filepath.Walk(d.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(d.dir, path)
+		if fi.IsDir() {
+			if reserved[rel] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(rel) == "." {
+			return nil
+		}
+		for _, suffix := range []string{d.ext(), expiresExt, checksumExt} {
+			if strings.HasSuffix(fi.Name(), suffix) && !collectionReserved[fi.Name()] {
+				from := strings.TrimSuffix(fi.Name(), suffix)
+				moves = append(moves, keyMove{filepath.Dir(path), d.keyOf(from), from, suffix})
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if d.opts.BackupDir != "" {
+		if moves, err = d.backupKeyMoves(moves); err != nil {
+			return err
+		}
+	}
+
+	d.rawKeys = false
+	renamed := 0
+	for _, m := range moves {
+		to := d.fileKey(m.key)
+		if to == m.from {
+			continue
+		}
+		target := filepath.Join(m.dir, to+m.suffix)
+		if _, err := os.Lstat(target); err == nil {
+			d.rawKeys = true
+			return fmt.Errorf("cannot migrate %s: %s already exists", filepath.Join(m.dir, m.from+m.suffix), target)
+		}
+		if err := d.recordLongKey(m.key); err != nil {
+			return err
+		}
+		if err := d.rename(filepath.Join(m.dir, m.from+m.suffix), target); err != nil {
+			d.rawKeys = true
+			return err
+		}
+		renamed++
+	}
+	if err := os.RemoveAll(filepath.Join(d.dir, keysDir)); err != nil {
+		return err
+	}
+
+	m, err := readManifest(d.dir)
+	if err != nil {
+		return err
+	}
+	m.KeyEncoding = keyEncodingPercent
+	if err := writeManifest(d.dir, *m); err != nil {
+		return err
+	}
+	d.log.Info("Migrated %s to encoded keys, renaming %d files", d.dir, renamed)
+	return nil
+}
+
+// backupKeyMoves adds to moves the backup directories, one per record,
+// kept under BackupDir for the collections that moves touch.
+func (d *Driver) backupKeyMoves(moves []keyMove) ([]keyMove, error) {
+	collections := make(map[string]bool)
+	for _, m := range moves {
+		collections[m.dir] = true
+	}
+	for dir := range collections {
+		rel, _ := filepath.Rel(d.dir, dir)
+		entries, err := ioutil.ReadDir(filepath.Join(d.opts.BackupDir, rel))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// A directory of the same name in the database is a nested
+			// collection, whose backups are a level further down.
+			if fi, err := os.Stat(filepath.Join(dir, entry.Name())); entry.IsDir() && (err != nil || !fi.IsDir()) {
+				moves = append(moves, keyMove{filepath.Join(d.opts.BackupDir, rel), d.keyOf(entry.Name()), entry.Name(), ""})
+			}
+		}
+	}
+	return moves, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestReservedKeyRoundTrip(t *testing.T) {
+	d := openTest(t, nil)
+
+	keys := []string{`a:b/c?*"<>| .`, "50%", "CON", "plain"}
+	for i, key := range keys {
+		if err := d.Write("keys", key, i); err != nil {
+			t.Fatalf("Write(%q): %s", key, err)
+		}
+	}
+
+	for i, key := range keys {
+		var n int
+		if err := d.Read("keys", key, &n); err != nil {
+			t.Fatalf("Read(%q): %s", key, err)
+		}
+		if n != i {
+			t.Errorf("Read(%q) = %d, want %d", key, n, i)
+		}
+	}
+
+	got, err := d.Keys("keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]string(nil), keys...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys = %q, want %q", got, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(d.dir, "keys", "50%25.json")); err != nil {
+		t.Errorf("50%% is not stored percent-encoded: %s", err)
+	}
+
+	for _, key := range keys {
+		if err := d.Delete("keys", key); err != nil {
+			t.Fatalf("Delete(%q): %s", key, err)
+		}
+	}
+	if got, err := d.Keys("keys"); err != nil || len(got) != 0 {
+		t.Errorf("Keys after Delete = %q, %v", got, err)
+	}
+}
+
+func TestRawKeysStayReadable(t *testing.T) {
+	dir := t.TempDir()
+
+	// A database written before keys were encoded has no manifest and
+	// records named after their keys.
+	legacy := map[string]string{"a:b": "a%3Ab", "50%": "50%25", "plain": "plain"}
+	if err := os.MkdirAll(filepath.Join(dir, "keys"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key := range legacy {
+		if err := ioutil.WriteFile(filepath.Join(dir, "keys", key+".json"), []byte(`"`+key+`"`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(d *Driver) {
+		t.Helper()
+		for key := range legacy {
+			var v string
+			if err := d.Read("keys", key, &v); err != nil {
+				t.Fatalf("Read(%q): %s", key, err)
+			}
+			if v != key {
+				t.Errorf("Read(%q) = %q", key, v)
+			}
+		}
+		got, err := d.Keys("keys")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		if want := []string{"50%", "a:b", "plain"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Keys = %q, want %q", got, want)
+		}
+	}
+
+	d := reopenTest(t, dir, nil)
+	check(d)
+	if err := d.Write("keys", "new%", "new%"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keys", "new%.json")); err != nil {
+		t.Errorf("new record of a database with raw keys is not stored raw: %s", err)
+	}
+	if err := d.Delete("keys", "new%"); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = reopenTest(t, dir, &Options{MigrateKeyEncoding: true})
+	check(d)
+	for _, name := range legacy {
+		if _, err := os.Stat(filepath.Join(dir, "keys", name+".json")); err != nil {
+			t.Errorf("record not migrated to %s: %s", name, err)
+		}
+	}
+	d.Close()
+
+	d = reopenTest(t, dir, nil)
+	check(d)
+	m, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.KeyEncoding != keyEncodingPercent {
+		t.Errorf("KeyEncoding = %q after migration, want %q", m.KeyEncoding, keyEncodingPercent)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "sync/atomic"
+
+type Stats struct {
+	ThrottledWrites uint64
+	RejectedWrites  uint64
+	ThrottledReads  uint64
+	RejectedReads   uint64
+	// Throttling lists the rate limit buckets currently holding calls back.
+	Throttling []string
+
+	DefaultReads  uint64
+	StrongReads   uint64
+	CachedReads   uint64
+	SnapshotReads uint64
+
+	// ReplicaDivergences counts the records found to differ from their
+	// MirrorDir copy by VerifyReplicaReads.
+	ReplicaDivergences uint64
+
+	// CollectionWrites counts record writes and deletes per collection.
+	CollectionWrites map[string]uint64
+}
+
+// Stats reports what the Driver did since it was created beyond the file
+// operations IOStats counts: rate limiting, reads by consistency level,
+// replica checks and writes per collection.
+func (d *Driver) Stats() Stats {
+	var stats Stats
+	stats.ThrottledWrites, stats.RejectedWrites = d.writeLimit.counts()
+	stats.ThrottledReads, stats.RejectedReads = d.readLimit.counts()
+	stats.Throttling = append(d.writeLimit.engaged(), d.readLimit.engaged()...)
+
+	c := &d.io
+	stats.DefaultReads = atomic.LoadUint64(&c.consistency[ConsistencyDefault])
+	stats.StrongReads = atomic.LoadUint64(&c.consistency[ConsistencyStrong])
+	stats.CachedReads = atomic.LoadUint64(&c.consistency[ConsistencyCached])
+	stats.SnapshotReads = atomic.LoadUint64(&c.consistency[ConsistencySnapshot])
+	stats.ReplicaDivergences = atomic.LoadUint64(&c.replicaDivergences)
+
+	d.maint.mutex.Lock()
+	stats.CollectionWrites = make(map[string]uint64, len(d.maint.writes))
+	for collection, n := range d.maint.writes {
+		stats.CollectionWrites[collection] = n
+	}
+	d.maint.mutex.Unlock()
+
+	return stats
+}
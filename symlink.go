@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// rejectSymlinks refuses paths that are symlinks unless FollowSymlinks is
+// set, so a planted link cannot make the driver read or clobber files
+// outside the database.
+func (d *Driver) rejectSymlinks(log Logger, paths ...string) error {
+	if d.opts.FollowSymlinks {
+		return nil
+	}
+
+	for _, path := range paths {
+		fi, err := os.Lstat(path)
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			log.Warn("Refusing to follow symlink %s", path)
+			return fmt.Errorf("%w: %s", ErrSymlink, path)
+		}
+	}
+	return nil
+}
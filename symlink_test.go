@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A symlink planted where a record should be is neither read through nor
+// written through, unless FollowSymlinks is set.
+func TestPlantedSymlink(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.json")
+	if err := os.WriteFile(outside, []byte(`{"Name": "secret"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, follow := range []bool{false, true} {
+		d := openTest(t, &Options{FollowSymlinks: follow})
+		seedUsers(t, d)
+		record := d.recordPath("user", "John")
+		if err := os.Remove(record); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, record); err != nil {
+			t.Fatal(err)
+		}
+
+		var u User
+		err := d.Read("user", "John", &u)
+		if follow {
+			if err != nil || u.Name != "secret" {
+				t.Errorf("Read following symlinks = %+v, %v", u, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrSymlink) {
+			t.Errorf("Read through a symlink = %v, want ErrSymlink", err)
+		}
+		if err := d.Write("user", "John", testUsers[0]); !errors.Is(err, ErrSymlink) {
+			t.Errorf("Write through a symlink = %v, want ErrSymlink", err)
+		}
+		if b, _ := os.ReadFile(outside); string(b) != `{"Name": "secret"}` {
+			t.Errorf("the link target was overwritten with %s", b)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSyncTo(t *testing.T) {
+	src, dst := openTest(t, nil), openTest(t, nil)
+	for _, w := range []struct{ collection, resource, v string }{
+		{"a", "same", "1"},
+		{"a", "changed", "new"},
+		{"a", "missing", "3"},
+	} {
+		if err := src.Write(w.collection, w.resource, w.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, w := range []struct{ collection, resource, v string }{
+		{"a", "same", "1"},
+		{"a", "changed", "old"},
+		{"a", "extra", "4"},
+		{"b", "extra", "5"},
+	} {
+		if err := dst.Write(w.collection, w.resource, w.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := src.SyncTo(dst, SyncOptions{PruneExtra: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SyncStats{Copied: 2, Skipped: 1, Deleted: 2}); stats != want {
+		t.Errorf("SyncTo = %+v, want %+v", stats, want)
+	}
+
+	var v string
+	if err := dst.Read("a", "changed", &v); err != nil || v != "new" {
+		t.Errorf("a/changed = %q, %v", v, err)
+	}
+	for _, collection := range []string{"a", "b"} {
+		if err := dst.Read(collection, "extra", &v); err == nil {
+			t.Errorf("%s/extra survived PruneExtra", collection)
+		}
+	}
+
+	if _, err := src.SyncTo(src, SyncOptions{}); err == nil {
+		t.Error("SyncTo itself succeeded")
+	}
+}
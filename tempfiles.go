@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempFilePolicy decides what New does with .tmp files left behind by
+// writes that were interrupted before their final rename.
+type TempFilePolicy int
+
+const (
+	// TempFilesKeep leaves them alone.
+	TempFilesKeep TempFilePolicy = iota
+	// TempFilesPromote renames a temp file over its record when the record
+	// is missing or torn and the temp file holds a complete document, and
+	// discards every other temp file.
+	TempFilesPromote
+	// TempFilesDiscard removes every temp file.
+	TempFilesDiscard
+)
+
+func (p TempFilePolicy) String() string {
+	switch p {
+	case TempFilesKeep:
+		return "keep"
+	case TempFilesPromote:
+		return "promote"
+	case TempFilesDiscard:
+		return "discard"
+	}
+	return fmt.Sprintf("TempFilePolicy(%d)", int(p))
+}
+
+// recoverTempFiles applies RecoverTempFiles to every collection. It only
+// runs on a writable driver that holds the writer lease, so it never
+// touches the temp file of a write in flight in another process.
+func (d *Driver) recoverTempFiles() error {
+	if d.opts.RecoverTempFiles == TempFilesKeep || d.readOnly || !d.IsPrimary() {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+		if _, err := d.recoverCollectionTempFiles(entry.Name(), d.opts.RecoverTempFiles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverCollectionTempFiles applies policy to the temp files of
+// collection and returns how many it promoted or removed. Temp files of
+// the collection's reserved files are written without the collection
+// mutex and are left alone.
+func (d *Driver) recoverCollectionTempFiles(collection string, policy TempFilePolicy) (int, error) {
+	dir := filepath.Join(d.dir, collection)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	handled := 0
+	promoted := false
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".tmp") || collectionReserved[strings.TrimSuffix(name, ".tmp")] {
+			continue
+		}
+		temp := filepath.Join(dir, name)
+		record := strings.TrimSuffix(temp, ".tmp")
+		handled++
+
+		if policy == TempFilesPromote && d.isRecord(filepath.Base(record)) && d.promotable(temp, record) {
+			if err := d.rename(temp, record); err != nil {
+				return handled, err
+			}
+			if err := d.mirror(d.log, record); err != nil {
+				return handled, err
+			}
+			d.log.Warn("Promoted %s left by an interrupted write", temp)
+			promoted = true
+			d.events.emit(EventWrite, collection, d.resourceOf(filepath.Base(record)))
+			continue
+		}
+
+		if err := os.Remove(temp); err != nil && !os.IsNotExist(err) {
+			return handled, err
+		}
+		d.log.Warn("Discarded %s left by an interrupted write", temp)
+	}
+
+	if promoted {
+		d.references.reset(collection)
+		d.invalidateIndexes(collection)
+	}
+	return handled, nil
+}
+
+func (d *Driver) promotable(temp, record string) bool {
+	b, err := d.readRecord(d.log, record)
+	if err == nil && !isTorn(b, nil) {
+		return false
+	}
+	if err != nil && !os.IsNotExist(err) && !isTorn(nil, err) {
+		return false
+	}
+	tb, err := d.readRecord(d.log, temp)
+	return err == nil && json.Valid(tb)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// On New a complete temp file with no record is promoted or discarded per
+// RecoverTempFiles, a broken one never replaces its record, and with the
+// default policy both are left alone.
+func TestRecoverTempFiles(t *testing.T) {
+	for _, policy := range []TempFilePolicy{TempFilesKeep, TempFilesPromote, TempFilesDiscard} {
+		t.Run(policy.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			d := reopenTest(t, dir, nil)
+			seedUsers(t, d)
+			d.Close()
+
+			ann := User{Name: "Ann", Age: "40", Address: Address{Code: "1"}}
+			b, err := d.marshal(ann)
+			if err != nil {
+				t.Fatal(err)
+			}
+			annTemp, johnTemp := d.recordPath("user", "Ann")+".tmp", d.recordPath("user", "John")+".tmp"
+			if err := os.WriteFile(annTemp, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(johnTemp, []byte(`{"Name": "Jo`), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			d = reopenTest(t, dir, &Options{RecoverTempFiles: policy})
+			var u User
+			err = d.Read("user", "Ann", &u)
+			if policy == TempFilesPromote {
+				if err != nil || u != ann {
+					t.Errorf("the promoted record reads %+v, %v", u, err)
+				}
+			} else if !errors.Is(err, ErrNotFound) {
+				t.Errorf("Read of Ann = %v, want ErrNotFound", err)
+			}
+			if err := d.Read("user", "John", &u); err != nil || u != testUsers[0] {
+				t.Errorf("John reads %+v, %v, want the record untouched", u, err)
+			}
+			for _, temp := range []string{annTemp, johnTemp} {
+				_, err := os.Stat(temp)
+				if kept := err == nil; kept != (policy == TempFilesKeep) {
+					t.Errorf("%s kept = %t", temp, kept)
+				}
+			}
+		})
+	}
+}
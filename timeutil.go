@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type TimeError struct {
+	Resource string
+	Field    string
+	Value    interface{}
+	Err      error
+}
+
+func (e TimeError) Error() string {
+	return fmt.Sprintf("%s: field %s: %v: %s", e.Resource, e.Field, e.Value, e.Err)
+}
+
+// ParseTime converts a stored timestamp into a time.Time. Strings are parsed
+// with layout when given, otherwise as RFC3339/RFC3339Nano or a numeric epoch.
+// Epoch values are interpreted as seconds, milliseconds, microseconds or
+// nanoseconds depending on their magnitude.
+func ParseTime(v interface{}, layout string) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		if layout != "" {
+			t, err := time.Parse(layout, v)
+			return t.UTC(), err
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.UTC(), nil
+		}
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return epoch(n), nil
+		}
+		return time.Time{}, fmt.Errorf("unrecognized time format %q", v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return epoch(n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(f*float64(time.Second))).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))).UTC(), nil
+	case int64:
+		return epoch(v), nil
+	case int:
+		return epoch(int64(v)), nil
+	case nil:
+		return time.Time{}, fmt.Errorf("missing time value")
+	}
+	return time.Time{}, fmt.Errorf("unsupported time value of type %T", v)
+}
+
+func epoch(n int64) time.Time {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= 1e17:
+		return time.Unix(0, n).UTC()
+	case abs >= 1e14:
+		return time.UnixMicro(n).UTC()
+	case abs >= 1e11:
+		return time.UnixMilli(n).UTC()
+	}
+	return time.Unix(n, 0).UTC()
+}
+
+func (q *QueryBuilder) WhereTime(field string, op string, t time.Time) *QueryBuilder {
+	layout := q.d.opts.TimeLayouts[field]
+
+	q.filters = append(q.filters, func(q *QueryBuilder, resource string, doc map[string]interface{}) bool {
+		v, _ := lookup(doc, field)
+		got, err := ParseTime(v, layout)
+		if err != nil {
+			q.unparsed = append(q.unparsed, TimeError{resource, field, v, err})
+			return false
+		}
+
+		switch op {
+		case "=", "==":
+			return got.Equal(t)
+		case "!=":
+			return !got.Equal(t)
+		case "<":
+			return got.Before(t)
+		case "<=":
+			return !got.After(t)
+		case ">":
+			return got.After(t)
+		case ">=":
+			return !got.Before(t)
+		}
+		q.unparsed = append(q.unparsed, TimeError{resource, field, v, fmt.Errorf("unknown operator %q", op)})
+		return false
+	})
+	return q
+}
+
+// Unparsed reports the records the last query skipped because a time field
+// was missing or could not be parsed.
+func (q *QueryBuilder) Unparsed() []TimeError {
+	return q.unparsed
+}
+
+// FindByTime returns the resources whose time field falls in [from, to),
+// in name order. With an index on field it parses each distinct indexed
+// value once and reads no records; otherwise it scans the collection.
+// Records whose field is missing or unparseable are left out.
+func (d *Driver) FindByTime(collection, field string, from, to time.Time) (_ []string, err error) {
+	op := d.trace(nil, "FindByTime", collection, field)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return nil, fmt.Errorf("collection is required")
+	}
+	layout := d.opts.TimeLayouts[field]
+
+	defer d.lockCollections(collection)()
+
+	idx, ok := d.indexes.of(collection)[field]
+	if !ok {
+		idx = &index{}
+		op.log.Debug("No index on %s.%s; scanning", collection, field)
+	}
+	if !idx.built {
+		if err := d.buildIndex(collection, field, idx); err != nil {
+			return nil, err
+		}
+	}
+
+	var resources []string
+	for key, holders := range idx.entries {
+		dec := json.NewDecoder(bytes.NewReader([]byte(key)))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			continue
+		}
+		t, err := ParseTime(v, layout)
+		if err != nil || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		for resource := range holders {
+			// Expiry does not update the index.
+			if d.checkExpiry(collection, resource) == nil {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	sort.Strings(resources)
+	return resources, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A time range matches timestamps stored as RFC 3339 strings, epoch seconds
+// and epoch milliseconds alike, and with an index reads no records.
+func TestTimeRange(t *testing.T) {
+	d := openTest(t, nil)
+	events := map[string]interface{}{
+		"rfc3339": "2024-01-01T10:00:00Z",
+		"seconds": 1704106800,    // 11:00
+		"millis":  1704110400000, // 12:00
+		"later":   "2024-01-02T00:00:00Z",
+		"garbled": "yesterday",
+	}
+	for resource, at := range events {
+		if err := d.Write("event", resource, map[string]interface{}{"At": at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)
+	want := []string{"millis", "rfc3339", "seconds"}
+
+	var docs []map[string]interface{}
+	q := d.Find("event").WhereTime("At", ">=", from).WhereTime("At", "<", to)
+	if err := q.Collect(&docs); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != len(want) {
+		t.Errorf("WhereTime matched %d records, want %d", len(docs), len(want))
+	}
+	if unparsed := q.Unparsed(); len(unparsed) == 0 || unparsed[0].Resource != "garbled" {
+		t.Errorf("Unparsed = %v, want the garbled record", unparsed)
+	}
+
+	if got, err := d.FindByTime("event", "At", from, to); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("FindByTime by scan = %v, %v, want %v", got, err, want)
+	}
+
+	if err := d.CreateIndex("event", "At"); err != nil {
+		t.Fatal(err)
+	}
+	reads := d.IOStats().Reads
+	if got, err := d.FindByTime("event", "At", from, to); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("FindByTime by index = %v, %v, want %v", got, err, want)
+	}
+	if n := d.IOStats().Reads - reads; n != 0 {
+		t.Errorf("FindByTime with an index read %d files", n)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	authDir = "_auth"
+
+	// maxAuthFailures failed attempts from one client within
+	// authFailureWindow make TokenAuth turn it away until the window ends.
+	maxAuthFailures   = 10
+	authFailureWindow = time.Minute
+)
+
+// Access is what a token may do with a collection.
+type Access int
+
+const (
+	AccessRead Access = 1 << iota
+	AccessWrite
+
+	AccessReadWrite = AccessRead | AccessWrite
+)
+
+type (
+	// TokenInfo describes an API token. Scopes grants access by collection
+	// name; the scope "*" applies to every collection.
+	TokenInfo struct {
+		ID      string
+		Name    string
+		Scopes  map[string]Access
+		Created time.Time
+	}
+
+	// storedToken is the file _auth/<id>.json. Only the SHA-256 of the
+	// token's secret is kept.
+	storedToken struct {
+		TokenInfo
+		Hash string
+	}
+
+	// AuthOptions configures TokenAuth.
+	AuthOptions struct {
+		// Collection returns the collection a request addresses, by default
+		// the first segment of its URL path.
+		Collection func(r *http.Request) string
+		// MaskProfile names the mask profile, see Options.Masks, applied to
+		// the reads of requests made with a token, by way of the request
+		// context. The empty name applies none.
+		MaskProfile func(t TokenInfo) string
+	}
+
+	tokenSet struct {
+		mutex    sync.Mutex
+		cache    map[string]cachedToken
+		failures map[string]*authFailures
+	}
+
+	cachedToken struct {
+		token   storedToken
+		modTime time.Time
+	}
+
+	authFailures struct {
+		start time.Time
+		n     int
+	}
+
+	tokenKey struct{}
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "r"
+	case AccessWrite:
+		return "w"
+	case AccessReadWrite:
+		return "rw"
+	}
+	return strconv.Itoa(int(a))
+}
+
+func (a Access) MarshalText() ([]byte, error) {
+	if a&^AccessReadWrite != 0 || a == 0 {
+		return nil, fmt.Errorf("invalid access %d", int(a))
+	}
+	return []byte(a.String()), nil
+}
+
+func (a *Access) UnmarshalText(b []byte) error {
+	access, err := ParseAccess(string(b))
+	if err != nil {
+		return err
+	}
+	*a = access
+	return nil
+}
+
+// ParseAccess parses "r", "w" or "rw".
+func ParseAccess(s string) (Access, error) {
+	switch s {
+	case "r":
+		return AccessRead, nil
+	case "w":
+		return AccessWrite, nil
+	case "rw":
+		return AccessReadWrite, nil
+	}
+	return 0, fmt.Errorf("invalid access %q, want r, w or rw", s)
+}
+
+// Allows reports whether the token grants access to collection.
+func (t TokenInfo) Allows(collection string, access Access) bool {
+	return (t.Scopes[collection]|t.Scopes["*"])&access == access
+}
+
+func newTokenSet() *tokenSet {
+	return &tokenSet{cache: make(map[string]cachedToken), failures: make(map[string]*authFailures)}
+}
+
+// CreateToken creates a token named name granting scopes, and returns it
+// with its description. The token itself is only returned here: the
+// database keeps its hash.
+func (d *Driver) CreateToken(name string, scopes map[string]Access) (_ string, _ TokenInfo, err error) {
+	op := d.trace(nil, "CreateToken", authDir, name)
+	defer func() { op.end(err) }()
+
+	if len(scopes) == 0 {
+		return "", TokenInfo{}, fmt.Errorf("a token needs at least one scope")
+	}
+	info := TokenInfo{Name: name, Scopes: make(map[string]Access, len(scopes)), Created: time.Now().UTC()}
+	for collection, access := range scopes {
+		if collection != "*" {
+			if err := validateName(collection, ""); err != nil {
+				return "", TokenInfo{}, err
+			}
+		}
+		if access == 0 || access&^AccessReadWrite != 0 {
+			return "", TokenInfo{}, fmt.Errorf("invalid access %d for %s", int(access), collection)
+		}
+		info.Scopes[collection] = access
+	}
+	if err := d.checkWritable(); err != nil {
+		return "", TokenInfo{}, err
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", TokenInfo{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", TokenInfo{}, err
+	}
+	info.ID = hex.EncodeToString(id)
+	token := info.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+
+	b, err := json.Marshal(storedToken{TokenInfo: info, Hash: tokenHash(token)})
+	if err != nil {
+		return "", TokenInfo{}, err
+	}
+	defer d.lockCollections(authDir)()
+	if err := os.MkdirAll(filepath.Join(d.dir, authDir), 0755); err != nil {
+		return "", TokenInfo{}, err
+	}
+	path := d.tokenPath(info.ID)
+	if err := d.writeFile(path+".tmp", b, true); err != nil {
+		return "", TokenInfo{}, err
+	}
+	if err := d.syncFile(path + ".tmp"); err != nil {
+		return "", TokenInfo{}, err
+	}
+	if err := d.rename(path+".tmp", path); err != nil {
+		return "", TokenInfo{}, err
+	}
+	if err := d.syncDir(filepath.Dir(path)); err != nil {
+		return "", TokenInfo{}, err
+	}
+	op.log.Info("Created token %s (%s)", info.ID, name)
+	return token, info, nil
+}
+
+// Tokens returns the tokens of the database, oldest first.
+func (d *Driver) Tokens() (_ []TokenInfo, err error) {
+	op := d.trace(nil, "Tokens", authDir, "")
+	defer func() { op.end(err) }()
+
+	files, err := ioutil.ReadDir(filepath.Join(d.dir, authDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var infos []TokenInfo
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".json")
+		if !isTokenID(id) || id+".json" != file.Name() {
+			continue
+		}
+		t, err := d.tokens.lookup(d, id)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, t.TokenInfo)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].Created.Equal(infos[j].Created) {
+			return infos[i].Created.Before(infos[j].Created)
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
+
+// RevokeToken deletes the token id. Authorize refuses it from then on, in
+// this process and in any other using the database.
+func (d *Driver) RevokeToken(id string) (err error) {
+	op := d.trace(nil, "RevokeToken", authDir, id)
+	defer func() { op.end(err) }()
+
+	if !isTokenID(id) {
+		return fmt.Errorf("token %s: %w", id, ErrNotFound)
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	defer d.lockCollections(authDir)()
+	path := d.tokenPath(id)
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("token %s: %w", id, ErrNotFound)
+		}
+		return err
+	}
+	d.tokens.forget(id)
+	if err := d.syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	op.log.Info("Revoked token %s", id)
+	return nil
+}
+
+// Authorize checks that token grants access to collection, returning its
+// description. An unknown, revoked or malformed token is
+// ErrUnauthenticated; one lacking the scope is ErrPermissionDenied. The
+// token file is checked on every call, so a token revoked by another
+// process, e.g. `godb tokens revoke`, is refused at once.
+func (d *Driver) Authorize(token, collection string, access Access) (TokenInfo, error) {
+	id, _, _ := strings.Cut(token, ".")
+	var stored storedToken
+	if isTokenID(id) {
+		t, err := d.tokens.lookup(d, id)
+		if err != nil && !os.IsNotExist(err) {
+			return TokenInfo{}, err
+		}
+		stored = t
+	}
+
+	// Compare even when the token is unknown, so the time taken does not
+	// tell which IDs exist.
+	want := stored.Hash
+	if want == "" {
+		want = strings.Repeat("0", sha256.Size*2)
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenHash(token)), []byte(want)) != 1 || stored.Hash == "" {
+		return TokenInfo{}, ErrUnauthenticated
+	}
+	if !stored.Allows(collection, access) {
+		return stored.TokenInfo, fmt.Errorf("%w: token %s may not %s %s", ErrPermissionDenied, id, accessVerb(access), collection)
+	}
+	return stored.TokenInfo, nil
+}
+
+// TokenAuth wraps next so it only serves requests whose Authorization
+// header carries a bearer token granting access to the collection they
+// address: GET, HEAD and OPTIONS need read access, every other method
+// write access. A missing or invalid token is answered 401, a token lacking
+// the scope 403, and a client that failed too often recently 429. The
+// token's description is in the context of the requests next serves; see
+// TokenFromContext. So is its mask profile, when opts selects one.
+func (d *Driver) TokenAuth(next http.Handler, opts AuthOptions) http.Handler {
+	collectionOf := opts.Collection
+	if collectionOf == nil {
+		collectionOf = func(r *http.Request) string {
+			collection, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			return collection
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+		if wait := d.tokens.blocked(client); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+			http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		access := AccessWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			access = AccessRead
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		info, err := d.Authorize(token, collectionOf(r), access)
+		switch {
+		case err == nil:
+			ctx := context.WithValue(r.Context(), tokenKey{}, info)
+			if opts.MaskProfile != nil {
+				if profile := opts.MaskProfile(info); profile != "" {
+					ctx = WithMaskProfile(ctx, profile)
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		case errors.Is(err, ErrUnauthenticated):
+			d.tokens.failed(client)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="godb"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, ErrPermissionDenied):
+			d.tokens.failed(client)
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			d.log.Error("Unable to authorize a request for %s: %s", r.URL.Path, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	})
+}
+
+// TokenFromContext returns the token TokenAuth accepted for a request.
+func TokenFromContext(ctx context.Context) (TokenInfo, bool) {
+	info, ok := ctx.Value(tokenKey{}).(TokenInfo)
+	return info, ok
+}
+
+func (d *Driver) tokenPath(id string) string {
+	return filepath.Join(d.dir, authDir, id+".json")
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func isTokenID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func accessVerb(access Access) string {
+	if access&AccessWrite != 0 {
+		return "write"
+	}
+	return "read"
+}
+
+// lookup returns the token id, reading its file again when it changed
+// since it was cached.
+func (s *tokenSet) lookup(d *Driver, id string) (storedToken, error) {
+	path := d.tokenPath(id)
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.forget(id)
+		}
+		return storedToken{}, err
+	}
+
+	s.mutex.Lock()
+	cached, ok := s.cache[id]
+	s.mutex.Unlock()
+	if ok && cached.modTime.Equal(fi.ModTime()) {
+		return cached.token, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return storedToken{}, err
+	}
+	var t storedToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return storedToken{}, fmt.Errorf("invalid token %s: %w", path, err)
+	}
+	s.mutex.Lock()
+	s.cache[id] = cachedToken{token: t, modTime: fi.ModTime()}
+	s.mutex.Unlock()
+	return t, nil
+}
+
+func (s *tokenSet) forget(id string) {
+	s.mutex.Lock()
+	delete(s.cache, id)
+	s.mutex.Unlock()
+}
+
+// failed counts a failed attempt by client.
+func (s *tokenSet) failed(client string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	f := s.failures[client]
+	if f == nil || now.Sub(f.start) >= authFailureWindow {
+		if f == nil && len(s.failures) >= 1024 {
+			for other, g := range s.failures {
+				if now.Sub(g.start) >= authFailureWindow {
+					delete(s.failures, other)
+				}
+			}
+		}
+		f = &authFailures{start: now}
+		s.failures[client] = f
+	}
+	f.n++
+}
+
+// blocked returns how long client is still turned away for, or 0.
+func (s *tokenSet) blocked(client string) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f := s.failures[client]
+	if f == nil || f.n < maxAuthFailures {
+		return 0
+	}
+	wait := authFailureWindow - time.Since(f.start)
+	if wait <= 0 {
+		delete(s.failures, client)
+		return 0
+	}
+	return wait
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordHandler serves GET and PUT of /<collection>/<resource>.
+func recordHandler(d *Driver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collection, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch r.Method {
+		case http.MethodGet:
+			var v map[string]interface{}
+			if err := d.Read(collection, resource, &v); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			}
+		case http.MethodPut:
+			if err := d.Write(collection, resource, map[string]string{"by": r.Header.Get("Authorization")}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	})
+}
+
+func tokenRequest(t *testing.T, srv *httptest.Server, method, path, token string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// A read-only token can GET but not PUT, a revoked token is refused at
+// once, and tokens survive a reopen.
+func TestTokenAuth(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	seedUsers(t, d)
+
+	reader, _, err := d.CreateToken("reader", map[string]Access{"user": AccessRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, info, err := d.CreateToken("writer", map[string]Access{"*": AccessReadWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(d.TokenAuth(recordHandler(d), AuthOptions{}))
+	defer srv.Close()
+	for _, c := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/user/John", reader, http.StatusOK},
+		{"PUT", "/user/John", reader, http.StatusForbidden},
+		{"GET", "/other/x", reader, http.StatusForbidden},
+		{"GET", "/user/John", "", http.StatusUnauthorized},
+		{"GET", "/user/John", reader[:len(reader)-1] + "x", http.StatusUnauthorized},
+		{"PUT", "/user/John", writer, http.StatusOK},
+		{"PUT", "/other/x", writer, http.StatusOK},
+	} {
+		if got := tokenRequest(t, srv, c.method, c.path, c.token); got != c.want {
+			t.Errorf("%s %s = %d, want %d", c.method, c.path, got, c.want)
+		}
+	}
+
+	if err := d.RevokeToken(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokenRequest(t, srv, "GET", "/user/John", writer); got != http.StatusUnauthorized {
+		t.Errorf("GET with a revoked token = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if err := d.RevokeToken(info.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking twice = %v, want ErrNotFound", err)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = reopenTest(t, dir, nil)
+	tokens, err := d.Tokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "reader" || tokens[0].Scopes["user"] != AccessRead {
+		t.Fatalf("Tokens after reopen = %+v, want the reader", tokens)
+	}
+	if _, err := d.Authorize(reader, "user", AccessRead); err != nil {
+		t.Errorf("Authorize after reopen: %s", err)
+	}
+	if _, err := d.Authorize(reader, "user", AccessWrite); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Authorize a write = %v, want ErrPermissionDenied", err)
+	}
+	if err := d.Write(authDir, "x", map[string]string{}); !errors.Is(err, ErrReservedName) {
+		t.Errorf("Write to %s = %v, want ErrReservedName", authDir, err)
+	}
+}
+
+// A token revoked through another handle, as `godb tokens revoke` does, is
+// refused without a restart.
+func TestTokenRevokedElsewhere(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	token, info, err := d.CreateToken("reader", map[string]Access{"user": AccessRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authorize(token, "user", AccessRead); err != nil {
+		t.Fatal(err)
+	}
+
+	other := openUnshared(t, dir, Options{})
+	if err := other.RevokeToken(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authorize(token, "user", AccessRead); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Authorize a token revoked elsewhere = %v, want ErrUnauthenticated", err)
+	}
+}
+
+// Too many failed attempts from a client get it turned away, even with a
+// good token, until the window ends.
+func TestTokenAuthRateLimit(t *testing.T) {
+	d := openTest(t, nil)
+	token, _, err := d.CreateToken("reader", map[string]Access{"user": AccessRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(d.TokenAuth(recordHandler(d), AuthOptions{}))
+	defer srv.Close()
+
+	for i := 0; i < maxAuthFailures; i++ {
+		if got := tokenRequest(t, srv, "GET", "/user/x", "bad"); got != http.StatusUnauthorized {
+			t.Fatalf("attempt %d = %d, want %d", i, got, http.StatusUnauthorized)
+		}
+	}
+	if got := tokenRequest(t, srv, "GET", "/user/x", token); got != http.StatusTooManyRequests {
+		t.Errorf("request after %d failures = %d, want %d", maxAuthFailures, got, http.StatusTooManyRequests)
+	}
+}
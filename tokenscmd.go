@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jcelliott/lumber"
+)
+
+const tokensUsage = `usage: godb tokens [-dir DIR] create NAME COLLECTION:ACCESS...
+       godb tokens [-dir DIR] list
+       godb tokens [-dir DIR] revoke ID
+ACCESS is r, w or rw; the collection * stands for every collection.`
+
+// runTokens implements `godb tokens`, managing the API tokens TokenAuth
+// accepts.
+func runTokens(args []string) int {
+	flags := flag.NewFlagSet("tokens", flag.ContinueOnError)
+	dir := flags.String("dir", "./db", "database directory")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, tokensUsage)
+		return 2
+	}
+
+	var scopes map[string]Access
+	switch {
+	case args[0] == "create" && len(args) >= 3:
+		scopes = make(map[string]Access)
+		for _, scope := range args[2:] {
+			collection, access, ok := strings.Cut(scope, ":")
+			a, err := ParseAccess(access)
+			if !ok || collection == "" || err != nil {
+				fmt.Fprintf(os.Stderr, "invalid scope %q\n%s\n", scope, tokensUsage)
+				return 2
+			}
+			scopes[collection] |= a
+		}
+	case args[0] == "list" && len(args) == 1:
+	case args[0] == "revoke" && len(args) == 2:
+	default:
+		fmt.Fprintln(os.Stderr, tokensUsage)
+		return 2
+	}
+
+	db, err := New(*dir, &Options{Logger: lumber.NewConsoleLogger(lumber.WARN)})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "create":
+		token, info, err := db.CreateToken(args[1], scopes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "created token %s; it is not shown again\n", info.ID)
+		fmt.Println(token)
+	case "list":
+		tokens, err := db.Tokens()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, t := range tokens {
+			fmt.Printf("%s  %s  %s  %s\n", t.ID, t.Created.Format("2006-01-02T15:04:05Z"), t.Name, formatScopes(t.Scopes))
+		}
+	case "revoke":
+		if err := db.RevokeToken(args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func formatScopes(scopes map[string]Access) string {
+	list := make([]string, 0, len(scopes))
+	for collection, access := range scopes {
+		list = append(list, collection+":"+access.String())
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
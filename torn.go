@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type TornWriteError struct {
+	Record string
+	Temp   string
+}
+
+func (e *TornWriteError) Error() string {
+	if e.Temp == "" {
+		return fmt.Sprintf("torn write: %s is truncated and no temp file survived", e.Record)
+	}
+	return fmt.Sprintf("torn write: %s is truncated; %s holds a complete document", e.Record, e.Temp)
+}
+
+func (e *TornWriteError) Unwrap() error { return ErrTornWrite }
+
+// isTorn reports whether a record looks like it was cut off mid-write: the
+// decoder ran out of input before the document ended.
+func isTorn(b []byte, err error) bool {
+	if err != nil {
+		return errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return true
+	}
+	var raw json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(b))
+	return dec.Decode(&raw) == io.ErrUnexpectedEOF
+}
+
+// recoverTorn promotes the surviving temp file of a torn record when
+// AutoRecoverTornWrites is set and returns the recovered bytes; otherwise it
+// returns a *TornWriteError naming both files.
+func (d *Driver) recoverTorn(op *operation, collection, resource string) ([]byte, error) {
+	defer d.lockCollections(collection)()
+
+	record := d.recordPath(collection, resource)
+	temp := record + ".tmp"
+
+	b, err := d.readRecord(op.log, record)
+	if !isTorn(b, err) {
+		return b, err
+	}
+
+	tb, err := d.readRecord(op.log, temp)
+	if err != nil || !json.Valid(tb) {
+		return nil, &TornWriteError{Record: record}
+	}
+	if !d.opts.AutoRecoverTornWrites {
+		return nil, &TornWriteError{Record: record, Temp: temp}
+	}
+
+	if err := d.rename(temp, record); err != nil {
+		return nil, err
+	}
+	if err := d.mirror(op.log, record); err != nil {
+		return nil, err
+	}
+	d.listings.invalidate(collection)
+	d.invalidateIndexes(collection)
+	op.log.Warn("Recovered torn write of %s from %s", record, temp)
+
+	d.events.emit(EventWrite, collection, resource)
+	return tb, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// tornWrite leaves John's record as a crash between writing the temp file
+// and renaming it would: the record cut off mid-document, the temp file
+// holding the complete new one.
+func tornWrite(t *testing.T, d *Driver, want User) {
+	t.Helper()
+	record := d.recordPath("user", "John")
+	b, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(record, b[:len(b)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	nb, err := d.marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(record+".tmp", nb, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// With AutoRecoverTornWrites a torn record reads back as the surviving temp
+// file, which is promoted in its place.
+func TestTornWriteRecovered(t *testing.T) {
+	d := openTest(t, &Options{AutoRecoverTornWrites: true})
+	seedUsers(t, d)
+	want := testUsers[0]
+	want.Age = "99"
+	tornWrite(t, d, want)
+
+	var got User
+	if err := d.Read("user", "John", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("recovered %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(d.recordPath("user", "John") + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temp file was not promoted: %v", err)
+	}
+}
+
+// Without recovery a torn record is reported as a TornWriteError naming
+// both files, and ReadAll skips it instead of failing.
+func TestTornWriteReported(t *testing.T) {
+	d := openTest(t, nil)
+	seedUsers(t, d)
+	tornWrite(t, d, testUsers[0])
+
+	var torn *TornWriteError
+	var u User
+	err := d.Read("user", "John", &u)
+	if !errors.Is(err, ErrTornWrite) || !errors.As(err, &torn) {
+		t.Fatalf("Read = %v, want a TornWriteError", err)
+	}
+	if record := d.recordPath("user", "John"); torn.Record != record || torn.Temp != record+".tmp" {
+		t.Errorf("the error names %q and %q", torn.Record, torn.Temp)
+	}
+
+	records, err := d.ReadAll("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(testUsers)-1 {
+		t.Errorf("ReadAll returned %d records, want all but the torn one", len(records))
+	}
+}
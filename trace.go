@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type TracePhase int
+
+const (
+	TraceStart TracePhase = iota
+	TraceEnd
+)
+
+type (
+	TraceEvent struct {
+		ID         uint64
+		Op         string
+		Phase      TracePhase
+		Collection string
+		Resource   string
+		Start      time.Time
+		Duration   time.Duration
+		Bytes      int64
+		Err        error
+		Context    context.Context
+	}
+	operation struct {
+		d     *Driver
+		ev    TraceEvent
+		log   Logger
+		bytes int64
+		call  callOptions
+
+		// stage makes a write leave its record in a temp file, described
+		// by staged, for a group commit to sync and put in place.
+		stage  bool
+		staged *stagedWrite
+	}
+	opLogger struct {
+		Logger
+		prefix string
+	}
+)
+
+var operationID uint64
+
+// trace marks the start of a public operation. The returned operation's
+// logger tags every line with the operation ID so driver logs can be matched
+// with TraceHook events.
+func (d *Driver) trace(ctx context.Context, op, collection, resource string) *operation {
+	o := &operation{d: d, log: d.log}
+	if d.opts.TraceHook == nil {
+		return o
+	}
+
+	o.ev = TraceEvent{
+		ID:         atomic.AddUint64(&operationID, 1),
+		Op:         op,
+		Phase:      TraceStart,
+		Collection: collection,
+		Resource:   resource,
+		Start:      time.Now(),
+		Context:    ctx,
+	}
+	o.log = opLogger{d.log, fmt.Sprintf("[op %d] ", o.ev.ID)}
+
+	d.opts.TraceHook(o.ev)
+	return o
+}
+
+func (o *operation) end(err error) {
+	if o.d.opts.TraceHook == nil {
+		return
+	}
+
+	ev := o.ev
+	ev.Phase = TraceEnd
+	ev.Duration = time.Since(ev.Start)
+	ev.Bytes = o.bytes
+	ev.Err = err
+
+	o.d.opts.TraceHook(ev)
+}
+
+func (l opLogger) Fatal(format string, v ...interface{}) { l.Logger.Fatal(l.prefix+format, v...) }
+func (l opLogger) Error(format string, v ...interface{}) { l.Logger.Error(l.prefix+format, v...) }
+func (l opLogger) Warn(format string, v ...interface{})  { l.Logger.Warn(l.prefix+format, v...) }
+func (l opLogger) Info(format string, v ...interface{})  { l.Logger.Info(l.prefix+format, v...) }
+func (l opLogger) Debug(format string, v ...interface{}) { l.Logger.Debug(l.prefix+format, v...) }
+func (l opLogger) Trace(format string, v ...interface{}) { l.Logger.Trace(l.prefix+format, v...) }
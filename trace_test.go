@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lineLog keeps the warnings it is given.
+type lineLog struct {
+	nopLog
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *lineLog) Warn(format string, v ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+// Every operation of a mixed, concurrent workload is traced as one start
+// and one end event sharing an ID, the end carrying the outcome.
+func TestTracePairs(t *testing.T) {
+	var mutex sync.Mutex
+	events := make(map[uint64][]TraceEvent)
+	d := openTest(t, &Options{TraceHook: func(ev TraceEvent) {
+		mutex.Lock()
+		events[ev.ID] = append(events[ev.ID], ev)
+		mutex.Unlock()
+	}})
+
+	var wg sync.WaitGroup
+	for _, u := range testUsers {
+		wg.Add(1)
+		go func(u User) {
+			defer wg.Done()
+			var got User
+			d.Write("user", u.Name, u)
+			d.Read("user", u.Name, &got)
+			d.ReadAll("user")
+			d.Delete("user", u.Name)
+			d.Read("user", u.Name, &got)
+		}(u)
+	}
+	wg.Wait()
+
+	ops := make(map[string]int)
+	failed := 0
+	for id, evs := range events {
+		if len(evs) != 2 || evs[0].Phase != TraceStart || evs[1].Phase != TraceEnd || evs[0].Op != evs[1].Op {
+			t.Fatalf("operation %d traced as %+v", id, evs)
+		}
+		if evs[1].Err != nil {
+			failed++
+		}
+		ops[evs[0].Op]++
+	}
+	n := len(testUsers)
+	if ops["Write"] != n || ops["Read"] != 2*n || ops["ReadAll"] != n || ops["Delete"] != n {
+		t.Errorf("traced operations %v", ops)
+	}
+	if failed != n {
+		t.Errorf("%d operations ended with an error, want the %d reads of deleted records", failed, n)
+	}
+}
+
+// A warning logged on the way through an operation carries its ID.
+func TestTracedWarnings(t *testing.T) {
+	log := &lineLog{}
+	var ids []uint64
+	d := openTest(t, &Options{Logger: log, TraceHook: func(ev TraceEvent) {
+		if ev.Phase == TraceStart && ev.Op == "Read" {
+			ids = append(ids, ev.ID)
+		}
+	}})
+	if err := d.Write("s", "target", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(d.recordPath("s", "target"), d.recordPath("s", "link")); err != nil {
+		t.Skip(err)
+	}
+
+	var v string
+	if err := d.Read("s", "link", &v); !errors.Is(err, ErrSymlink) {
+		t.Fatalf("Read through a symlink = %v, want ErrSymlink", err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("traced %d reads, want 1", len(ids))
+	}
+
+	prefix := fmt.Sprintf("[op %d] Refusing to follow symlink", ids[0])
+	for _, line := range log.lines {
+		if strings.HasPrefix(line, prefix) {
+			return
+		}
+	}
+	t.Errorf("no warning starts with %q in %q", prefix, log.lines)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const metaFile = "_meta.json"
+
+// collectionReserved holds the names inside a collection directory that
+// describe the collection rather than store its records.
+var collectionReserved = map[string]bool{
+	metaFile:   true,
+	accessFile: true,
+}
+
+// Truncate removes every record of collection, keeping its definitions,
+// indexes and constraints. Records of other collections referencing them
+// get their references' delete actions, as with Delete.
+func (d *Driver) Truncate(collection string) (err error) {
+	op := d.trace(nil, "Truncate", collection, "")
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if reserved[collection] {
+		return fmt.Errorf("%s is reserved", collection)
+	}
+	if err := validateName(collection, ""); err != nil {
+		return err
+	}
+
+	if err := d.writeLimit.take(d.ctx, op.log, collection); err != nil {
+		return err
+	}
+	scope := d.deleteScope(collection)
+	defer d.lockCollections(scope...)()
+	defer d.listings.invalidate(collection)
+
+	if err := d.checkWritable(scope...); err != nil {
+		return err
+	}
+
+	// Records elsewhere referencing the truncated ones get their delete
+	// actions first, and a restricting one leaves everything as it was.
+	dropping := map[string]bool{collection: true}
+	deletes, nulls, err := d.planDrop(collection, dropping)
+	if err != nil {
+		return err
+	}
+	if err := d.applyDelete(op, deletes, nulls, dropping); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(d.dir, collection)
+
+	files, err := d.readDir(dir)
+	if err != nil {
+		return err
+	}
+	if ps, err := d.packsOf(collection); err != nil {
+		return err
+	} else if ps != nil {
+		if err := ps.reset(); err != nil {
+			return err
+		}
+	}
+
+	var removed []string
+	for _, file := range files {
+		if file.IsDir() || collectionReserved[file.Name()] {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed = append(removed, path)
+	}
+	if err := d.mirror(op.log, removed...); err != nil {
+		return err
+	}
+
+	if d.opts.BackupDir != "" {
+		if err := os.RemoveAll(filepath.Join(d.opts.BackupDir, collection)); err != nil {
+			return err
+		}
+	}
+	d.references.reset(collection)
+	d.invalidateIndexes(collection)
+
+	d.events.emit(EventTruncate, collection, "")
+	return nil
+}
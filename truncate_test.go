@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Truncate empties a collection but keeps its definitions, indexes and
+// constraints in force, also after a reopen.
+func TestTruncateKeepsConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	seedUsers(t, d)
+	if err := d.ApplyDefinitions("user", Definitions{KeyFields: []string{"Name"}}, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetUnique("user", "Contact"); err == nil {
+		t.Fatal("SetUnique over duplicate contacts succeeded")
+	}
+	if err := d.SetUnique("user", "Age"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateIndex("user", "Company"); err != nil {
+		t.Fatal(err)
+	}
+	defs, err := d.ExportDefinitions("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Truncate("user"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Count("user"); err != nil || n != 0 {
+		t.Fatalf("Count after Truncate = %d, %v, want 0", n, err)
+	}
+
+	check := func(d *Driver) {
+		t.Helper()
+		if got, err := d.ExportDefinitions("user"); err != nil || !reflect.DeepEqual(got, defs) {
+			t.Errorf("definitions after Truncate = %+v, %v, want %+v", got, err, defs)
+		}
+		if fields, err := d.Indexes("user"); err != nil || !reflect.DeepEqual(fields, []string{"Age", "Company"}) {
+			t.Errorf("indexes after Truncate = %v, %v", fields, err)
+		}
+		if found, err := d.FindBy("user", "Company", "Google"); err != nil || !reflect.DeepEqual(found, []string{"Paul"}) {
+			t.Errorf("FindBy after Truncate = %v, %v, want [Paul]", found, err)
+		}
+		clash := testUsers[2]
+		clash.Age = testUsers[1].Age
+		if err := d.Write("user", clash.Name, clash); !errors.Is(err, ErrDuplicateValue) {
+			t.Errorf("write of a duplicate age after Truncate = %v, want ErrDuplicateValue", err)
+		}
+	}
+	if err := d.Write("user", "Paul", testUsers[1]); err != nil {
+		t.Fatal(err)
+	}
+	check(d)
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check(reopenTest(t, dir, nil))
+}
+
+// Truncate refuses the database's reserved directories, so the change log
+// survives an attempt to truncate it.
+func TestTruncateReserved(t *testing.T) {
+	d := openTest(t, &Options{ChangeLog: true})
+	seedUsers(t, d)
+	for _, collection := range []string{changeLogDir, countersDir, changeLogDir + "/x", "user/" + packsDir} {
+		if err := d.Truncate(collection); err == nil {
+			t.Errorf("Truncate(%q) succeeded", collection)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, changeLogDir, "changes.log")); err != nil {
+		t.Errorf("the change log is gone: %s", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SetUnique makes writes to collection fail with ErrDuplicateValue when
+// another record already holds the same value of field, a dotted path.
+// The constraint is enforced through an index on field, created if need
+// be, and checked together with the write under the collection lock. It
+// fails if the records already stored violate it. DropIndex on field
+// removes the constraint with the index.
+func (d *Driver) SetUnique(collection, field string) (err error) {
+	op := d.trace(nil, "SetUnique", collection, field)
+	defer func() { op.end(err) }()
+
+	if collection == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if field == "" {
+		return fmt.Errorf("field is required")
+	}
+	if err := validateName(collection, ""); err != nil {
+		return err
+	}
+	if err := d.checkOccupied(metaPath(collection)); err != nil {
+		return err
+	}
+	defer d.lockCollections(collection)()
+
+	if err := d.checkWritable(collection); err != nil {
+		return err
+	}
+
+	_, existed := d.indexes.of(collection)[field]
+	idx := d.indexes.add(collection, field)
+	if idx.unique {
+		return nil
+	}
+	if err := d.buildIndex(collection, field, idx); err != nil {
+		if !existed {
+			d.indexes.remove(collection, field)
+		}
+		return err
+	}
+	if dup := idx.duplicates(); len(dup) > 0 {
+		if !existed {
+			d.indexes.remove(collection, field)
+		}
+		return fmt.Errorf("%w: %s/%s share %s", ErrDuplicateValue, collection, strings.Join(dup, ", "), field)
+	}
+
+	idx.unique = true
+	if err := d.saveIndexes(collection); err != nil {
+		idx.unique = false
+		return err
+	}
+	return nil
+}
+
+// duplicates returns the resources sharing the first value held by more
+// than one.
+func (idx *index) duplicates() []string {
+	for _, resources := range idx.entries {
+		if len(resources) < 2 {
+			continue
+		}
+		var dup []string
+		for resource := range resources {
+			dup = append(dup, resource)
+		}
+		sort.Strings(dup)
+		return dup
+	}
+	return nil
+}
+
+// checkUnique enforces the unique indexes of collection on a document
+// about to be stored as resource. It must be called with the collection
+// mutex held.
+func (d *Driver) checkUnique(collection, resource string, b []byte) error {
+	var doc map[string]interface{}
+	for field, idx := range d.indexes.of(collection) {
+		if !idx.unique {
+			continue
+		}
+		if doc == nil {
+			var err error
+			if doc, err = decodeDocument(b); err != nil {
+				return err
+			}
+		}
+		v, ok := lookup(doc, field)
+		if !ok {
+			continue
+		}
+		key, err := indexKey(v)
+		if err != nil {
+			return err
+		}
+		if !idx.built {
+			if err := d.buildIndex(collection, field, idx); err != nil {
+				return err
+			}
+		}
+		for other := range idx.entries[key] {
+			if other != resource {
+				return fmt.Errorf("%w: %s of %s/%s is already held by %s", ErrDuplicateValue, field, collection, resource, other)
+			}
+		}
+	}
+	return nil
+}
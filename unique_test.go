@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type account struct {
+	Name  string
+	Email string
+}
+
+// After SetUnique a second user with the same email is refused, even from
+// concurrent writers, while rewriting the holder of the email is not, and
+// the constraint survives a reopen.
+func TestSetUnique(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, nil)
+	if err := d.SetUnique("account", "Email"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("account", "john", account{"John", "john@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("account", "johnny", account{"Johnny", "john@example.com"}); !errors.Is(err, ErrDuplicateValue) {
+		t.Errorf("a second user with John's email = %v, want ErrDuplicateValue", err)
+	}
+	if err := d.Write("account", "john", account{"John Doe", "john@example.com"}); err != nil {
+		t.Errorf("rewriting John with his own email = %v", err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		accepted int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := d.Write("account", fmt.Sprint("racer", i), account{"Racer", "race@example.com"})
+			if err != nil && !errors.Is(err, ErrDuplicateValue) {
+				t.Error(err)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == nil {
+				accepted++
+			}
+		}(i)
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("%d concurrent writers claimed one email", accepted)
+	}
+	d.Close()
+
+	d = reopenTest(t, dir, nil)
+	if err := d.Write("account", "jon", account{"Jon", "john@example.com"}); !errors.Is(err, ErrDuplicateValue) {
+		t.Errorf("a duplicate email after a reopen = %v, want ErrDuplicateValue", err)
+	}
+}
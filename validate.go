@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// verifyRoundTrip decodes b into a fresh value of v's type and checks that
+// nothing was lost, catching unexported or ignored fields before they are
+// silently dropped from the stored record.
+func verifyRoundTrip(v interface{}, b []byte, marshal func(interface{}) ([]byte, error)) error {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil
+	}
+	orig := reflect.ValueOf(v)
+	if t.Kind() == reflect.Ptr {
+		if orig.IsNil() {
+			return nil
+		}
+		t, orig = t.Elem(), orig.Elem()
+	}
+
+	fresh := reflect.New(t)
+	if err := json.Unmarshal(b, fresh.Interface()); err != nil {
+		return fmt.Errorf("%s does not round-trip through JSON: %s", t, err)
+	}
+
+	again, err := marshal(fresh.Interface())
+	if err != nil {
+		return fmt.Errorf("%s does not round-trip through JSON: %s", t, err)
+	}
+	if !bytes.Equal(b, again) {
+		return fmt.Errorf("%s does not round-trip through JSON: re-encoded as %s, want %s", t, again, b)
+	}
+
+	if path := droppedField(orig, t.Name()); path != "" {
+		return fmt.Errorf("%s does not round-trip through JSON: field %s is set but never encoded", t, path)
+	}
+	return nil
+}
+
+// droppedField returns the path of the first non-zero field that JSON
+// encoding skips, i.e. an unexported field or one tagged "-".
+func droppedField(v reflect.Value, path string) string {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+		return droppedField(v.Elem(), path)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			skipped := (f.PkgPath != "" && !f.Anonymous) || f.Tag.Get("json") == "-"
+			if skipped {
+				if !v.Field(i).IsZero() {
+					return path + "." + f.Name
+				}
+				continue
+			}
+			if p := droppedField(v.Field(i), path+"."+f.Name); p != "" {
+				return p
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if p := droppedField(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); p != "" {
+				return p
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if p := droppedField(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key())); p != "" {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// checkEncodable walks v before any file is touched and reports the first
+// value JSON cannot encode, naming its field path. A nil value is refused
+// unless AllowNull is set, rather than being stored as "null".
+func (d *Driver) checkEncodable(v interface{}) error {
+	t := reflect.TypeOf(v)
+	if t == nil || (t.Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		if d.opts.AllowNull {
+			return nil
+		}
+		return fmt.Errorf("%w: nil value", ErrUnsupportedValue)
+	}
+
+	root := t.Name()
+	if t.Kind() == reflect.Ptr {
+		root = t.Elem().Name()
+	}
+	if path, reason := unencodable(reflect.ValueOf(v), root, make(map[interface{}]bool)); path != "" {
+		return fmt.Errorf("%w: %s is %s", ErrUnsupportedValue, path, reason)
+	}
+	return nil
+}
+
+func marshals(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) ||
+		reflect.PointerTo(t).Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)
+}
+
+// unencodable returns the path of the first value under v that JSON cannot
+// encode and why. seen holds the pointers on the current path to catch
+// cycles.
+func unencodable(v reflect.Value, path string, seen map[interface{}]bool) (string, string) {
+	if !v.IsValid() || (v.Kind() != reflect.Interface && marshals(v.Type())) {
+		return "", ""
+	}
+
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return path, "a " + v.Kind().String()
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return path, fmt.Sprint(f)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			return unencodable(v.Elem(), path, seen)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "", ""
+		}
+		if seen[v.Pointer()] {
+			return path, "a cyclic reference"
+		}
+		seen[v.Pointer()] = true
+		defer delete(seen, v.Pointer())
+		return unencodable(v.Elem(), path, seen)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if (f.PkgPath != "" && !f.Anonymous) || f.Tag.Get("json") == "-" {
+				continue
+			}
+			if p, reason := unencodable(v.Field(i), path+"."+f.Name, seen); p != "" {
+				return p, reason
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return "", ""
+			}
+			key := [2]uintptr{v.Pointer(), uintptr(v.Len())}
+			if seen[key] {
+				return path, "a cyclic reference"
+			}
+			seen[key] = true
+			defer delete(seen, key)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if p, reason := unencodable(v.Index(i), fmt.Sprintf("%s[%d]", path, i), seen); p != "" {
+				return p, reason
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return "", ""
+		}
+		switch k := v.Type().Key(); k.Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		default:
+			if !k.Implements(textMarshalerType) {
+				return path, "a map keyed by " + k.String()
+			}
+		}
+		if seen[v.Pointer()] {
+			return path, "a cyclic reference"
+		}
+		seen[v.Pointer()] = true
+		defer delete(seen, v.Pointer())
+
+		iter := v.MapRange()
+		for iter.Next() {
+			if p, reason := unencodable(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), seen); p != "" {
+				return p, reason
+			}
+		}
+	}
+	return "", ""
+}
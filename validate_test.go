@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type session struct {
+	User  string
+	token string
+}
+
+// With VerifyRoundTrip a struct whose unexported field would be dropped is
+// refused with a warning naming the field, and nothing is written.
+func TestVerifyRoundTrip(t *testing.T) {
+	log := &lineLog{}
+	d := openTest(t, &Options{Logger: log, VerifyRoundTrip: true})
+
+	err := d.Write("session", "s", session{User: "John", token: "secret"})
+	if err == nil || !strings.Contains(err.Error(), "session.token") {
+		t.Fatalf("Write = %v, want an error naming session.token", err)
+	}
+	if len(log.lines) != 1 || !strings.Contains(log.lines[0], "session.token") {
+		t.Errorf("warnings %q, want one naming session.token", log.lines)
+	}
+	if _, err := os.Stat(d.recordPath("session", "s")); !os.IsNotExist(err) {
+		t.Errorf("the refused record was written: %v", err)
+	}
+
+	if err := d.Write("session", "s", session{User: "John"}); err != nil {
+		t.Errorf("Write with the unexported field unset = %v", err)
+	}
+}
+
+type notifier struct {
+	Name  string
+	Hooks struct{ Done chan bool }
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+// A value JSON cannot encode is refused before any file or directory is
+// created, with the offending field named, and nil is refused unless
+// AllowNull is set.
+func TestUnsupportedValue(t *testing.T) {
+	d := openTest(t, nil)
+	loop := &node{Name: "a"}
+	loop.Next = loop
+
+	for v, field := range map[interface{}]string{
+		notifier{Name: "n"}: "notifier.Hooks.Done",
+		loop:                "node.Next",
+	} {
+		err := d.Write("bad", "x", v)
+		if !errors.Is(err, ErrUnsupportedValue) || !strings.Contains(err.Error(), field) {
+			t.Errorf("Write = %v, want ErrUnsupportedValue naming %s", err, field)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, "bad")); !os.IsNotExist(err) {
+		t.Errorf("the refused writes created the collection: %v", err)
+	}
+	if stats := d.IOStats(); stats.Writes != 0 || stats.TempFiles != 0 {
+		t.Errorf("the refused writes touched the filesystem: %+v", stats)
+	}
+
+	var nilUser *User
+	for _, v := range []interface{}{nil, nilUser} {
+		if err := d.Write("user", "x", v); !errors.Is(err, ErrUnsupportedValue) {
+			t.Errorf("Write(%#v) = %v, want ErrUnsupportedValue", v, err)
+		}
+	}
+	d = openTest(t, &Options{AllowNull: true})
+	if err := d.Write("user", "x", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(d.recordPath("user", "x")); err != nil || strings.TrimSpace(string(b)) != "null" {
+		t.Errorf("the null record holds %q, %v", b, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// bumpVersion enforces VersionField on a write: the incoming document must
+// carry the version currently stored (0 for a new record), and is written
+// with that version plus one.
+func (d *Driver) bumpVersion(op *operation, collection, resource string, b []byte) ([]byte, error) {
+	field := d.opts.VersionField
+
+	doc, err := decodeDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	incoming, err := versionOf(doc, field)
+	if err != nil {
+		return nil, err
+	}
+
+	var current int64
+	stored, err := d.readRecord(op.log, d.recordPath(collection, resource))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		existing, err := decodeDocument(stored)
+		if err != nil {
+			return nil, err
+		}
+		if current, err = versionOf(existing, field); err != nil {
+			return nil, err
+		}
+	}
+
+	if incoming != current {
+		return nil, fmt.Errorf("%w: %s/%s is at version %d, write was based on %d", ErrConflict, collection, resource, current, incoming)
+	}
+
+	assign(doc, field, current+1)
+	return json.Marshal(doc)
+}
+
+func versionOf(doc map[string]interface{}, field string) (int64, error) {
+	v, ok := lookup(doc, field)
+	if !ok || v == nil {
+		return 0, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("version field %s must be an integer, got %T", field, v)
+	}
+	version, err := n.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("version field %s must be an integer: %w", field, err)
+	}
+	return version, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+type versioned struct {
+	Name    string
+	V       int64
+	Updated string `json:",omitempty"`
+}
+
+func TestVersionConflict(t *testing.T) {
+	d := openTest(t, &Options{VersionField: "V"})
+	if err := d.Write("doc", "a", versioned{Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two clients read version 1 and both try to write on top of it.
+	var one, two versioned
+	d.Read("doc", "a", &one)
+	d.Read("doc", "a", &two)
+	if one.V != 1 {
+		t.Fatalf("stored version %d, want 1", one.V)
+	}
+
+	one.Name = "one"
+	if err := d.Write("doc", "a", one); err != nil {
+		t.Fatal(err)
+	}
+	two.Name = "two"
+	if err := d.Write("doc", "a", two); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	var got versioned
+	d.Read("doc", "a", &got)
+	if got.Name != "one" || got.V != 2 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+// Modify, Batch and AppendToList write through the same path as Write, so
+// versions, update stamps and TTLs behave the same for all of them.
+func TestWritePathsShareHooks(t *testing.T) {
+	d := openTest(t, &Options{VersionField: "V", UpdatedAtField: "Updated"})
+	if err := d.Put(context.Background(), "doc", "a", versioned{Name: "a"}, WithTTL(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.Modify("doc", "a", func(current []byte) ([]byte, error) {
+		var v versioned
+		if err := json.Unmarshal(current, &v); err != nil {
+			return nil, err
+		}
+		v.Name = "modified"
+		v.Updated = ""
+		return json.Marshal(v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got versioned
+	d.Read("doc", "a", &got)
+	if got.Name != "modified" || got.V != 2 || got.Updated == "" {
+		t.Fatalf("Modify skipped the write hooks: %+v", got)
+	}
+	if _, err := os.Stat(d.expiryPath("doc", "a")); !os.IsNotExist(err) {
+		t.Fatalf("Modify kept the TTL: %v", err)
+	}
+
+	if err := d.Batch().Write("doc", "a", versioned{Name: "stale", V: 1}).Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected the batch to conflict, got %v", err)
+	}
+	if err := d.Batch().Write("doc", "a", versioned{Name: "batch", V: 2}).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	d.Read("doc", "a", &got)
+	if got.Name != "batch" || got.V != 3 {
+		t.Fatalf("got %+v", got)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	viewsDir = "_views"
+
+	viewRetries = 3
+	viewBackoff = 50 * time.Millisecond
+)
+
+// ViewReducer folds one change of the source collection into a view's
+// accumulated value. acc is nil for a new view, doc is nil for deletes.
+// A reducer that must undo the previous version of a record on overwrite
+// or delete has to remember it in acc itself. Truncating or dropping the
+// source resets acc to nil without calling the reducer.
+type ViewReducer func(acc json.RawMessage, key string, doc json.RawMessage, op EventType) (json.RawMessage, error)
+
+type (
+	viewState struct {
+		Source string
+		Seq    uint64
+		Value  json.RawMessage
+	}
+	view struct {
+		name   string
+		source string
+		reduce ViewReducer
+
+		mutex sync.Mutex
+		state viewState
+		// err is the failure that left the view behind its source, set
+		// until the view is rebuilt.
+		err error
+	}
+	viewSet struct {
+		mutex sync.Mutex
+		views map[string]*view
+	}
+)
+
+// RegisterView keeps a materialized value derived from source in
+// _views/<name>.json. Each change is applied once, in change-log order:
+// the view records the sequence number it last applied, and on
+// registration replays whatever it missed while the driver was closed.
+// Changes are applied from the change stream in the background, or
+// synchronously within the mutation when Options.SyncViews is set. A
+// change that cannot be applied, even after retries in the background,
+// leaves the view stale: it stops following its source and ReadView fails
+// with ErrStaleView until RebuildView. RegisterView requires
+// Options.ChangeLog.
+func (d *Driver) RegisterView(name, source string, reduce ViewReducer) (err error) {
+	op := d.trace(nil, "RegisterView", source, name)
+	defer func() { op.end(err) }()
+
+	if name == "" {
+		return fmt.Errorf("view name is required")
+	}
+	if source == "" {
+		return fmt.Errorf("collection is required")
+	}
+	if reduce == nil {
+		return fmt.Errorf("reduce function is required")
+	}
+	if err := validateName(source, ""); err != nil {
+		return err
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if d.events.log == nil {
+		return fmt.Errorf("views require the change log")
+	}
+
+	v := &view{name: name, source: source, reduce: reduce}
+
+	d.views.mutex.Lock()
+	if _, ok := d.views.views[name]; ok {
+		d.views.mutex.Unlock()
+		return fmt.Errorf("view %s is already registered", name)
+	}
+	d.views.views[name] = v
+	d.views.mutex.Unlock()
+
+	defer func() {
+		if err != nil {
+			d.views.mutex.Lock()
+			delete(d.views.views, name)
+			d.views.mutex.Unlock()
+		}
+	}()
+
+	mutex := d.getOrCreateNewMutex(source)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	state, err := d.readViewState(name)
+	if err == nil {
+		d.events.mutex.Lock()
+		retained := d.events.retains(state.Seq)
+		d.events.mutex.Unlock()
+		if !retained {
+			op.log.Warn("Rebuilding view %s: the changes it missed were pruned from the change log", name)
+			state.Source = ""
+		}
+	}
+	switch {
+	case os.IsNotExist(err) || err == nil && state.Source != source:
+		if err := d.rebuildView(v); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		v.state = state
+		if err := d.catchUpView(v); err != nil {
+			return err
+		}
+	}
+
+	// Subscribing while the source is still locked means no change can
+	// fall between the catch-up above and the first event delivered.
+	if d.opts.SyncViews {
+		return nil
+	}
+	events, _ := d.Watch(WatchOptions{})
+	go func() {
+		for ev := range events {
+			if ev.Collection != source {
+				continue
+			}
+			backoff := viewBackoff
+			for attempt := 0; ; attempt++ {
+				mutex := d.getOrCreateNewMutex(source)
+				mutex.Lock()
+				err := d.applyView(v, ev)
+				mutex.Unlock()
+				if err == nil {
+					break
+				}
+				if attempt == viewRetries {
+					d.failView(v, ev, err)
+					break
+				}
+				d.log.Warn("Retrying change %d of view %s: %s", ev.Seq, name, err)
+				select {
+				case <-time.After(backoff):
+				case <-d.ctx.Done():
+					return
+				}
+				backoff *= 2
+			}
+		}
+	}()
+	return nil
+}
+
+// catchUpView applies the changes a view missed while the driver was
+// closed. It must be called with the source collection mutex held. The
+// change log only names the records that changed, and a record holds just
+// its latest version, so the view is rebuilt instead when a record changed
+// more than once or the source was truncated.
+func (d *Driver) catchUpView(v *view) error {
+	var missed []Event
+	d.events.mutex.Lock()
+	err := d.events.replay(v.state.Seq, func(ev Event) bool {
+		if ev.Collection == v.source {
+			missed = append(missed, ev)
+		}
+		return true
+	})
+	d.events.mutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	changed := make(map[string]bool, len(missed))
+	for _, ev := range missed {
+		if ev.Resource == "" || changed[ev.Resource] {
+			return d.rebuildView(v)
+		}
+		changed[ev.Resource] = true
+	}
+
+	for _, ev := range missed {
+		if ev.Type == EventWrite {
+			doc, err := d.loadViewDoc(v.source, ev.Resource)
+			if os.IsNotExist(err) {
+				return d.rebuildView(v)
+			}
+			if err != nil {
+				return err
+			}
+			ev.doc = doc
+		}
+		if err := d.applyView(v, ev); err != nil {
+			return fmt.Errorf("view %s: change %d of %s: %w", v.name, ev.Seq, v.source, err)
+		}
+	}
+	return nil
+}
+
+// RebuildView recomputes a view by folding over every record of its
+// source, replacing whatever it held.
+func (d *Driver) RebuildView(name string) (err error) {
+	op := d.trace(nil, "RebuildView", "", name)
+	defer func() { op.end(err) }()
+
+	d.views.mutex.Lock()
+	v, ok := d.views.views[name]
+	d.views.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: view %s", ErrNotFound, name)
+	}
+
+	mutex := d.getOrCreateNewMutex(v.source)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return d.rebuildView(v)
+}
+
+// ReadView decodes the current value of a view into out. It fails with
+// ErrStaleView while a registered view is stale.
+func (d *Driver) ReadView(name string, out interface{}) (err error) {
+	op := d.trace(nil, "ReadView", "", name)
+	defer func() { op.end(err) }()
+
+	d.views.mutex.Lock()
+	v, ok := d.views.views[name]
+	d.views.mutex.Unlock()
+
+	var state viewState
+	if ok {
+		v.mutex.Lock()
+		state, err = v.state, v.err
+		v.mutex.Unlock()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %s; rebuild it with RebuildView", ErrStaleView, name, err)
+		}
+	} else if state, err = d.readViewState(name); os.IsNotExist(err) {
+		return fmt.Errorf("%w: view %s", ErrNotFound, name)
+	} else if err != nil {
+		return err
+	}
+
+	if len(state.Value) == 0 {
+		return nil
+	}
+	return json.Unmarshal(state.Value, out)
+}
+
+// rebuildView must be called with the source collection mutex held.
+func (d *Driver) rebuildView(v *view) error {
+	seq := d.events.current()
+
+	var acc json.RawMessage
+	err := d.eachRecord(d.log, v.source, func(resource string, b []byte) (err error) {
+		acc, err = v.reduce(acc, resource, bytes.TrimSpace(b), EventWrite)
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	state := viewState{Source: v.source, Seq: seq, Value: acc}
+	if err := d.writeViewState(v.name, state); err != nil {
+		return err
+	}
+	v.state, v.err = state, nil
+	return nil
+}
+
+// applyView must be called with the source collection mutex held. A write
+// is folded in with the document its event carries; one that carries none,
+// as when the change stream fell back to the change log, leaves the view
+// to be rebuilt from the records as they are, since they may have changed
+// again since.
+func (d *Driver) applyView(v *view, ev Event) error {
+	v.mutex.Lock()
+	if ev.Seq <= v.state.Seq || ev.Collection != v.source || v.err != nil {
+		v.mutex.Unlock()
+		return nil
+	}
+	if ev.Type == EventWrite && ev.Resource != "" && ev.doc == nil {
+		v.mutex.Unlock()
+		return d.rebuildView(v)
+	}
+	defer v.mutex.Unlock()
+
+	state := v.state
+	state.Seq = ev.Seq
+
+	switch {
+	case ev.Resource == "":
+		state.Value = nil
+	case ev.Type == EventWrite:
+		var err error
+		if state.Value, err = v.reduce(state.Value, ev.Resource, ev.doc, ev.Type); err != nil {
+			return err
+		}
+	default:
+		var err error
+		if state.Value, err = v.reduce(state.Value, ev.Resource, nil, ev.Type); err != nil {
+			return err
+		}
+	}
+
+	if err := d.writeViewState(v.name, state); err != nil {
+		return err
+	}
+	v.state = state
+	return nil
+}
+
+// applySyncViews runs after a mutation of collection is logged, with the
+// collection mutex still held.
+func (d *Driver) applySyncViews(ev Event) {
+	if !d.opts.SyncViews || d.views == nil {
+		return
+	}
+	d.views.mutex.Lock()
+	var views []*view
+	for _, v := range d.views.views {
+		if v.source == ev.Collection {
+			views = append(views, v)
+		}
+	}
+	d.views.mutex.Unlock()
+
+	for _, v := range views {
+		if err := d.applyView(v, ev); err != nil {
+			d.failView(v, ev, err)
+		}
+	}
+}
+
+// failView marks a view stale after a change could not be applied to it.
+// Applying the changes that follow would silently leave that one out.
+func (d *Driver) failView(v *view, ev Event, err error) {
+	v.mutex.Lock()
+	v.err = fmt.Errorf("change %d of %s: %w", ev.Seq, v.source, err)
+	v.mutex.Unlock()
+	d.log.Error("View %s is stale until rebuilt: unable to apply change %d of %s: %s", v.name, ev.Seq, v.source, err)
+}
+
+// viewDoc captures for a write event the record it left, when views are
+// derived from its collection. It runs within the mutation, under the
+// collection lock, so the record read is the version the event reports; a
+// record that cannot be read is left out, and the views rebuilt.
+func (d *Driver) viewDoc(collection, resource string) []byte {
+	if d.views == nil || !d.views.derive(collection) {
+		return nil
+	}
+	b, err := d.loadViewDoc(collection, resource)
+	if err != nil {
+		d.log.Warn("Unable to capture %s/%s for its views: %s", collection, resource, err)
+		return nil
+	}
+	return b
+}
+
+func (d *Driver) loadViewDoc(collection, resource string) ([]byte, error) {
+	b, err := d.readRecord(d.log, d.recordPath(collection, resource))
+	if err == nil {
+		b, err = d.transform(collection, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b = bytes.TrimSpace(b); b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+// derive reports whether any view is derived from collection.
+func (s *viewSet) derive(collection string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, v := range s.views {
+		if v.source == collection {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Driver) viewPath(name string) string {
+	return filepath.Join(d.dir, viewsDir, d.fileKey(name)+".json")
+}
+
+func (d *Driver) readViewState(name string) (viewState, error) {
+	var state viewState
+	b, err := ioutil.ReadFile(d.viewPath(name))
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(b, &state)
+}
+
+func (d *Driver) writeViewState(name string, state viewState) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(d.dir, viewsDir), 0755); err != nil {
+		return err
+	}
+	path := d.viewPath(name)
+	if err := d.writeFile(path+".tmp", b, true); err != nil {
+		return err
+	}
+	return d.rename(path+".tmp", path)
+}
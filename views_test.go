@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// history is a reducer that keeps every document it is given, in order.
+func history(acc json.RawMessage, key string, doc json.RawMessage, op EventType) (json.RawMessage, error) {
+	var docs []json.RawMessage
+	if acc != nil {
+		if err := json.Unmarshal(acc, &docs); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(append(docs, doc))
+}
+
+// A view folds in each write with the document that write left, even when
+// the record has been overwritten again by the time the change is applied.
+func TestViewAppliesEachVersion(t *testing.T) {
+	d := openTest(t, &Options{ChangeLog: true})
+	if err := d.RegisterView("history", "n", history); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []int
+	for i := 0; i < 100; i++ {
+		if err := d.Write("n", "k", i); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, i)
+	}
+
+	var got []int
+	waitFor(t, "the view to catch up", func() bool {
+		got = nil
+		return d.ReadView("history", &got) == nil && len(got) >= len(want)
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("view saw %v, want %v", got, want)
+	}
+}
+
+// A change the reducer rejects leaves the view stale, rather than silently
+// missing from it, until the view is rebuilt.
+func TestViewFailureIsReported(t *testing.T) {
+	d := openTest(t, &Options{ChangeLog: true, SyncViews: true})
+
+	var reject atomic.Bool
+	reject.Store(true)
+	reduce := func(acc json.RawMessage, key string, doc json.RawMessage, op EventType) (json.RawMessage, error) {
+		if reject.Load() && string(doc) == `"bad"` {
+			return nil, fmt.Errorf("rejected %s", doc)
+		}
+		return history(acc, key, doc, op)
+	}
+	if err := d.RegisterView("history", "s", reduce); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"good", "bad", "later"} {
+		if err := d.Write("s", v, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	if err := d.ReadView("history", &got); !errors.Is(err, ErrStaleView) {
+		t.Fatalf("ReadView of a view missing a change = %v, want ErrStaleView", err)
+	}
+
+	reject.Store(false)
+	if err := d.RebuildView("history"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReadView("history", &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Errorf("rebuilt view holds %q, want all three records", got)
+	}
+}
+
+// byCompany is the users-by-company view: user counts per company, plus
+// each user's company so an overwrite or delete can undo it.
+type byCompany struct {
+	Counts map[string]int
+	Users  map[string]string
+}
+
+func reduceByCompany(acc json.RawMessage, key string, doc json.RawMessage, op EventType) (json.RawMessage, error) {
+	view := byCompany{Counts: map[string]int{}, Users: map[string]string{}}
+	if acc != nil {
+		if err := json.Unmarshal(acc, &view); err != nil {
+			return nil, err
+		}
+	}
+	if old, ok := view.Users[key]; ok {
+		if view.Counts[old]--; view.Counts[old] == 0 {
+			delete(view.Counts, old)
+		}
+		delete(view.Users, key)
+	}
+	if doc != nil {
+		var u User
+		if err := json.Unmarshal(doc, &u); err != nil {
+			return nil, err
+		}
+		view.Counts[u.Company]++
+		view.Users[key] = u.Company
+	}
+	return json.Marshal(view)
+}
+
+// Under random writes and deletes the users-by-company view, maintained
+// both in the background and synchronously, matches a rebuild from scratch.
+func TestViewRandomMutations(t *testing.T) {
+	for _, syncViews := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sync=%t", syncViews), func(t *testing.T) {
+			d := openTest(t, &Options{ChangeLog: true, SyncViews: syncViews})
+			if err := d.RegisterView("users_by_company", "user", reduceByCompany); err != nil {
+				t.Fatal(err)
+			}
+
+			rng := rand.New(rand.NewSource(1))
+			companies := []string{"Google", "Microsoft", "Apple"}
+			want := map[string]string{}
+			for i := 0; i < 300; i++ {
+				key := fmt.Sprintf("u%d", rng.Intn(20))
+				if rng.Intn(4) == 0 {
+					if err := d.Delete("user", key); err == nil {
+						delete(want, key)
+					} else if !errors.Is(err, ErrNotFound) {
+						t.Fatal(err)
+					}
+					continue
+				}
+				u := testUsers[0]
+				u.Name, u.Company = key, companies[rng.Intn(len(companies))]
+				if err := d.Write("user", key, u); err != nil {
+					t.Fatal(err)
+				}
+				want[key] = u.Company
+			}
+
+			var got byCompany
+			waitFor(t, "the view to catch up", func() bool {
+				got = byCompany{}
+				return d.ReadView("users_by_company", &got) == nil && reflect.DeepEqual(got.Users, want)
+			})
+
+			if err := d.RebuildView("users_by_company"); err != nil {
+				t.Fatal(err)
+			}
+			var rebuilt byCompany
+			if err := d.ReadView("users_by_company", &rebuilt); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, rebuilt) {
+				t.Errorf("the view holds %+v, a rebuild %+v", got, rebuilt)
+			}
+		})
+	}
+}
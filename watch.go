@@ -0,0 +1,624 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventWrite EventType = iota + 1
+	EventDelete
+	EventTruncate
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventWrite:
+		return "write"
+	case EventDelete:
+		return "delete"
+	case EventTruncate:
+		return "truncate"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+type (
+	Event struct {
+		Seq        uint64
+		Type       EventType
+		Collection string
+		Resource   string
+		Time       time.Time
+
+		// doc is the record a write event left, captured while the
+		// mutation still held the collection lock. Only events delivered
+		// live for a collection with views carry it; those read back from
+		// the change log do not.
+		doc []byte
+	}
+	WatchOptions struct {
+		Collection     string
+		ResourcePrefix string
+		EventTypes     []EventType
+		// SinceSeq replays the logged events after it first. When the
+		// change log is disabled or no longer holds all of them, the
+		// channel is closed instead of skipping the gap.
+		SinceSeq uint64
+		// Buffer caps the events queued for a slow receiver, 4096 by
+		// default. With the change log, a subscriber that falls that far
+		// behind reads the rest back from the log; without it, its channel
+		// is closed and it can resubscribe.
+		Buffer int
+	}
+	eventBus struct {
+		mutex       sync.Mutex
+		seq         uint64
+		log         *os.File
+		path        string
+		logger      Logger
+		retain      int
+		logged      int
+		first       uint64
+		subscribers map[*subscriber]struct{}
+		hook        func(Event)
+		load        func(collection, resource string) []byte
+
+		// size is the length of the log and gen counts its prunes, each of
+		// which moves every event to a new offset.
+		size int64
+		gen  int
+	}
+	subscriber struct {
+		opts   WatchOptions
+		bus    *eventBus
+		mutex  sync.Mutex
+		cond   *sync.Cond
+		queue  []Event
+		last   uint64
+		behind bool
+		closed bool
+		ending bool
+		done   chan struct{}
+		out    chan Event
+
+		// offset is where the log line after last starts, while the log
+		// is still at generation gen.
+		offset int64
+		gen    int
+	}
+)
+
+const (
+	changeLogDir = "_changes"
+
+	defaultChangeLogRetain = 100000
+	defaultWatchBuffer     = 4096
+)
+
+func newEventBus(dir string, opts Options) (*eventBus, error) {
+	bus := &eventBus{logger: opts.Logger, subscribers: make(map[*subscriber]struct{})}
+	if !opts.ChangeLog {
+		return bus, nil
+	}
+	if bus.retain = opts.ChangeLogRetain; bus.retain <= 0 {
+		bus.retain = defaultChangeLogRetain
+	}
+
+	logDir := filepath.Join(dir, changeLogDir)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, err
+	}
+	bus.path = filepath.Join(logDir, "changes.log")
+
+	var size int64
+	err := bus.scan(func(ev Event, end int64) bool {
+		if bus.first == 0 {
+			bus.first = ev.Seq
+		}
+		bus.seq, bus.logged, size = ev.Seq, bus.logged+1, end
+		return true
+	})
+	bus.size = size
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if info, err := os.Stat(bus.path); err == nil && info.Size() > size {
+		bus.logger.Warn("Discarding the last %d bytes of %s, an append cut short", info.Size()-size, bus.path)
+		if err := os.Truncate(bus.path, size); err != nil {
+			return nil, err
+		}
+	}
+
+	if bus.log, err = os.OpenFile(bus.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	return bus, nil
+}
+
+// scan calls fn with every complete event in the log and the offset just
+// past it, until fn returns false. A final line without its newline is an
+// append that a crash cut short and is left out.
+func (b *eventBus) scan(fn func(ev Event, end int64) bool) error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanFrom(f, 0, fn)
+}
+
+// scanFrom is scan over f from offset, which must start a line.
+func scanFrom(f *os.File, offset int64, fn func(ev Event, end int64) bool) error {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return fmt.Errorf("%s at offset %d: %w", f.Name(), offset-int64(len(line)), err)
+		}
+		if !fn(ev, offset) {
+			return nil
+		}
+	}
+}
+
+// replay calls fn with the logged events after since, in order, until fn
+// returns false.
+func (b *eventBus) replay(since uint64, fn func(Event) bool) error {
+	return b.scan(func(ev Event, _ int64) bool {
+		return ev.Seq <= since || fn(ev)
+	})
+}
+
+// retains reports whether the log still holds every event after since. It
+// must be called with b.mutex held.
+func (b *eventBus) retains(since uint64) bool {
+	return b.first == 0 || since+1 >= b.first
+}
+
+// emit must be called while the collection mutex is held, after the
+// mutation became visible, so that the sequence order matches the order
+// the mutations became visible. Watch and the change log promise it.
+//
+// The mutation is already on disk by then, so a change log that cannot be
+// appended to is logged rather than failing it; live subscribers still
+// receive the event.
+func (b *eventBus) emit(typ EventType, collection, resource string) {
+	if b == nil {
+		return
+	}
+	var doc []byte
+	if typ == EventWrite && b.load != nil {
+		doc = b.load(collection, resource)
+	}
+
+	b.mutex.Lock()
+	ev, err := b.append(typ, collection, resource, doc)
+	b.mutex.Unlock()
+
+	if err != nil {
+		b.logger.Error("Unable to append event %d to the change log: %s", ev.Seq, err)
+	}
+	if b.hook != nil {
+		b.hook(ev)
+	}
+}
+
+func (b *eventBus) append(typ EventType, collection, resource string, doc []byte) (ev Event, err error) {
+	b.seq++
+	ev = Event{
+		Seq:        b.seq,
+		Type:       typ,
+		Collection: collection,
+		Resource:   resource,
+		Time:       time.Now().UTC(),
+		doc:        doc,
+	}
+
+	for s := range b.subscribers {
+		if !s.push(ev) {
+			delete(b.subscribers, s)
+			b.logger.Warn("Ending a Watch of %q that fell %d events behind", s.opts.Collection, s.opts.Buffer)
+		}
+	}
+
+	if b.log == nil {
+		return ev, nil
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return ev, err
+	}
+	n, err := b.log.Write(append(line, '\n'))
+	if b.size += int64(n); err != nil {
+		return ev, err
+	}
+	if err := b.log.Sync(); err != nil {
+		return ev, err
+	}
+	if b.first == 0 {
+		b.first = ev.Seq
+	}
+	if b.logged++; b.logged >= 2*b.retain {
+		if err := b.prune(); err != nil {
+			b.logger.Error("Unable to prune the change log: %s", err)
+		}
+	}
+	return ev, nil
+}
+
+// prune rewrites the log with only its newest b.retain events. It must be
+// called with b.mutex held.
+func (b *eventBus) prune() error {
+	skip := b.logged - b.retain
+	tmp := b.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	var first uint64
+	n := 0
+	err = b.scan(func(ev Event, _ int64) bool {
+		if n++; n <= skip {
+			return true
+		}
+		if first == 0 {
+			first = ev.Seq
+		}
+		var line []byte
+		if line, err = json.Marshal(ev); err == nil {
+			_, err = w.Write(append(line, '\n'))
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(b.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	log, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := log.Stat()
+	if err != nil {
+		log.Close()
+		return err
+	}
+	b.log.Close()
+	b.log, b.first, b.logged = log, first, n-skip
+	b.size, b.gen = info.Size(), b.gen+1
+	return nil
+}
+
+// current returns the sequence number of the latest event.
+func (b *eventBus) current() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.seq
+}
+
+// Watch delivers the events matching opts until the returned function is
+// called or the driver is closed.
+//
+// Events of one collection, and so of one resource, are numbered and
+// delivered in the order their changes became visible on disk: each is
+// sequenced while the mutation still holds the collection lock, and every
+// subscriber receives events in sequence order. Events of different
+// collections may interleave in any order consistent with that.
+func (d *Driver) Watch(opts WatchOptions) (<-chan Event, func()) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultWatchBuffer
+	}
+	b := d.events
+	s := &subscriber{opts: opts, bus: b, done: make(chan struct{}), out: make(chan Event)}
+	s.cond = sync.NewCond(&s.mutex)
+
+	// A subscriber replaying from SinceSeq starts out behind: it reads the
+	// log until it has caught up, the last of it with the bus locked, so no
+	// live event can slip in between.
+	b.mutex.Lock()
+	s.last, s.offset, s.gen = b.seq, b.size, b.gen
+	if opts.SinceSeq > 0 {
+		if b.log == nil {
+			d.log.Warn("Ending a Watch from sequence %d, the change log is disabled", opts.SinceSeq)
+			s.ending = true
+		} else {
+			s.last, s.offset, s.behind = opts.SinceSeq, 0, true
+		}
+	}
+	if !s.ending {
+		b.subscribers[s] = struct{}{}
+	}
+	b.mutex.Unlock()
+
+	go s.run()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, s)
+			b.mutex.Unlock()
+			s.close()
+		})
+	}
+	context.AfterFunc(d.ctx, cancel)
+	return s.out, cancel
+}
+
+// forget ends the subscriptions scoped to a collection that was dropped.
+func (b *eventBus) forget(collection string) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscribers {
+		if s.opts.Collection == collection {
+			delete(b.subscribers, s)
+			s.end()
+		}
+	}
+}
+
+func (b *eventBus) close() error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		s.close()
+	}
+	if b.log == nil {
+		return nil
+	}
+	err := b.log.Close()
+	b.log = nil
+	return err
+}
+
+func (s *subscriber) matches(ev Event) bool {
+	if s.opts.Collection != "" && s.opts.Collection != ev.Collection {
+		return false
+	}
+	if s.opts.ResourcePrefix != "" && !strings.HasPrefix(ev.Resource, s.opts.ResourcePrefix) {
+		return false
+	}
+	if len(s.opts.EventTypes) == 0 {
+		return true
+	}
+	for _, typ := range s.opts.EventTypes {
+		if typ == ev.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// push queues ev for s with the bus mutex held. It returns false when s
+// is full and, without a change log to catch up from, must be dropped.
+func (s *subscriber) push(ev Event) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || s.ending {
+		return false
+	}
+	if s.behind {
+		return true
+	}
+	if s.matches(ev) {
+		if len(s.queue) >= s.opts.Buffer {
+			if s.bus.log == nil {
+				s.ending = true
+				s.cond.Signal()
+				return false
+			}
+			// ev is not in the log yet, so the log ends just past
+			// the last event s has seen.
+			s.offset, s.gen = s.bus.size, s.bus.gen
+			s.behind = true
+			s.cond.Signal()
+			return true
+		}
+		s.queue = append(s.queue, ev)
+		s.cond.Signal()
+	}
+	s.last = ev.Seq
+	return true
+}
+
+// catchUp queues the logged events after the last one s has seen, up to
+// its buffer. It reads the log without the bus lock, taking it only for the
+// events appended meanwhile; once those are queued too, s goes back to live
+// delivery with nothing fallen in between. A subscriber whose next events
+// were pruned from the log is ended rather than skipping them.
+func (b *eventBus) catchUp(s *subscriber) {
+	full, err := b.replayTo(s, false)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err == nil && !full {
+		full, err = b.replayTo(s, true)
+	}
+	if err != nil {
+		delete(b.subscribers, s)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case err == errNoChangeLog || err == errPruned:
+		s.ending = true
+	case err != nil:
+		b.logger.Error("Unable to replay change log: %s", err)
+		s.ending = true
+	case !full:
+		s.behind = false
+	}
+	s.cond.Signal()
+}
+
+var (
+	// errNoChangeLog is returned by replayTo once the bus was closed.
+	errNoChangeLog = errors.New("change log closed")
+	// errPruned is returned by replayTo when events s has not seen were
+	// pruned from the log.
+	errPruned = errors.New("events pruned from the change log")
+)
+
+// replayTo queues the logged events after the last one s has seen, from
+// the offset where it left off, until the end of the log or of its buffer.
+// It reports whether the buffer filled up. Unless locked is set, it takes
+// the bus mutex only to open the log.
+func (b *eventBus) replayTo(s *subscriber, locked bool) (full bool, err error) {
+	if !locked {
+		b.mutex.Lock()
+	}
+	f, offset, gen, err := b.openAt(s)
+	if !locked {
+		b.mutex.Unlock()
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	err = scanFrom(f, offset, func(ev Event, end int64) bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if ev.Seq <= s.last {
+			return true
+		}
+		if len(s.queue) >= s.opts.Buffer {
+			full = true
+			return false
+		}
+		if s.matches(ev) {
+			s.queue = append(s.queue, ev)
+		}
+		s.last, s.offset, s.gen = ev.Seq, end, gen
+		return true
+	})
+	return full, err
+}
+
+// openAt opens the log where s left off. It must be called with b.mutex
+// held; the file stays the same one however the log is pruned later.
+func (b *eventBus) openAt(s *subscriber) (*os.File, int64, int, error) {
+	if b.log == nil {
+		return nil, 0, 0, errNoChangeLog
+	}
+	s.mutex.Lock()
+	since, offset := s.last, s.offset
+	if s.gen != b.gen {
+		offset = 0
+	}
+	s.mutex.Unlock()
+	if !b.retains(since) {
+		b.logger.Warn("Ending a Watch that missed events %d to %d, pruned from the change log", since+1, b.first-1)
+		return nil, 0, 0, errPruned
+	}
+
+	f, err := os.Open(b.path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return f, offset, b.gen, nil
+}
+
+func (s *subscriber) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.cond.Signal()
+}
+
+// end stops the subscription once the already queued events are delivered.
+func (s *subscriber) end() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ending = true
+	s.cond.Signal()
+}
+
+func (s *subscriber) run() {
+	defer close(s.out)
+	for {
+		s.mutex.Lock()
+		for len(s.queue) == 0 && !s.closed && !s.ending && !s.behind {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mutex.Unlock()
+			return
+		}
+		if len(s.queue) == 0 {
+			behind := s.behind && !s.ending
+			s.mutex.Unlock()
+			if !behind {
+				return
+			}
+			s.bus.catchUp(s)
+			continue
+		}
+		ev := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		select {
+		case s.out <- ev:
+		case <-s.done:
+			return
+		}
+	}
+}
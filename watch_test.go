@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeN(t *testing.T, d *Driver, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := d.Write("n", fmt.Sprint(i), i); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+// receive reads n events from events, failing if they take too long.
+func receive(t *testing.T, events <-chan Event, n int) []Event {
+	t.Helper()
+	var got []Event
+	timeout := time.After(10 * time.Second)
+	for len(got) < n {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("channel closed after %d of %d events", len(got), n)
+			}
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatalf("received %d of %d events", len(got), n)
+		}
+	}
+	return got
+}
+
+// Events written while a subscriber is still replaying the log arrive
+// exactly once and in order, even when the subscriber's buffer is far
+// smaller than the replay.
+func TestWatchReplayHandOff(t *testing.T) {
+	d := openTest(t, &Options{ChangeLog: true})
+	writeN(t, d, 0, 200)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeN(t, d, 200, 400)
+	}()
+	events, cancel := d.Watch(WatchOptions{Collection: "n", SinceSeq: 1, Buffer: 16})
+	defer cancel()
+	<-done
+
+	for i, ev := range receive(t, events, 399) {
+		if ev.Seq != uint64(i+2) || ev.Resource != fmt.Sprint(i+1) {
+			t.Fatalf("event %d is %d %s", i, ev.Seq, ev.Resource)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %d", ev.Seq)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// A subscriber catching up resumes reading the log at the line after the
+// last event it has seen rather than from the start.
+func TestWatchCatchUpOffset(t *testing.T) {
+	d := openTest(t, &Options{ChangeLog: true})
+	writeN(t, d, 0, 50)
+	events, cancel := d.Watch(WatchOptions{SinceSeq: 1, Buffer: 4})
+	defer cancel()
+	receive(t, events, 10)
+
+	var s *subscriber
+	d.events.mutex.Lock()
+	for s = range d.events.subscribers {
+	}
+	d.events.mutex.Unlock()
+	s.mutex.Lock()
+	last, offset := s.last, s.offset
+	s.mutex.Unlock()
+
+	b, err := os.ReadFile(d.events.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(b[:offset], []byte("\n")), []byte("\n"))
+	var ev Event
+	if err := json.Unmarshal(lines[len(lines)-1], &ev); err != nil || ev.Seq != last || b[offset-1] != '\n' {
+		t.Fatalf("offset %d does not follow event %d: %v", offset, last, err)
+	}
+
+	for i, ev := range receive(t, events, 39) {
+		if ev.Seq != uint64(i+12) {
+			t.Fatalf("event %d is %d", i, ev.Seq)
+		}
+	}
+}
+
+func TestWatchFilters(t *testing.T) {
+	d := openTest(t, nil)
+	events, cancel := d.Watch(WatchOptions{Collection: "user", ResourcePrefix: "J", EventTypes: []EventType{EventDelete}})
+	defer cancel()
+
+	seedUsers(t, d)
+	for _, u := range testUsers {
+		if err := d.Delete("user", u.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ev := receive(t, events, 1)[0]; ev.Type != EventDelete || ev.Resource != "John" {
+		t.Fatalf("received %+v", ev)
+	}
+}
+
+// Closing the driver ends its watchers: their channels close, Done fires
+// and the subscriber goroutines exit.
+func TestWatchEndsOnClose(t *testing.T) {
+	d := openTest(t, nil)
+	before := runtime.NumGoroutine()
+	events, cancel := d.Watch(WatchOptions{})
+	defer cancel()
+	writeN(t, d, 0, 1)
+	receive(t, events, 1)
+
+	d.Close()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("received an event after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the watcher was not ended by Close")
+	}
+	select {
+	case <-d.Done():
+	default:
+		t.Error("Done is not closed after Close")
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running after Close, %d before Watch", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Without a change log to catch up from, a subscriber that falls a full
+// buffer behind is ended rather than queueing without bound.
+func TestWatchBufferBound(t *testing.T) {
+	d := openTest(t, nil)
+	events, cancel := d.Watch(WatchOptions{Buffer: 2})
+	defer cancel()
+	writeN(t, d, 0, 5)
+
+	receive(t, events, 2)
+	select {
+	case ev, ok := <-events:
+		if ok {
+			t.Fatalf("received event %d past the buffer", ev.Seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the subscription was not ended")
+	}
+}
+
+// A subscriber asking for events the change log cannot replay is ended
+// rather than silently skipping them.
+func TestWatchMissedEvents(t *testing.T) {
+	closed := func(t *testing.T, events <-chan Event) {
+		t.Helper()
+		select {
+		case ev, ok := <-events:
+			if ok {
+				t.Fatalf("received event %d across the gap", ev.Seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the subscription was not ended")
+		}
+	}
+
+	d := openTest(t, nil)
+	writeN(t, d, 0, 3)
+	events, cancel := d.Watch(WatchOptions{SinceSeq: 1})
+	defer cancel()
+	closed(t, events)
+
+	d = openTest(t, &Options{ChangeLog: true, ChangeLogRetain: 10})
+	writeN(t, d, 0, 25)
+	events, cancel = d.Watch(WatchOptions{SinceSeq: 1})
+	defer cancel()
+	closed(t, events)
+	d.events.mutex.Lock()
+	n := len(d.events.subscribers)
+	d.events.mutex.Unlock()
+	if n != 0 {
+		t.Fatalf("%d subscribers left", n)
+	}
+}
+
+func TestChangeLogTornTail(t *testing.T) {
+	dir := t.TempDir()
+	d := reopenTest(t, dir, &Options{ChangeLog: true})
+	writeN(t, d, 0, 3)
+	d.Close()
+
+	path := filepath.Join(dir, changeLogDir, "changes.log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Seq":4,"Ty`)
+	f.Close()
+
+	d = reopenTest(t, dir, &Options{ChangeLog: true})
+	writeN(t, d, 3, 4)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n != 4 || !bytes.Contains(b, []byte(`"Seq":4,"Type":1`)) {
+		t.Fatalf("change log after recovery:\n%s", b)
+	}
+}
+
+func TestChangeLogPrune(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{ChangeLog: true, ChangeLogRetain: 10}
+	d := reopenTest(t, dir, &opts)
+	writeN(t, d, 0, 25)
+
+	b, err := os.ReadFile(filepath.Join(dir, changeLogDir, "changes.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n < 10 || n >= 20 {
+		t.Fatalf("the change log holds %d events", n)
+	}
+	d.Close()
+
+	d = reopenTest(t, dir, &opts)
+	writeN(t, d, 25, 26)
+	events, cancel := d.Watch(WatchOptions{SinceSeq: 20})
+	defer cancel()
+	for i, ev := range receive(t, events, 6) {
+		if ev.Seq != uint64(21+i) {
+			t.Fatalf("event %d is %d", i, ev.Seq)
+		}
+	}
+}
+
+// Under concurrent writers sharing keys, every subscriber receives each
+// key's events in the order the writes became visible: a writer's own
+// writes to a key, made one after another, must arrive in that order, and
+// the last event of each key must carry what is left on disk.
+func TestWatchPerKeyOrder(t *testing.T) {
+	const writers, keys, writes = 8, 4, 50
+
+	for round := int64(0); round < 5; round++ {
+		rng := rand.New(rand.NewSource(round))
+		// A view makes write events carry the record they left; a
+		// synchronous one is done writing by the time Close returns.
+		d := openTest(t, &Options{ChangeLog: true, SyncViews: true})
+		if err := d.RegisterView("nop", "n", func(acc json.RawMessage, _ string, _ json.RawMessage, _ EventType) (json.RawMessage, error) {
+			return acc, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		events, cancel := d.Watch(WatchOptions{Collection: "n"})
+
+		type write struct{ Writer, N int }
+		plans := make([][]int, writers)
+		for w := range plans {
+			for i := 0; i < writes; i++ {
+				plans[w] = append(plans[w], rng.Intn(keys))
+			}
+		}
+		var wg sync.WaitGroup
+		for w, plan := range plans {
+			wg.Add(1)
+			go func(w int, plan []int) {
+				defer wg.Done()
+				for n, k := range plan {
+					if err := d.Write("n", fmt.Sprint(k), write{w, n}); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(w, plan)
+		}
+		wg.Wait()
+
+		last := make(map[string]write)
+		seen := make(map[string]map[int]int)
+		var seq uint64
+		for _, ev := range receive(t, events, writers*writes) {
+			if ev.Seq <= seq {
+				t.Fatalf("round %d: event %d after %d", round, ev.Seq, seq)
+			}
+			seq = ev.Seq
+			var w write
+			if err := json.Unmarshal(ev.doc, &w); err != nil {
+				t.Fatalf("round %d: event %d: %s", round, ev.Seq, err)
+			}
+			if seen[ev.Resource] == nil {
+				seen[ev.Resource] = make(map[int]int)
+			}
+			if prev, ok := seen[ev.Resource][w.Writer]; ok && w.N <= prev {
+				t.Fatalf("round %d: key %s: write %d of writer %d arrived after its write %d", round, ev.Resource, w.N, w.Writer, prev)
+			}
+			seen[ev.Resource][w.Writer] = w.N
+			last[ev.Resource] = w
+		}
+		cancel()
+
+		for key, w := range last {
+			var stored write
+			if err := d.Read("n", key, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored != w {
+				t.Errorf("round %d: key %s holds %+v, its last event %+v", round, key, stored, w)
+			}
+		}
+		d.Close()
+	}
+}
@@ -0,0 +1,1655 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// This file implements the Zstandard format (RFC 8878) for
+// CompressionZstd. The decoder reads any single-threaded zstd frame,
+// including those written by the zstd tool; the encoder produces frames
+// with lazily chosen LZ77 matches, Huffman-coded literals and per-block
+// sequence tables, close to the zstd tool's fastest level.
+
+const (
+	zstdMagic         = 0xFD2FB528
+	zstdMaxBlockSize  = 128 << 10
+	zstdMaxWindowSize = 64 << 20
+)
+
+var errZstdCorrupt = errors.New("zstd: corrupt input")
+
+// xxhash64 is XXH64 with seed 0, whose low 32 bits form the frame checksum.
+func xxhash64(b []byte) uint64 {
+	const (
+		p1 = 11400714785074694791
+		p2 = 14029467366897019727
+		p3 = 1609587929392839161
+		p4 = 9650029242287828579
+		p5 = 2870177450012600261
+	)
+	round := func(acc, v uint64) uint64 {
+		return bits.RotateLeft64(acc+v*p2, 31) * p1
+	}
+	merge := func(acc, v uint64) uint64 {
+		return (acc^round(0, v))*p1 + p4
+	}
+
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		prime1 := uint64(p1)
+		v1, v2, v3, v4 := prime1+p2, uint64(p2), uint64(0), -prime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = round(v1, binary.LittleEndian.Uint64(b))
+			v2 = round(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = round(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = round(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = merge(h, v1)
+		h = merge(h, v2)
+		h = merge(h, v3)
+		h = merge(h, v4)
+	} else {
+		h = p5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*p1 + p4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * p1
+		h = bits.RotateLeft64(h, 23)*p2 + p3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * p5
+		h = bits.RotateLeft64(h, 11) * p1
+	}
+
+	h ^= h >> 33
+	h *= p2
+	h ^= h >> 29
+	h *= p3
+	h ^= h >> 32
+	return h
+}
+
+// backwardBits reads a zstd bitstream from its end towards its start, the
+// way FSE and Huffman streams are laid out. Bits before the start read as
+// zero.
+type backwardBits struct {
+	b   []byte
+	pos int
+}
+
+func newBackwardBits(b []byte) (*backwardBits, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, errZstdCorrupt
+	}
+	// The highest set bit of the last byte marks the end of the stream.
+	pos := len(b)*8 - bits.LeadingZeros8(b[len(b)-1]) - 1
+	return &backwardBits{b: b, pos: pos}, nil
+}
+
+// peek returns the n bits below the current position without consuming
+// them.
+func (r *backwardBits) peek(n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+	start := r.pos - n
+	if start >= 0 {
+		i := start >> 3
+		var word [8]byte
+		copy(word[:], r.b[i:])
+		v := binary.LittleEndian.Uint64(word[:]) >> uint(start&7)
+		return v & (1<<uint(n) - 1)
+	}
+	var v uint64
+	for i := 0; i < n; i++ {
+		if bit := start + i; bit >= 0 {
+			v |= uint64(r.b[bit>>3]>>uint(bit&7)&1) << uint(i)
+		}
+	}
+	return v
+}
+
+func (r *backwardBits) read(n int) uint64 {
+	v := r.peek(n)
+	r.pos -= n
+	return v
+}
+
+// forwardBits writes a bitstream LSB first; the reader consumes it from
+// the end, so values must be written in the reverse of their read order.
+type forwardBits struct {
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *forwardBits) write(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	w.acc |= (v & (1<<n - 1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// close writes the end-of-stream marker and pads to a byte boundary.
+func (w *forwardBits) close() []byte {
+	w.write(1, 1)
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.out
+}
+
+func highBit(v uint32) int {
+	return 31 - bits.LeadingZeros32(v)
+}
+
+// fseTable is a decoding table for one FSE-coded alphabet.
+type fseTable struct {
+	log     int
+	entries []fseEntry
+}
+
+type fseEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	baseline uint16
+}
+
+// spreadSymbols lays out the symbols of a normalized distribution over the
+// states of a table, as both the encoder and the decoder must.
+func spreadSymbols(norm []int16, log int) []uint8 {
+	size := 1 << uint(log)
+	table := make([]uint8, size)
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			table[high] = uint8(s)
+			high--
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			table[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	return table
+}
+
+func newFSETable(norm []int16, log int) *fseTable {
+	size := 1 << uint(log)
+	symbols := spreadSymbols(norm, log)
+	next := make([]uint32, len(norm))
+	for s, n := range norm {
+		if n == -1 {
+			next[s] = 1
+		} else {
+			next[s] = uint32(n)
+		}
+	}
+	t := &fseTable{log: log, entries: make([]fseEntry, size)}
+	for state, s := range symbols {
+		n := next[s]
+		next[s]++
+		nbBits := log - highBit(n)
+		t.entries[state] = fseEntry{
+			symbol:   s,
+			nbBits:   uint8(nbBits),
+			baseline: uint16(int(n)<<uint(nbBits) - size),
+		}
+	}
+	return t
+}
+
+// rleFSETable always decodes symbol without reading bits.
+func rleFSETable(symbol uint8) *fseTable {
+	return &fseTable{log: 0, entries: []fseEntry{{symbol: symbol}}}
+}
+
+// readFSEDistribution decodes a normalized distribution header from b,
+// returning it with its accuracy log and the number of bytes it took.
+func readFSEDistribution(b []byte, maxSymbol, maxLog int) ([]int16, int, int, error) {
+	bitPos := 0
+	readBits := func(n int) uint32 {
+		var v uint32
+		for i := 0; i < n; i++ {
+			p := bitPos + i
+			if p>>3 < len(b) {
+				v |= uint32(b[p>>3]>>uint(p&7)&1) << uint(i)
+			}
+		}
+		return v
+	}
+
+	log := int(readBits(4)) + 5
+	bitPos += 4
+	if log > maxLog {
+		return nil, 0, 0, errZstdCorrupt
+	}
+
+	remaining := (1 << uint(log)) + 1
+	threshold := 1 << uint(log)
+	nbBits := log + 1
+	var norm []int16
+	for remaining > 1 && len(norm) <= maxSymbol {
+		max := 2*threshold - 1 - remaining
+		var count int
+		if small := int(readBits(nbBits - 1)); small < max {
+			count = small
+			bitPos += nbBits - 1
+		} else {
+			count = int(readBits(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+			bitPos += nbBits
+		}
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+
+		if count == 0 {
+			for {
+				repeat := int(readBits(2))
+				bitPos += 2
+				for i := 0; i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || len(norm) > maxSymbol+1 || bitPos>>3 >= len(b)+1 {
+		return nil, 0, 0, errZstdCorrupt
+	}
+	return norm, log, (bitPos + 7) >> 3, nil
+}
+
+// Predefined distributions of the sequence codes.
+var (
+	zstdLitLengthNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdMatchLengthNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdOffsetNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+
+	zstdLitLengthTable   = newFSETable(zstdLitLengthNorm, 6)
+	zstdMatchLengthTable = newFSETable(zstdMatchLengthNorm, 6)
+	zstdOffsetTable      = newFSETable(zstdOffsetNorm, 5)
+)
+
+// Baselines and extra bits of the literal and match length codes.
+var (
+	zstdLitLengthCodes = func() [][2]uint32 {
+		codes := make([][2]uint32, 0, 36)
+		for i := uint32(0); i < 16; i++ {
+			codes = append(codes, [2]uint32{i, 0})
+		}
+		return append(codes,
+			[2]uint32{16, 1}, [2]uint32{18, 1}, [2]uint32{20, 1}, [2]uint32{22, 1},
+			[2]uint32{24, 2}, [2]uint32{28, 2}, [2]uint32{32, 3}, [2]uint32{40, 3},
+			[2]uint32{48, 4}, [2]uint32{64, 6}, [2]uint32{128, 7}, [2]uint32{256, 8},
+			[2]uint32{512, 9}, [2]uint32{1024, 10}, [2]uint32{2048, 11}, [2]uint32{4096, 12},
+			[2]uint32{8192, 13}, [2]uint32{16384, 14}, [2]uint32{32768, 15}, [2]uint32{65536, 16},
+		)
+	}()
+	zstdMatchLengthCodes = func() [][2]uint32 {
+		codes := make([][2]uint32, 0, 53)
+		for i := uint32(0); i < 32; i++ {
+			codes = append(codes, [2]uint32{i + 3, 0})
+		}
+		return append(codes,
+			[2]uint32{35, 1}, [2]uint32{37, 1}, [2]uint32{39, 1}, [2]uint32{41, 1},
+			[2]uint32{43, 2}, [2]uint32{47, 2}, [2]uint32{51, 3}, [2]uint32{59, 3},
+			[2]uint32{67, 4}, [2]uint32{83, 4}, [2]uint32{99, 5}, [2]uint32{131, 7},
+			[2]uint32{259, 8}, [2]uint32{515, 9}, [2]uint32{1027, 10}, [2]uint32{2051, 11},
+			[2]uint32{4099, 12}, [2]uint32{8195, 13}, [2]uint32{16387, 14}, [2]uint32{32771, 15},
+			[2]uint32{65539, 16},
+		)
+	}()
+)
+
+// zstdDecoder holds the state carried between the blocks of a frame.
+type zstdDecoder struct {
+	out     []byte
+	huffman *huffmanTable
+	tables  [3]*fseTable // literal lengths, offsets, match lengths
+	reps    [3]uint32
+}
+
+func zstdDecompress(src []byte) ([]byte, error) {
+	var out []byte
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return nil, errZstdCorrupt
+		}
+		magic := binary.LittleEndian.Uint32(src)
+		if magic&0xFFFFFFF0 == 0x184D2A50 {
+			// A skippable frame.
+			if len(src) < 8 {
+				return nil, errZstdCorrupt
+			}
+			n := int(binary.LittleEndian.Uint32(src[4:]))
+			if n > len(src)-8 {
+				return nil, errZstdCorrupt
+			}
+			src = src[8+n:]
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, fmt.Errorf("zstd: bad magic %#x", magic)
+		}
+		frame, n, err := decodeZstdFrame(src[4:])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, frame...)
+		src = src[4+n:]
+	}
+	return out, nil
+}
+
+// decodeZstdFrame decodes the frame after its magic number, returning its
+// content and the number of bytes it took.
+func decodeZstdFrame(src []byte) ([]byte, int, error) {
+	if len(src) < 1 {
+		return nil, 0, errZstdCorrupt
+	}
+	desc := src[0]
+	fcsFlag := desc >> 6
+	singleSegment := desc&0x20 != 0
+	hasChecksum := desc&0x04 != 0
+	dictFlag := desc & 3
+	if desc&0x08 != 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	pos := 1
+
+	windowSize := uint64(0)
+	if !singleSegment {
+		if pos >= len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		exp := uint64(src[pos]>>3) + 10
+		base := uint64(1) << exp
+		windowSize = base + base/8*uint64(src[pos]&7)
+		pos++
+	}
+	if dictFlag != 0 {
+		n := []int{0, 1, 2, 4}[dictFlag]
+		if pos+n > len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		dict := uint32(0)
+		for i := 0; i < n; i++ {
+			dict |= uint32(src[pos+i]) << (8 * uint(i))
+		}
+		if dict != 0 {
+			return nil, 0, fmt.Errorf("zstd: dictionaries are not supported")
+		}
+		pos += n
+	}
+	fcsSize := []int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+	contentSize := uint64(0)
+	if fcsSize > 0 {
+		if pos+fcsSize > len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := 0; i < fcsSize; i++ {
+			contentSize |= uint64(src[pos+i]) << (8 * uint(i))
+		}
+		if fcsSize == 2 {
+			contentSize += 256
+		}
+		pos += fcsSize
+	}
+	if singleSegment {
+		windowSize = contentSize
+	}
+	if windowSize > zstdMaxWindowSize {
+		return nil, 0, fmt.Errorf("zstd: window of %d bytes is too large", windowSize)
+	}
+
+	d := &zstdDecoder{reps: [3]uint32{1, 4, 8}}
+	if fcsSize > 0 && contentSize <= zstdMaxWindowSize {
+		d.out = make([]byte, 0, contentSize)
+	}
+	for {
+		if pos+3 > len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		header := uint32(src[pos]) | uint32(src[pos+1])<<8 | uint32(src[pos+2])<<16
+		pos += 3
+		last := header&1 != 0
+		kind := (header >> 1) & 3
+		size := int(header >> 3)
+
+		switch kind {
+		case 0:
+			if pos+size > len(src) {
+				return nil, 0, errZstdCorrupt
+			}
+			d.out = append(d.out, src[pos:pos+size]...)
+			pos += size
+		case 1:
+			if pos >= len(src) {
+				return nil, 0, errZstdCorrupt
+			}
+			for i := 0; i < size; i++ {
+				d.out = append(d.out, src[pos])
+			}
+			pos++
+		case 2:
+			if size > zstdMaxBlockSize || pos+size > len(src) {
+				return nil, 0, errZstdCorrupt
+			}
+			if err := d.decodeBlock(src[pos : pos+size]); err != nil {
+				return nil, 0, err
+			}
+			pos += size
+		default:
+			return nil, 0, errZstdCorrupt
+		}
+		if last {
+			break
+		}
+	}
+
+	if fcsSize > 0 && uint64(len(d.out)) != contentSize {
+		return nil, 0, errZstdCorrupt
+	}
+	if hasChecksum {
+		if pos+4 > len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		if binary.LittleEndian.Uint32(src[pos:]) != uint32(xxhash64(d.out)) {
+			return nil, 0, fmt.Errorf("zstd: checksum mismatch")
+		}
+		pos += 4
+	}
+	return d.out, pos, nil
+}
+
+func (d *zstdDecoder) decodeBlock(b []byte) error {
+	literals, n, err := d.decodeLiterals(b)
+	if err != nil {
+		return err
+	}
+	return d.decodeSequences(b[n:], literals)
+}
+
+// decodeLiterals decodes the literals section at the start of a compressed
+// block, returning the literals and the size of the section.
+func (d *zstdDecoder) decodeLiterals(b []byte) ([]byte, int, error) {
+	if len(b) < 1 {
+		return nil, 0, errZstdCorrupt
+	}
+	kind := b[0] & 3
+	sizeFormat := (b[0] >> 2) & 3
+
+	if kind < 2 {
+		var size, header int
+		switch sizeFormat {
+		case 0, 2:
+			size, header = int(b[0]>>3), 1
+		case 1:
+			if len(b) < 2 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, header = int(b[0]>>4)|int(b[1])<<4, 2
+		case 3:
+			if len(b) < 3 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, header = int(b[0]>>4)|int(b[1])<<4|int(b[2])<<12, 3
+		}
+		if kind == 0 {
+			if header+size > len(b) {
+				return nil, 0, errZstdCorrupt
+			}
+			return b[header : header+size], header + size, nil
+		}
+		if header >= len(b) {
+			return nil, 0, errZstdCorrupt
+		}
+		out := make([]byte, size)
+		for i := range out {
+			out[i] = b[header]
+		}
+		return out, header + 1, nil
+	}
+
+	var regen, compressed, header int
+	streams := 4
+	switch sizeFormat {
+	case 0, 1:
+		if len(b) < 3 {
+			return nil, 0, errZstdCorrupt
+		}
+		v := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		regen, compressed, header = (v>>4)&0x3FF, v>>14, 3
+		if sizeFormat == 0 {
+			streams = 1
+		}
+	case 2:
+		if len(b) < 4 {
+			return nil, 0, errZstdCorrupt
+		}
+		v := int(binary.LittleEndian.Uint32(b))
+		regen, compressed, header = (v>>4)&0x3FFF, v>>18, 4
+	case 3:
+		if len(b) < 5 {
+			return nil, 0, errZstdCorrupt
+		}
+		v := int(binary.LittleEndian.Uint32(b)) | int(b[4])<<32
+		regen, compressed, header = (v>>4)&0x3FFFF, v>>22, 5
+	}
+	if header+compressed > len(b) || regen > zstdMaxBlockSize {
+		return nil, 0, errZstdCorrupt
+	}
+	data := b[header : header+compressed]
+
+	if kind == 2 {
+		table, n, err := readHuffmanTable(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.huffman = table
+		data = data[n:]
+	} else if d.huffman == nil {
+		return nil, 0, errZstdCorrupt
+	}
+
+	out := make([]byte, regen)
+	if streams == 1 {
+		if err := d.huffman.decode(data, out); err != nil {
+			return nil, 0, err
+		}
+		return out, header + compressed, nil
+	}
+
+	if len(data) < 6 {
+		return nil, 0, errZstdCorrupt
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(data)),
+		int(binary.LittleEndian.Uint16(data[2:])),
+		int(binary.LittleEndian.Uint16(data[4:])),
+	}
+	data = data[6:]
+	sizes[3] = len(data) - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	segment := (regen + 3) / 4
+	if 3*segment > regen {
+		return nil, 0, errZstdCorrupt
+	}
+	start := 0
+	for i, size := range sizes {
+		end := start + segment
+		if i == 3 {
+			end = regen
+		}
+		if err := d.huffman.decode(data[:size], out[start:end]); err != nil {
+			return nil, 0, err
+		}
+		data = data[size:]
+		start = end
+	}
+	return out, header + compressed, nil
+}
+
+// huffmanTable decodes literals by peeking maxBits bits.
+type huffmanTable struct {
+	maxBits int
+	symbols []uint8
+	lengths []uint8
+}
+
+// readHuffmanTable decodes a Huffman tree description, returning the table
+// and the number of bytes it took.
+func readHuffmanTable(b []byte) (*huffmanTable, int, error) {
+	if len(b) < 1 {
+		return nil, 0, errZstdCorrupt
+	}
+	var weights []uint8
+	n := 1
+	if header := int(b[0]); header >= 128 {
+		count := header - 127
+		n += (count + 1) / 2
+		if n > len(b) {
+			return nil, 0, errZstdCorrupt
+		}
+		for i := 0; i < count; i++ {
+			w := b[1+i/2]
+			if i%2 == 0 {
+				w >>= 4
+			}
+			weights = append(weights, w&0xF)
+		}
+	} else {
+		n += header
+		if n > len(b) {
+			return nil, 0, errZstdCorrupt
+		}
+		var err error
+		if weights, err = decodeHuffmanWeights(b[1:n]); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(weights) > 255 {
+		return nil, 0, errZstdCorrupt
+	}
+
+	total := uint32(0)
+	for _, w := range weights {
+		if w > 11 {
+			return nil, 0, errZstdCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	maxBits := highBit(total) + 1
+	if maxBits > 11 {
+		return nil, 0, errZstdCorrupt
+	}
+	rest := uint32(1)<<uint(maxBits) - total
+	if rest&(rest-1) != 0 {
+		return nil, 0, errZstdCorrupt
+	}
+	weights = append(weights, uint8(highBit(rest)+1))
+
+	t := &huffmanTable{
+		maxBits: maxBits,
+		symbols: make([]uint8, 1<<uint(maxBits)),
+		lengths: make([]uint8, 1<<uint(maxBits)),
+	}
+	index := 0
+	for w := uint8(1); w <= 11; w++ {
+		for s, sw := range weights {
+			if sw != w {
+				continue
+			}
+			span := 1 << (w - 1)
+			for i := 0; i < span; i++ {
+				t.symbols[index+i] = uint8(s)
+				t.lengths[index+i] = uint8(maxBits + 1 - int(w))
+			}
+			index += span
+		}
+	}
+	return t, n, nil
+}
+
+// decodeHuffmanWeights decodes FSE-compressed Huffman weights.
+func decodeHuffmanWeights(b []byte) ([]uint8, error) {
+	norm, log, n, err := readFSEDistribution(b, 255, 6)
+	if err != nil {
+		return nil, err
+	}
+	table := newFSETable(norm, log)
+	r, err := newBackwardBits(b[n:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Two interleaved states share the stream.
+	states := [2]int{int(r.read(log)), int(r.read(log))}
+	var weights []uint8
+	for i := 0; ; i = 1 - i {
+		e := table.entries[states[i]]
+		weights = append(weights, e.symbol)
+		if len(weights) > 255 {
+			return nil, errZstdCorrupt
+		}
+		if r.pos < int(e.nbBits) {
+			// The stream is exhausted: the other state yields the last weight.
+			weights = append(weights, table.entries[states[1-i]].symbol)
+			break
+		}
+		states[i] = int(e.baseline) + int(r.read(int(e.nbBits)))
+	}
+	return weights, nil
+}
+
+func (t *huffmanTable) decode(stream []byte, out []byte) error {
+	r, err := newBackwardBits(stream)
+	if err != nil {
+		return err
+	}
+	for i := range out {
+		v := r.peek(t.maxBits)
+		out[i] = t.symbols[v]
+		r.pos -= int(t.lengths[v])
+	}
+	if r.pos != 0 {
+		return errZstdCorrupt
+	}
+	return nil
+}
+
+// decodeSequences decodes the sequences section of a block and executes
+// it against the literals.
+func (d *zstdDecoder) decodeSequences(b []byte, literals []byte) error {
+	if len(b) < 1 {
+		return errZstdCorrupt
+	}
+	count := int(b[0])
+	pos := 1
+	switch {
+	case count == 0:
+		d.out = append(d.out, literals...)
+		return nil
+	case count == 255:
+		if len(b) < 3 {
+			return errZstdCorrupt
+		}
+		count = int(b[1]) + int(b[2])<<8 + 0x7F00
+		pos = 3
+	case count >= 128:
+		if len(b) < 2 {
+			return errZstdCorrupt
+		}
+		count = (count-128)<<8 + int(b[1])
+		pos = 2
+	}
+	if pos >= len(b) {
+		return errZstdCorrupt
+	}
+	modes := b[pos]
+	pos++
+
+	kinds := []struct {
+		mode    byte
+		norm    []int16
+		log     int
+		max     int
+		maxLog  int
+		initial *fseTable
+	}{
+		{modes >> 6, zstdLitLengthNorm, 6, 35, 9, zstdLitLengthTable},
+		{(modes >> 4) & 3, zstdOffsetNorm, 5, 31, 8, zstdOffsetTable},
+		{(modes >> 2) & 3, zstdMatchLengthNorm, 6, 52, 9, zstdMatchLengthTable},
+	}
+	for i, k := range kinds {
+		switch k.mode {
+		case 0:
+			d.tables[i] = k.initial
+		case 1:
+			if pos >= len(b) || int(b[pos]) > k.max {
+				return errZstdCorrupt
+			}
+			d.tables[i] = rleFSETable(b[pos])
+			pos++
+		case 2:
+			norm, log, n, err := readFSEDistribution(b[pos:], k.max, k.maxLog)
+			if err != nil {
+				return err
+			}
+			d.tables[i] = newFSETable(norm, log)
+			pos += n
+		case 3:
+			if d.tables[i] == nil {
+				return errZstdCorrupt
+			}
+		}
+	}
+
+	r, err := newBackwardBits(b[pos:])
+	if err != nil {
+		return err
+	}
+	ll, of, ml := d.tables[0], d.tables[1], d.tables[2]
+	llState := int(r.read(ll.log))
+	ofState := int(r.read(of.log))
+	mlState := int(r.read(ml.log))
+
+	for i := 0; i < count; i++ {
+		ofCode := of.entries[ofState].symbol
+		mlCode := ml.entries[mlState].symbol
+		llCode := ll.entries[llState].symbol
+		if int(mlCode) >= len(zstdMatchLengthCodes) || int(llCode) >= len(zstdLitLengthCodes) || ofCode > 31 {
+			return errZstdCorrupt
+		}
+
+		offsetValue := uint32(1)<<ofCode + uint32(r.read(int(ofCode)))
+		mc := zstdMatchLengthCodes[mlCode]
+		matchLength := mc[0] + uint32(r.read(int(mc[1])))
+		lc := zstdLitLengthCodes[llCode]
+		litLength := lc[0] + uint32(r.read(int(lc[1])))
+
+		var offset uint32
+		if offsetValue > 3 {
+			offset = offsetValue - 3
+			d.reps = [3]uint32{offset, d.reps[0], d.reps[1]}
+		} else {
+			index := offsetValue
+			if litLength == 0 {
+				index++
+			}
+			switch index {
+			case 1:
+				offset = d.reps[0]
+			case 2:
+				offset = d.reps[1]
+				d.reps[0], d.reps[1] = d.reps[1], d.reps[0]
+			case 3:
+				offset = d.reps[2]
+				d.reps = [3]uint32{offset, d.reps[0], d.reps[1]}
+			case 4:
+				offset = d.reps[0] - 1
+				d.reps = [3]uint32{offset, d.reps[0], d.reps[1]}
+			}
+		}
+
+		if int(litLength) > len(literals) {
+			return errZstdCorrupt
+		}
+		d.out = append(d.out, literals[:litLength]...)
+		literals = literals[litLength:]
+		if offset == 0 || int(offset) > len(d.out) {
+			return errZstdCorrupt
+		}
+		start := len(d.out) - int(offset)
+		for j := 0; j < int(matchLength); j++ {
+			d.out = append(d.out, d.out[start+j])
+		}
+
+		if i < count-1 {
+			e := ll.entries[llState]
+			llState = int(e.baseline) + int(r.read(int(e.nbBits)))
+			e = ml.entries[mlState]
+			mlState = int(e.baseline) + int(r.read(int(e.nbBits)))
+			e = of.entries[ofState]
+			ofState = int(e.baseline) + int(r.read(int(e.nbBits)))
+		}
+	}
+	if r.pos != 0 {
+		return errZstdCorrupt
+	}
+	d.out = append(d.out, literals...)
+	return nil
+}
+
+// fseEncoder encodes symbols of a normalized distribution.
+type fseEncoder struct {
+	log        int
+	stateTable []uint16
+	transforms []fseTransform
+}
+
+type fseTransform struct {
+	deltaNbBits    int32
+	deltaFindState int32
+}
+
+func newFSEEncoder(norm []int16, log int) *fseEncoder {
+	size := 1 << uint(log)
+	symbols := spreadSymbols(norm, log)
+
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			n = 1
+		}
+		cumul[s+1] = cumul[s] + int(n)
+	}
+	e := &fseEncoder{log: log, stateTable: make([]uint16, size), transforms: make([]fseTransform, len(norm))}
+	for u, s := range symbols {
+		e.stateTable[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+
+	total := int32(0)
+	for s, n := range norm {
+		switch n {
+		case 0:
+			e.transforms[s].deltaNbBits = int32(log+1)<<16 - int32(size)
+		case -1, 1:
+			e.transforms[s] = fseTransform{int32(log)<<16 - int32(size), total - 1}
+			total++
+		default:
+			maxBitsOut := int32(log - highBit(uint32(n-1)))
+			minStatePlus := int32(n) << uint(maxBitsOut)
+			e.transforms[s] = fseTransform{maxBitsOut<<16 - minStatePlus, total - int32(n)}
+			total += int32(n)
+		}
+	}
+	return e
+}
+
+// init returns the state the stream ends in after encoding symbol last.
+func (e *fseEncoder) init(symbol uint8) uint32 {
+	t := e.transforms[symbol]
+	nbBitsOut := (t.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - t.deltaNbBits
+	return uint32(e.stateTable[(value>>uint(nbBitsOut))+t.deltaFindState])
+}
+
+func (e *fseEncoder) encode(w *forwardBits, state uint32, symbol uint8) uint32 {
+	t := e.transforms[symbol]
+	nbBitsOut := uint32(int32(state)+t.deltaNbBits) >> 16
+	w.write(uint64(state), uint(nbBitsOut))
+	return uint32(e.stateTable[int32(state>>nbBitsOut)+t.deltaFindState])
+}
+
+func (e *fseEncoder) flush(w *forwardBits, state uint32) {
+	w.write(uint64(state), uint(e.log))
+}
+
+var (
+	zstdLitLengthEncoder   = newFSEEncoder(zstdLitLengthNorm, 6)
+	zstdMatchLengthEncoder = newFSEEncoder(zstdMatchLengthNorm, 6)
+	zstdOffsetEncoder      = newFSEEncoder(zstdOffsetNorm, 5)
+)
+
+// lengthCode returns the code of a literal or match length and its extra
+// bits.
+func lengthCode(codes [][2]uint32, v uint32) (uint8, uint32, uint) {
+	code := len(codes) - 1
+	for codes[code][0] > v {
+		code--
+	}
+	return uint8(code), v - codes[code][0], uint(codes[code][1])
+}
+
+// zstdSequence holds the offset as coded: 1 to 3 name a repeated offset,
+// larger values are the distance plus 3.
+type zstdSequence struct {
+	litLength, matchLength, offset uint32
+}
+
+const (
+	zstdMinMatch  = 4
+	zstdHashLog   = 15
+	zstdMaxOffset = 1<<28 - 4
+)
+
+func zstdHash(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b) * 2654435761 >> (32 - zstdHashLog)
+}
+
+func zstdHashLong(b []byte) uint32 {
+	return uint32(binary.LittleEndian.Uint64(b) * 0x9E3779B185EBCA87 >> (64 - zstdHashLog))
+}
+
+// zstdTables remember the last position of each 4 and 8 byte prefix, the
+// latter finding long matches the former has overwritten.
+type zstdTables struct {
+	short, long []int32
+}
+
+func newZstdTables() *zstdTables {
+	t := &zstdTables{short: make([]int32, 1<<zstdHashLog), long: make([]int32, 1<<zstdHashLog)}
+	for i := range t.short {
+		t.short[i], t.long[i] = -1, -1
+	}
+	return t
+}
+
+// zstdMatchLen returns how many bytes from src[i:end] repeat those at
+// src[candidate:].
+func zstdMatchLen(src []byte, candidate, i, end int) int {
+	n := 0
+	for i+n+8 <= end {
+		if x := binary.LittleEndian.Uint64(src[i+n:]) ^ binary.LittleEndian.Uint64(src[candidate+n:]); x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for i+n < end && src[i+n] == src[candidate+n] {
+		n++
+	}
+	return n
+}
+
+func zstdCompress(src []byte) []byte {
+	out := make([]byte, 0, len(src)/2+16)
+	out = binary.LittleEndian.AppendUint32(out, zstdMagic)
+
+	// A single segment frame: the window is the whole content.
+	n := uint64(len(src))
+	switch {
+	case n < 256:
+		out = append(out, 0x24, byte(n))
+	case n < 65536+256:
+		out = append(out, 0x64)
+		out = binary.LittleEndian.AppendUint16(out, uint16(n-256))
+	case n < 1<<32:
+		out = append(out, 0xA4)
+		out = binary.LittleEndian.AppendUint32(out, uint32(n))
+	default:
+		out = append(out, 0xE4)
+		out = binary.LittleEndian.AppendUint64(out, n)
+	}
+
+	tables := newZstdTables()
+	// Repeated offsets carry over between compressed blocks only, so each
+	// block works on a copy that is kept if the block is.
+	reps := [3]uint32{1, 4, 8}
+	for start := 0; ; {
+		end := start + zstdMaxBlockSize
+		if end > len(src) {
+			end = len(src)
+		}
+		last := end == len(src)
+
+		blockReps := reps
+		block := compressZstdBlock(src, start, end, tables, &blockReps)
+		kind := uint32(2)
+		if block == nil || len(block) >= end-start {
+			block, kind = src[start:end], 0
+		} else {
+			reps = blockReps
+		}
+		header := kind<<1 | uint32(len(block))<<3
+		if last {
+			header |= 1
+		}
+		out = append(out, byte(header), byte(header>>8), byte(header>>16))
+		out = append(out, block...)
+
+		if last {
+			break
+		}
+		start = end
+	}
+	return binary.LittleEndian.AppendUint32(out, uint32(xxhash64(src)))
+}
+
+// compressZstdBlock encodes src[start:end] as the content of a compressed
+// block, matching against everything before it in src. It returns nil if
+// the block cannot be represented.
+func compressZstdBlock(src []byte, start, end int, tables *zstdTables, reps *[3]uint32) []byte {
+	var sequences []zstdSequence
+	var literals []byte
+
+	anchor := start
+	// find returns the best match at i, if any, weighing the bytes each
+	// candidate saves against its offset's extra bits.
+	find := func(i int) (candidate, length, score int) {
+		candidate = -1
+		try := func(c int) {
+			if c < 0 || c >= i || i-c > zstdMaxOffset {
+				return
+			}
+			n := zstdMatchLen(src, c, i, end)
+			if n < zstdMinMatch {
+				return
+			}
+			code, _ := zstdOffsetCode(*reps, uint32(i-c), uint32(i-anchor))
+			if sc := 6*n - highBit(code); sc > score {
+				candidate, length, score = c, n, sc
+			}
+		}
+		for _, rep := range reps {
+			try(i - int(rep))
+		}
+		h := zstdHash(src[i:])
+		try(int(tables.short[h]))
+		tables.short[h] = int32(i)
+		if i+8 <= end {
+			h := zstdHashLong(src[i:])
+			try(int(tables.long[h]))
+			tables.long[h] = int32(i)
+		}
+		return candidate, length, score
+	}
+
+	for i := start; i+zstdMinMatch <= end; {
+		candidate, length, score := find(i)
+		if candidate < 0 {
+			i++
+			continue
+		}
+		// Take a literal instead when the next position matches better.
+		for i+1+zstdMinMatch <= end {
+			c, n, sc := find(i + 1)
+			if c < 0 || sc <= score+6 {
+				break
+			}
+			i, candidate, length, score = i+1, c, n, sc
+		}
+
+		// Extend backwards over pending literals.
+		for i > anchor && candidate > 0 && src[i-1] == src[candidate-1] {
+			i--
+			candidate--
+			length++
+		}
+
+		literals = append(literals, src[anchor:i]...)
+		var code uint32
+		code, *reps = zstdOffsetCode(*reps, uint32(i-candidate), uint32(i-anchor))
+		sequences = append(sequences, zstdSequence{
+			litLength:   uint32(i - anchor),
+			matchLength: uint32(length),
+			offset:      code,
+		})
+		for j := i + 1; j < i+length && j+8 <= end; j++ {
+			tables.short[zstdHash(src[j:])] = int32(j)
+			tables.long[zstdHashLong(src[j:])] = int32(j)
+		}
+		i += length
+		anchor = i
+	}
+	literals = append(literals, src[anchor:end]...)
+
+	out := encodeZstdLiterals(nil, literals)
+	return encodeZstdSequences(out, sequences)
+}
+
+// zstdOffsetCode returns how a match at offset is coded after litLength
+// literals, and the repeated offsets the decoder will have after it.
+func zstdOffsetCode(reps [3]uint32, offset, litLength uint32) (uint32, [3]uint32) {
+	switch {
+	case offset == reps[0] && litLength > 0:
+		return 1, reps
+	case offset == reps[1]:
+		code := uint32(2)
+		if litLength == 0 {
+			code = 1
+		}
+		return code, [3]uint32{reps[1], reps[0], reps[2]}
+	case offset == reps[2]:
+		code := uint32(3)
+		if litLength == 0 {
+			code = 2
+		}
+		return code, [3]uint32{reps[2], reps[0], reps[1]}
+	case offset == reps[0]-1 && litLength == 0:
+		return 3, [3]uint32{offset, reps[0], reps[1]}
+	}
+	return offset + 3, [3]uint32{offset, reps[0], reps[1]}
+}
+
+func encodeZstdLiterals(out, literals []byte) []byte {
+	if compressed := encodeHuffmanLiterals(literals); compressed != nil {
+		return append(out, compressed...)
+	}
+
+	n := len(literals)
+	switch {
+	case n < 32:
+		out = append(out, byte(n<<3))
+	case n < 4096:
+		out = append(out, byte(n<<4)|1<<2, byte(n>>4))
+	default:
+		out = append(out, byte(n<<4)|3<<2, byte(n>>4), byte(n>>12))
+	}
+	return append(out, literals...)
+}
+
+// encodeHuffmanLiterals returns a Huffman-compressed literals section, or
+// nil when raw literals would not be larger.
+func encodeHuffmanLiterals(literals []byte) []byte {
+	if len(literals) < 64 {
+		return nil
+	}
+	var freq [256]int
+	maxSymbol := 0
+	distinct := 0
+	for _, c := range literals {
+		if freq[c] == 0 {
+			distinct++
+		}
+		freq[c]++
+		if int(c) > maxSymbol {
+			maxSymbol = int(c)
+		}
+	}
+	// Weights are written directly, which allows up to 128 of them.
+	if distinct < 2 || maxSymbol > 128 {
+		return nil
+	}
+
+	lengths := huffmanLengths(freq[:maxSymbol+1], 11)
+	maxBits := 0
+	for _, l := range lengths {
+		if int(l) > maxBits {
+			maxBits = int(l)
+		}
+	}
+	codes := make([]uint16, len(lengths))
+	index := 0
+	for w := 1; w <= maxBits; w++ {
+		for s, l := range lengths {
+			if l == 0 || maxBits+1-int(l) != w {
+				continue
+			}
+			codes[s] = uint16(index >> uint(w-1))
+			index += 1 << uint(w-1)
+		}
+	}
+
+	tree := []byte{byte(127 + maxSymbol)}
+	for s := 0; s < maxSymbol; s += 2 {
+		var hi, lo byte
+		if lengths[s] > 0 {
+			hi = byte(maxBits + 1 - int(lengths[s]))
+		}
+		if s+1 < maxSymbol && lengths[s+1] > 0 {
+			lo = byte(maxBits + 1 - int(lengths[s+1]))
+		}
+		tree = append(tree, hi<<4|lo)
+	}
+
+	encode := func(b []byte) []byte {
+		w := &forwardBits{}
+		for i := len(b) - 1; i >= 0; i-- {
+			w.write(uint64(codes[b[i]]), uint(lengths[b[i]]))
+		}
+		return w.close()
+	}
+
+	regen := len(literals)
+	var body []byte
+	streams := 1
+	if regen >= 1024 {
+		streams = 4
+	}
+	if streams == 1 {
+		body = append(tree, encode(literals)...)
+		if len(body) >= 1024 {
+			streams = 4
+		}
+	}
+	if streams == 4 {
+		segment := (regen + 3) / 4
+		var parts [4][]byte
+		for i := range parts {
+			lo, hi := i*segment, (i+1)*segment
+			if i == 3 {
+				hi = regen
+			}
+			parts[i] = encode(literals[lo:hi])
+		}
+		for _, p := range parts[:3] {
+			if len(p) > 0xFFFF {
+				return nil
+			}
+		}
+		body = append([]byte(nil), tree...)
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(parts[0])))
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(parts[1])))
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(parts[2])))
+		for _, p := range parts {
+			body = append(body, p...)
+		}
+	}
+
+	var header []byte
+	comp := len(body)
+	switch {
+	case streams == 1:
+		v := 2 | regen<<4 | comp<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case regen < 1024 && comp < 1024:
+		v := 2 | 1<<2 | regen<<4 | comp<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case regen < 16384 && comp < 16384:
+		v := 2 | 2<<2 | regen<<4 | comp<<18
+		header = binary.LittleEndian.AppendUint32(nil, uint32(v))
+	default:
+		v := uint64(2 | 3<<2 | regen<<4 | comp<<22)
+		header = binary.LittleEndian.AppendUint32(nil, uint32(v))
+		header = append(header, byte(v>>32))
+	}
+	if len(header)+comp >= regen+3 {
+		return nil
+	}
+	return append(header, body...)
+}
+
+// huffmanLengths returns Huffman code lengths for freq limited to limit
+// bits, halving the frequencies until the tree is shallow enough.
+func huffmanLengths(freq []int, limit int) []uint8 {
+	type node struct {
+		weight      int
+		left, right int
+	}
+	f := append([]int(nil), freq...)
+	for {
+		var nodes []node
+		var queue []int
+		for s, n := range f {
+			if n > 0 {
+				nodes = append(nodes, node{weight: n, left: -1, right: s})
+				queue = append(queue, len(nodes)-1)
+			}
+		}
+		lengths := make([]uint8, len(f))
+		for len(queue) > 1 {
+			// Take the two lightest nodes; alphabets are small enough
+			// for a linear scan.
+			pick := func() int {
+				best := 0
+				for i := range queue {
+					if nodes[queue[i]].weight < nodes[queue[best]].weight {
+						best = i
+					}
+				}
+				n := queue[best]
+				queue = append(queue[:best], queue[best+1:]...)
+				return n
+			}
+			a, b := pick(), pick()
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, left: a, right: b})
+			queue = append(queue, len(nodes)-1)
+		}
+
+		depth := 0
+		var walk func(n, d int)
+		walk = func(n, d int) {
+			if nodes[n].left < 0 {
+				lengths[nodes[n].right] = uint8(d)
+				if d > depth {
+					depth = d
+				}
+				return
+			}
+			walk(nodes[n].left, d+1)
+			walk(nodes[n].right, d+1)
+		}
+		walk(queue[0], 0)
+		if depth <= limit {
+			return lengths
+		}
+		for s := range f {
+			if f[s] > 0 {
+				f[s] = (f[s] + 1) / 2
+			}
+		}
+	}
+}
+
+func encodeZstdSequences(out []byte, sequences []zstdSequence) []byte {
+	n := len(sequences)
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8)+128, byte(n))
+	default:
+		out = append(out, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return out
+	}
+	type coded struct {
+		ll, ml, of             uint8
+		llBits, mlBits, ofBits uint32
+		llN, mlN, ofN          uint
+	}
+	codes := make([]coded, n)
+	for i, s := range sequences {
+		c := &codes[i]
+		c.ll, c.llBits, c.llN = lengthCode(zstdLitLengthCodes, s.litLength)
+		c.ml, c.mlBits, c.mlN = lengthCode(zstdMatchLengthCodes, s.matchLength)
+		v := s.offset
+		c.of = uint8(highBit(v))
+		c.ofBits, c.ofN = v-1<<c.of, uint(c.of)
+	}
+
+	llCodes, ofCodes, mlCodes := make([]uint8, n), make([]uint8, n), make([]uint8, n)
+	for i, c := range codes {
+		llCodes[i], ofCodes[i], mlCodes[i] = c.ll, c.of, c.ml
+	}
+	llTable := chooseZstdTable(llCodes, zstdLitLengthNorm, zstdLitLengthEncoder, 9)
+	ofTable := chooseZstdTable(ofCodes, zstdOffsetNorm, zstdOffsetEncoder, 8)
+	mlTable := chooseZstdTable(mlCodes, zstdMatchLengthNorm, zstdMatchLengthEncoder, 9)
+	out = append(out, llTable.mode<<6|ofTable.mode<<4|mlTable.mode<<2)
+	out = append(out, llTable.header...)
+	out = append(out, ofTable.header...)
+	out = append(out, mlTable.header...)
+
+	w := &forwardBits{}
+	last := codes[n-1]
+	ll := llTable.init(last.ll)
+	ml := mlTable.init(last.ml)
+	of := ofTable.init(last.of)
+	w.write(uint64(last.llBits), last.llN)
+	w.write(uint64(last.mlBits), last.mlN)
+	w.write(uint64(last.ofBits), last.ofN)
+	for i := n - 2; i >= 0; i-- {
+		c := codes[i]
+		of = ofTable.encode(w, of, c.of)
+		ml = mlTable.encode(w, ml, c.ml)
+		ll = llTable.encode(w, ll, c.ll)
+		w.write(uint64(c.llBits), c.llN)
+		w.write(uint64(c.mlBits), c.mlN)
+		w.write(uint64(c.ofBits), c.ofN)
+	}
+	mlTable.flush(w, ml)
+	ofTable.flush(w, of)
+	llTable.flush(w, ll)
+	return append(out, w.close()...)
+}
+
+// Modes of a sequence code table.
+const (
+	zstdPredefined = 0
+	zstdRLE        = 1
+	zstdCompressed = 2
+)
+
+// zstdSeqTable is how one sequence code is coded in a block: a mode, the
+// description following the modes byte, and the encoder, which is nil for
+// a single repeated code as it then takes no bits.
+type zstdSeqTable struct {
+	mode   byte
+	header []byte
+	enc    *fseEncoder
+}
+
+func (t zstdSeqTable) init(symbol uint8) uint32 {
+	if t.enc == nil {
+		return 0
+	}
+	return t.enc.init(symbol)
+}
+
+func (t zstdSeqTable) encode(w *forwardBits, state uint32, symbol uint8) uint32 {
+	if t.enc == nil {
+		return 0
+	}
+	return t.enc.encode(w, state, symbol)
+}
+
+func (t zstdSeqTable) flush(w *forwardBits, state uint32) {
+	if t.enc != nil {
+		t.enc.flush(w, state)
+	}
+}
+
+// chooseZstdTable picks the cheapest way to code codes: as a single
+// repeated code, with the predefined distribution, or with one normalized
+// from their counts and described in the block.
+func chooseZstdTable(codes []uint8, predefined []int16, predefinedEnc *fseEncoder, maxLog int) zstdSeqTable {
+	counts := make([]int, len(predefined))
+	distinct, last := 0, 0
+	for _, c := range codes {
+		if counts[c] == 0 {
+			distinct++
+		}
+		counts[c]++
+		if int(c) > last {
+			last = int(c)
+		}
+	}
+	if distinct == 1 {
+		return zstdSeqTable{mode: zstdRLE, header: []byte{codes[0]}}
+	}
+
+	best := zstdSeqTable{mode: zstdPredefined, enc: predefinedEnc}
+	cost := fseCost(counts, predefined, predefinedEnc.log)
+
+	log := highBit(uint32(len(codes))) + 1
+	for log < maxLog && 1<<uint(log) < 4*distinct {
+		log++
+	}
+	if log > maxLog {
+		log = maxLog
+	}
+	if log < 5 {
+		log = 5
+	}
+	if norm := normalizeCounts(counts[:last+1], len(codes), log); norm != nil {
+		header := writeFSEDistribution(norm, log)
+		if c := fseCost(counts, norm, log) + float64(8*len(header)); c < cost {
+			best = zstdSeqTable{mode: zstdCompressed, header: header, enc: newFSEEncoder(norm, log)}
+		}
+	}
+	return best
+}
+
+// fseCost estimates the bits taken by symbols with counts under norm.
+func fseCost(counts []int, norm []int16, log int) float64 {
+	bits := 0.0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := 1.0
+		if s < len(norm) && norm[s] > 1 {
+			p = float64(norm[s])
+		}
+		bits += float64(c) * (float64(log) - math.Log2(p))
+	}
+	return bits
+}
+
+// normalizeCounts scales counts to sum to 1<<log, keeping every used
+// symbol, or returns nil if that is impossible.
+func normalizeCounts(counts []int, total, log int) []int16 {
+	norm := make([]int16, len(counts))
+	target := 1 << uint(log)
+	sum, largest := 0, 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		n := c * target / total
+		if n < 1 {
+			n = 1
+		}
+		norm[s] = int16(n)
+		sum += n
+		if c > counts[largest] {
+			largest = s
+		}
+	}
+	if int(norm[largest])+target-sum < 1 {
+		return nil
+	}
+	norm[largest] += int16(target - sum)
+	return norm
+}
+
+// writeFSEDistribution is the inverse of readFSEDistribution.
+func writeFSEDistribution(norm []int16, log int) []byte {
+	w := &forwardBits{}
+	w.write(uint64(log-5), 4)
+
+	remaining := 1<<uint(log) + 1
+	threshold := 1 << uint(log)
+	nbBits := log + 1
+	previousZero := false
+	for s := 0; remaining > 1 && s < len(norm); {
+		if previousZero {
+			start := s
+			for s < len(norm) && norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				w.write(0xFFFF, 16)
+			}
+			for s >= start+3 {
+				start += 3
+				w.write(3, 2)
+			}
+			w.write(uint64(s-start), 2)
+		}
+
+		count := int(norm[s])
+		s++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += max
+		}
+		n := nbBits
+		if count < max {
+			n--
+		}
+		w.write(uint64(count), uint(n))
+		previousZero = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.acc))
+	}
+	return w.out
+}